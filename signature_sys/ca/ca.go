@@ -0,0 +1,278 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// ca/ca.go
+// 本文件实现了系统内置的两级证书颁发机构：根CA和签发CA。
+// 根CA只用于签发签发CA的证书，签发CA为用户签发终端实体证书。
+// 验签时以根CA作为信任锚，信任根CA即可验证本系统签发的全部签名。
+// 国密CA（SM2）是独立的一套根CA和签发CA，密钥为SM2，证书和CRL按GM/T 0015使用SM2-with-SM3签名。
+
+// 证书主题中的组织名称
+const Organization = "Signature System"
+
+// 各级证书的有效期（年）
+const (
+	RootValidityYears    = 20
+	IssuingValidityYears = 10
+)
+
+// oidEmailAddress 主题中的电子邮件地址属性（PKCS#9 emailAddress）
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// CA 证书颁发机构
+type CA struct {
+	Root       *x509.Certificate // 根CA证书（信任锚）
+	RootKey    crypto.Signer     // 根CA私钥，仅用于签发签发CA证书
+	Issuing    *x509.Certificate // 签发CA证书
+	IssuingKey crypto.Signer     // 签发CA私钥，用于签发用户证书
+	SM2        bool              // 是否为国密CA
+
+	// 写入用户证书扩展的服务地址（可选）
+	CRLURL    string // CRL分发点
+	OCSPURL   string // OCSP服务（AIA）
+	IssuerURL string // 签发CA证书下载地址（AIA）
+}
+
+// 证书和私钥的文件名
+const (
+	rootCertFile    = "root_cert.pem"
+	rootKeyFile     = "root_private.pem"
+	issuingCertFile = "issuing_cert.pem"
+	issuingKeyFile  = "issuing_private.pem"
+)
+
+// LoadOrCreate 从dir目录加载根CA和签发CA，文件不存在时生成新的密钥和证书
+func LoadOrCreate(dir string) (*CA, error) {
+	return load(dir, false, "Signature System")
+}
+
+// LoadOrCreateSM2 从dir目录加载国密根CA和签发CA，文件不存在时生成新的SM2密钥和证书
+func LoadOrCreateSM2(dir string) (*CA, error) {
+	return load(dir, true, "Signature System SM2")
+}
+
+// load 加载或生成一套根CA和签发CA，name为CA名称前缀
+func load(dir string, sm2Key bool, name string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &CA{SM2: sm2Key}
+	var err error
+	c.Root, c.RootKey, err = loadPair(filepath.Join(dir, rootCertFile), filepath.Join(dir, rootKeyFile))
+	if os.IsNotExist(err) {
+		c.Root, c.RootKey, err = c.createCA(dir, rootCertFile, rootKeyFile, pkix.Name{
+			CommonName:   name + " Root CA",
+			Organization: []string{Organization},
+		}, RootValidityYears, nil, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("根CA加载失败: %w", err)
+	}
+	c.Issuing, c.IssuingKey, err = loadPair(filepath.Join(dir, issuingCertFile), filepath.Join(dir, issuingKeyFile))
+	if os.IsNotExist(err) {
+		c.Issuing, c.IssuingKey, err = c.createCA(dir, issuingCertFile, issuingKeyFile, pkix.Name{
+			CommonName:   name + " Issuing CA",
+			Organization: []string{Organization},
+		}, IssuingValidityYears, c.Root, c.RootKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("签发CA加载失败: %w", err)
+	}
+	if err := utils.CheckSignatureFrom(c.Issuing, c.Root); err != nil {
+		return nil, errors.New("签发CA证书不是由根CA签发的")
+	}
+	return c, nil
+}
+
+// loadPair 读取证书和私钥文件，证书文件不存在时返回os.ErrNotExist
+func loadPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	if _, err := os.Stat(certPath); err != nil {
+		return nil, nil, err
+	}
+	cert, err := utils.LoadCertificate(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := utils.LoadPrivateKey(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取私钥失败: %w", err)
+	}
+	return cert, key, nil
+}
+
+// createCA 生成CA密钥和证书并写入文件，parent为空时生成自签名的根CA
+// 国密CA使用SM2密钥，私钥保存为PKCS#8；其他CA使用ECDSA P-384密钥
+func (c *CA) createCA(dir, certFile, keyFile string, subject pkix.Name, years int, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+	if c.SM2 {
+		key, err = sm2.GenerateKey(rand.Reader)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(years, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		// 根CA自签名
+		parent, parentKey = tmpl, key
+	} else {
+		// 签发CA下只允许签发终端实体证书
+		tmpl.MaxPathLenZero = true
+		if tmpl.NotAfter.After(parent.NotAfter) {
+			tmpl.NotAfter = parent.NotAfter
+		}
+	}
+	der, err := smx509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := utils.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keyBlock *pem.Block
+	if c.SM2 {
+		keyDER, err := smx509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}
+	} else {
+		keyDER, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+		if err != nil {
+			return nil, nil, err
+		}
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}
+	}
+	if err := os.WriteFile(filepath.Join(dir, keyFile), pem.EncodeToMemory(keyBlock), 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// UserSubject 根据用户名和邮箱生成用户证书的主题
+func UserSubject(username, email string) pkix.Name {
+	name := pkix.Name{
+		CommonName:   username,
+		Organization: []string{Organization},
+	}
+	if email != "" {
+		name.ExtraNames = []pkix.AttributeTypeAndValue{{Type: oidEmailAddress, Value: email}}
+	}
+	return name
+}
+
+// IssueUserCertificate 为用户公钥签发签名证书，有效期不超过签发CA证书的有效期
+// 国密CA只为SM2公钥签发证书，其他CA不为SM2公钥签发证书
+func (c *CA) IssueUserCertificate(pub crypto.PublicKey, username, email string, notBefore, notAfter time.Time) (*x509.Certificate, error) {
+	if utils.IsSM2PublicKey(pub) != c.SM2 {
+		return nil, errors.New("用户公钥算法与CA算法不一致")
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	if notAfter.After(c.Issuing.NotAfter) {
+		notAfter = c.Issuing.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               UserSubject(username, email),
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, // 用途：数字签名和不可否认性
+		BasicConstraintsValid: true,
+	}
+	if email != "" {
+		tmpl.EmailAddresses = []string{email}
+	}
+	// CRL分发点和AIA扩展，供验证方获取吊销信息和颁发者证书
+	if c.CRLURL != "" {
+		tmpl.CRLDistributionPoints = []string{c.CRLURL}
+	}
+	if c.OCSPURL != "" {
+		tmpl.OCSPServer = []string{c.OCSPURL}
+	}
+	if c.IssuerURL != "" {
+		tmpl.IssuingCertificateURL = []string{c.IssuerURL}
+	}
+	der, err := smx509.CreateCertificate(rand.Reader, tmpl, c.Issuing, pub, c.IssuingKey)
+	if err != nil {
+		return nil, err
+	}
+	return utils.ParseCertificate(der)
+}
+
+// Chain 返回CA证书链（签发CA在前，根CA在后）
+func (c *CA) Chain() []*x509.Certificate {
+	return []*x509.Certificate{c.Issuing, c.Root}
+}
+
+// ChainFor 返回签名时需要随证书内嵌的CA证书，cert不是由本CA签发时返回nil
+func (c *CA) ChainFor(cert *x509.Certificate) []*x509.Certificate {
+	if utils.CheckSignatureFrom(cert, c.Issuing) != nil {
+		return nil
+	}
+	return c.Chain()
+}
+
+// Verify 校验cert能否通过intermediates中的证书（及签发CA）链接到根CA，at为校验时间
+func (c *CA) Verify(cert *x509.Certificate, intermediates []*x509.Certificate, at time.Time) error {
+	roots := smx509.NewCertPool()
+	roots.AddCert((*smx509.Certificate)(c.Root))
+	pool := smx509.NewCertPool()
+	pool.AddCert((*smx509.Certificate)(c.Issuing))
+	for _, ic := range intermediates {
+		pool.AddCert((*smx509.Certificate)(ic))
+	}
+	_, err := (*smx509.Certificate)(cert).Verify(smx509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// randomSerial 生成128位随机序列号
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+}
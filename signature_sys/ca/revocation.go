@@ -0,0 +1,136 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/emmansun/gmsm/smx509"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ca/revocation.go
+// 本文件实现了签发CA的证书吊销列表（CRL）生成和OCSP响应（RFC 6960）签发。
+// CRL和OCSP响应均由签发CA直接签名，吊销状态由调用方从证书库中查询后传入。
+// OCSP没有SM2签名算法的标准定义，国密CA只发布CRL。
+
+// 吊销原因（RFC 5280 CRLReason）
+const (
+	ReasonUnspecified          = 0 // 未指定
+	ReasonKeyCompromise        = 1 // 密钥泄露
+	ReasonAffiliationChanged   = 3 // 隶属关系变更
+	ReasonSuperseded           = 4 // 证书已被取代
+	ReasonCessationOfOperation = 5 // 停止使用
+)
+
+// ReasonName 返回吊销原因的中文名称
+func ReasonName(reason int) string {
+	switch reason {
+	case ReasonUnspecified:
+		return "未指定"
+	case ReasonKeyCompromise:
+		return "密钥泄露"
+	case ReasonAffiliationChanged:
+		return "隶属关系变更"
+	case ReasonSuperseded:
+		return "证书已被取代"
+	case ReasonCessationOfOperation:
+		return "停止使用"
+	}
+	return "其他"
+}
+
+// RevokedCert 已吊销的证书
+type RevokedCert struct {
+	SerialNumber *big.Int  // 证书序列号
+	RevokedAt    time.Time // 吊销时间
+	Reason       int       // 吊销原因
+}
+
+// CreateCRL 生成签发CA的CRL（DER编码），number为单调递增的CRL编号
+func (c *CA) CreateCRL(revoked []RevokedCert, number *big.Int, thisUpdate, nextUpdate time.Time) ([]byte, error) {
+	var entries []x509.RevocationListEntry
+	for _, rc := range revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   rc.SerialNumber,
+			RevocationTime: rc.RevokedAt.UTC(),
+			ReasonCode:     rc.Reason,
+		})
+	}
+	return smx509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                thisUpdate.UTC(),
+		NextUpdate:                nextUpdate.UTC(),
+	}, (*smx509.Certificate)(c.Issuing), c.IssuingKey)
+}
+
+// StatusFunc 按序列号查询证书状态，found为false表示不是本CA签发的证书
+type StatusFunc func(serial *big.Int) (revoked *RevokedCert, found bool, err error)
+
+// OCSPResponse 处理DER编码的OCSP请求，返回DER编码的OCSP响应
+// 请求的颁发者不是签发CA或证书不存在时返回unknown状态，nextUpdate为响应的有效期；国密CA不提供OCSP服务，返回unauthorized
+func (c *CA) OCSPResponse(reqDER []byte, status StatusFunc, validity time.Duration) []byte {
+	if c.SM2 {
+		return ocsp.UnauthorizedErrorResponse
+	}
+	req, err := ocsp.ParseRequest(reqDER)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	tmpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(validity),
+	}
+	if c.issuedBy(req) {
+		revoked, found, err := status(req.SerialNumber)
+		if err != nil {
+			return ocsp.InternalErrorErrorResponse
+		}
+		switch {
+		case revoked != nil:
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = revoked.RevokedAt.UTC()
+			tmpl.RevocationReason = revoked.Reason
+		case found:
+			tmpl.Status = ocsp.Good
+		}
+	}
+	resp, err := ocsp.CreateResponse(c.Issuing, c.Issuing, tmpl, c.IssuingKey)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse
+	}
+	return resp
+}
+
+// issuedBy 检查OCSP请求中的颁发者是否为签发CA
+func (c *CA) issuedBy(req *ocsp.Request) bool {
+	nameHash, keyHash, err := issuerHashes(c.Issuing, req.HashAlgorithm)
+	if err != nil {
+		return false
+	}
+	return string(nameHash) == string(req.IssuerNameHash) && string(keyHash) == string(req.IssuerKeyHash)
+}
+
+// issuerHashes 计算颁发者名称和公钥的摘要（OCSP CertID）
+func issuerHashes(issuer *x509.Certificate, hash crypto.Hash) ([]byte, []byte, error) {
+	if !hash.Available() {
+		return nil, nil, errors.New("不支持的摘要算法")
+	}
+	// 复用ocsp.CreateRequest计算CertID，序列号任意
+	der, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(1)}, issuer, &ocsp.RequestOptions{Hash: hash})
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req.IssuerNameHash, req.IssuerKeyHash, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"signature_sys/signer/kmsmock"
+)
+
+// cmd/kmsmock
+// KMS模拟服务，用于本地联调KMS密钥后端：
+//   go run ./cmd/kmsmock -addr :9100 -token dev-token
+// 然后以 KMS_URL=http://localhost:9100 KMS_TOKEN=dev-token 启动主程序。密钥只保存在内存中，重启后丢失。
+
+func main() {
+	addr := flag.String("addr", ":9100", "监听地址")
+	token := flag.String("token", "", "访问令牌，为空时不校验")
+	flag.Parse()
+	log.Println("KMS mock started at", *addr)
+	log.Fatal(http.ListenAndServe(*addr, kmsmock.New(*token)))
+}
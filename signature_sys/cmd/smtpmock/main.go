@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"signature_sys/mailer/smtpmock"
+)
+
+// cmd/smtpmock
+// SMTP模拟服务，用于本地联调邮件发送（访客签署链接和验证码）：
+//   go run ./cmd/smtpmock -addr 127.0.0.1:2525 -dir mail
+// 然后以 SMTP_ADDR=127.0.0.1:2525 启动主程序，收到的邮件保存在-dir指定的目录下。
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:2525", "监听地址")
+	dir := flag.String("dir", "mail", "邮件保存目录，为空时只输出日志")
+	flag.Parse()
+	s := smtpmock.New()
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0700); err != nil {
+			log.Fatal(err)
+		}
+		s.Dir = *dir
+	}
+	log.Println("SMTP mock started at", *addr)
+	log.Fatal(s.ListenAndServe(*addr))
+}
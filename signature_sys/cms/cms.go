@@ -0,0 +1,757 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"sort"
+	"time"
+
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// cms/cms.go
+// 本文件实现了CMS（RFC 5652）SignedData结构的生成与解析。
+// 供PDF数字签名（PAdES）使用，签名私钥通过crypto.Signer接口传入。
+// SM2密钥按GM/T 0010使用SM3摘要和SM2-with-SM3签名算法，签名值为对SM3(Z_A‖签名属性)的SM2签名。
+
+// 常用OID
+var (
+	OIDData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	OIDAttrContentType        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDAttrMessageDigest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	OIDAttrSigningTime        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	OIDAttrSigningCertV1      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 12}
+	OIDAttrSigningCertV2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	OIDAttrTimeStampToken     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	OIDTSTInfo                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	OIDDigestSHA1             = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	OIDDigestSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	OIDDigestSHA384           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	OIDDigestSHA512           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	OIDEncryptionRSA          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	OIDSignatureSHA1WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	OIDSignatureSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	OIDSignatureSHA384WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	OIDSignatureSHA512WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	OIDPublicKeyECDSA         = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	OIDSignatureECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	OIDSignatureECDSASHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	OIDSignatureECDSASHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	OIDDigestSM3              = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 401}
+	OIDPublicKeySM2           = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301, 1}
+	OIDSignatureSM2WithSM3    = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 501}
+)
+
+// ContentInfo CMS最外层结构
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData SignedData内部结构
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// Attribute 签名属性（取第一个属性值）
+type Attribute struct {
+	Type  asn1.ObjectIdentifier // 属性类型
+	Value asn1.RawValue         // 属性值（DER）
+}
+
+// SignerInfo 解析后的签名者信息
+type SignerInfo struct {
+	IssuerRaw          []byte                   // 签名者证书颁发者（DER）
+	SerialNumber       *big.Int                 // 签名者证书序列号
+	SubjectKeyID       []byte                   // 以SKI方式标识签名者时的取值
+	DigestAlgorithm    asn1.ObjectIdentifier    // 摘要算法
+	SignatureAlgorithm pkix.AlgorithmIdentifier // 签名算法
+	Signature          []byte                   // 签名值
+	SignedAttrsRaw     []byte                   // 签名属性原始字节（SET OF，已还原为0x31标签）
+	SignedAttrs        []Attribute              // 签名属性
+	UnsignedAttrs      []Attribute              // 非签名属性
+}
+
+// SignedData 解析或生成的CMS签名数据
+type SignedData struct {
+	ContentType  asn1.ObjectIdentifier // 被签内容类型
+	Content      []byte                // 封装的内容（分离式签名为nil）
+	Certificates []*x509.Certificate   // 内嵌证书
+	CRLs         [][]byte              // 内嵌CRL（DER）
+	Signers      []SignerInfo          // 签名者列表
+	digestAlgs   []pkix.AlgorithmIdentifier
+}
+
+// SignOptions 生成签名时的可选参数
+type SignOptions struct {
+	Hash             crypto.Hash           // 摘要算法，默认SHA256；SM2密钥固定使用SM3
+	ContentType      asn1.ObjectIdentifier // 被签内容类型，默认id-data
+	Content          []byte                // 需要封装的内容，nil表示分离式签名
+	SigningTime      time.Time             // 非零时写入signingTime签名属性
+	ExtraSignedAttrs []Attribute           // 额外的签名属性
+}
+
+// Sign 对摘要值digest生成CMS SignedData
+// digest为被签内容按opts.Hash（SM2密钥为SM3，见NewDigest）计算出的摘要，cert为签名证书，chain为需要一并内嵌的证书链
+func Sign(digest []byte, signer crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate, opts SignOptions) (*SignedData, error) {
+	if opts.Hash == 0 {
+		opts.Hash = crypto.SHA256
+	}
+	if opts.ContentType == nil {
+		opts.ContentType = OIDData
+	}
+	sm2Key := utils.IsSM2PublicKey(signer.Public())
+	digestOID := OIDDigestSM3
+	sigAlg := pkix.AlgorithmIdentifier{Algorithm: OIDSignatureSM2WithSM3}
+	if !sm2Key {
+		var err error
+		if digestOID, err = DigestOID(opts.Hash); err != nil {
+			return nil, err
+		}
+		if sigAlg, err = signatureAlgorithm(signer.Public(), opts.Hash); err != nil {
+			return nil, err
+		}
+	}
+	// 组装签名属性：内容类型、消息摘要、签名证书（ESS signing-certificate-v2）
+	attrs := []Attribute{}
+	if a, err := NewAttribute(OIDAttrContentType, opts.ContentType); err == nil {
+		attrs = append(attrs, a)
+	}
+	if a, err := NewAttribute(OIDAttrMessageDigest, digest); err == nil {
+		attrs = append(attrs, a)
+	}
+	if !opts.SigningTime.IsZero() {
+		if a, err := NewAttribute(OIDAttrSigningTime, opts.SigningTime.UTC()); err == nil {
+			attrs = append(attrs, a)
+		}
+	}
+	essAttr, err := signingCertificateV2(cert, sm2Key)
+	if err != nil {
+		return nil, err
+	}
+	attrs = append(attrs, essAttr)
+	attrs = append(attrs, opts.ExtraSignedAttrs...)
+	signedAttrsRaw, err := marshalAttributeSet(attrs)
+	if err != nil {
+		return nil, err
+	}
+	// 对签名属性（DER SET OF）计算摘要后签名
+	var signature []byte
+	if sm2Key {
+		var e []byte
+		if e, err = utils.SM2Digest(signer.Public(), signedAttrsRaw); err != nil {
+			return nil, err
+		}
+		signature, err = signer.Sign(rand.Reader, e, utils.SM2SignerOpts{})
+	} else {
+		h := opts.Hash.New()
+		h.Write(signedAttrsRaw)
+		signature, err = signer.Sign(rand.Reader, h.Sum(nil), opts.Hash)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("签名运算失败: %w", err)
+	}
+	certs := []*x509.Certificate{cert}
+	for _, c := range chain {
+		if !c.Equal(cert) {
+			certs = append(certs, c)
+		}
+	}
+	return &SignedData{
+		ContentType:  opts.ContentType,
+		Content:      opts.Content,
+		Certificates: certs,
+		Signers: []SignerInfo{{
+			IssuerRaw:          cert.RawIssuer,
+			SerialNumber:       cert.SerialNumber,
+			DigestAlgorithm:    digestOID,
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+			SignedAttrsRaw:     signedAttrsRaw,
+			SignedAttrs:        attrs,
+		}},
+		digestAlgs: []pkix.AlgorithmIdentifier{{Algorithm: digestOID}},
+	}, nil
+}
+
+// AddUnsignedAttribute 为第一个签名者追加非签名属性（如时间戳）
+func (sd *SignedData) AddUnsignedAttribute(oid asn1.ObjectIdentifier, value interface{}) error {
+	if len(sd.Signers) == 0 {
+		return errors.New("无签名者")
+	}
+	a, err := NewAttribute(oid, value)
+	if err != nil {
+		return err
+	}
+	sd.Signers[0].UnsignedAttrs = append(sd.Signers[0].UnsignedAttrs, a)
+	return nil
+}
+
+// Marshal 将SignedData编码为DER格式的ContentInfo
+func (sd *SignedData) Marshal() ([]byte, error) {
+	eci := encapContentInfo{EContentType: sd.ContentType}
+	if sd.Content != nil {
+		octets, err := asn1.Marshal(sd.Content)
+		if err != nil {
+			return nil, err
+		}
+		eci.EContent = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets}
+	}
+	var certBytes []byte
+	for _, c := range sd.Certificates {
+		certBytes = append(certBytes, c.Raw...)
+	}
+	var crlBytes []byte
+	for _, c := range sd.CRLs {
+		crlBytes = append(crlBytes, c...)
+	}
+	infos := make([]signerInfo, 0, len(sd.Signers))
+	for _, s := range sd.Signers {
+		sid, err := asn1.Marshal(issuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.IssuerRaw}, SerialNumber: s.SerialNumber})
+		if err != nil {
+			return nil, err
+		}
+		si := signerInfo{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: s.DigestAlgorithm},
+			SignatureAlgorithm: s.SignatureAlgorithm,
+			Signature:          s.Signature,
+		}
+		if len(s.SignedAttrsRaw) > 0 {
+			// 签名属性以[0] IMPLICIT方式编码，内容与签名时的SET OF一致
+			var set asn1.RawValue
+			if _, err := asn1.Unmarshal(s.SignedAttrsRaw, &set); err != nil {
+				return nil, err
+			}
+			si.SignedAttrs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: set.Bytes}
+		}
+		if len(s.UnsignedAttrs) > 0 {
+			raw, err := marshalAttributeSet(s.UnsignedAttrs)
+			if err != nil {
+				return nil, err
+			}
+			var set asn1.RawValue
+			asn1.Unmarshal(raw, &set)
+			si.UnsignedAttrs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: set.Bytes}
+		}
+		infos = append(infos, si)
+	}
+	// 封装内容类型不是id-data时（如时间戳令牌的TSTInfo），SignedData版本号为3
+	version := 1
+	if !sd.ContentType.Equal(OIDData) {
+		version = 3
+	}
+	sdv := signedData{
+		Version:          version,
+		DigestAlgorithms: sd.digestAlgs,
+		EncapContentInfo: eci,
+		SignerInfos:      infos,
+	}
+	if len(certBytes) > 0 {
+		sdv.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certBytes}
+	}
+	if len(crlBytes) > 0 {
+		sdv.CRLs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: crlBytes}
+	}
+	if len(sdv.DigestAlgorithms) == 0 {
+		for _, s := range sd.Signers {
+			sdv.DigestAlgorithms = append(sdv.DigestAlgorithms, pkix.AlgorithmIdentifier{Algorithm: s.DigestAlgorithm})
+		}
+	}
+	inner, err := asn1.Marshal(sdv)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
+
+// Parse 解析DER（或带尾部填充0的）ContentInfo格式的CMS SignedData
+func Parse(data []byte) (*SignedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(data, &ci); err != nil {
+		return nil, fmt.Errorf("CMS结构解析失败: %w", err)
+	}
+	if !ci.ContentType.Equal(OIDSignedData) {
+		return nil, fmt.Errorf("CMS内容类型不是SignedData: %s", ci.ContentType)
+	}
+	var sdv signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sdv); err != nil {
+		return nil, fmt.Errorf("SignedData解析失败: %w", err)
+	}
+	sd := &SignedData{ContentType: sdv.EncapContentInfo.EContentType, digestAlgs: sdv.DigestAlgorithms}
+	if len(sdv.EncapContentInfo.EContent.Bytes) > 0 {
+		var octets []byte
+		if _, err := asn1.Unmarshal(sdv.EncapContentInfo.EContent.Bytes, &octets); err != nil {
+			return nil, fmt.Errorf("封装内容解析失败: %w", err)
+		}
+		sd.Content = octets
+	}
+	// 内嵌证书逐个解析，忽略非X.509证书（如属性证书）
+	rest := sdv.Certificates.Bytes
+	for len(rest) > 0 {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("内嵌证书解析失败: %w", err)
+		}
+		if raw.Class != asn1.ClassUniversal {
+			continue
+		}
+		cert, err := utils.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("内嵌证书解析失败: %w", err)
+		}
+		sd.Certificates = append(sd.Certificates, cert)
+	}
+	rest = sdv.CRLs.Bytes
+	for len(rest) > 0 {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, fmt.Errorf("内嵌CRL解析失败: %w", err)
+		}
+		if raw.Class == asn1.ClassUniversal {
+			sd.CRLs = append(sd.CRLs, raw.FullBytes)
+		}
+	}
+	for _, si := range sdv.SignerInfos {
+		s := SignerInfo{
+			DigestAlgorithm:    si.DigestAlgorithm.Algorithm,
+			SignatureAlgorithm: si.SignatureAlgorithm,
+			Signature:          si.Signature,
+		}
+		if si.SID.Class == asn1.ClassContextSpecific && si.SID.Tag == 0 {
+			s.SubjectKeyID = si.SID.Bytes
+		} else {
+			var ias issuerAndSerial
+			if _, err := asn1.Unmarshal(si.SID.FullBytes, &ias); err != nil {
+				return nil, fmt.Errorf("签名者标识解析失败: %w", err)
+			}
+			s.IssuerRaw = ias.Issuer.FullBytes
+			s.SerialNumber = ias.SerialNumber
+		}
+		if len(si.SignedAttrs.Bytes) > 0 {
+			// 验证签名时需使用SET OF（0x31）标签重新编码
+			raw, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: si.SignedAttrs.Bytes})
+			if err != nil {
+				return nil, err
+			}
+			s.SignedAttrsRaw = raw
+			if s.SignedAttrs, err = parseAttributes(si.SignedAttrs.Bytes); err != nil {
+				return nil, err
+			}
+		}
+		if len(si.UnsignedAttrs.Bytes) > 0 {
+			var err error
+			if s.UnsignedAttrs, err = parseAttributes(si.UnsignedAttrs.Bytes); err != nil {
+				return nil, err
+			}
+		}
+		sd.Signers = append(sd.Signers, s)
+	}
+	if len(sd.Signers) == 0 {
+		return nil, errors.New("CMS中没有签名者信息")
+	}
+	return sd, nil
+}
+
+// SignerCertificate 在内嵌证书中查找签名者证书
+func (sd *SignedData) SignerCertificate(s SignerInfo) (*x509.Certificate, error) {
+	for _, c := range sd.Certificates {
+		if s.SubjectKeyID != nil {
+			if bytes.Equal(c.SubjectKeyId, s.SubjectKeyID) {
+				return c, nil
+			}
+			continue
+		}
+		if c.SerialNumber.Cmp(s.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, s.IssuerRaw) {
+			return c, nil
+		}
+	}
+	return nil, errors.New("CMS中未包含签名者证书")
+}
+
+// Attribute 按OID查找签名属性
+func (s SignerInfo) Attribute(oid asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	for _, a := range s.SignedAttrs {
+		if a.Type.Equal(oid) {
+			return a.Value, true
+		}
+	}
+	return asn1.RawValue{}, false
+}
+
+// UnsignedAttribute 按OID查找非签名属性
+func (s SignerInfo) UnsignedAttribute(oid asn1.ObjectIdentifier) (asn1.RawValue, bool) {
+	for _, a := range s.UnsignedAttrs {
+		if a.Type.Equal(oid) {
+			return a.Value, true
+		}
+	}
+	return asn1.RawValue{}, false
+}
+
+// SigningTime 返回签名属性中声明的签名时间（不存在时返回零值）
+func (s SignerInfo) SigningTime() time.Time {
+	v, ok := s.Attribute(OIDAttrSigningTime)
+	if !ok {
+		return time.Time{}
+	}
+	var t time.Time
+	if _, err := asn1.Unmarshal(v.FullBytes, &t); err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Hash 返回签名者使用的摘要算法，SM3不在crypto.Hash中，需使用NewHash
+func (s SignerInfo) Hash() (crypto.Hash, error) {
+	return HashForOID(s.DigestAlgorithm)
+}
+
+// NewHash 返回签名者所用摘要算法的新哈希器，支持SM3
+func (s SignerInfo) NewHash() (hash.Hash, error) {
+	return NewHash(s.DigestAlgorithm)
+}
+
+// VerifyDigest 校验签名者的messageDigest属性与外部计算出的摘要是否一致
+// 无签名属性时无法单独校验摘要，由VerifySignature直接对内容验签
+func (s SignerInfo) VerifyDigest(digest []byte) error {
+	if len(s.SignedAttrsRaw) == 0 {
+		return nil
+	}
+	v, ok := s.Attribute(OIDAttrMessageDigest)
+	if !ok {
+		return errors.New("签名属性中缺少messageDigest")
+	}
+	var md []byte
+	if _, err := asn1.Unmarshal(v.FullBytes, &md); err != nil {
+		return fmt.Errorf("messageDigest解析失败: %w", err)
+	}
+	if !bytes.Equal(md, digest) {
+		return errors.New("文档摘要与签名中的messageDigest不一致")
+	}
+	return nil
+}
+
+// VerifySignature 用证书公钥校验签名值
+// 有签名属性时对签名属性验签，否则对digest（被签内容摘要）验签
+func (s SignerInfo) VerifySignature(cert *x509.Certificate, digest []byte) error {
+	if utils.IsSM2PublicKey(cert.PublicKey) {
+		// SM2签名针对Z_A‖签名属性，无法仅凭外部摘要验签
+		if len(s.SignedAttrsRaw) == 0 {
+			return errors.New("SM2签名缺少签名属性")
+		}
+		if !utils.VerifySM2(cert.PublicKey, s.SignedAttrsRaw, s.Signature) {
+			return errors.New("SM2签名值校验失败")
+		}
+		return nil
+	}
+	hash, err := s.Hash()
+	if err != nil {
+		return err
+	}
+	signed := digest
+	if len(s.SignedAttrsRaw) > 0 {
+		h := hash.New()
+		h.Write(s.SignedAttrsRaw)
+		signed = h.Sum(nil)
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, signed, s.Signature); err != nil {
+			return errors.New("RSA签名值校验失败")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, signed, s.Signature) {
+			return errors.New("ECDSA签名值校验失败")
+		}
+	default:
+		return fmt.Errorf("不支持的公钥类型: %T", pub)
+	}
+	return nil
+}
+
+// AlgorithmName 返回签名算法的可读名称
+func (s SignerInfo) AlgorithmName() string {
+	oid := s.SignatureAlgorithm.Algorithm
+	switch {
+	case oid.Equal(OIDEncryptionRSA), oid.Equal(OIDSignatureSHA1WithRSA), oid.Equal(OIDSignatureSHA256WithRSA),
+		oid.Equal(OIDSignatureSHA384WithRSA), oid.Equal(OIDSignatureSHA512WithRSA):
+		return "RSA"
+	case oid.Equal(OIDPublicKeyECDSA), oid.Equal(OIDSignatureECDSASHA256), oid.Equal(OIDSignatureECDSASHA384),
+		oid.Equal(OIDSignatureECDSASHA512):
+		return "ECDSA"
+	case oid.Equal(OIDSignatureSM2WithSM3), oid.Equal(OIDPublicKeySM2):
+		return "SM2"
+	}
+	return oid.String()
+}
+
+// NewAttribute 构造单值属性
+func NewAttribute(oid asn1.ObjectIdentifier, value interface{}) (Attribute, error) {
+	var raw []byte
+	var err error
+	if rv, ok := value.(asn1.RawValue); ok && rv.FullBytes != nil {
+		raw = rv.FullBytes
+	} else if t, ok := value.(time.Time); ok {
+		raw, err = asn1.MarshalWithParams(t, "utc")
+	} else {
+		raw, err = asn1.Marshal(value)
+	}
+	if err != nil {
+		return Attribute{}, err
+	}
+	var rv asn1.RawValue
+	if _, err := asn1.Unmarshal(raw, &rv); err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{Type: oid, Value: rv}, nil
+}
+
+// marshalAttributeSet 按DER规则（按编码排序）编码属性集合为SET OF
+func marshalAttributeSet(attrs []Attribute) ([]byte, error) {
+	encoded := make([][]byte, 0, len(attrs))
+	for _, a := range attrs {
+		b, err := asn1.Marshal(attribute{Type: a.Type, Values: []asn1.RawValue{a.Value}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+}
+
+// parseAttributes 解析属性集合内容
+func parseAttributes(b []byte) ([]Attribute, error) {
+	var attrs []Attribute
+	for len(b) > 0 {
+		var a attribute
+		var err error
+		b, err = asn1.Unmarshal(b, &a)
+		if err != nil {
+			return nil, fmt.Errorf("属性解析失败: %w", err)
+		}
+		if len(a.Values) == 0 {
+			continue
+		}
+		attrs = append(attrs, Attribute{Type: a.Type, Value: a.Values[0]})
+	}
+	return attrs, nil
+}
+
+// ESS signing-certificate-v2 属性结构（RFC 5035）
+type essCertIDv2 struct {
+	CertHash     []byte
+	IssuerSerial essIssuerSerial
+}
+
+type essIssuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signingCertificateV2Value struct {
+	Certs []essCertIDv2
+}
+
+// essCertIDv2SM3 显式给出摘要算法的ESSCertIDv2
+type essCertIDv2SM3 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	CertHash      []byte
+	IssuerSerial  essIssuerSerial
+}
+
+// signingCertificateV2 生成ESS signing-certificate-v2属性，绑定签名证书（PAdES-B-B要求）
+// 国密签名使用SM3计算证书摘要，并显式写入摘要算法
+func signingCertificateV2(cert *x509.Certificate, useSM3 bool) (Attribute, error) {
+	// GeneralName directoryName [4] EXPLICIT Name
+	dirName := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: cert.RawIssuer}
+	issuerSerial := essIssuerSerial{Issuer: []asn1.RawValue{dirName}, SerialNumber: cert.SerialNumber}
+	if useSM3 {
+		sum := sm3.Sum(cert.Raw)
+		v := struct{ Certs []essCertIDv2SM3 }{Certs: []essCertIDv2SM3{{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: OIDDigestSM3},
+			CertHash:      sum[:],
+			IssuerSerial:  issuerSerial,
+		}}}
+		return NewAttribute(OIDAttrSigningCertV2, v)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	v := signingCertificateV2Value{Certs: []essCertIDv2{{
+		CertHash:     sum[:],
+		IssuerSerial: issuerSerial,
+	}}}
+	return NewAttribute(OIDAttrSigningCertV2, v)
+}
+
+// CheckSigningCertificate 校验ESS signing-certificate-v2属性与签名证书是否一致
+// 属性不存在时返回false和nil
+func (s SignerInfo) CheckSigningCertificate(cert *x509.Certificate) (bool, error) {
+	v, ok := s.Attribute(OIDAttrSigningCertV2)
+	if !ok {
+		return false, nil
+	}
+	var certs struct {
+		Certs []asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(v.FullBytes, &certs); err != nil || len(certs.Certs) == 0 {
+		return true, errors.New("signing-certificate-v2属性解析失败")
+	}
+	// ESSCertIDv2的hashAlgorithm字段缺省为SHA256
+	h := crypto.SHA256.New()
+	rest := certs.Certs[0].Bytes
+	var first asn1.RawValue
+	rest, err := asn1.Unmarshal(rest, &first)
+	if err != nil {
+		return true, errors.New("signing-certificate-v2属性解析失败")
+	}
+	if first.Tag == asn1.TagSequence {
+		var alg pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(first.FullBytes, &alg); err != nil {
+			return true, errors.New("signing-certificate-v2属性解析失败")
+		}
+		if h, err = NewHash(alg.Algorithm); err != nil {
+			return true, err
+		}
+		if _, err = asn1.Unmarshal(rest, &first); err != nil {
+			return true, errors.New("signing-certificate-v2属性解析失败")
+		}
+	}
+	h.Write(cert.Raw)
+	if !bytes.Equal(h.Sum(nil), first.Bytes) {
+		return true, errors.New("signing-certificate-v2中的证书摘要与签名证书不一致")
+	}
+	return true, nil
+}
+
+// DigestOID 返回摘要算法对应的OID
+func DigestOID(h crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch h {
+	case crypto.SHA1:
+		return OIDDigestSHA1, nil
+	case crypto.SHA256:
+		return OIDDigestSHA256, nil
+	case crypto.SHA384:
+		return OIDDigestSHA384, nil
+	case crypto.SHA512:
+		return OIDDigestSHA512, nil
+	}
+	return nil, fmt.Errorf("不支持的摘要算法: %v", h)
+}
+
+// HashForOID 返回OID对应的摘要算法
+func HashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(OIDDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(OIDDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(OIDDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(OIDDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("不支持的摘要算法: %s", oid)
+}
+
+// NewHash 返回OID对应摘要算法的新哈希器，支持SM3
+func NewHash(oid asn1.ObjectIdentifier) (hash.Hash, error) {
+	if oid.Equal(OIDDigestSM3) {
+		return sm3.New(), nil
+	}
+	h, err := HashForOID(oid)
+	if err != nil {
+		return nil, err
+	}
+	return h.New(), nil
+}
+
+// NewDigest 返回签名时计算被签内容摘要所用的哈希器：SM2密钥为SM3，其他为h
+func NewDigest(pub crypto.PublicKey, h crypto.Hash) hash.Hash {
+	if utils.IsSM2PublicKey(pub) {
+		return sm3.New()
+	}
+	return h.New()
+}
+
+// HashName 返回摘要算法的可读名称
+func HashName(oid asn1.ObjectIdentifier) string {
+	if oid.Equal(OIDDigestSM3) {
+		return "SM3"
+	}
+	if h, err := HashForOID(oid); err == nil {
+		return h.String()
+	}
+	return oid.String()
+}
+
+// signatureAlgorithm 根据公钥类型确定签名算法标识
+func signatureAlgorithm(pub crypto.PublicKey, h crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: OIDEncryptionRSA, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return pkix.AlgorithmIdentifier{Algorithm: OIDSignatureECDSASHA256}, nil
+		case crypto.SHA384:
+			return pkix.AlgorithmIdentifier{Algorithm: OIDSignatureECDSASHA384}, nil
+		case crypto.SHA512:
+			return pkix.AlgorithmIdentifier{Algorithm: OIDSignatureECDSASHA512}, nil
+		}
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("ECDSA不支持的摘要算法: %v", h)
+	}
+	return pkix.AlgorithmIdentifier{}, fmt.Errorf("不支持的签名密钥类型: %T", pub)
+}
@@ -0,0 +1,149 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"signature_sys/ca"
+	"signature_sys/signer"
+)
+
+// testSigner 由测试CA签发证书的签名密钥
+func testSigner(t *testing.T, algo string) (crypto.Signer, *x509.Certificate, *ca.CA) {
+	t.Helper()
+	load := ca.LoadOrCreate
+	if algo == signer.AlgoSM2 {
+		load = ca.LoadOrCreateSM2
+	}
+	c, err := load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := signer.GenerateSoftwareKey(algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.IssueUserCertificate(key.Public(), "tester", "tester@example.com", time.Now().Add(-time.Hour), time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert, c
+}
+
+func TestSignParseVerify(t *testing.T) {
+	content := []byte("待签名的内容")
+	tests := []struct {
+		algo    string
+		digest  string
+		sigName string
+	}{
+		{signer.AlgoRSA, "SHA-256", "RSA"},
+		{signer.AlgoECC, "SHA-256", "ECDSA"},
+		{signer.AlgoSM2, "SM3", "SM2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			key, cert, c := testSigner(t, tt.algo)
+			h := NewDigest(key.Public(), crypto.SHA256)
+			h.Write(content)
+			digest := h.Sum(nil)
+			signingTime := time.Now().Truncate(time.Second)
+			sd, err := Sign(digest, key, cert, c.ChainFor(cert), SignOptions{SigningTime: signingTime})
+			if err != nil {
+				t.Fatal(err)
+			}
+			der, err := sd.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := Parse(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parsed.Signers) != 1 {
+				t.Fatalf("签名者数量 = %d，应为1", len(parsed.Signers))
+			}
+			if len(parsed.Certificates) != 3 {
+				t.Errorf("内嵌证书数量 = %d，应为3（用户证书、签发CA、根CA）", len(parsed.Certificates))
+			}
+			s := parsed.Signers[0]
+			signerCert, err := parsed.SignerCertificate(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !signerCert.Equal(cert) {
+				t.Fatal("签名者证书与签名证书不一致")
+			}
+			if got := HashName(s.DigestAlgorithm); got != tt.digest {
+				t.Errorf("摘要算法 = %s，应为%s", got, tt.digest)
+			}
+			if got := s.AlgorithmName(); got != tt.sigName {
+				t.Errorf("签名算法 = %s，应为%s", got, tt.sigName)
+			}
+			if !s.SigningTime().Equal(signingTime) {
+				t.Errorf("签名时间 = %v，应为%v", s.SigningTime(), signingTime)
+			}
+			if ok, err := s.CheckSigningCertificate(signerCert); err != nil || !ok {
+				t.Errorf("signing-certificate-v2校验失败: %v", err)
+			}
+			// 重新计算内容摘要并验签
+			vh, err := s.NewHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			vh.Write(content)
+			if err := s.VerifyDigest(vh.Sum(nil)); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.VerifySignature(signerCert, digest); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Verify(signerCert, parsed.Certificates, time.Now()); err != nil {
+				t.Fatalf("证书链校验失败: %v", err)
+			}
+			// 内容被篡改时摘要不一致
+			vh.Reset()
+			vh.Write([]byte("被篡改的内容"))
+			if err := s.VerifyDigest(vh.Sum(nil)); err == nil {
+				t.Fatal("篡改内容后摘要校验应失败")
+			}
+			// 签名值被篡改时验签失败
+			s.Signature[len(s.Signature)/2] ^= 0xff
+			if err := s.VerifySignature(signerCert, digest); err == nil {
+				t.Fatal("篡改签名值后验签应失败")
+			}
+		})
+	}
+}
+
+func TestUnsignedAttribute(t *testing.T) {
+	key, cert, _ := testSigner(t, signer.AlgoECC)
+	h := NewDigest(key.Public(), crypto.SHA256)
+	h.Write([]byte("content"))
+	sd, err := Sign(h.Sum(nil), key, cert, nil, SignOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.AddUnsignedAttribute(OIDAttrTimeStampToken, []byte("token")); err != nil {
+		t.Fatal(err)
+	}
+	der, err := sd.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := parsed.Signers[0].UnsignedAttribute(OIDAttrTimeStampToken); !ok {
+		t.Fatal("解析后缺少非签名属性")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err == nil {
+		t.Fatal("非SignedData结构应解析失败")
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// config/admin.go
+// 系统管理员初始化：管理后台（/admin）只允许[User].IsAdmin为1的用户访问，
+// 首个管理员通过环境变量指定，之后可由管理员在后台设置其他管理员
+
+// InitAdmins 将ADMIN_USERS（逗号分隔的用户名）中的用户设为系统管理员，程序启动时在InitDB之后调用
+func InitAdmins() {
+	names := os.Getenv("ADMIN_USERS")
+	if names == "" {
+		return
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		res, err := DB.Exec("UPDATE [User] SET IsAdmin=1 WHERE Username=@p1 AND IsAdmin=0", name)
+		if err != nil {
+			log.Println("设置系统管理员失败:", name, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Println("已设置系统管理员:", name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"crypto"
+	"crypto/x509"
+	"log"
+	"os"
+	"time"
+
+	"signature_sys/ca"
+	"signature_sys/utils"
+)
+
+// config/ca.go
+// 内置证书颁发机构配置与初始化
+// 根CA和签发CA的密钥由服务端保存，用户证书和内置时间戳服务证书均由签发CA签发
+// SM2用户证书由独立的国密CA签发，国密CA只发布CRL，不提供OCSP服务
+
+// CADir 根CA和签发CA证书、私钥的存放目录（位于私钥目录下）
+const CADir = KeysDir + "/ca"
+
+// SM2CADir 国密根CA和签发CA证书、私钥的存放目录
+const SM2CADir = CADir + "/sm2"
+
+// PublicBaseURL 本系统对外访问地址，用于用户证书中的CRL分发点和AIA扩展
+var PublicBaseURL = publicBaseURL()
+
+// CRL每隔CRLInterval重新生成一次，下次更新时间为生成时间加CRLValidity；OCSP响应有效期为OCSPValidity
+const (
+	CRLInterval  = time.Hour
+	CRLValidity  = 24 * time.Hour
+	OCSPValidity = time.Hour
+)
+
+// CA 系统内置证书颁发机构
+var CA *ca.CA
+
+// SM2CA 系统内置国密证书颁发机构
+var SM2CA *ca.CA
+
+// publicBaseURL 读取环境变量PUBLIC_BASE_URL，未设置时使用本地监听地址
+func publicBaseURL() string {
+	if u := os.Getenv("PUBLIC_BASE_URL"); u != "" {
+		return u
+	}
+	return "http://localhost:8080"
+}
+
+// InitCA 加载内置证书颁发机构，首次启动时自动生成根CA和签发CA，程序启动时调用
+func InitCA() {
+	var err error
+	CA, err = ca.LoadOrCreate(CADir)
+	if err != nil {
+		log.Fatal("证书颁发机构初始化失败:", err)
+	}
+	CA.CRLURL = PublicBaseURL + "/ca/crl"
+	CA.OCSPURL = PublicBaseURL + "/ocsp"
+	CA.IssuerURL = PublicBaseURL + "/ca/chain?type=issuing&format=der"
+	log.Println("证书颁发机构已就绪:", CA.Root.Subject.CommonName)
+	SM2CA, err = ca.LoadOrCreateSM2(SM2CADir)
+	if err != nil {
+		log.Fatal("国密证书颁发机构初始化失败:", err)
+	}
+	SM2CA.CRLURL = PublicBaseURL + "/ca/crl?ca=sm2"
+	SM2CA.IssuerURL = PublicBaseURL + "/ca/chain?ca=sm2&type=issuing&format=der"
+	log.Println("国密证书颁发机构已就绪:", SM2CA.Root.Subject.CommonName)
+}
+
+// CAs 返回全部内置证书颁发机构
+func CAs() []*ca.CA {
+	return []*ca.CA{CA, SM2CA}
+}
+
+// CAFor 返回为该公钥签发证书的CA：SM2公钥由国密CA签发，其他由CA签发
+func CAFor(pub crypto.PublicKey) *ca.CA {
+	if utils.IsSM2PublicKey(pub) {
+		return SM2CA
+	}
+	return CA
+}
+
+// ChainFor 返回签名时需要随证书内嵌的CA证书链，cert不是由内置CA签发时返回nil
+func ChainFor(cert *x509.Certificate) []*x509.Certificate {
+	return CAFor(cert.PublicKey).ChainFor(cert)
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"signature_sys/pdfsign"
+)
+
+// config/font.go
+// 可见签名说明文字（签名人、日期、原因、地点）使用的字体配置与初始化
+// 字体需为TrueType轮廓的中文字体（.ttf或.ttc），嵌入PDF时只保留用到的字形
+
+// SignFontPath 环境变量SIGN_FONT指定的字体文件，为空时依次查找defaultFontPaths
+var SignFontPath = os.Getenv("SIGN_FONT")
+
+// 未指定字体时查找的位置：程序目录下的fonts/signature.ttf和常见系统中文字体
+var defaultFontPaths = []string{
+	"fonts/signature.ttf",
+	"C:/Windows/Fonts/simsun.ttc",
+	"C:/Windows/Fonts/simhei.ttf",
+	"C:/Windows/Fonts/msyh.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-microhei.ttc",
+	"/usr/share/fonts/wqy-microhei/wqy-microhei.ttc",
+	"/usr/share/fonts/truetype/arphic/uming.ttc",
+}
+
+// SignFont 可见签名说明文字的字体，未找到字体时为nil，此时可见签名只显示签章图片
+var SignFont *pdfsign.Font
+
+// InitFont 加载可见签名字体，程序启动时调用；找不到字体不影响启动
+func InitFont() {
+	paths := defaultFontPaths
+	if SignFontPath != "" {
+		paths = []string{SignFontPath}
+	}
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		f, err := pdfsign.LoadFont(p)
+		if err != nil {
+			log.Println("可见签名字体加载失败:", p, err)
+			continue
+		}
+		SignFont = f
+		log.Println("可见签名字体已加载:", p)
+		return
+	}
+	log.Println("未找到可见签名字体（可通过环境变量SIGN_FONT指定），可见签名将不显示说明文字")
+}
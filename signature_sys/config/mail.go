@@ -0,0 +1,31 @@
+package config
+
+import (
+	"log"
+	"os"
+
+	"signature_sys/mailer"
+)
+
+// config/mail.go
+// 邮件发送配置与初始化，用于向访客签署人发送签署链接和验证码
+// 设置SMTP_ADDR时通过SMTP服务器发送；未设置时只在日志中输出邮件内容（本地联调可运行cmd/smtpmock）
+
+// Mailer 系统邮件发送器
+var Mailer mailer.Sender = mailer.Log{}
+
+// InitMail 根据环境变量初始化邮件发送器，程序启动时调用
+// SMTP_ADDR：SMTP服务器地址（host:port）；SMTP_FROM：发件人地址；SMTP_USER、SMTP_PASS：认证用户名和密码
+func InitMail() {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		log.Println("未配置SMTP服务器（SMTP_ADDR），邮件内容将输出到日志")
+		return
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "电子签章系统 <noreply@localhost>"
+	}
+	Mailer = &mailer.SMTP{Addr: addr, From: from, Username: os.Getenv("SMTP_USER"), Password: os.Getenv("SMTP_PASS")}
+	log.Println("邮件发送已启用，SMTP服务器:", addr)
+}
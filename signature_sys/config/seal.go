@@ -0,0 +1,34 @@
+package config
+
+import (
+	"log"
+
+	"signature_sys/ses"
+)
+
+// config/seal.go
+// 电子印章（GB/T 38540）制章人配置与初始化
+// 制章人证书由国密签发CA签发，私钥由系统管理员保管在服务端；替换下面两个文件即可使用管理员自己的制章人证书
+
+// 制章人证书和私钥文件位置（位于私钥目录下）
+const (
+	SealMakerDir      = KeysDir + "/sealmaker"
+	SealMakerCertPath = SealMakerDir + "/maker_cert.pem"
+	SealMakerKeyPath  = SealMakerDir + "/maker_private.pem"
+)
+
+// 电子印章图片的默认显示尺寸（毫米）
+const DefaultSealSizeMM = 40
+
+// SealMaker 系统制章人，为用户制作电子印章
+var SealMaker *ses.Maker
+
+// InitSealMaker 加载制章人证书和私钥，首次启动时自动生成，须在InitCA之后调用
+func InitSealMaker() {
+	var err error
+	SealMaker, err = ses.LoadOrCreateMaker(SealMakerCertPath, SealMakerKeyPath, SM2CA.Issuing, SM2CA.IssuingKey)
+	if err != nil {
+		log.Fatal("制章人初始化失败:", err)
+	}
+	log.Println("制章人已就绪:", SealMaker.Certificate.Subject.CommonName)
+}
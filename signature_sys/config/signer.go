@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"signature_sys/signer"
+)
+
+// config/signer.go
+// 签名密钥后端配置与初始化
+// 本地文件后端始终可用；设置PKCS11_MODULE时启用PKCS#11（HSM）后端，设置KMS_URL时启用远程KMS后端
+
+// KeyProviders 已启用的密钥后端，键为后端名称
+var KeyProviders = map[string]signer.Provider{}
+
+// InitSigners 根据环境变量初始化密钥后端，程序启动时调用（需先调用InitStorage）
+// PKCS11_MODULE：PKCS#11模块路径；PKCS11_TOKEN：令牌标签；PKCS11_PIN：令牌用户PIN码
+// KMS_URL：KMS服务地址；KMS_TOKEN：KMS访问令牌
+func InitSigners() {
+	KeyProviders[signer.BackendFile] = &signer.File{Dir: KeysDir}
+	if module := os.Getenv("PKCS11_MODULE"); module != "" {
+		KeyProviders[signer.BackendPKCS11] = &signer.PKCS11{Module: module, TokenLabel: os.Getenv("PKCS11_TOKEN"), PIN: os.Getenv("PKCS11_PIN")}
+		log.Println("已启用PKCS#11密钥后端:", module)
+	}
+	if u := os.Getenv("KMS_URL"); u != "" {
+		KeyProviders[signer.BackendKMS] = &signer.KMS{URL: u, Token: os.Getenv("KMS_TOKEN")}
+		log.Println("已启用KMS密钥后端:", u)
+	}
+}
+
+// KeyProvider 按名称返回密钥后端，名称为空时为本地文件后端
+func KeyProvider(backend string) (signer.Provider, error) {
+	if backend == "" {
+		backend = signer.BackendFile
+	}
+	p, ok := KeyProviders[backend]
+	if !ok {
+		return nil, fmt.Errorf("密钥后端未启用: %s", backend)
+	}
+	return p, nil
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config/storage.go
+// 受保护的文件存储配置与初始化
+// 文档、签章图片和证书保存在storage目录下，不经静态文件服务对外发布，只能通过校验归属的下载接口访问；
+// 用户私钥和服务端密钥（CA、内置时间戳服务、制章人）统一保存在storage/keys目录下（权限0700），
+// 任何HTTP接口都不提供私钥下载
+
+// 受保护存储的各子目录
+const (
+	StorageDir = "storage"
+	DocsDir    = StorageDir + "/docs"  // PDF文档及其各修订版本
+	SealsDir   = StorageDir + "/seals" // 签章图片
+	CertsDir   = StorageDir + "/certs" // 用户证书（公开部分）
+	KeysDir    = StorageDir + "/keys"  // 用户私钥，服务端密钥保存在其子目录中
+)
+
+// legacyKeysDir 早期版本保存服务端密钥的目录
+const legacyKeysDir = "keys"
+
+// serverKeyDirs 服务端密钥目录
+var serverKeyDirs = []string{CADir, TSADir, SealMakerDir}
+
+// legacyDirs 早期版本保存在static目录下的文件及迁移后的目录
+var legacyDirs = []struct{ Old, New, Table string }{
+	{"static/docs", DocsDir, "Document"},
+	{"static/docs", DocsDir, "DocumentVersion"},
+	{"static/seals", SealsDir, "Seal"},
+	{"static/certs", CertsDir, "Cert"},
+}
+
+// PrivateKeyPath 返回证书对应私钥文件的路径
+func PrivateKeyPath(certID string) string {
+	return filepath.Join(KeysDir, certID+"_private.pem")
+}
+
+// InitStorage 创建受保护存储目录，并把早期保存在static和keys目录下的文件迁移过来，程序启动时调用（需先调用InitDB，在InitCA之前）
+func InitStorage() {
+	migrateLegacyKeys()
+	for _, dir := range []string{DocsDir, SealsDir, CertsDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal("创建存储目录失败:", err)
+		}
+	}
+	// 已存在的私钥目录（包括迁移过来的）同样收紧为0700
+	for _, dir := range append([]string{KeysDir}, serverKeyDirs...) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatal("创建私钥目录失败:", err)
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			log.Fatal("设置私钥目录权限失败:", err)
+		}
+	}
+	for _, d := range legacyDirs {
+		migrateLegacyFiles(d.Old, d.New, d.Table)
+	}
+	// 数据库中没有记录的文件（历史预览文件、遗留私钥等）同样移出static目录
+	for _, d := range legacyDirs {
+		sweepLegacyDir(d.Old, d.New)
+	}
+}
+
+// migrateLegacyKeys 把早期保存在keys目录下的服务端密钥移到私钥目录
+// 须在创建新目录之前调用，新目录已存在时保留新目录
+func migrateLegacyKeys() {
+	if err := os.MkdirAll(KeysDir, 0700); err != nil {
+		log.Fatal("创建私钥目录失败:", err)
+	}
+	for _, dir := range serverKeyDirs {
+		old := filepath.Join(legacyKeysDir, filepath.Base(dir))
+		if _, err := os.Stat(old); err != nil {
+			continue
+		}
+		if _, err := os.Stat(dir); err == nil {
+			log.Println("服务端密钥目录已存在，未迁移:", old)
+			continue
+		}
+		if err := os.Rename(old, dir); err != nil {
+			log.Fatal("迁移服务端密钥失败:", err)
+		}
+		log.Println("已迁移服务端密钥:", old, "->", dir)
+	}
+	// 旧目录为空时删除
+	os.Remove(legacyKeysDir)
+}
+
+// sweepLegacyDir 把旧目录中剩余的文件移到新目录，私钥文件移到私钥目录
+func sweepLegacyDir(oldDir, newDir string) {
+	entries, err := os.ReadDir(oldDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		dst := filepath.Join(newDir, e.Name())
+		if strings.HasSuffix(e.Name(), "_private.pem") {
+			dst = filepath.Join(KeysDir, e.Name())
+		}
+		if err := moveFile(filepath.Join(oldDir, e.Name()), dst); err != nil {
+			log.Println("迁移文件失败:", e.Name(), err)
+		}
+	}
+}
+
+// migrateLegacyFiles 移动表中位于旧目录下的文件并更新Location，证书的私钥一并移到私钥目录
+func migrateLegacyFiles(oldDir, newDir, table string) {
+	rows, err := DB.Query("SELECT Location FROM [" + table + "] WHERE REPLACE(Location, '\\', '/') LIKE '" + oldDir + "/%'")
+	if err != nil {
+		log.Println("查询待迁移文件失败:", table, err)
+		return
+	}
+	var locations []string
+	for rows.Next() {
+		var loc string
+		if err := rows.Scan(&loc); err == nil {
+			locations = append(locations, loc)
+		}
+	}
+	rows.Close()
+	for _, loc := range locations {
+		name := filepath.Base(strings.ReplaceAll(loc, "\\", "/"))
+		newPath := filepath.Join(newDir, name)
+		if err := moveFile(loc, newPath); err != nil {
+			log.Println("迁移文件失败:", loc, err)
+			continue
+		}
+		if table == "Cert" {
+			oldKey := strings.TrimSuffix(loc, ".pem") + "_private.pem"
+			if err := moveFile(oldKey, PrivateKeyPath(strings.TrimSuffix(name, ".pem"))); err != nil {
+				log.Println("迁移私钥失败:", oldKey, err)
+				continue
+			}
+		}
+		if _, err := DB.Exec("UPDATE ["+table+"] SET Location=@p1 WHERE Location=@p2", newPath, loc); err != nil {
+			log.Println("更新文件位置失败:", loc, err)
+			continue
+		}
+		log.Println("已迁移文件:", loc, "->", newPath)
+	}
+}
+
+// moveFile 移动文件，目标已存在（例如同一文件被多条记录引用）时只删除源文件
+func moveFile(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Remove(src); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"signature_sys/tsp"
+)
+
+// config/tsa.go
+// 时间戳服务配置与初始化
+// 签名时优先使用环境变量TSA_URL指定的外部时间戳服务，未配置时使用系统内置时间戳服务
+
+// 内置时间戳服务的证书和私钥文件位置（位于私钥目录下，避免被直接访问）
+const (
+	TSADir      = KeysDir + "/tsa"
+	TSACertPath = TSADir + "/tsa_cert.pem"
+	TSAKeyPath  = TSADir + "/tsa_private.pem"
+)
+
+// 已签名文档的归档时间戳续期：每隔ArchiveCheckInterval检查一次，
+// 最新文档时间戳的TSA证书剩余有效期不足ArchiveRenewBefore时，追加验证数据并重新加盖文档时间戳
+const (
+	ArchiveCheckInterval = 24 * time.Hour
+	ArchiveRenewBefore   = 365 * 24 * time.Hour
+)
+
+// TSAURL 外部时间戳服务（RFC 3161）地址，为空时使用内置时间戳服务
+var TSAURL = os.Getenv("TSA_URL")
+
+// TSA 系统内置时间戳服务，供签名和/tsa接口使用
+var TSA *tsp.Authority
+
+// InitTSA 加载内置时间戳服务的专用密钥，首次启动时自动生成并由签发CA签发证书，须在InitCA之后调用
+func InitTSA() {
+	var err error
+	TSA, err = tsp.LoadOrCreateAuthority(TSACertPath, TSAKeyPath, CA.Issuing, CA.IssuingKey)
+	if err != nil {
+		log.Fatal("内置时间戳服务初始化失败:", err)
+	}
+	log.Println("内置时间戳服务已就绪")
+}
+
+// Timestamper 返回签名时使用的时间戳服务
+func Timestamper() tsp.Timestamper {
+	if TSAURL != "" {
+		return &tsp.Client{URL: TSAURL}
+	}
+	return TSA
+}
@@ -4,10 +4,15 @@ go 1.24.0
 
 require (
 	github.com/denisenkom/go-mssqldb v0.12.3
+	github.com/emmansun/gmsm v0.30.1
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/sessions v1.4.0
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/pdfcpu/pdfcpu v0.10.2
+	golang.org/x/crypto v0.37.0
+	golang.org/x/image v0.26.0
+	golang.org/x/text v0.24.0
 )
 
 require (
@@ -20,8 +25,5 @@ require (
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/crypto v0.37.0 // indirect
-	golang.org/x/image v0.26.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -0,0 +1,673 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"signature_sys/ca"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 实现管理后台（/admin）：系统管理员查询、停用和启用用户，查看和吊销全部证书，查看和停用全部签章，按条件浏览签章日志。
+// 路由由middleware.AdminMiddleware保护；管理员的每次操作与操作日志（AdminLog）在同一事务中写入，日志写入失败时操作不生效。
+
+// adminPageSize 管理后台列表每页条数
+const adminPageSize = 50
+
+// errAdminNoop 管理操作没有影响任何记录：对象不存在或已处于目标状态
+var errAdminNoop = errors.New("对象不存在或状态已变更")
+
+// adminFilter 管理后台列表的查询条件，参数按添加顺序编号
+type adminFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+// add 添加一个查询条件，条件中的?均替换为该参数的占位符
+func (f *adminFilter) add(cond string, arg interface{}) {
+	f.args = append(f.args, arg)
+	f.conds = append(f.conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("@p%d", len(f.args))))
+}
+
+// addRaw 添加不带参数的查询条件
+func (f *adminFilter) addRaw(cond string) {
+	f.conds = append(f.conds, cond)
+}
+
+// where 返回WHERE子句，没有条件时为空字符串
+func (f *adminFilter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// paged 返回分页子句和追加了分页参数的参数列表，page从1开始
+func (f *adminFilter) paged(page int) (string, []interface{}) {
+	n := len(f.args)
+	args := append(append([]interface{}{}, f.args...), (page-1)*adminPageSize, adminPageSize)
+	return fmt.Sprintf(" OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY", n+1, n+2), args
+}
+
+// adminPager 列表分页信息，上一页、下一页链接保留当前的查询条件
+type adminPager struct {
+	Page    int
+	Pages   int
+	Total   int
+	PrevURL string // 上一页链接，没有上一页时为空
+	NextURL string // 下一页链接，没有下一页时为空
+}
+
+// adminPage 读取请求中的页码，非法时为第1页
+func adminPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// newAdminPager 根据总条数生成分页信息
+func newAdminPager(r *http.Request, page, total int) adminPager {
+	p := adminPager{Page: page, Total: total, Pages: (total + adminPageSize - 1) / adminPageSize}
+	link := func(n int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		return r.URL.Path + "?" + q.Encode()
+	}
+	if page > 1 {
+		p.PrevURL = link(page - 1)
+	}
+	if page < p.Pages {
+		p.NextURL = link(page + 1)
+	}
+	return p
+}
+
+// adminBack 操作完成后返回的列表页面：表单中的back（须为管理后台页面），否则为默认页面
+func adminBack(r *http.Request, fallback string) string {
+	back := r.FormValue("back")
+	if u, err := url.Parse(back); err == nil && u.Host == "" && u.Scheme == "" && strings.HasPrefix(u.Path, "/admin") {
+		return back
+	}
+	return fallback
+}
+
+// adminAction 在一个事务中执行管理操作并写入操作日志；apply返回受影响的记录数，为0时返回errAdminNoop
+func adminAction(adminID, action, targetType, targetID, detail string, apply func(tx *sql.Tx) (int64, error)) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	n, err := apply(tx)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errAdminNoop
+	}
+	if _, err := tx.Exec("INSERT INTO [AdminLog] (AdminID, Action, TargetType, TargetID, Detail, CreatedAt) VALUES (@p1, @p2, @p3, @p4, @p5, GETDATE())",
+		adminID, action, targetType, targetID, detail); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Println("[AdminLog]", adminID, action, targetType, targetID, detail)
+	return nil
+}
+
+// adminActionError 返回管理操作失败的响应
+func adminActionError(w http.ResponseWriter, handler string, err error) {
+	if errors.Is(err, errAdminNoop) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	fmt.Println("["+handler+"] 操作失败:", err)
+	http.Error(w, "数据库更新失败", 500)
+}
+
+// renderAdmin 渲染管理后台页面
+func renderAdmin(w http.ResponseWriter, name string, data map[string]interface{}) {
+	t, err := template.ParseFiles("templates/" + name)
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, data)
+}
+
+// adminLogFrom 操作日志查询的表和关联，附带管理员用户名和操作对象名称
+const adminLogFrom = ` FROM [AdminLog] l JOIN [User] a ON l.AdminID=a.UserID
+    LEFT JOIN [User] tu ON l.TargetType='user' AND l.TargetID=tu.UserID
+    LEFT JOIN [Cert] tc ON l.TargetType='cert' AND l.TargetID=tc.CertID
+    LEFT JOIN [Seal] ts ON l.TargetType='seal' AND l.TargetID=ts.SealID`
+
+// queryAdminLogs 按条件查询操作日志的第page页，按时间倒序
+func queryAdminLogs(f *adminFilter, page int) []models.AdminLog {
+	paging, args := f.paged(page)
+	rows, err := config.DB.Query(`SELECT l.LogID, a.Username, l.Action, l.TargetType, l.TargetID,
+    ISNULL(COALESCE(tu.Username, tc.SerialNumber, NULLIF(ts.SealName, ''), ts.OriginalName), ''), ISNULL(l.Detail, ''), l.CreatedAt`+
+		adminLogFrom+f.where()+" ORDER BY l.LogID DESC"+paging, args...)
+	if err != nil {
+		fmt.Println("查询操作日志失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var logs []models.AdminLog
+	for rows.Next() {
+		var l models.AdminLog
+		var t sql.NullTime
+		if err := rows.Scan(&l.LogID, &l.AdminName, &l.Action, &l.TargetType, &l.TargetID, &l.TargetName, &l.Detail, &t); err != nil {
+			fmt.Println("扫描操作日志失败:", err)
+			continue
+		}
+		l.Time = t.Time
+		logs = append(logs, l)
+	}
+	return logs
+}
+
+// adminCount 按条件统计记录数
+func adminCount(from string, f *adminFilter) int {
+	var n int
+	if err := config.DB.QueryRow("SELECT COUNT(*)"+from+f.where(), f.args...).Scan(&n); err != nil {
+		fmt.Println("统计记录数失败:", err)
+	}
+	return n
+}
+
+// AdminIndexHandler 管理概览：用户、证书、签章和今日签章的统计，以及最近的管理操作
+func AdminIndexHandler(w http.ResponseWriter, r *http.Request) {
+	var stats struct {
+		Users, DisabledUsers, Admins int
+		Certs, RevokedCerts          int
+		Seals, RetiredSeals          int
+		SignsToday                   int
+	}
+	config.DB.QueryRow("SELECT COUNT(*), ISNULL(SUM(CASE WHEN DisabledAt IS NULL THEN 0 ELSE 1 END), 0), ISNULL(SUM(CASE WHEN IsAdmin=1 THEN 1 ELSE 0 END), 0) FROM [User]").
+		Scan(&stats.Users, &stats.DisabledUsers, &stats.Admins)
+	config.DB.QueryRow("SELECT COUNT(*), ISNULL(SUM(CASE WHEN RevokedAt IS NULL THEN 0 ELSE 1 END), 0) FROM [Cert]").Scan(&stats.Certs, &stats.RevokedCerts)
+	config.DB.QueryRow("SELECT COUNT(*), ISNULL(SUM(CASE WHEN RetiredAt IS NULL THEN 0 ELSE 1 END), 0) FROM [Seal]").Scan(&stats.Seals, &stats.RetiredSeals)
+	config.DB.QueryRow("SELECT COUNT(*) FROM [SignLog] WHERE ISNULL(Action, @p1)=@p1 AND SignTime>=CAST(GETDATE() AS date)", models.ActionSign).Scan(&stats.SignsToday)
+	logs := queryAdminLogs(&adminFilter{}, 1)
+	if len(logs) > 10 {
+		logs = logs[:10]
+	}
+	renderAdmin(w, "admin_index.html", map[string]interface{}{"Stats": stats, "Logs": logs})
+}
+
+// adminUserRow 用户管理列表的一行
+type adminUserRow struct {
+	models.User
+	Certs int // 未吊销的证书数
+	Seals int // 签章数
+	Orgs  int // 所在组织数
+}
+
+// AdminUserListHandler 用户管理：按用户名或邮箱搜索，按状态筛选
+func AdminUserListHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	status := r.URL.Query().Get("status")
+	f := &adminFilter{}
+	if q != "" {
+		f.add("(u.Username LIKE ? OR u.Email LIKE ?)", "%"+q+"%")
+	}
+	switch status {
+	case "active":
+		f.addRaw("u.DisabledAt IS NULL")
+	case "disabled":
+		f.addRaw("u.DisabledAt IS NOT NULL")
+	case "admin":
+		f.addRaw("u.IsAdmin=1")
+	}
+	const from = " FROM [User] u"
+	page := adminPage(r)
+	paging, args := f.paged(page)
+	rows, err := config.DB.Query(`SELECT u.UserID, u.Username, ISNULL(u.Email, ''), u.IsAdmin, u.CreatedAt, u.DisabledAt, ISNULL(u.DisabledReason, ''),
+    (SELECT COUNT(*) FROM [Cert] c WHERE c.UserID=u.UserID AND c.RevokedAt IS NULL),
+    (SELECT COUNT(*) FROM [Seal] s WHERE s.UserID=u.UserID),
+    (SELECT COUNT(*) FROM [OrgMember] m WHERE m.UserID=u.UserID)`+from+f.where()+" ORDER BY u.Username"+paging, args...)
+	if err != nil {
+		fmt.Println("[AdminUserListHandler] 查询用户失败:", err)
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	defer rows.Close()
+	var users []adminUserRow
+	for rows.Next() {
+		var u adminUserRow
+		var createdAt, disabledAt sql.NullTime
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Email, &u.IsAdmin, &createdAt, &disabledAt, &u.DisabledReason, &u.Certs, &u.Seals, &u.Orgs); err != nil {
+			fmt.Println("[AdminUserListHandler] 扫描用户失败:", err)
+			continue
+		}
+		u.CreatedAt = createdAt.Time
+		if disabledAt.Valid {
+			u.DisabledAt = &disabledAt.Time
+		}
+		users = append(users, u)
+	}
+	renderAdmin(w, "admin_users.html", map[string]interface{}{
+		"Users":  users,
+		"Query":  q,
+		"Status": status,
+		"Self":   adminID,
+		"Back":   r.URL.RequestURI(),
+		"Pager":  newAdminPager(r, page, adminCount(from, f)),
+	})
+}
+
+// AdminUserDisableHandler 停用用户（仅POST）：停用后不能登录，已登录的会话立即失效，须填写停用原因
+func AdminUserDisableHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	userID := r.FormValue("user_id")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if userID == "" || reason == "" || len([]rune(reason)) > 255 {
+		http.Error(w, "请填写停用原因（不超过255个字符）", 400)
+		return
+	}
+	if userID == adminID {
+		http.Error(w, "不能停用自己", 400)
+		return
+	}
+	err := adminAction(adminID, models.AdminUserDisable, models.AdminTargetUser, userID, reason, func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec("UPDATE [User] SET DisabledAt=GETDATE(), DisabledReason=@p1 WHERE UserID=@p2 AND DisabledAt IS NULL", reason, userID)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+	if err != nil {
+		adminActionError(w, "AdminUserDisableHandler", err)
+		return
+	}
+	http.Redirect(w, r, adminBack(r, "/admin/users"), http.StatusSeeOther)
+}
+
+// AdminUserEnableHandler 启用已停用的用户（仅POST）
+func AdminUserEnableHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	userID := r.FormValue("user_id")
+	err := adminAction(adminID, models.AdminUserEnable, models.AdminTargetUser, userID, "", func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec("UPDATE [User] SET DisabledAt=NULL, DisabledReason=NULL WHERE UserID=@p1 AND DisabledAt IS NOT NULL", userID)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+	if err != nil {
+		adminActionError(w, "AdminUserEnableHandler", err)
+		return
+	}
+	http.Redirect(w, r, adminBack(r, "/admin/users"), http.StatusSeeOther)
+}
+
+// AdminUserRoleHandler 设置或取消系统管理员（仅POST），不能修改自己的管理员身份
+func AdminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	userID := r.FormValue("user_id")
+	if userID == adminID {
+		http.Error(w, "不能修改自己的管理员身份", 400)
+		return
+	}
+	action, query := models.AdminUserRevokeAdmin, "UPDATE [User] SET IsAdmin=0 WHERE UserID=@p1 AND IsAdmin=1"
+	if r.FormValue("admin") == "1" {
+		action, query = models.AdminUserGrantAdmin, "UPDATE [User] SET IsAdmin=1 WHERE UserID=@p1 AND IsAdmin=0 AND DisabledAt IS NULL"
+	}
+	err := adminAction(adminID, action, models.AdminTargetUser, userID, "", func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec(query, userID)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+	if err != nil {
+		adminActionError(w, "AdminUserRoleHandler", err)
+		return
+	}
+	http.Redirect(w, r, adminBack(r, "/admin/users"), http.StatusSeeOther)
+}
+
+// adminCertRow 证书管理列表的一行
+type adminCertRow struct {
+	models.Cert
+	Username string // 所属用户名（组织证书为申请人）
+	Expired  bool   // 是否已过期
+}
+
+// AdminCertListHandler 证书管理：全部证书，按用户名、组织名称、证书ID或序列号搜索，按状态和算法筛选
+func AdminCertListHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	status := r.URL.Query().Get("status")
+	algo := r.URL.Query().Get("algo")
+	f := &adminFilter{}
+	if q != "" {
+		f.add("(u.Username LIKE ? OR o.Name LIKE ? OR c.CertID LIKE ? OR c.SerialNumber LIKE ?)", "%"+q+"%")
+	}
+	switch status {
+	case "valid":
+		f.addRaw("c.RevokedAt IS NULL AND c.ValidTo>GETDATE()")
+	case "revoked":
+		f.addRaw("c.RevokedAt IS NOT NULL")
+	case "expired":
+		f.addRaw("c.RevokedAt IS NULL AND c.ValidTo<=GETDATE()")
+	}
+	if algo != "" {
+		f.add("c.Algo=?", algo)
+	}
+	const from = " FROM [Cert] c LEFT JOIN [User] u ON c.UserID=u.UserID LEFT JOIN [Organization] o ON c.OrgID=o.OrgID"
+	page := adminPage(r)
+	paging, args := f.paged(page)
+	rows, err := config.DB.Query(`SELECT c.CertID, ISNULL(u.Username, ''), ISNULL(o.Name, ''), ISNULL(c.IssuerDN, ''), c.ValidFrom, c.ValidTo, ISNULL(c.Algo, ''),
+    ISNULL(c.SerialNumber, ''), c.RevokedAt, ISNULL(c.RevocationReason, 0), ISNULL(c.KeyBackend, 'file')`+from+f.where()+" ORDER BY c.ValidFrom DESC"+paging, args...)
+	if err != nil {
+		fmt.Println("[AdminCertListHandler] 查询证书失败:", err)
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	defer rows.Close()
+	now := time.Now()
+	var certs []adminCertRow
+	for rows.Next() {
+		var c adminCertRow
+		var validFrom, validTo, revokedAt sql.NullTime
+		if err := rows.Scan(&c.CertID, &c.Username, &c.OrgName, &c.IssuerDN, &validFrom, &validTo, &c.Algo, &c.SerialNumber, &revokedAt, &c.RevocationReason, &c.KeyBackend); err != nil {
+			fmt.Println("[AdminCertListHandler] 扫描证书失败:", err)
+			continue
+		}
+		c.ValidFrom, c.ValidTo = validFrom.Time, validTo.Time
+		if revokedAt.Valid {
+			c.RevokedAt = &revokedAt.Time
+		}
+		c.Expired = !c.ValidTo.After(now)
+		c.KeyBackend = backendNames[c.KeyBackend]
+		certs = append(certs, c)
+	}
+	renderAdmin(w, "admin_certs.html", map[string]interface{}{
+		"Certs":   certs,
+		"Query":   q,
+		"Status":  status,
+		"Algo":    algo,
+		"Reasons": revocationReasons,
+		"Back":    r.URL.RequestURI(),
+		"Pager":   newAdminPager(r, page, adminCount(from, f)),
+	})
+}
+
+// AdminCertDownloadHandler 下载任意证书（PEM格式，仅含公钥）
+func AdminCertDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	certID := r.URL.Query().Get("cert_id")
+	var certPath string
+	if err := config.DB.QueryRow("SELECT Location FROM [Cert] WHERE CertID=@p1", certID).Scan(&certPath); err != nil {
+		http.Error(w, "未找到证书", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", "attachment; filename="+certID+".pem")
+	http.ServeFile(w, r, certPath)
+}
+
+// AdminCertRevokeHandler 吊销任意证书（仅POST），吊销后立即重新生成CRL
+func AdminCertRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	certID := r.FormValue("cert_id")
+	reason, err := strconv.Atoi(r.FormValue("reason"))
+	valid := false
+	for _, rr := range revocationReasons {
+		valid = valid || rr.Code == reason
+	}
+	if certID == "" || err != nil || !valid {
+		http.Error(w, "参数错误", 400)
+		return
+	}
+	err = adminAction(adminID, models.AdminCertRevoke, models.AdminTargetCert, certID, ca.ReasonName(reason), func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec("UPDATE [Cert] SET RevokedAt=GETDATE(), RevocationReason=@p1 WHERE CertID=@p2 AND RevokedAt IS NULL", reason, certID)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+	if err != nil {
+		adminActionError(w, "AdminCertRevokeHandler", err)
+		return
+	}
+	// 立即更新CRL，OCSP直接查询证书库无需更新
+	refreshCRL()
+	http.Redirect(w, r, adminBack(r, "/admin/certs"), http.StatusSeeOther)
+}
+
+// adminSealRow 签章管理列表的一行
+type adminSealRow struct {
+	SealID       string
+	Name         string       // 印章名称或原始文件名
+	SealType     int          // 印章类型，早期上传的图片为0
+	HasSES       bool         // 是否已制作电子印章
+	ValidEnd     sql.NullTime // 电子印章有效期截止
+	Username     string       // 上传者用户名
+	OrgName      string       // 所属组织名称，个人签章为空
+	ActiveGrants int          // 未撤销的用印授权数
+	SignCount    int          // 签章次数
+	RetiredAt    sql.NullTime // 停用时间
+}
+
+// AdminSealListHandler 签章管理：全部签章，按签章名称、上传者或组织名称搜索，按状态筛选
+func AdminSealListHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	status := r.URL.Query().Get("status")
+	f := &adminFilter{}
+	if q != "" {
+		f.add("(s.SealName LIKE ? OR s.OriginalName LIKE ? OR u.Username LIKE ? OR o.Name LIKE ?)", "%"+q+"%")
+	}
+	switch status {
+	case "active":
+		f.addRaw("s.RetiredAt IS NULL")
+	case "retired":
+		f.addRaw("s.RetiredAt IS NOT NULL")
+	}
+	const from = " FROM [Seal] s LEFT JOIN [User] u ON s.UserID=u.UserID LEFT JOIN [Organization] o ON s.OrgID=o.OrgID"
+	page := adminPage(r)
+	paging, args := f.paged(page)
+	rows, err := config.DB.Query(`SELECT s.SealID, ISNULL(NULLIF(s.SealName, ''), ISNULL(s.OriginalName, '')), ISNULL(s.SealType, 0), ISNULL(s.SESLocation, ''), s.ValidEnd,
+    ISNULL(u.Username, ''), ISNULL(o.Name, ''),
+    (SELECT COUNT(*) FROM [SealGrant] g WHERE g.SealID=s.SealID AND g.RevokedAt IS NULL),
+    (SELECT COUNT(*) FROM [SignLog] l WHERE l.SealID=s.SealID), s.RetiredAt`+from+f.where()+" ORDER BY u.Username, s.SealID"+paging, args...)
+	if err != nil {
+		fmt.Println("[AdminSealListHandler] 查询签章失败:", err)
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	defer rows.Close()
+	var seals []adminSealRow
+	for rows.Next() {
+		var s adminSealRow
+		var sesPath string
+		if err := rows.Scan(&s.SealID, &s.Name, &s.SealType, &sesPath, &s.ValidEnd, &s.Username, &s.OrgName, &s.ActiveGrants, &s.SignCount, &s.RetiredAt); err != nil {
+			fmt.Println("[AdminSealListHandler] 扫描签章失败:", err)
+			continue
+		}
+		s.HasSES = sesPath != ""
+		seals = append(seals, s)
+	}
+	renderAdmin(w, "admin_seals.html", map[string]interface{}{
+		"Seals":  seals,
+		"Query":  q,
+		"Status": status,
+		"Back":   r.URL.RequestURI(),
+		"Pager":  newAdminPager(r, page, adminCount(from, f)),
+	})
+}
+
+// AdminSealImageHandler 查看任意签章图片
+func AdminSealImageHandler(w http.ResponseWriter, r *http.Request) {
+	var imgPath string
+	if err := config.DB.QueryRow("SELECT Location FROM [Seal] WHERE SealID=@p1", r.URL.Query().Get("seal_id")).Scan(&imgPath); err != nil {
+		http.Error(w, "未找到图片", 404)
+		return
+	}
+	http.ServeFile(w, r, imgPath)
+}
+
+// AdminSealRetireHandler 停用签章（仅POST）：停用后任何人不能再用该签章盖章，
+// 其上的用印授权一并撤销、未使用的用印申请一并撤回；签章记录和文件保留，供签章日志追溯
+func AdminSealRetireHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, _ := middleware.GetCurrentUser(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	sealID := r.FormValue("seal_id")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if sealID == "" || reason == "" || len([]rune(reason)) > 255 {
+		http.Error(w, "请填写停用原因（不超过255个字符）", 400)
+		return
+	}
+	err := adminAction(adminID, models.AdminSealRetire, models.AdminTargetSeal, sealID, reason, func(tx *sql.Tx) (int64, error) {
+		res, err := tx.Exec("UPDATE [Seal] SET RetiredAt=GETDATE() WHERE SealID=@p1 AND RetiredAt IS NULL", sealID)
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return n, err
+		}
+		if _, err := tx.Exec("UPDATE [SealRequest] SET Status=@p1, Comment=@p2, DecidedAt=GETDATE() WHERE Status IN ('pending', 'approved') AND GrantID IN (SELECT GrantID FROM [SealGrant] WHERE SealID=@p3)",
+			models.SealRequestCancelled, "签章已停用", sealID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE [SealGrant] SET RevokedAt=GETDATE() WHERE SealID=@p1 AND RevokedAt IS NULL", sealID); err != nil {
+			return 0, err
+		}
+		return n, nil
+	})
+	if err != nil {
+		adminActionError(w, "AdminSealRetireHandler", err)
+		return
+	}
+	http.Redirect(w, r, adminBack(r, "/admin/seals"), http.StatusSeeOther)
+}
+
+// adminSignLogFrom 签章日志查询的表和关联，访客签署人以访客姓名显示
+const adminSignLogFrom = ` FROM [SignLog] l LEFT JOIN [User] u ON l.UserID=u.UserID LEFT JOIN [Document] d ON l.DocID=d.DocID
+    LEFT JOIN [Seal] s ON l.SealID=s.SealID LEFT JOIN [EnvelopeSigner] g ON l.SignerID=g.SignerID AND l.UserID IS NULL`
+
+// AdminSignLogHandler 签章日志：按操作人、文档、签章、证书、信封、操作和时间范围筛选，按时间倒序分页
+func AdminSignLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f := &adminFilter{}
+	if v := strings.TrimSpace(query.Get("user")); v != "" {
+		f.add("(u.Username LIKE ? OR g.GuestName LIKE ?)", "%"+v+"%")
+	}
+	if v := strings.TrimSpace(query.Get("doc")); v != "" {
+		f.add("(l.DocID=? OR d.OriginalName LIKE '%' + ? + '%')", v)
+	}
+	for _, p := range []struct{ Param, Column string }{
+		{"seal_id", "l.SealID"}, {"cert_id", "l.CertID"}, {"envelope_id", "l.EnvelopeID"}, {"grant_id", "l.GrantID"},
+	} {
+		if v := strings.TrimSpace(query.Get(p.Param)); v != "" {
+			f.add(p.Column+"=?", v)
+		}
+	}
+	// 早期的签章日志没有记录操作，均为签章签名
+	if v := query.Get("action"); v != "" {
+		f.add("ISNULL(l.Action, '"+models.ActionSign+"')=?", v)
+	}
+	if v, err := time.ParseInLocation("2006-01-02", query.Get("from"), time.Local); err == nil {
+		f.add("l.SignTime>=?", v)
+	}
+	if v, err := time.ParseInLocation("2006-01-02", query.Get("to"), time.Local); err == nil {
+		f.add("l.SignTime<?", v.AddDate(0, 0, 1))
+	}
+	page := adminPage(r)
+	paging, args := f.paged(page)
+	rows, err := config.DB.Query(`SELECT l.LogID, ISNULL(u.Username, ISNULL(g.GuestName + '（访客）', '')), ISNULL(l.DocID, ''), ISNULL(d.OriginalName, ''),
+    ISNULL(l.Action, '`+models.ActionSign+`'), ISNULL(l.Detail, ''), l.SignTime, ISNULL(l.SealID, ''), ISNULL(NULLIF(s.SealName, ''), ISNULL(s.OriginalName, '')),
+    ISNULL(l.CertID, ''), ISNULL(l.SignAlgorithm, ''), ISNULL(l.StampMode, ''), ISNULL(l.EnvelopeID, ''), ISNULL(l.GrantID, '')`+
+		adminSignLogFrom+f.where()+" ORDER BY l.LogID DESC"+paging, args...)
+	if err != nil {
+		fmt.Println("[AdminSignLogHandler] 查询签章日志失败:", err)
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	defer rows.Close()
+	var logs []models.SignLogEntry
+	for rows.Next() {
+		var l models.SignLogEntry
+		var t sql.NullTime
+		if err := rows.Scan(&l.LogID, &l.Username, &l.DocID, &l.DocName, &l.Action, &l.Detail, &t, &l.SealID, &l.SealName,
+			&l.CertID, &l.Algorithm, &l.StampMode, &l.EnvelopeID, &l.GrantID); err != nil {
+			fmt.Println("[AdminSignLogHandler] 扫描签章日志失败:", err)
+			continue
+		}
+		l.Time = t.Time
+		logs = append(logs, l)
+	}
+	type actionOption struct{ Value, Name string }
+	var actions []actionOption
+	for _, a := range []string{models.ActionSign, models.ActionCreate, models.ActionSend, models.ActionDecline, models.ActionExpire, models.ActionComplete} {
+		actions = append(actions, actionOption{a, models.AuditEntry{Action: a}.ActionName()})
+	}
+	renderAdmin(w, "admin_signlog.html", map[string]interface{}{
+		"Logs":    logs,
+		"Filter":  query,
+		"Actions": actions,
+		"Pager":   newAdminPager(r, page, adminCount(adminSignLogFrom, f)),
+	})
+}
+
+// AdminLogHandler 操作日志：按管理员、对象类型、对象ID和操作筛选，按时间倒序分页
+func AdminLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	f := &adminFilter{}
+	if v := strings.TrimSpace(query.Get("admin")); v != "" {
+		f.add("a.Username LIKE ?", "%"+v+"%")
+	}
+	if v := query.Get("target_type"); v != "" {
+		f.add("l.TargetType=?", v)
+	}
+	if v := strings.TrimSpace(query.Get("target_id")); v != "" {
+		f.add("l.TargetID=?", v)
+	}
+	if v := query.Get("action"); v != "" {
+		f.add("l.Action=?", v)
+	}
+	page := adminPage(r)
+	type actionOption struct{ Value, Name string }
+	var actions []actionOption
+	for _, a := range []string{models.AdminUserDisable, models.AdminUserEnable, models.AdminUserGrantAdmin, models.AdminUserRevokeAdmin, models.AdminCertRevoke, models.AdminSealRetire} {
+		actions = append(actions, actionOption{a, models.AdminLog{Action: a}.ActionName()})
+	}
+	renderAdmin(w, "admin_log.html", map[string]interface{}{
+		"Logs":    queryAdminLogs(f, page),
+		"Filter":  query,
+		"Actions": actions,
+		"Pager":   newAdminPager(r, page, adminCount(adminLogFrom, f)),
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"signature_sys/config"
+	"signature_sys/models"
+	"signature_sys/pdfsign"
+	"time"
+)
+
+// 实现已签名文档的归档时间戳续期任务。
+// 文档时间戳的证明力依赖TSA证书，证书到期前需追加最新的验证数据并重新加盖文档时间戳，
+// 形成时间戳链，使签名在签名证书过期多年后仍可验证。
+
+// StartArchiveJob 启动归档时间戳续期的后台任务，程序启动时调用
+func StartArchiveJob() {
+	go func() {
+		for {
+			renewArchiveTimestamps()
+			time.Sleep(config.ArchiveCheckInterval)
+		}
+	}()
+}
+
+// renewArchiveTimestamps 检查全部已签名的PDF文档，为需要续期的文档加盖新的文档时间戳
+// OFD文档没有PAdES文档时间戳，不参与续期
+func renewArchiveTimestamps() {
+	rows, err := config.DB.Query(`SELECT d.DocID, d.Location FROM [Document] d WHERE EXISTS
+    (SELECT 1 FROM [DocumentVersion] v WHERE v.DocID=d.DocID AND v.Operation IN ('sign', 'timestamp'))`)
+	if err != nil {
+		fmt.Println("[ArchiveJob] 查询已签名文档失败:", err)
+		return
+	}
+	var docs []struct{ DocID, Location string }
+	for rows.Next() {
+		var d struct{ DocID, Location string }
+		if err := rows.Scan(&d.DocID, &d.Location); err == nil && !isOFDDocument(d.Location) {
+			docs = append(docs, d)
+		}
+	}
+	rows.Close()
+	renewed := 0
+	for _, d := range docs {
+		ok, err := renewArchiveTimestamp(d.DocID, d.Location)
+		if err != nil {
+			fmt.Println("[ArchiveJob] 文档时间戳续期失败:", d.DocID, err)
+			continue
+		}
+		if ok {
+			renewed++
+		}
+	}
+	fmt.Printf("[ArchiveJob] 检查已签名PDF文档%d个，续期%d个\n", len(docs), renewed)
+}
+
+// renewArchiveTimestamp 需要时为文档追加验证数据和文档时间戳，生成新的文档版本
+func renewArchiveTimestamp(docID, location string) (bool, error) {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return false, err
+	}
+	report, err := pdfsign.Verify(data)
+	if err != nil {
+		return false, err
+	}
+	if !needsArchiveRenewal(docID, report) {
+		return false, nil
+	}
+	ltv, err := pdfsign.AddLTV(data, pdfsign.LTVOptions{Timestamper: config.Timestamper(), Revocation: revocationSource(), Certificates: append(config.CA.Chain(), config.SM2CA.Chain()...)})
+	if err != nil {
+		return false, err
+	}
+	for _, w := range ltv.Warnings {
+		fmt.Println("[ArchiveJob] 提示:", docID, w)
+	}
+	newPath := filepath.Join(filepath.Dir(location), fmt.Sprintf("%s_%d_LTA.pdf", docID, time.Now().UnixNano()))
+	if err := os.WriteFile(newPath, ltv.PDF, 0644); err != nil {
+		return false, err
+	}
+	hashSum := sha256.Sum256(ltv.PDF)
+	fileHash := hex.EncodeToString(hashSum[:])
+	// 与签名相同，仅当文档在此期间未被签署时更新，否则留到下一轮
+	res, err := config.DB.Exec("UPDATE [Document] SET FileHash=@p1, Location=@p2 WHERE DocID=@p3 AND Location=@p4", fileHash, newPath, docID, location)
+	if err != nil {
+		os.Remove(newPath)
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		os.Remove(newPath)
+		return false, nil
+	}
+	if _, err := addDocumentVersion(config.DB, docID, latestDocumentVersionID(config.DB, docID), newPath, fileHash, models.VersionOpTimestamp, ""); err != nil {
+		fmt.Println("[ArchiveJob] 文档版本写入失败:", err)
+	}
+	fmt.Println("[ArchiveJob] 已续期文档时间戳:", docID, ltv.Timestamp)
+	return true, nil
+}
+
+// needsArchiveRenewal 判断文档是否需要重新加盖文档时间戳：
+// 文档的签名均完整，且没有覆盖全部签名的有效文档时间戳，或最新文档时间戳的TSA证书即将到期
+func needsArchiveRenewal(docID string, report *pdfsign.Report) bool {
+	if len(report.Signatures) == 0 {
+		return false
+	}
+	var latest *pdfsign.SignatureInfo
+	for _, sig := range report.Signatures {
+		if !sig.Valid() {
+			fmt.Println("[ArchiveJob] 文档存在无效签名，跳过:", docID, sig.FieldName)
+			return false
+		}
+		if sig.DocTimestamp && (latest == nil || sig.ByteRange[2]+sig.ByteRange[3] > latest.ByteRange[2]+latest.ByteRange[3]) {
+			latest = sig
+		}
+	}
+	if latest == nil || latest.Signer == nil || !latest.CoversWholeFile {
+		return true
+	}
+	if time.Until(latest.Signer.NotAfter) >= config.ArchiveRenewBefore {
+		return false
+	}
+	// 续期使用的TSA证书本身即将到期时，重复加盖时间戳无法延长有效期
+	if config.TSAURL == "" && config.TSA != nil && latest.Signer.Equal(config.TSA.Certificate) {
+		fmt.Println("[ArchiveJob] 内置时间戳服务证书即将到期，请更换时间戳证书:", config.TSA.Certificate.NotAfter)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"signature_sys/config"
+)
+
+// 实现内置证书颁发机构相关的HTTP处理逻辑。
+
+// CAChainHandler 下载CA证书链（PEM格式，签发CA在前，根CA在后）
+// type=root时只返回根CA证书，用于导入为信任锚；type=issuing时只返回签发CA证书
+// 只返回单个证书时可用format=der获取DER编码（用户证书AIA扩展中的颁发者证书地址）
+// ca=sm2时返回国密CA的证书
+func CAChainHandler(w http.ResponseWriter, r *http.Request) {
+	authority := config.CA
+	prefix := "signature_sys_"
+	if r.URL.Query().Get("ca") == "sm2" {
+		authority = config.SM2CA
+		prefix = "signature_sys_sm2_"
+	}
+	certs := authority.Chain()
+	filename := prefix + "ca_chain"
+	switch r.URL.Query().Get("type") {
+	case "root":
+		certs = []*x509.Certificate{authority.Root}
+		filename = prefix + "root_ca"
+	case "issuing":
+		certs = []*x509.Certificate{authority.Issuing}
+		filename = prefix + "issuing_ca"
+	}
+	if len(certs) == 1 && r.URL.Query().Get("format") == "der" {
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Header().Set("Content-Disposition", "attachment; filename="+filename+".cer")
+		w.Write(certs[0].Raw)
+		return
+	}
+	filename += ".pem"
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	for _, c := range certs {
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"signature_sys/ca"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/signer"
+	"signature_sys/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/google/uuid"
+)
+
+// 实现用户证书管理的HTTP处理逻辑，包括证书列表展示、证书申请、证书吊销，
+// 以及在各密钥后端（本地文件、HSM、KMS）中生成签名密钥并签发证书。
+
+// backendNames 密钥后端的中文名称
+var backendNames = map[string]string{
+	signer.BackendFile:   "本地加密文件",
+	signer.BackendPKCS11: "HSM（PKCS#11）",
+	signer.BackendKMS:    "KMS",
+}
+
+// issueCertificate 在指定密钥后端中生成签名密钥，由签发CA（SM2密钥为国密签发CA）签发5年有效期的证书，保存证书文件并写入证书表，返回证书ID
+// orgID不为空时为组织证书，证书主体名称为组织名称，按成员角色授权使用
+func issueCertificate(userID, orgID, name, email, backend, algo, pin string) (string, error) {
+	provider, err := config.KeyProvider(backend)
+	if err != nil {
+		return "", err
+	}
+	certID := uuid.New().String()
+	// 密钥标签使用证书ID，本地文件后端的私钥文件为<证书ID>_private.pem
+	keyRef, pub, err := provider.GenerateKey(algo, certID, pin)
+	if err != nil {
+		return "", err
+	}
+	validFrom := time.Now()
+	cert, err := config.CAFor(pub).IssueUserCertificate(pub, name, email, validFrom, validFrom.AddDate(5, 0, 0))
+	if err != nil {
+		return "", err
+	}
+	certPath := filepath.Join(config.CertsDir, certID+".pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		return "", err
+	}
+	pubDER, err := smx509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	org := sql.NullString{String: orgID, Valid: orgID != ""}
+	_, err = config.DB.Exec("INSERT INTO [Cert] (CertID, UserID, Location, IssuerDN, ValidFrom, ValidTo, PublicKey, Algo, SerialNumber, KeyBackend, KeyRef, OrgID) VALUES (@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,@p11,@p12)",
+		certID, userID, certPath, cert.Issuer.String(), cert.NotBefore, cert.NotAfter, pubPEM, algo, strings.ToUpper(cert.SerialNumber.Text(16)), provider.Name(), keyRef, org)
+	if err != nil {
+		return "", err
+	}
+	return certID, nil
+}
+
+// certKeyRef 返回证书的密钥引用，早期证书没有记录密钥引用，私钥为私钥目录下的文件
+func certKeyRef(certID, keyRef string) string {
+	if keyRef == "" {
+		return config.PrivateKeyPath(certID)
+	}
+	return keyRef
+}
+
+// openCertSigner 打开证书对应的签名密钥，用完后须调用Close
+func openCertSigner(certID, backend, keyRef, pin string) (signer.Signer, error) {
+	provider, err := config.KeyProvider(backend)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Open(certKeyRef(certID, keyRef), pin)
+}
+
+// revocationReasons 用户可选择的吊销原因
+var revocationReasons = []struct {
+	Code int
+	Name string
+}{
+	{ca.ReasonUnspecified, ca.ReasonName(ca.ReasonUnspecified)},
+	{ca.ReasonKeyCompromise, ca.ReasonName(ca.ReasonKeyCompromise)},
+	{ca.ReasonAffiliationChanged, ca.ReasonName(ca.ReasonAffiliationChanged)},
+	{ca.ReasonSuperseded, ca.ReasonName(ca.ReasonSuperseded)},
+	{ca.ReasonCessationOfOperation, ca.ReasonName(ca.ReasonCessationOfOperation)},
+}
+
+// 证书列表页面
+// 展示当前用户可查看的证书（含所在组织的证书）及吊销状态
+func CertListHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	rows, err := config.DB.Query(`SELECT c.CertID, ISNULL(c.IssuerDN, ''), c.ValidFrom, c.ValidTo, ISNULL(c.Algo, ''), ISNULL(c.SerialNumber, ''), c.RevokedAt, ISNULL(c.RevocationReason, 0), ISNULL(c.KeyBackend, 'file'),
+    ISNULL(c.OrgID, ''), ISNULL(o.Name, ''), CASE WHEN `+middleware.Scope(middleware.ResourceCert, middleware.ActionManage, "c", "@p1")+` THEN 1 ELSE 0 END
+    FROM [Cert] c LEFT JOIN [Organization] o ON c.OrgID=o.OrgID WHERE `+middleware.Scope(middleware.ResourceCert, middleware.ActionView, "c", "@p1")+" ORDER BY c.ValidFrom DESC", userID)
+	if err != nil {
+		// 如果数据库查询失败，返回500错误
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	defer rows.Close() // 关闭结果集
+	type certRow struct {
+		models.Cert
+		CanManage bool // 当前用户能否吊销该证书
+	}
+	var certs []certRow
+	for rows.Next() {
+		var c certRow
+		var validFrom, validTo, revokedAt sql.NullTime
+		if err := rows.Scan(&c.CertID, &c.IssuerDN, &validFrom, &validTo, &c.Algo, &c.SerialNumber, &revokedAt, &c.RevocationReason, &c.KeyBackend, &c.OrgID, &c.OrgName, &c.CanManage); err != nil {
+			continue
+		}
+		c.ValidFrom, c.ValidTo = validFrom.Time, validTo.Time
+		if revokedAt.Valid {
+			c.RevokedAt = &revokedAt.Time
+		}
+		certs = append(certs, c)
+	}
+	// 可用于申请新证书的密钥后端
+	var backends []struct{ Name, Title string }
+	for _, b := range []string{signer.BackendFile, signer.BackendPKCS11, signer.BackendKMS} {
+		if _, ok := config.KeyProviders[b]; ok {
+			backends = append(backends, struct{ Name, Title string }{b, backendNames[b]})
+		}
+	}
+	// 渲染证书列表页面
+	t, _ := template.ParseFiles("templates/cert_list.html")
+	t.Execute(w, map[string]interface{}{"Certs": certs, "Reasons": revocationReasons, "Backends": backends, "BackendNames": backendNames, "Orgs": userOrgs(userID, models.RoleAdmin)})
+}
+
+// 申请新证书
+// 仅支持POST，校验签名PIN码后在所选密钥后端中生成密钥并签发证书
+func CertCreateHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, username := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		// 如果请求方法不是POST，返回405错误
+		http.Error(w, "仅支持POST", http.StatusMethodNotAllowed)
+		return
+	}
+	backend := r.FormValue("backend")
+	algo := r.FormValue("algo")
+	pin := r.FormValue("pin")
+	if algo != signer.AlgoECC && algo != signer.AlgoRSA && algo != signer.AlgoSM2 {
+		http.Error(w, "参数错误", 400)
+		return
+	}
+	// 校验PIN码，本地文件后端还用PIN码加密私钥
+	var pinHash, email string
+	err := config.DB.QueryRow("SELECT PINHash, ISNULL(Email, '') FROM [User] WHERE UserID=@p1", userID).Scan(&pinHash, &email)
+	if err != nil || !utils.CheckPassword(pin, pinHash) {
+		http.Error(w, "PIN码错误", 403)
+		return
+	}
+	// 组织证书由组织管理员申请，私钥须在HSM或KMS中，供成员凭各自的PIN码使用
+	name := username
+	orgID := r.FormValue("org_id")
+	if orgID != "" {
+		if !middleware.CanInOrg(userID, orgID, middleware.ResourceCert, middleware.ActionManage) {
+			middleware.Forbidden(w)
+			return
+		}
+		if backend == "" || backend == signer.BackendFile {
+			http.Error(w, "组织证书的私钥须保存在HSM或KMS中", 400)
+			return
+		}
+		config.DB.QueryRow("SELECT Name FROM [Organization] WHERE OrgID=@p1", orgID).Scan(&name)
+	}
+	certID, err := issueCertificate(userID, orgID, name, email, backend, algo, pin)
+	if err != nil {
+		fmt.Println("[CertCreateHandler] 证书生成失败:", err)
+		http.Error(w, "证书生成失败: "+err.Error(), 500)
+		return
+	}
+	fmt.Println("[CertCreateHandler] 已签发证书:", certID, backend, algo)
+	// 重定向到证书列表页面
+	http.Redirect(w, r, "/cert/list", http.StatusSeeOther)
+}
+
+// 下载证书
+// 只能下载自己可查看的证书（PEM格式，仅含公钥），私钥不提供下载
+func CertDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// 校验证书访问权限，查询证书路径
+	certID := r.URL.Query().Get("cert_id")
+	var certPath string
+	err := config.DB.QueryRow("SELECT Location FROM [Cert] WHERE CertID=@p1 AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionView, "", "@p2"), certID, userID).Scan(&certPath)
+	if err != nil {
+		// 如果未找到证书，返回404错误
+		http.Error(w, "未找到证书", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", "attachment; filename="+certID+".pem")
+	http.ServeFile(w, r, certPath)
+}
+
+// 吊销证书
+// 仅支持POST，只能吊销自己的证书或自己管理的组织证书，吊销后立即重新生成CRL
+func CertRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		// 如果请求方法不是POST，返回405错误
+		http.Error(w, "仅支持POST", http.StatusMethodNotAllowed)
+		return
+	}
+	// 获取证书ID和吊销原因
+	certID := r.FormValue("cert_id")
+	reason, err := strconv.Atoi(r.FormValue("reason"))
+	valid := false
+	for _, rr := range revocationReasons {
+		valid = valid || rr.Code == reason
+	}
+	if certID == "" || err != nil || !valid {
+		// 如果参数不合法，返回400错误
+		http.Error(w, "参数错误", 400)
+		return
+	}
+	res, err := config.DB.Exec("UPDATE [Cert] SET RevokedAt=GETDATE(), RevocationReason=@p1 WHERE CertID=@p2 AND RevokedAt IS NULL AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionManage, "", "@p3"), reason, certID, userID)
+	if err != nil {
+		// 如果数据库更新失败，返回500错误
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// 证书不存在、当前用户无权吊销或已被吊销
+		http.Error(w, "未找到可吊销的证书", 404)
+		return
+	}
+	fmt.Println("[CertRevokeHandler] 证书已吊销:", certID, ca.ReasonName(reason))
+	// 立即更新CRL，OCSP直接查询证书库无需更新
+	refreshCRL()
+	// 重定向到证书列表页面
+	http.Redirect(w, r, "/cert/list", http.StatusSeeOther)
+}
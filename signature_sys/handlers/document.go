@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"signature_sys/config"
 	"signature_sys/middleware"
+	"signature_sys/models"
 	"strconv"
 	"strings"
 
@@ -20,9 +23,9 @@ import (
 	"github.com/pdfcpu/pdfcpu/pkg/api"
 )
 
-// DocumentUploadHandler 处理PDF文档上传页面和上传逻辑
+// DocumentUploadHandler 处理文档上传页面和上传逻辑，支持PDF和OFD文档
 // GET: 渲染上传页面
-// POST: 处理PDF上传，计算哈希，保存文件，写入数据库
+// POST: 处理文档上传，识别格式，计算哈希，保存文件，写入数据库
 func DocumentUploadHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// 渲染上传页面
@@ -38,23 +41,27 @@ func DocumentUploadHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer file.Close() // 关闭文件句柄，防止资源泄漏
-		// 计算PDF哈希值，用于唯一标识文件
-		hasher := sha256.New()            // 新建SHA256哈希器
-		tee := io.TeeReader(file, hasher) // tee用于边读边哈希
-		// 创建保存目录
-		os.MkdirAll("static/docs", 0755) // 确保目录存在
-		// 生成文档唯一ID并保存文件
-		docID := uuid.New().String()                                                 // 生成唯一文档ID
-		pdfPath := filepath.Join("static/docs", docID+filepath.Ext(header.Filename)) // 拼接PDF保存路径
-		out, err := os.Create(pdfPath)                                               // 创建PDF文件
+		data, err := io.ReadAll(file)
 		if err != nil {
-			http.Error(w, "保存PDF失败", 500)
+			http.Error(w, "文件读取失败", 400)
+			return
+		}
+		// 按文件内容识别格式，只接受PDF和OFD文档
+		format := documentFormat(data)
+		if format == "" {
+			http.Error(w, "仅支持PDF和OFD文档", 400)
+			return
+		}
+		// 计算文档哈希值，用于唯一标识文件
+		hashSum := sha256.Sum256(data)
+		fileHash := hex.EncodeToString(hashSum[:])
+		// 生成文档唯一ID并保存到受保护存储目录，扩展名按识别出的格式
+		docID := uuid.New().String()                               // 生成唯一文档ID
+		pdfPath := filepath.Join(config.DocsDir, docID+"."+format) // 拼接文档保存路径
+		if err := os.WriteFile(pdfPath, data, 0644); err != nil {
+			http.Error(w, "保存文档失败", 500)
 			return
 		}
-		defer out.Close() // 关闭输出文件
-		io.Copy(out, tee) // 写入PDF并计算哈希
-		// 计算文件哈希值
-		fileHash := hex.EncodeToString(hasher.Sum(nil)) // 获取PDF哈希值
 		// 获取当前登录用户ID
 		userID, _ := middleware.GetCurrentUser(r)
 		if userID == "" {
@@ -67,12 +74,16 @@ func DocumentUploadHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "数据库写入失败", 500)
 			return
 		}
+		// 记录原始上传版本
+		if _, err = addDocumentVersion(config.DB, docID, "", pdfPath, fileHash, models.VersionOpUpload, userID); err != nil {
+			fmt.Println("[DocumentUploadHandler] 文档版本写入失败:", err)
+		}
 		// 返回上传成功信息，重定向到首页
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
 
-// DocumentListHandler 展示当前用户所有PDF文档列表
+// DocumentListHandler 展示当前用户所有文档列表
 func DocumentListHandler(w http.ResponseWriter, r *http.Request) {
 	// 获取当前登录用户ID
 	userID, _ := middleware.GetCurrentUser(r)
@@ -86,9 +97,9 @@ func DocumentListHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if query != "" {
 		// 按原始文件名模糊查询
-		rows, err = config.DB.Query("SELECT DocID, FileHash, Location, OriginalName FROM [Document] WHERE UserID=@p1 AND OriginalName LIKE @p2", userID, "%"+query+"%")
+		rows, err = config.DB.Query("SELECT DocID, FileHash, Location, OriginalName FROM [Document] WHERE "+middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "", "@p1")+" AND OriginalName LIKE @p2", userID, "%"+query+"%")
 	} else {
-		rows, err = config.DB.Query("SELECT DocID, FileHash, Location, OriginalName FROM [Document] WHERE UserID=@p1", userID)
+		rows, err = config.DB.Query("SELECT DocID, FileHash, Location, OriginalName FROM [Document] WHERE "+middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "", "@p1"), userID)
 	}
 	if err != nil {
 		http.Error(w, "数据库查询失败", 500)
@@ -96,22 +107,27 @@ func DocumentListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close() // 关闭结果集
 	// 构造文档列表，包含原始文件名
-	var docs []struct {
-		DocID        string // 文档ID
-		FileHash     string // 文件哈希
-		Location     string // 文件路径
-		OriginalName string // 原始文件名
+	type docItem struct {
+		DocID        string                   // 文档ID
+		FileHash     string                   // 文件哈希
+		Location     string                   // 文件路径（最新版本）
+		OriginalName string                   // 原始文件名
+		Versions     []models.DocumentVersion // 版本历史
 	}
+	var docs []docItem
 	for rows.Next() {
-		var d struct {
-			DocID        string
-			FileHash     string
-			Location     string
-			OriginalName string
-		}
+		var d docItem
 		rows.Scan(&d.DocID, &d.FileHash, &d.Location, &d.OriginalName)
 		docs = append(docs, d)
 	}
+	// 查询当前用户所有文档的版本历史，按文档分组
+	versions := map[string][]models.DocumentVersion{}
+	for _, v := range queryDocumentVersions(middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "d", "@p1"), userID) {
+		versions[v.DocID] = append(versions[v.DocID], v)
+	}
+	for i := range docs {
+		docs[i].Versions = versions[docs[i].DocID]
+	}
 	// 渲染文档列表页面，带上当前搜索关键字
 	t, _ := template.ParseFiles("templates/document_list.html")
 	t.Execute(w, map[string]interface{}{"Docs": docs, "Query": query})
@@ -138,23 +154,60 @@ func DocumentDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// 查询文件路径
 	var pdfPath string
-	err := config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND UserID=@p2", docID, userID).Scan(&pdfPath)
+	err := config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionManage, "", "@p2"), docID, userID).Scan(&pdfPath)
 	if err != nil {
 		http.Error(w, "未找到文档", 404)
 		return
 	}
-	// 删除数据库记录
-	_, err = config.DB.Exec("DELETE FROM [Document] WHERE DocID=@p1 AND UserID=@p2", docID, userID)
-	if err != nil {
+	// 信封中的文档需保留，供签署人签署和审计
+	var envelopes int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [EnvelopeDocument] WHERE DocID=@p1", docID).Scan(&envelopes)
+	if envelopes > 0 {
+		http.Error(w, "文档已放入多方签署信封，不能删除", 400)
+		return
+	}
+	// 用印授权限定的文档和申请过用印的文档需保留，供用印审计
+	var sealRefs int
+	config.DB.QueryRow("SELECT (SELECT COUNT(*) FROM [SealGrantDocument] WHERE DocID=@p1) + (SELECT COUNT(*) FROM [SealRequest] WHERE DocID=@p1)", docID).Scan(&sealRefs)
+	if sealRefs > 0 {
+		http.Error(w, "文档在用印授权范围内或有用印申请，不能删除", 400)
+		return
+	}
+	// 在同一事务中删除数据库记录，先删除版本记录（子版本引用父版本，按版本号倒序删除）
+	versions := queryDocumentVersions("v.DocID=@p1", docID)
+	if err := deleteDocumentRecords(docID, versions); err != nil {
+		fmt.Println("[DocumentDeleteHandler] 数据库删除失败:", docID, err)
 		http.Error(w, "数据库删除失败", 500)
 		return
 	}
-	// 删除文件（忽略删除失败）
+	// 事务提交后再删除各版本文件和预览文件（忽略删除失败）
 	os.Remove(pdfPath)
+	os.Remove(pdfPath + ".preview.pdf")
+	for _, v := range versions {
+		os.Remove(v.Location)
+	}
 	// 重定向到文档列表页面
 	http.Redirect(w, r, "/document/list", http.StatusSeeOther)
 }
 
+// deleteDocumentRecords 在一个事务中删除文档的全部版本记录和文档记录
+func deleteDocumentRecords(docID string, versions []models.DocumentVersion) error {
+	tx, err := config.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i := len(versions) - 1; i >= 0; i-- {
+		if _, err := tx.Exec("DELETE FROM [DocumentVersion] WHERE VersionID=@p1", versions[i].VersionID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM [Document] WHERE DocID=@p1", docID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // SignPDFPreviewHandler PDF签章预览接口（生成临时PDF，不保存数据库）
 // POST: 根据参数生成带签章的预览PDF，返回预览路径
 func SignPDFPreviewHandler(w http.ResponseWriter, r *http.Request) {
@@ -176,12 +229,17 @@ func SignPDFPreviewHandler(w http.ResponseWriter, r *http.Request) {
 	rotation := r.FormValue("rotation") // 旋转角度
 	// 查询PDF和签章图片路径
 	var pdfPath, sealPath string
-	err := config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND UserID=@p2", docID, userID).Scan(&pdfPath)
+	err := config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionUse, "", "@p2"), docID, userID).Scan(&pdfPath)
 	if err != nil {
 		http.Error(w, "未找到PDF", 404)
 		return
 	}
-	err = config.DB.QueryRow("SELECT Location FROM [Seal] WHERE SealID=@p1 AND UserID=@p2", sealID, userID).Scan(&sealPath)
+	// 预览PDF由浏览器直接打开，OFD文档无法在浏览器中预览
+	if isOFDDocument(pdfPath) {
+		http.Error(w, "OFD文档暂不支持签章预览", 400)
+		return
+	}
+	err = config.DB.QueryRow("SELECT Location FROM [Seal] WHERE SealID=@p1 AND RetiredAt IS NULL AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionUse, "", "@p2"), sealID, userID).Scan(&sealPath)
 	if err != nil {
 		http.Error(w, "未找到签章图片", 404)
 		return
@@ -207,13 +265,13 @@ func SignPDFPreviewHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "PDF预览生成失败:"+err.Error(), 500)
 		return
 	}
-	// 返回静态路径（去掉static/前缀）
-	previewURL := "/" + outputPath
+	// 预览文件不对外发布，通过预览接口校验归属后下载
+	previewURL := "/document/preview?doc_id=" + url.QueryEscape(docID)
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"preview_url": "%s"}`, previewURL)
 }
 
-// VerifyPDFPageHandler PDF验签页面，展示用户证书列表，区分ECC和RSA，渲染验签页面
+// VerifyPDFPageHandler PDF验签页面，签名证书由后端根据PDF内嵌证书自动识别，无需用户选择
 func VerifyPDFPageHandler(w http.ResponseWriter, r *http.Request) {
 	// 获取当前登录用户ID
 	userID, _ := middleware.GetCurrentUser(r)
@@ -221,28 +279,175 @@ func VerifyPDFPageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	// 查询用户证书，直接使用Algo字段
-	certRows, err := config.DB.Query("SELECT CertID, Location, Algo FROM [Cert] WHERE UserID=@p1", userID)
+	// 渲染验签页面
+	t, err := template.ParseFiles("templates/verify_pdf.html")
 	if err != nil {
-		fmt.Println("查询用户证书失败:", err)
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
 	}
-	defer certRows.Close()
-	var certs []struct{ CertID, Location, Algo string }
-	for certRows.Next() {
-		var c struct{ CertID, Location, Algo string }
-		err := certRows.Scan(&c.CertID, &c.Location, &c.Algo)
-		if err != nil {
-			fmt.Println("扫描证书数据失败:", err)
-			continue // Skip this row and continue with the next
-		}
-		certs = append(certs, c)
+	t.Execute(w, nil)
+}
+
+// DocumentVersionsHandler 文档版本历史和下载页面，展示文档的所有版本并提供各版本下载链接
+func DocumentVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
 	}
-	// 渲染验签页面
-	t, err := template.ParseFiles("templates/verify_pdf.html")
+	// 校验文档归属
+	docID := r.URL.Query().Get("doc_id")
+	var originalName, fileHash string
+	err := config.DB.QueryRow("SELECT OriginalName, FileHash FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "", "@p2"), docID, userID).Scan(&originalName, &fileHash)
+	if err != nil {
+		http.Error(w, "未找到文档", 404)
+		return
+	}
+	// 渲染版本历史页面
+	t, err := template.ParseFiles("templates/document_versions.html")
 	if err != nil {
 		http.Error(w, "加载模板失败", 500)
 		fmt.Println("加载模板失败:", err)
 		return
 	}
-	t.Execute(w, map[string]interface{}{"Certs": certs})
+	t.Execute(w, map[string]interface{}{
+		"DocID":        docID,
+		"OriginalName": originalName,
+		"FileHash":     fileHash,
+		"Versions":     queryDocumentVersions("v.DocID=@p1", docID),
+	})
+}
+
+// DocumentDownloadHandler 下载文档的指定版本，未指定版本时下载最新版本
+func DocumentDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	docID := r.URL.Query().Get("doc_id")
+	versionID := r.URL.Query().Get("version_id")
+	// 校验文档归属，获取最新版本路径
+	var originalName, pdfPath string
+	err := config.DB.QueryRow("SELECT OriginalName, Location FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "", "@p2"), docID, userID).Scan(&originalName, &pdfPath)
+	if err != nil {
+		http.Error(w, "未找到文档", 404)
+		return
+	}
+	fileName := originalName
+	if versionID != "" {
+		// 查找指定版本，版本必须属于该文档
+		var versionNo int
+		err = config.DB.QueryRow("SELECT VersionNo, Location FROM [DocumentVersion] WHERE VersionID=@p1 AND DocID=@p2", versionID, docID).Scan(&versionNo, &pdfPath)
+		if err != nil {
+			http.Error(w, "未找到文档版本", 404)
+			return
+		}
+		ext := filepath.Ext(originalName)
+		fileName = fmt.Sprintf("%s_v%d%s", strings.TrimSuffix(originalName, ext), versionNo, ext)
+	}
+	if _, err := os.Stat(pdfPath); err != nil {
+		http.Error(w, "文档文件不存在", 404)
+		return
+	}
+	// inline=1时在浏览器中打开（签章页面预览），否则作为附件下载
+	disposition := "attachment"
+	if r.URL.Query().Get("inline") == "1" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", documentContentType(pdfPath))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	http.ServeFile(w, r, pdfPath)
+}
+
+// DocumentPreviewHandler 查看文档最新版本的签章预览PDF（由预览接口生成）
+func DocumentPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// 校验文档归属，预览文件与最新版本放在一起
+	docID := r.URL.Query().Get("doc_id")
+	var pdfPath string
+	err := config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionView, "", "@p2"), docID, userID).Scan(&pdfPath)
+	if err != nil {
+		http.Error(w, "未找到文档", 404)
+		return
+	}
+	previewPath := pdfPath + ".preview.pdf"
+	if _, err := os.Stat(previewPath); err != nil {
+		http.Error(w, "预览文件不存在", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline")
+	http.ServeFile(w, r, previewPath)
+}
+
+// sqlExecer 数据库连接或事务，文档版本可以单独写入，也可以与签名结果在同一事务中写入
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// addDocumentVersion 记录文档的新版本，版本号在该文档已有版本的基础上递增，返回新版本ID
+func addDocumentVersion(db sqlExecer, docID, parentVersionID, location, fileHash, operation, userID string) (string, error) {
+	versionID := uuid.New().String()
+	var parent, creator interface{}
+	if parentVersionID != "" {
+		parent = parentVersionID
+	}
+	// 系统任务创建的版本没有操作人
+	if userID != "" {
+		creator = userID
+	}
+	_, err := db.Exec(`INSERT INTO [DocumentVersion] 
+    (VersionID, DocID, VersionNo, ParentVersionID, Location, FileHash, Operation, CreatedBy, CreatedAt) 
+    SELECT @p1, @p2, ISNULL(MAX(VersionNo), 0) + 1, @p3, @p4, @p5, @p6, @p7, GETDATE() FROM [DocumentVersion] WHERE DocID=@p2`,
+		versionID, docID, parent, location, fileHash, operation, creator)
+	if err != nil {
+		return "", err
+	}
+	return versionID, nil
+}
+
+// latestDocumentVersionID 返回文档最新版本的ID，没有版本记录时返回空字符串
+func latestDocumentVersionID(db sqlExecer, docID string) string {
+	var versionID string
+	db.QueryRow("SELECT TOP 1 VersionID FROM [DocumentVersion] WHERE DocID=@p1 ORDER BY VersionNo DESC", docID).Scan(&versionID)
+	return versionID
+}
+
+// queryDocumentVersions 按条件查询文档版本，附带创建者用户名，按文档和版本号排序
+// where中可使用别名v（DocumentVersion）和d（Document）
+func queryDocumentVersions(where string, args ...interface{}) []models.DocumentVersion {
+	rows, err := config.DB.Query(`SELECT v.VersionID, v.DocID, v.VersionNo, ISNULL(v.ParentVersionID, ''), ISNULL(v.Location, ''), 
+    ISNULL(v.FileHash, ''), v.Operation, ISNULL(v.CreatedBy, ''), ISNULL(u.Username, ''), v.CreatedAt 
+    FROM [DocumentVersion] v JOIN [Document] d ON v.DocID=d.DocID LEFT JOIN [User] u ON v.CreatedBy=u.UserID 
+    WHERE `+where+` ORDER BY v.DocID, v.VersionNo`, args...)
+	if err != nil {
+		fmt.Println("查询文档版本失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var versions []models.DocumentVersion
+	for rows.Next() {
+		var v models.DocumentVersion
+		var createdAt sql.NullTime
+		if err := rows.Scan(&v.VersionID, &v.DocID, &v.VersionNo, &v.ParentVersionID, &v.Location,
+			&v.FileHash, &v.Operation, &v.CreatedBy, &v.CreatorName, &createdAt); err != nil {
+			fmt.Println("扫描文档版本失败:", err)
+			continue
+		}
+		if createdAt.Valid {
+			v.CreatedAt = createdAt.Time
+		}
+		versions = append(versions, v)
+	}
+	return versions
 }
@@ -0,0 +1,1048 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/ofdsign"
+	"signature_sys/pdfsign"
+	"signature_sys/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 实现多方签署流程（信封）：发起人把文档放入信封，添加签署人并预置每个签署人的签章位置，
+// 发送后签署人在“待我签署”中按顺序签署或拒签。信封状态依次为草稿、已发送、签署中、已完成，
+// 拒签或超过截止时间时流程终止；每一步都记录在签章日志（SignLog）中。
+
+// 信封的默认签署期限和过期检查间隔
+const (
+	defaultEnvelopeDays   = 30
+	envelopeCheckInterval = 10 * time.Minute
+)
+
+// 信封查询的公共部分，附带发起人用户名
+const envelopeQuery = `SELECT e.EnvelopeID, e.OwnerID, u.Username, e.Subject, ISNULL(e.Message, ''), e.SigningOrder, e.Status,
+    e.ExpiresAt, e.CreatedAt, e.SentAt, e.CompletedAt FROM [Envelope] e JOIN [User] u ON e.OwnerID=u.UserID`
+
+// envelopeDocument 信封中的一份文档
+type envelopeDocument struct {
+	DocID        string
+	OriginalName string
+	Location     string
+}
+
+// queryEnvelopes 按条件查询信封，按创建时间倒序；where中可使用别名e（Envelope）
+func queryEnvelopes(where string, args ...interface{}) []models.Envelope {
+	rows, err := config.DB.Query(envelopeQuery+" WHERE "+where+" ORDER BY e.CreatedAt DESC", args...)
+	if err != nil {
+		fmt.Println("查询信封失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var envelopes []models.Envelope
+	for rows.Next() {
+		e, err := scanEnvelope(rows)
+		if err != nil {
+			fmt.Println("扫描信封失败:", err)
+			continue
+		}
+		envelopes = append(envelopes, e)
+	}
+	return envelopes
+}
+
+// loadEnvelope 按ID读取信封
+func loadEnvelope(envelopeID string) (models.Envelope, error) {
+	return scanEnvelope(config.DB.QueryRow(envelopeQuery+" WHERE e.EnvelopeID=@p1", envelopeID))
+}
+
+// scanEnvelope 读取envelopeQuery的一行
+func scanEnvelope(row interface{ Scan(...interface{}) error }) (models.Envelope, error) {
+	var e models.Envelope
+	var createdAt, sentAt, completedAt sql.NullTime
+	err := row.Scan(&e.EnvelopeID, &e.OwnerID, &e.OwnerName, &e.Subject, &e.Message, &e.SigningOrder, &e.Status,
+		&e.ExpiresAt, &createdAt, &sentAt, &completedAt)
+	if err != nil {
+		return e, err
+	}
+	e.CreatedAt = createdAt.Time
+	if sentAt.Valid {
+		e.SentAt = &sentAt.Time
+	}
+	if completedAt.Valid {
+		e.CompletedAt = &completedAt.Time
+	}
+	return e, nil
+}
+
+// envelopeSigners 查询信封的签署人，按签署顺序排列
+func envelopeSigners(envelopeID string) []models.EnvelopeSigner {
+	rows, err := config.DB.Query(`SELECT s.SignerID, s.EnvelopeID, ISNULL(s.UserID, ''), ISNULL(u.Username, s.GuestName), ISNULL(s.GuestEmail, ''),
+    s.RoutingOrder, s.Status, s.SignedAt, ISNULL(s.DeclineReason, '')
+    FROM [EnvelopeSigner] s LEFT JOIN [User] u ON s.UserID=u.UserID WHERE s.EnvelopeID=@p1 ORDER BY s.RoutingOrder, ISNULL(u.Username, s.GuestName)`, envelopeID)
+	if err != nil {
+		fmt.Println("查询签署人失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var signers []models.EnvelopeSigner
+	for rows.Next() {
+		var s models.EnvelopeSigner
+		var signedAt sql.NullTime
+		if err := rows.Scan(&s.SignerID, &s.EnvelopeID, &s.UserID, &s.Username, &s.GuestEmail, &s.RoutingOrder, &s.Status, &signedAt, &s.DeclineReason); err != nil {
+			fmt.Println("扫描签署人失败:", err)
+			continue
+		}
+		if signedAt.Valid {
+			s.SignedAt = &signedAt.Time
+		}
+		signers = append(signers, s)
+	}
+	return signers
+}
+
+// envelopeFields 查询信封的签章位置，按文档顺序和页码排列；signerID非空时只查询该签署人的签章位置
+func envelopeFields(envelopeID, signerID string) []models.EnvelopeField {
+	query := `SELECT f.FieldID, f.EnvelopeID, f.SignerID, f.DocID, ISNULL(d.OriginalName, ''), f.Page, f.PositionX, f.PositionY, f.Scale, f.Rotation, f.SignedAt
+    FROM [EnvelopeField] f JOIN [Document] d ON f.DocID=d.DocID JOIN [EnvelopeDocument] ed ON ed.EnvelopeID=f.EnvelopeID AND ed.DocID=f.DocID
+    WHERE f.EnvelopeID=@p1`
+	args := []interface{}{envelopeID}
+	if signerID != "" {
+		query += " AND f.SignerID=@p2"
+		args = append(args, signerID)
+	}
+	rows, err := config.DB.Query(query+" ORDER BY ed.SortOrder, f.Page, f.FieldID", args...)
+	if err != nil {
+		fmt.Println("查询签章位置失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var fields []models.EnvelopeField
+	for rows.Next() {
+		var f models.EnvelopeField
+		var signedAt sql.NullTime
+		if err := rows.Scan(&f.FieldID, &f.EnvelopeID, &f.SignerID, &f.DocID, &f.DocName, &f.Page, &f.X, &f.Y, &f.Scale, &f.Rotation, &signedAt); err != nil {
+			fmt.Println("扫描签章位置失败:", err)
+			continue
+		}
+		if signedAt.Valid {
+			f.SignedAt = &signedAt.Time
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// envelopeDocuments 查询信封中的文档，按放入顺序排列
+func envelopeDocuments(envelopeID string) []envelopeDocument {
+	rows, err := config.DB.Query(`SELECT d.DocID, ISNULL(d.OriginalName, ''), d.Location FROM [EnvelopeDocument] ed
+    JOIN [Document] d ON ed.DocID=d.DocID WHERE ed.EnvelopeID=@p1 ORDER BY ed.SortOrder`, envelopeID)
+	if err != nil {
+		fmt.Println("查询信封文档失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var docs []envelopeDocument
+	for rows.Next() {
+		var d envelopeDocument
+		if err := rows.Scan(&d.DocID, &d.OriginalName, &d.Location); err == nil {
+			docs = append(docs, d)
+		}
+	}
+	return docs
+}
+
+// envelopeAudit 查询信封的审计记录（签章日志），按时间顺序排列
+func envelopeAudit(envelopeID string) []models.AuditEntry {
+	rows, err := config.DB.Query(`SELECT l.LogID, ISNULL(u.Username, ISNULL(g.GuestName + '（访客）', '')), ISNULL(d.OriginalName, ''), ISNULL(l.Action, ''), ISNULL(l.Detail, ''), l.SignTime
+    FROM [SignLog] l LEFT JOIN [User] u ON l.UserID=u.UserID LEFT JOIN [Document] d ON l.DocID=d.DocID
+    LEFT JOIN [EnvelopeSigner] g ON l.SignerID=g.SignerID AND l.UserID IS NULL
+    WHERE l.EnvelopeID=@p1 ORDER BY l.LogID`, envelopeID)
+	if err != nil {
+		fmt.Println("查询信封审计记录失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var a models.AuditEntry
+		var t sql.NullTime
+		if err := rows.Scan(&a.LogID, &a.Username, &a.DocName, &a.Action, &a.Detail, &t); err != nil {
+			fmt.Println("扫描信封审计记录失败:", err)
+			continue
+		}
+		a.Time = t.Time
+		entries = append(entries, a)
+	}
+	return entries
+}
+
+// addAudit 在签章日志中记录信封流程的一步，userID为空表示系统任务，docID为空表示与具体文档无关
+func addAudit(envelopeID, docID, userID, action, detail string) {
+	var doc, user interface{}
+	if docID != "" {
+		doc = docID
+	}
+	if userID != "" {
+		user = userID
+	}
+	_, err := config.DB.Exec("INSERT INTO SignLog (UserID, DocID, EnvelopeID, Action, Detail, SignTime) VALUES (@p1, @p2, @p3, @p4, @p5, GETDATE())",
+		user, doc, envelopeID, action, detail)
+	if err != nil {
+		fmt.Println("[Envelope] 审计记录写入失败:", envelopeID, action, err)
+	}
+}
+
+// addGuestAudit 在签章日志中记录访客签署人的操作
+func addGuestAudit(envelopeID, signerID, action, detail string) {
+	_, err := config.DB.Exec("INSERT INTO SignLog (SignerID, EnvelopeID, Action, Detail, SignTime) VALUES (@p1, @p2, @p3, @p4, GETDATE())",
+		signerID, envelopeID, action, detail)
+	if err != nil {
+		fmt.Println("[Envelope] 审计记录写入失败:", envelopeID, action, err)
+	}
+}
+
+// findSigner 返回用户在信封中的签署人记录，访客签署人没有用户ID，不会被匹配
+func findSigner(signers []models.EnvelopeSigner, userID string) *models.EnvelopeSigner {
+	if userID == "" {
+		return nil
+	}
+	for i := range signers {
+		if signers[i].UserID == userID {
+			return &signers[i]
+		}
+	}
+	return nil
+}
+
+// signerTurn 判断签署人当前能否签署：信封进行中、签署人待签署，且没有顺序号更小的待签署人
+func signerTurn(e models.Envelope, signers []models.EnvelopeSigner, s *models.EnvelopeSigner) bool {
+	if s == nil || !e.Active() || s.Status != models.SignerPending {
+		return false
+	}
+	for _, o := range signers {
+		if o.Status == models.SignerPending && o.RoutingOrder < s.RoutingOrder {
+			return false
+		}
+	}
+	return true
+}
+
+// expireIfDue 信封超过截止时间仍未完成时置为已过期，返回信封是否已过期
+func expireIfDue(e *models.Envelope) bool {
+	if e.Status == models.EnvelopeExpired {
+		return true
+	}
+	if !e.Active() || time.Now().Before(e.ExpiresAt) {
+		return false
+	}
+	res, err := config.DB.Exec("UPDATE [Envelope] SET Status=@p1 WHERE EnvelopeID=@p2 AND Status IN ('sent', 'in_progress')", models.EnvelopeExpired, e.EnvelopeID)
+	if err != nil {
+		fmt.Println("[Envelope] 信封过期更新失败:", e.EnvelopeID, err)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		addAudit(e.EnvelopeID, "", "", models.ActionExpire, "超过截止时间"+e.ExpiresAt.Format("2006-01-02 15:04")+"未完成签署")
+	}
+	e.Status = models.EnvelopeExpired
+	return true
+}
+
+// StartEnvelopeJob 启动信封过期检查的后台任务，程序启动时调用
+func StartEnvelopeJob() {
+	go func() {
+		for {
+			for _, e := range queryEnvelopes("e.Status IN ('sent', 'in_progress') AND e.ExpiresAt<GETDATE()") {
+				if expireIfDue(&e) {
+					fmt.Println("[EnvelopeJob] 信封已过期:", e.EnvelopeID)
+				}
+			}
+			time.Sleep(envelopeCheckInterval)
+		}
+	}()
+}
+
+// ownedDraft 读取当前用户发起的草稿信封，不是草稿或不属于当前用户时返回错误
+func ownedDraft(envelopeID, userID string) (models.Envelope, error) {
+	e, err := loadEnvelope(envelopeID)
+	if err != nil || e.OwnerID != userID {
+		return e, errors.New("未找到信封")
+	}
+	if e.Status != models.EnvelopeDraft {
+		return e, errors.New("信封已发送，不能修改")
+	}
+	return e, nil
+}
+
+// envelopeViewURL 信封详情页地址
+func envelopeViewURL(envelopeID string) string {
+	return "/envelope/view?envelope_id=" + url.QueryEscape(envelopeID)
+}
+
+// EnvelopeListHandler 我发起的信封列表和新建信封表单
+func EnvelopeListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// 可放入信封的文档：当前用户的全部文档
+	rows, err := config.DB.Query("SELECT DocID, ISNULL(OriginalName, '') FROM [Document] WHERE "+middleware.Scope(middleware.ResourceDocument, middleware.ActionUse, "", "@p1"), userID)
+	if err != nil {
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	var docs []struct{ DocID, OriginalName string }
+	for rows.Next() {
+		var d struct{ DocID, OriginalName string }
+		rows.Scan(&d.DocID, &d.OriginalName)
+		docs = append(docs, d)
+	}
+	rows.Close()
+	t, err := template.ParseFiles("templates/envelope_list.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Envelopes":      queryEnvelopes("e.OwnerID=@p1", userID),
+		"Docs":           docs,
+		"DefaultExpires": time.Now().AddDate(0, 0, defaultEnvelopeDays).Format("2006-01-02"),
+	})
+}
+
+// EnvelopeCreateHandler 新建草稿信封（仅POST），文档必须属于当前用户，创建后跳转到信封详情页编辑签署人和签章位置
+func EnvelopeCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	r.ParseForm()
+	subject := strings.TrimSpace(r.FormValue("subject"))
+	message := strings.TrimSpace(r.FormValue("message"))
+	order := r.FormValue("signing_order")
+	docIDs := r.Form["doc_id"]
+	if subject == "" || len(docIDs) == 0 {
+		http.Error(w, "请填写主题并选择至少一份文档", 400)
+		return
+	}
+	if len([]rune(subject)) > 255 || len([]rune(message)) > 1000 {
+		http.Error(w, "主题或说明过长", 400)
+		return
+	}
+	if order != models.SigningOrderParallel {
+		order = models.SigningOrderSerial
+	}
+	// 截止日期当天结束前有效
+	day, err := time.ParseInLocation("2006-01-02", r.FormValue("expires_at"), time.Local)
+	if err != nil {
+		http.Error(w, "截止日期格式错误", 400)
+		return
+	}
+	expiresAt := day.AddDate(0, 0, 1).Add(-time.Second)
+	if expiresAt.Before(time.Now()) {
+		http.Error(w, "截止日期不能早于今天", 400)
+		return
+	}
+	seen := map[string]bool{}
+	for _, docID := range docIDs {
+		if !middleware.Authorize(userID, middleware.ResourceDocument, docID, middleware.ActionUse) {
+			http.Error(w, "未找到文档", 404)
+			return
+		}
+		if seen[docID] {
+			http.Error(w, "文档重复", 400)
+			return
+		}
+		seen[docID] = true
+	}
+	envelopeID := uuid.New().String()
+	_, err = config.DB.Exec(`INSERT INTO [Envelope] (EnvelopeID, OwnerID, Subject, Message, SigningOrder, Status, ExpiresAt, CreatedAt)
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, GETDATE())`, envelopeID, userID, subject, message, order, models.EnvelopeDraft, expiresAt)
+	if err != nil {
+		fmt.Println("[EnvelopeCreateHandler] 信封写入失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	for i, docID := range docIDs {
+		if _, err := config.DB.Exec("INSERT INTO [EnvelopeDocument] (EnvelopeID, DocID, SortOrder) VALUES (@p1, @p2, @p3)", envelopeID, docID, i+1); err != nil {
+			fmt.Println("[EnvelopeCreateHandler] 信封文档写入失败:", err)
+			http.Error(w, "数据库写入失败", 500)
+			return
+		}
+	}
+	addAudit(envelopeID, "", userID, models.ActionCreate, fmt.Sprintf("主题：%s，文档%d份", subject, len(docIDs)))
+	http.Redirect(w, r, envelopeViewURL(envelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeViewHandler 信封详情：文档、签署人、签章位置和审计记录，发起人和签署人可查看
+// 草稿信封的发起人在此页面添加签署人和签章位置并发送
+func EnvelopeViewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	e, err := loadEnvelope(r.URL.Query().Get("envelope_id"))
+	if err != nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	signers := envelopeSigners(e.EnvelopeID)
+	me := findSigner(signers, userID)
+	if e.OwnerID != userID && me == nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	expireIfDue(&e)
+	t, err := template.ParseFiles("templates/envelope_view.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Envelope":   e,
+		"IsOwner":    e.OwnerID == userID,
+		"Editable":   e.OwnerID == userID && e.Status == models.EnvelopeDraft,
+		"MyTurn":     signerTurn(e, signers, me),
+		"CanDecline": me != nil && e.Active() && me.Status == models.SignerPending,
+		"Docs":       envelopeDocuments(e.EnvelopeID),
+		"Signers":    signers,
+		"Fields":     envelopeFields(e.EnvelopeID, ""),
+		"Audit":      envelopeAudit(e.EnvelopeID),
+	})
+}
+
+// EnvelopeSignerAddHandler 为草稿信封添加签署人（仅POST）：填写用户名时按用户名查找用户，
+// 填写邮箱时添加没有账号的访客签署人，发送信封后访客通过邮件中的签署链接签署
+// 依次签署时顺序号按添加顺序递增，同时签署时全部为1
+func EnvelopeSignerAddHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := ownedDraft(r.FormValue("envelope_id"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	signers := envelopeSigners(e.EnvelopeID)
+	var signerUserID, guestName, guestEmail interface{}
+	if email := strings.TrimSpace(r.FormValue("guest_email")); email != "" {
+		addr, err := mail.ParseAddress(email)
+		name := strings.TrimSpace(r.FormValue("guest_name"))
+		if err != nil || addr.Address != email {
+			http.Error(w, "邮箱格式错误", 400)
+			return
+		}
+		if name == "" || len([]rune(name)) > 64 {
+			http.Error(w, "请填写访客姓名（不超过64个字）", 400)
+			return
+		}
+		for _, s := range signers {
+			if strings.EqualFold(s.GuestEmail, email) {
+				http.Error(w, "该邮箱已是签署人", 400)
+				return
+			}
+		}
+		guestName, guestEmail = name, email
+	} else {
+		var id string
+		err = config.DB.QueryRow("SELECT UserID FROM [User] WHERE Username=@p1", strings.TrimSpace(r.FormValue("username"))).Scan(&id)
+		if err != nil {
+			http.Error(w, "未找到该用户", 404)
+			return
+		}
+		if findSigner(signers, id) != nil {
+			http.Error(w, "该用户已是签署人", 400)
+			return
+		}
+		signerUserID = id
+	}
+	order := 1
+	if e.SigningOrder == models.SigningOrderSerial {
+		for _, s := range signers {
+			order = max(order, s.RoutingOrder+1)
+		}
+	}
+	_, err = config.DB.Exec("INSERT INTO [EnvelopeSigner] (SignerID, EnvelopeID, UserID, GuestName, GuestEmail, RoutingOrder, Status) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)",
+		uuid.New().String(), e.EnvelopeID, signerUserID, guestName, guestEmail, order, models.SignerPending)
+	if err != nil {
+		fmt.Println("[EnvelopeSignerAddHandler] 签署人写入失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeSignerDeleteHandler 从草稿信封中移除签署人及其签章位置（仅POST）
+func EnvelopeSignerDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := ownedDraft(r.FormValue("envelope_id"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	signerID := r.FormValue("signer_id")
+	config.DB.Exec("DELETE FROM [EnvelopeField] WHERE SignerID=@p1 AND EnvelopeID=@p2", signerID, e.EnvelopeID)
+	if _, err := config.DB.Exec("DELETE FROM [EnvelopeSigner] WHERE SignerID=@p1 AND EnvelopeID=@p2", signerID, e.EnvelopeID); err != nil {
+		http.Error(w, "数据库删除失败", 500)
+		return
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeFieldAddHandler 为草稿信封中的签署人预置签章位置（仅POST）
+// 位置与签章页面一致：签章左下角相对页面左下角的偏移，缩放比例相对页面尺寸
+func EnvelopeFieldAddHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := ownedDraft(r.FormValue("envelope_id"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	signerID := r.FormValue("signer_id")
+	docID := r.FormValue("doc_id")
+	var n int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [EnvelopeSigner] WHERE SignerID=@p1 AND EnvelopeID=@p2", signerID, e.EnvelopeID).Scan(&n)
+	if n == 0 {
+		http.Error(w, "未找到签署人", 404)
+		return
+	}
+	var location string
+	err = config.DB.QueryRow(`SELECT d.Location FROM [EnvelopeDocument] ed JOIN [Document] d ON ed.DocID=d.DocID
+    WHERE ed.EnvelopeID=@p1 AND ed.DocID=@p2`, e.EnvelopeID, docID).Scan(&location)
+	if err != nil {
+		http.Error(w, "文档不在该信封中", 404)
+		return
+	}
+	p, err := formPlacement(r)
+	if err == nil {
+		err = checkPlacement(&p)
+	}
+	if err == nil {
+		err = checkPlacementPages(location, []sealPlacement{p})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	_, err = config.DB.Exec(`INSERT INTO [EnvelopeField] (FieldID, EnvelopeID, SignerID, DocID, Page, PositionX, PositionY, Scale, Rotation)
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9)`,
+		uuid.New().String(), e.EnvelopeID, signerID, docID, p.Page, p.X, p.Y, p.Scale, p.Rotation)
+	if err != nil {
+		fmt.Println("[EnvelopeFieldAddHandler] 签章位置写入失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeFieldDeleteHandler 删除草稿信封中的签章位置（仅POST）
+func EnvelopeFieldDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := ownedDraft(r.FormValue("envelope_id"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if _, err := config.DB.Exec("DELETE FROM [EnvelopeField] WHERE FieldID=@p1 AND EnvelopeID=@p2", r.FormValue("field_id"), e.EnvelopeID); err != nil {
+		http.Error(w, "数据库删除失败", 500)
+		return
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeSendHandler 发送草稿信封（仅POST），每个签署人至少要有一处签章位置
+func EnvelopeSendHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := ownedDraft(r.FormValue("envelope_id"), userID)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	signers := envelopeSigners(e.EnvelopeID)
+	if len(signers) == 0 {
+		http.Error(w, "请至少添加一位签署人", 400)
+		return
+	}
+	fieldCount := map[string]int{}
+	for _, f := range envelopeFields(e.EnvelopeID, "") {
+		fieldCount[f.SignerID]++
+	}
+	for _, s := range signers {
+		if fieldCount[s.SignerID] == 0 {
+			http.Error(w, "签署人"+s.Username+"还没有签章位置", 400)
+			return
+		}
+	}
+	if time.Now().After(e.ExpiresAt) {
+		http.Error(w, "信封已超过截止时间，请重新创建", 400)
+		return
+	}
+	res, err := config.DB.Exec("UPDATE [Envelope] SET Status=@p1, SentAt=GETDATE() WHERE EnvelopeID=@p2 AND Status=@p3", models.EnvelopeSent, e.EnvelopeID, models.EnvelopeDraft)
+	if err != nil {
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		var names []string
+		for _, s := range signers {
+			names = append(names, s.Username)
+		}
+		addAudit(e.EnvelopeID, "", userID, models.ActionSend, e.OrderName()+"："+strings.Join(names, "、"))
+		// 访客签署人通过邮件接收签署链接，发送失败时发起人可在详情页重新发送
+		for _, s := range signers {
+			if s.IsGuest() {
+				if err := sendSigningLink(e, s, userID); err != nil {
+					fmt.Println("[EnvelopeSendHandler] 签署链接发送失败:", s.GuestEmail, err)
+				}
+			}
+		}
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// EnvelopeInboxHandler 待我签署：当前用户作为签署人的已发送信封
+func EnvelopeInboxHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	type inboxItem struct {
+		Envelope models.Envelope
+		Me       models.EnvelopeSigner
+		MyTurn   bool
+	}
+	var items []inboxItem
+	for _, e := range queryEnvelopes("e.Status<>'draft' AND EXISTS (SELECT 1 FROM [EnvelopeSigner] s WHERE s.EnvelopeID=e.EnvelopeID AND s.UserID=@p1)", userID) {
+		expireIfDue(&e)
+		signers := envelopeSigners(e.EnvelopeID)
+		me := findSigner(signers, userID)
+		if me == nil {
+			continue
+		}
+		items = append(items, inboxItem{Envelope: e, Me: *me, MyTurn: signerTurn(e, signers, me)})
+	}
+	t, err := template.ParseFiles("templates/envelope_inbox.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{"Items": items})
+}
+
+// EnvelopeDocumentHandler 下载信封中文档的最新版本，发起人和签署人可下载
+func EnvelopeDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	e, err := loadEnvelope(r.URL.Query().Get("envelope_id"))
+	if err != nil || (e.OwnerID != userID && findSigner(envelopeSigners(e.EnvelopeID), userID) == nil) {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	serveEnvelopeDocument(w, r, e.EnvelopeID, r.URL.Query().Get("doc_id"))
+}
+
+// serveEnvelopeDocument 返回信封中文档的最新版本，inline=1时在浏览器中打开
+func serveEnvelopeDocument(w http.ResponseWriter, r *http.Request, envelopeID, docID string) {
+	for _, d := range envelopeDocuments(envelopeID) {
+		if d.DocID != docID {
+			continue
+		}
+		if _, err := os.Stat(d.Location); err != nil {
+			http.Error(w, "文档文件不存在", 404)
+			return
+		}
+		disposition := "attachment"
+		if r.URL.Query().Get("inline") == "1" {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Type", documentContentType(d.Location))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": d.OriginalName}))
+		http.ServeFile(w, r, d.Location)
+		return
+	}
+	http.Error(w, "文档不在该信封中", 404)
+}
+
+// EnvelopeSignPageHandler 签署页面：展示当前用户在信封中的签章位置，选择签章图片和证书
+func EnvelopeSignPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	e, err := loadEnvelope(r.URL.Query().Get("envelope_id"))
+	if err != nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	signers := envelopeSigners(e.EnvelopeID)
+	me := findSigner(signers, userID)
+	if me == nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	expireIfDue(&e)
+	if !signerTurn(e, signers, me) {
+		http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+		return
+	}
+	sealRows, _ := config.DB.Query("SELECT SealID, ISNULL(SealName, ''), ISNULL(OriginalName, '') FROM [Seal] WHERE RetiredAt IS NULL AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionUse, "", "@p1"), userID)
+	var seals []struct{ SealID, Name string }
+	for sealRows.Next() {
+		var s struct{ SealID, Name string }
+		var sealName, originalName string
+		sealRows.Scan(&s.SealID, &sealName, &originalName)
+		s.Name = sealName
+		if s.Name == "" {
+			s.Name = originalName
+		}
+		seals = append(seals, s)
+	}
+	sealRows.Close()
+	certRows, _ := config.DB.Query("SELECT CertID, Algo FROM [Cert] WHERE RevokedAt IS NULL AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "", "@p1"), userID)
+	var certs []struct{ CertID, Algo string }
+	for certRows.Next() {
+		var c struct{ CertID, Algo string }
+		certRows.Scan(&c.CertID, &c.Algo)
+		certs = append(certs, c)
+	}
+	certRows.Close()
+	t, err := template.ParseFiles("templates/envelope_sign.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Envelope": e,
+		"Fields":   envelopeFields(e.EnvelopeID, me.SignerID),
+		"Seals":    seals,
+		"Certs":    certs,
+	})
+}
+
+// EnvelopeSignHandler 签署信封（仅POST）：校验PIN码，在签署人的每份文档上加盖签章并签名，返回JSON响应
+// 每份文档签名一次，签名后更新文档的最新修订；全部签署人签署后信封完成
+func EnvelopeSignHandler(w http.ResponseWriter, r *http.Request) {
+	userID, username := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, `{"success":false,"msg":"仅支持POST"}`)
+		return
+	}
+	e, err := loadEnvelope(r.FormValue("envelope_id"))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"未找到信封"}`)
+		return
+	}
+	signers := envelopeSigners(e.EnvelopeID)
+	me := findSigner(signers, userID)
+	if me == nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"未找到信封"}`)
+		return
+	}
+	if expireIfDue(&e) {
+		fmt.Fprintf(w, `{"success":false,"msg":"信封已过期"}`)
+		return
+	}
+	if !signerTurn(e, signers, me) {
+		fmt.Fprintf(w, `{"success":false,"msg":"当前不能签署该信封（%s）"}`, e.StatusName())
+		return
+	}
+	// 校验PIN码
+	pin := r.FormValue("pin")
+	var pinHash string
+	err = config.DB.QueryRow("SELECT PINHash FROM [User] WHERE UserID=@p1", userID).Scan(&pinHash)
+	if err != nil || !utils.CheckPassword(pin, pinHash) {
+		fmt.Println("[EnvelopeSignHandler] PIN码校验失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"PIN码错误"}`)
+		return
+	}
+	// 签名证书须为本人或所在组织允许使用的证书，且未吊销
+	certID := r.FormValue("cert_id")
+	var certPath, keyBackend, keyRef string
+	err = config.DB.QueryRow("SELECT Location, ISNULL(KeyBackend, ''), ISNULL(KeyRef, '') FROM [Cert] WHERE CertID=@p1 AND RevokedAt IS NULL AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "", "@p2"), certID, userID).Scan(&certPath, &keyBackend, &keyRef)
+	if err != nil {
+		fmt.Println("[EnvelopeSignHandler] 未找到证书:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"未找到可用的证书"}`)
+		return
+	}
+	cert, err := utils.LoadCertificate(certPath)
+	if err != nil {
+		fmt.Println("[EnvelopeSignHandler] 读取证书失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"读取证书失败"}`)
+		return
+	}
+	privKey, err := openCertSigner(certID, keyBackend, keyRef, pin)
+	if err != nil {
+		fmt.Println("[EnvelopeSignHandler] 打开签名密钥失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"打开签名密钥失败"}`)
+		return
+	}
+	defer privKey.Close()
+	// 签署人的全部签章位置使用同一个签章图片
+	sealID := r.FormValue("seal_id")
+	seals, err := loadSeals(userID, []sealPlacement{{SealID: sealID}})
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	seal := seals[sealID]
+	m := envelopeSigning{Key: privKey, Cert: cert, CertID: certID, Image: seal.image, SES: seal.ses, SealID: sealID, Name: username, UserID: userID}
+	if err := signEnvelopeFields(e, me, m); err != nil {
+		jsonStr, _ := json.Marshal(map[string]interface{}{"success": false, "msg": err.Error()})
+		w.Write(jsonStr)
+		return
+	}
+	status := completeSigner(e, me.SignerID)
+	jsonStr, _ := json.Marshal(map[string]interface{}{
+		"success":  true,
+		"msg":      "签署成功",
+		"status":   status,
+		"view_url": envelopeViewURL(e.EnvelopeID),
+	})
+	w.Write(jsonStr)
+}
+
+// EnvelopeDeclineHandler 签署人拒签（仅POST），信封随即终止
+func EnvelopeDeclineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := loadEnvelope(r.FormValue("envelope_id"))
+	if err != nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	me := findSigner(envelopeSigners(e.EnvelopeID), userID)
+	if me == nil {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	if expireIfDue(&e) || !e.Active() || me.Status != models.SignerPending {
+		http.Error(w, "当前不能拒签该信封", 400)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		http.Error(w, "请填写拒签原因", 400)
+		return
+	}
+	if len([]rune(reason)) > 255 {
+		reason = string([]rune(reason)[:255])
+	}
+	declined, err := declineSigner(e, me.SignerID, reason)
+	if err != nil {
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if declined {
+		addAudit(e.EnvelopeID, "", userID, models.ActionDecline, reason)
+	}
+	http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+}
+
+// declineSigner 签署人拒签并终止信封；签署人已不是待签署状态时返回false
+func declineSigner(e models.Envelope, signerID, reason string) (bool, error) {
+	res, err := config.DB.Exec("UPDATE [EnvelopeSigner] SET Status=@p1, SignedAt=GETDATE(), DeclineReason=@p2 WHERE SignerID=@p3 AND Status=@p4",
+		models.SignerDeclined, reason, signerID, models.SignerPending)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	config.DB.Exec("UPDATE [Envelope] SET Status=@p1 WHERE EnvelopeID=@p2 AND Status IN ('sent', 'in_progress')", models.EnvelopeDeclined, e.EnvelopeID)
+	return true, nil
+}
+
+// envelopeSigning 签署人一次签署使用的签名材料
+type envelopeSigning struct {
+	Key    crypto.Signer     // 签名密钥
+	Cert   *x509.Certificate // 签名证书
+	CertID string            // 签名证书ID
+	Image  []byte            // 签章图片，签署人的全部签章位置共用
+	SES    []byte            // 电子印章，没有时为nil
+	SealID string            // 签章图片ID，访客手写签名为空
+	Name   string            // 签名人名称
+	UserID string            // 签署人用户ID，访客为空
+}
+
+// signEnvelopeFields 在签署人尚未签署的签章位置上加盖签章，每份文档签名一次并更新文档的最新修订，逐条写入签章日志
+// 中途失败时已签署的文档保留签名，重试时跳过
+func signEnvelopeFields(e models.Envelope, s *models.EnvelopeSigner, m envelopeSigning) error {
+	fields := map[string][]models.EnvelopeField{}
+	for _, f := range envelopeFields(e.EnvelopeID, s.SignerID) {
+		if f.SignedAt == nil {
+			fields[f.DocID] = append(fields[f.DocID], f)
+		}
+	}
+	var user, seal interface{}
+	if m.UserID != "" {
+		user = m.UserID
+	}
+	if m.SealID != "" {
+		seal = m.SealID
+	}
+	for _, d := range envelopeDocuments(e.EnvelopeID) {
+		docFields := fields[d.DocID]
+		if len(docFields) == 0 {
+			continue
+		}
+		var stamps []pdfsign.Stamp
+		for _, f := range docFields {
+			stamps = append(stamps, pdfsign.Stamp{Page: f.Page, Image: m.Image, X: f.X, Y: f.Y, Scale: f.Scale, Rotation: f.Rotation})
+		}
+		signedPath := filepath.Join(filepath.Dir(d.Location), fmt.Sprintf("%s_%d_SIGNED%s", d.DocID, time.Now().UnixNano(), filepath.Ext(d.Location)))
+		result, err := signDocumentStamps(d.Location, signedPath, stamps, m.Key, m.Cert, m.SES, m.Name)
+		if err == nil {
+			// 签章位置标记为已签署并写入签章日志，与文档更新在同一事务中
+			err = storeSignedVersion(d.DocID, d.Location, signedPath, m.UserID, func(tx *sql.Tx) error {
+				for _, f := range docFields {
+					if _, err := tx.Exec("UPDATE [EnvelopeField] SET SignedAt=GETDATE() WHERE FieldID=@p1", f.FieldID); err != nil {
+						fmt.Println("[Envelope] 签章位置更新失败:", err)
+						return err
+					}
+					if _, err := tx.Exec(`INSERT INTO SignLog
+    (UserID, DocID, SealID, CertID, SignAlgorithm, SignatureValue, PositionX, PositionY, Scale, Rotation, StampMode, EnvelopeID, SignerID, Action, SignTime)
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12, @p13, @p14, GETDATE())`,
+						user, d.DocID, seal, m.CertID, result.Algorithm, result.Signature, f.X, f.Y, f.Scale, int(f.Rotation)+180, models.StampModeNormal, e.EnvelopeID, s.SignerID, models.ActionSign); err != nil {
+						fmt.Println("[Envelope] 签章日志写入失败:", err)
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			fmt.Println("[Envelope] 文档签名失败:", d.DocID, err)
+			return errors.New(d.OriginalName + "签名失败: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// completeSigner 签署人完成签署后更新签署人和信封状态，全部签署人签署后信封完成，返回信封的新状态
+func completeSigner(e models.Envelope, signerID string) string {
+	config.DB.Exec("UPDATE [EnvelopeSigner] SET Status=@p1, SignedAt=GETDATE() WHERE SignerID=@p2 AND Status=@p3", models.SignerSigned, signerID, models.SignerPending)
+	var pending int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [EnvelopeSigner] WHERE EnvelopeID=@p1 AND Status=@p2", e.EnvelopeID, models.SignerPending).Scan(&pending)
+	if pending > 0 {
+		config.DB.Exec("UPDATE [Envelope] SET Status=@p1 WHERE EnvelopeID=@p2 AND Status=@p3", models.EnvelopeInProgress, e.EnvelopeID, models.EnvelopeSent)
+		return models.EnvelopeInProgress
+	}
+	res, err := config.DB.Exec("UPDATE [Envelope] SET Status=@p1, CompletedAt=GETDATE() WHERE EnvelopeID=@p2 AND Status IN ('sent', 'in_progress')", models.EnvelopeCompleted, e.EnvelopeID)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			addAudit(e.EnvelopeID, "", "", models.ActionComplete, "全部签署人已签署")
+		}
+	}
+	return models.EnvelopeCompleted
+}
+
+// signDocumentStamps 在文档最新修订上加盖签章并签名，结果写入signedPath
+// PDF文档签名域位于第一处签章所在页，并追加长期验证数据；OFD文档添加OFD签名（有电子印章时为电子签章）
+func signDocumentStamps(docPath, signedPath string, stamps []pdfsign.Stamp, key crypto.Signer, cert *x509.Certificate, sealDER []byte, name string) (*pdfsign.Result, error) {
+	if isOFDDocument(docPath) {
+		res, err := signOFDFile(docPath, signedPath, stamps, ofdsign.Options{
+			Signer:      key,
+			Certificate: cert,
+			Chain:       config.ChainFor(cert),
+			Seal:        sealDER,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &pdfsign.Result{FieldName: res.SignatureID, Signature: res.Signature, Algorithm: res.Algorithm}, nil
+	}
+	return pdfsign.SignFile(docPath, signedPath, pdfsign.Options{
+		Signer:      key,
+		Certificate: cert,
+		Chain:       config.ChainFor(cert),
+		Page:        stamps[0].Page,
+		Name:        name,
+		Stamps:      stamps,
+		Timestamper: config.Timestamper(),
+		LTV:         true,
+		Revocation:  revocationSource(),
+		Seal:        sealDER,
+	})
+}
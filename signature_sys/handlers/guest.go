@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"signature_sys/config"
+	"signature_sys/mailer"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/signer"
+	"signature_sys/utils"
+	"strings"
+	"time"
+
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/google/uuid"
+)
+
+// 实现访客签署：没有账号的签署人通过邮件中的一次性签署链接签署信封。
+// 链接令牌只保存哈希，签署或拒签后作废，重新发送时旧链接作废；签署和拒签前须输入发送到访客邮箱的验证码，
+// 每个链接的验证码发送次数和累计错误次数均有上限，累计错误达到上限时链接作废，须由发起人重新发送。
+// 访客签署时由签发CA为本次签署签发短期证书，密钥只在内存中使用，签名完成后即丢弃。
+
+// 访客签署链接、验证码和短期证书的有效期
+const (
+	guestLinkValidity = 7 * 24 * time.Hour // 签署链接有效期，不超过信封截止时间
+	otpValidity       = 10 * time.Minute   // 验证码有效期
+	otpResendInterval = time.Minute        // 两次获取验证码的最小间隔
+	otpMaxAttempts    = 5                  // 每个验证码允许的错误次数
+	otpMaxFailures    = 10                 // 每个签署链接累计允许的错误次数，达到后链接作废
+	otpMaxSends       = 5                  // 每个签署链接最多发送验证码的次数
+	guestCertValidity = time.Hour          // 访客短期证书有效期
+)
+
+// 访客手写签名图片的大小限制
+const (
+	maxSignatureImageSize = 512 << 10
+	maxSignatureImageSide = 2000
+)
+
+// guestSession 签署链接对应的访客签署人和信封
+type guestSession struct {
+	Link     models.SigningLink
+	Envelope models.Envelope
+	Signers  []models.EnvelopeSigner
+	Signer   *models.EnvelopeSigner
+}
+
+// newLinkToken 生成签署链接令牌（256位随机数，URL安全的base64编码）
+func newLinkToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sendSigningLink 为访客签署人生成新的签署链接并发送邮件，该签署人此前未使用的链接随即作废
+// userID为发起发送的用户，写入审计记录
+func sendSigningLink(e models.Envelope, s models.EnvelopeSigner, userID string) error {
+	token, err := newLinkToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(guestLinkValidity)
+	if e.ExpiresAt.Before(expiresAt) {
+		expiresAt = e.ExpiresAt
+	}
+	config.DB.Exec("UPDATE [SigningLink] SET RevokedAt=GETDATE() WHERE SignerID=@p1 AND UsedAt IS NULL AND RevokedAt IS NULL", s.SignerID)
+	_, err = config.DB.Exec("INSERT INTO [SigningLink] (LinkID, SignerID, TokenHash, ExpiresAt, CreatedAt, OTPAttempts, OTPFailures, OTPSends) VALUES (@p1, @p2, @p3, @p4, GETDATE(), 0, 0, 0)",
+		uuid.New().String(), s.SignerID, utils.HashPassword(token), expiresAt)
+	if err != nil {
+		return err
+	}
+	link := config.PublicBaseURL + "/guest/sign?token=" + url.QueryEscape(token)
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s，您好：\n\n%s 邀请您签署“%s”。\n", s.Username, e.OwnerName, e.Subject)
+	if e.Message != "" {
+		fmt.Fprintf(&body, "\n%s\n", e.Message)
+	}
+	fmt.Fprintf(&body, "\n请在%s前打开以下链接签署，链接仅可使用一次：\n%s\n\n签署时需输入发送到本邮箱的验证码。如果您不认识发起人，请忽略本邮件。\n",
+		expiresAt.Format("2006-01-02 15:04"), link)
+	err = config.Mailer.Send(mailer.Message{To: s.GuestEmail, Subject: "请签署：" + e.Subject, Body: body.String()})
+	if err != nil {
+		return err
+	}
+	addAudit(e.EnvelopeID, "", userID, models.ActionSend, "签署链接已发送至"+s.GuestEmail)
+	return nil
+}
+
+// openSigningLink 按令牌读取签署链接，校验链接未作废、未过期，且信封仍在进行、访客尚未签署
+func openSigningLink(token string) (*guestSession, error) {
+	if token == "" {
+		return nil, errors.New("签署链接无效")
+	}
+	var sess guestSession
+	var envelopeID string
+	var usedAt, revokedAt, otpSentAt, otpExpiresAt sql.NullTime
+	l := &sess.Link
+	err := config.DB.QueryRow(`SELECT l.LinkID, l.SignerID, s.EnvelopeID, l.ExpiresAt, l.UsedAt, l.RevokedAt, ISNULL(l.OTPHash, ''), l.OTPSentAt, l.OTPExpiresAt, l.OTPAttempts, l.OTPFailures, l.OTPSends
+    FROM [SigningLink] l JOIN [EnvelopeSigner] s ON l.SignerID=s.SignerID WHERE l.TokenHash=@p1`, utils.HashPassword(token)).
+		Scan(&l.LinkID, &l.SignerID, &envelopeID, &l.ExpiresAt, &usedAt, &revokedAt, &l.OTPHash, &otpSentAt, &otpExpiresAt, &l.OTPAttempts, &l.OTPFailures, &l.OTPSends)
+	if err != nil {
+		return nil, errors.New("签署链接无效")
+	}
+	if otpSentAt.Valid {
+		l.OTPSentAt = &otpSentAt.Time
+	}
+	if otpExpiresAt.Valid {
+		l.OTPExpiresAt = &otpExpiresAt.Time
+	}
+	switch {
+	case revokedAt.Valid:
+		return nil, errors.New("签署链接已失效，请使用最新邮件中的链接")
+	case usedAt.Valid:
+		return nil, errors.New("签署链接已使用")
+	case time.Now().After(l.ExpiresAt):
+		return nil, errors.New("签署链接已过期，请联系发起人重新发送")
+	}
+	if sess.Envelope, err = loadEnvelope(envelopeID); err != nil {
+		return nil, errors.New("签署链接无效")
+	}
+	if expireIfDue(&sess.Envelope) {
+		return nil, errors.New("信封已过期")
+	}
+	if !sess.Envelope.Active() {
+		return nil, errors.New("信封" + sess.Envelope.StatusName() + "，无需签署")
+	}
+	sess.Signers = envelopeSigners(envelopeID)
+	for i := range sess.Signers {
+		if sess.Signers[i].SignerID == l.SignerID {
+			sess.Signer = &sess.Signers[i]
+		}
+	}
+	if sess.Signer == nil || sess.Signer.Status != models.SignerPending {
+		return nil, errors.New("您已完成签署或拒签")
+	}
+	return &sess, nil
+}
+
+// verifyOTP 校验访客输入的邮件验证码。比较前先以条件更新占用一次尝试（当前验证码和链接累计各计一次），
+// 并发的猜测也不会超出次数限制；验证通过时归还占用，链接累计错误达到上限时作废链接
+func verifyOTP(l models.SigningLink, code string) error {
+	if l.OTPHash == "" || l.OTPExpiresAt == nil {
+		return errors.New("请先获取邮件验证码")
+	}
+	if time.Now().After(*l.OTPExpiresAt) {
+		return errors.New("验证码已过期，请重新获取")
+	}
+	res, err := config.DB.Exec("UPDATE [SigningLink] SET OTPAttempts=OTPAttempts+1, OTPFailures=OTPFailures+1 WHERE LinkID=@p1 AND RevokedAt IS NULL AND OTPAttempts<@p2 AND OTPFailures<@p3",
+		l.LinkID, otpMaxAttempts, otpMaxFailures)
+	if err != nil {
+		return errors.New("数据库更新失败")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if l.OTPFailures >= otpMaxFailures {
+			return errors.New("验证码错误次数过多，签署链接已失效，请联系发起人重新发送")
+		}
+		return errors.New("验证码错误次数过多，请重新获取")
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashPassword(l.LinkID+strings.TrimSpace(code))), []byte(l.OTPHash)) == 1 {
+		config.DB.Exec("UPDATE [SigningLink] SET OTPAttempts=OTPAttempts-1, OTPFailures=OTPFailures-1 WHERE LinkID=@p1", l.LinkID)
+		return nil
+	}
+	res, err = config.DB.Exec("UPDATE [SigningLink] SET RevokedAt=GETDATE() WHERE LinkID=@p1 AND RevokedAt IS NULL AND OTPFailures>=@p2", l.LinkID, otpMaxFailures)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			fmt.Println("[verifyOTP] 验证码累计错误次数过多，签署链接已作废:", l.LinkID)
+			return errors.New("验证码错误次数过多，签署链接已失效，请联系发起人重新发送")
+		}
+	}
+	return errors.New("验证码错误")
+}
+
+// claimSigningLink 将签署链接标记为已使用，保证同一链接只能签署或拒签一次；链接已被使用时返回false
+func claimSigningLink(linkID string) bool {
+	res, err := config.DB.Exec("UPDATE [SigningLink] SET UsedAt=GETDATE() WHERE LinkID=@p1 AND UsedAt IS NULL AND RevokedAt IS NULL", linkID)
+	if err != nil {
+		return false
+	}
+	n, _ := res.RowsAffected()
+	return n == 1
+}
+
+// releaseSigningLink 签署失败时恢复链接，访客可以重试
+func releaseSigningLink(linkID string) {
+	config.DB.Exec("UPDATE [SigningLink] SET UsedAt=NULL WHERE LinkID=@p1", linkID)
+}
+
+// maskEmail 隐藏邮箱用户名的中间部分，用于页面提示
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 1 {
+		return email
+	}
+	return email[:1] + strings.Repeat("*", min(at-1, 6)) + email[at:]
+}
+
+// decodeSignatureImage 解析页面提交的手写签名（PNG格式的data URL）
+func decodeSignatureImage(dataURL string) ([]byte, error) {
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(dataURL, prefix) || len(dataURL) > maxSignatureImageSize*4/3+len(prefix)+4 {
+		return nil, errors.New("请先手写签名")
+	}
+	img, err := base64.StdEncoding.DecodeString(dataURL[len(prefix):])
+	if err != nil {
+		return nil, errors.New("签名图片格式错误")
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(img))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxSignatureImageSide || cfg.Height > maxSignatureImageSide {
+		return nil, errors.New("签名图片格式错误")
+	}
+	return img, nil
+}
+
+// issueGuestCertificate 为访客签署人生成内存中的ECC密钥，由签发CA签发短期证书并写入证书表（不属于任何用户）
+// 证书表中的记录用于OCSP查询和验签时匹配证书；私钥不保存，签名完成后即丢弃
+func issueGuestCertificate(s *models.EnvelopeSigner) (crypto.Signer, *x509.Certificate, string, error) {
+	key, err := signer.GenerateSoftwareKey(signer.AlgoECC)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	validFrom := time.Now()
+	cert, err := config.CA.IssueUserCertificate(key.Public(), s.Username, s.GuestEmail, validFrom, validFrom.Add(guestCertValidity))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	certID := uuid.New().String()
+	certPath := filepath.Join(config.CertsDir, certID+".pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		return nil, nil, "", err
+	}
+	pubDER, err := smx509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		os.Remove(certPath)
+		return nil, nil, "", err
+	}
+	pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	_, err = config.DB.Exec("INSERT INTO [Cert] (CertID, SignerID, Location, IssuerDN, ValidFrom, ValidTo, PublicKey, Algo, SerialNumber) VALUES (@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9)",
+		certID, s.SignerID, certPath, cert.Issuer.String(), cert.NotBefore, cert.NotAfter, pubPEM, signer.AlgoECC, strings.ToUpper(cert.SerialNumber.Text(16)))
+	if err != nil {
+		os.Remove(certPath)
+		return nil, nil, "", err
+	}
+	return key, cert, certID, nil
+}
+
+// discardGuestCertificate 签署失败后删除未使用的访客短期证书记录和证书文件
+// 信封中已有文档用该证书签名时（存在签章日志）保留证书，以便验签时匹配
+func discardGuestCertificate(certID string) {
+	res, err := config.DB.Exec("DELETE FROM [Cert] WHERE CertID=@p1 AND NOT EXISTS (SELECT 1 FROM SignLog WHERE CertID=@p1)", certID)
+	if err != nil {
+		fmt.Println("[GuestSignHandler] 短期证书删除失败:", certID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		os.Remove(filepath.Join(config.CertsDir, certID+".pem"))
+	}
+}
+
+// EnvelopeGuestLinkHandler 发起人为访客签署人重新发送签署链接（仅POST），旧链接随即作废
+func EnvelopeGuestLinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	e, err := loadEnvelope(r.FormValue("envelope_id"))
+	if err != nil || e.OwnerID != userID {
+		http.Error(w, "未找到信封", 404)
+		return
+	}
+	if expireIfDue(&e) || !e.Active() {
+		http.Error(w, "信封"+e.StatusName()+"，不能发送签署链接", 400)
+		return
+	}
+	signerID := r.FormValue("signer_id")
+	for _, s := range envelopeSigners(e.EnvelopeID) {
+		if s.SignerID != signerID {
+			continue
+		}
+		if !s.IsGuest() || s.Status != models.SignerPending {
+			http.Error(w, "该签署人不需要签署链接", 400)
+			return
+		}
+		if err := sendSigningLink(e, s, userID); err != nil {
+			fmt.Println("[EnvelopeGuestLinkHandler] 签署链接发送失败:", s.GuestEmail, err)
+			http.Error(w, "签署链接发送失败", 500)
+			return
+		}
+		http.Redirect(w, r, envelopeViewURL(e.EnvelopeID), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "未找到签署人", 404)
+}
+
+// GuestSignHandler 访客签署：GET展示签署页面；POST校验验证码后用手写签名签署，返回JSON响应
+func GuestSignHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	if r.Method != http.MethodPost {
+		data := map[string]interface{}{"Token": token}
+		if sess, err := openSigningLink(token); err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Envelope"] = sess.Envelope
+			data["Signer"] = sess.Signer
+			data["Email"] = maskEmail(sess.Signer.GuestEmail)
+			data["MyTurn"] = signerTurn(sess.Envelope, sess.Signers, sess.Signer)
+			data["Docs"] = envelopeDocuments(sess.Envelope.EnvelopeID)
+			data["Fields"] = envelopeFields(sess.Envelope.EnvelopeID, sess.Signer.SignerID)
+		}
+		t, err := template.ParseFiles("templates/guest_sign.html")
+		if err != nil {
+			http.Error(w, "加载模板失败", 500)
+			fmt.Println("加载模板失败:", err)
+			return
+		}
+		t.Execute(w, data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	sess, err := openSigningLink(token)
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if !signerTurn(sess.Envelope, sess.Signers, sess.Signer) {
+		fmt.Fprintf(w, `{"success":false,"msg":"请等待前序签署人签署完成"}`)
+		return
+	}
+	image, err := decodeSignatureImage(r.FormValue("signature"))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if err := verifyOTP(sess.Link, r.FormValue("otp")); err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if !claimSigningLink(sess.Link.LinkID) {
+		fmt.Fprintf(w, `{"success":false,"msg":"签署链接已使用"}`)
+		return
+	}
+	key, cert, certID, err := issueGuestCertificate(sess.Signer)
+	if err != nil {
+		releaseSigningLink(sess.Link.LinkID)
+		fmt.Println("[GuestSignHandler] 短期证书签发失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"签名证书签发失败"}`)
+		return
+	}
+	m := envelopeSigning{Key: key, Cert: cert, CertID: certID, Image: image, Name: sess.Signer.Username}
+	if err := signEnvelopeFields(sess.Envelope, sess.Signer, m); err != nil {
+		discardGuestCertificate(certID)
+		releaseSigningLink(sess.Link.LinkID)
+		jsonStr, _ := json.Marshal(map[string]interface{}{"success": false, "msg": err.Error()})
+		w.Write(jsonStr)
+		return
+	}
+	status := completeSigner(sess.Envelope, sess.Signer.SignerID)
+	jsonStr, _ := json.Marshal(map[string]interface{}{
+		"success": true,
+		"msg":     "签署成功，感谢您的配合",
+		"status":  status,
+	})
+	w.Write(jsonStr)
+}
+
+// GuestOTPHandler 向访客邮箱发送验证码（仅POST），返回JSON响应
+func GuestOTPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, `{"success":false,"msg":"仅支持POST"}`)
+		return
+	}
+	sess, err := openSigningLink(r.FormValue("token"))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if sent := sess.Link.OTPSentAt; sent != nil && time.Since(*sent) < otpResendInterval {
+		fmt.Fprintf(w, `{"success":false,"msg":"请%d秒后再获取验证码"}`, int((otpResendInterval-time.Since(*sent)).Seconds())+1)
+		return
+	}
+	if sess.Link.OTPSends >= otpMaxSends {
+		fmt.Fprintf(w, `{"success":false,"msg":"验证码获取次数已达上限，请联系发起人重新发送签署链接"}`)
+		return
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"验证码生成失败"}`)
+		return
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	now := time.Now()
+	// 以条件更新限制发送间隔和次数，并发的请求也不会超出；累计错误次数（OTPFailures）不随重新获取清零
+	res, err := config.DB.Exec(`UPDATE [SigningLink] SET OTPHash=@p1, OTPSentAt=@p2, OTPExpiresAt=@p3, OTPAttempts=0, OTPSends=OTPSends+1
+    WHERE LinkID=@p4 AND RevokedAt IS NULL AND OTPSends<@p5 AND (OTPSentAt IS NULL OR OTPSentAt<=@p6)`,
+		utils.HashPassword(sess.Link.LinkID+code), now, now.Add(otpValidity), sess.Link.LinkID, otpMaxSends, now.Add(-otpResendInterval))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"数据库更新失败"}`)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		fmt.Fprintf(w, `{"success":false,"msg":"请稍后再获取验证码"}`)
+		return
+	}
+	err = config.Mailer.Send(mailer.Message{
+		To:      sess.Signer.GuestEmail,
+		Subject: "签署验证码",
+		Body: fmt.Sprintf("%s，您好：\n\n您正在签署“%s”，验证码为 %s ，%d分钟内有效。\n请勿将验证码告诉他人。\n",
+			sess.Signer.Username, sess.Envelope.Subject, code, int(otpValidity.Minutes())),
+	})
+	if err != nil {
+		fmt.Println("[GuestOTPHandler] 验证码邮件发送失败:", sess.Signer.GuestEmail, err)
+		fmt.Fprintf(w, `{"success":false,"msg":"验证码邮件发送失败，请稍后重试"}`)
+		return
+	}
+	fmt.Fprintf(w, `{"success":true,"msg":"验证码已发送至%s"}`, maskEmail(sess.Signer.GuestEmail))
+}
+
+// GuestDeclineHandler 访客拒签（仅POST），校验验证码后终止信封，返回JSON响应
+func GuestDeclineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, `{"success":false,"msg":"仅支持POST"}`)
+		return
+	}
+	sess, err := openSigningLink(r.FormValue("token"))
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		fmt.Fprintf(w, `{"success":false,"msg":"请填写拒签原因"}`)
+		return
+	}
+	if len([]rune(reason)) > 255 {
+		reason = string([]rune(reason)[:255])
+	}
+	if err := verifyOTP(sess.Link, r.FormValue("otp")); err != nil {
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if !claimSigningLink(sess.Link.LinkID) {
+		fmt.Fprintf(w, `{"success":false,"msg":"签署链接已使用"}`)
+		return
+	}
+	declined, err := declineSigner(sess.Envelope, sess.Signer.SignerID, reason)
+	if err != nil {
+		releaseSigningLink(sess.Link.LinkID)
+		fmt.Fprintf(w, `{"success":false,"msg":"数据库更新失败"}`)
+		return
+	}
+	if declined {
+		addGuestAudit(sess.Envelope.EnvelopeID, sess.Signer.SignerID, models.ActionDecline, reason)
+	}
+	fmt.Fprintf(w, `{"success":true,"msg":"已拒签，发起人将看到您的拒签原因"}`)
+}
+
+// GuestDocumentHandler 访客通过签署链接查看或下载信封中的文档
+func GuestDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	sess, err := openSigningLink(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+	serveEnvelopeDocument(w, r, sess.Envelope.EnvelopeID, r.URL.Query().Get("doc_id"))
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"signature_sys/config"
+	"signature_sys/models"
+	"signature_sys/utils"
+)
+
+// linkDB 测试用的数据库驱动，只模拟verifyOTP对[SigningLink]一行记录执行的条件更新
+type linkDB struct {
+	mu       sync.Mutex
+	attempts int64
+	failures int64
+	revoked  bool
+}
+
+func (db *linkDB) Open(string) (driver.Conn, error)             { return linkConn{db}, nil }
+func (db *linkDB) Connect(context.Context) (driver.Conn, error) { return linkConn{db}, nil }
+func (db *linkDB) Driver() driver.Driver                        { return db }
+
+type linkConn struct{ db *linkDB }
+
+func (c linkConn) Prepare(query string) (driver.Stmt, error) { return linkStmt{c.db, query}, nil }
+func (c linkConn) Close() error                              { return nil }
+func (c linkConn) Begin() (driver.Tx, error)                 { return nil, errors.New("不支持事务") }
+
+type linkStmt struct {
+	db    *linkDB
+	query string
+}
+
+func (s linkStmt) Close() error  { return nil }
+func (s linkStmt) NumInput() int { return -1 }
+func (s linkStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("不支持查询")
+}
+
+// Exec 按语句执行条件更新，参数顺序与verifyOTP中的@pN一致
+func (s linkStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch {
+	case strings.Contains(s.query, "OTPAttempts=OTPAttempts+1"):
+		if db.revoked || db.attempts >= args[1].(int64) || db.failures >= args[2].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		db.attempts++
+		db.failures++
+	case strings.Contains(s.query, "OTPAttempts=OTPAttempts-1"):
+		db.attempts--
+		db.failures--
+	case strings.Contains(s.query, "SET RevokedAt=GETDATE()"):
+		if db.revoked || db.failures < args[1].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		db.revoked = true
+	default:
+		return nil, errors.New("未模拟的语句: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// useLinkDB 将config.DB替换为模拟的签署链接记录，测试结束后恢复
+func useLinkDB(t *testing.T) *linkDB {
+	t.Helper()
+	db := &linkDB{}
+	conn := sql.OpenDB(db)
+	old := config.DB
+	config.DB = conn
+	t.Cleanup(func() {
+		conn.Close()
+		config.DB = old
+	})
+	return db
+}
+
+// testLink 返回验证码为code且未过期的签署链接
+func testLink(db *linkDB, code string) models.SigningLink {
+	expires := time.Now().Add(otpValidity)
+	return models.SigningLink{
+		LinkID:       "link1",
+		OTPHash:      utils.HashPassword("link1" + code),
+		OTPExpiresAt: &expires,
+		OTPAttempts:  int(db.attempts),
+		OTPFailures:  int(db.failures),
+	}
+}
+
+func TestVerifyOTPNoCode(t *testing.T) {
+	if err := verifyOTP(models.SigningLink{LinkID: "link1"}, "123456"); err == nil {
+		t.Error("未获取验证码时应校验失败")
+	}
+	expired := time.Now().Add(-time.Second)
+	l := models.SigningLink{LinkID: "link1", OTPHash: utils.HashPassword("link1123456"), OTPExpiresAt: &expired}
+	if err := verifyOTP(l, "123456"); err == nil {
+		t.Error("过期的验证码应校验失败")
+	}
+}
+
+func TestVerifyOTPCorrectCode(t *testing.T) {
+	db := useLinkDB(t)
+	if err := verifyOTP(testLink(db, "123456"), " 123456 "); err != nil {
+		t.Fatalf("正确的验证码校验失败: %v", err)
+	}
+	if db.attempts != 0 || db.failures != 0 {
+		t.Errorf("验证通过后应归还占用的次数: 尝试%d 累计错误%d", db.attempts, db.failures)
+	}
+}
+
+func TestVerifyOTPAttemptCap(t *testing.T) {
+	db := useLinkDB(t)
+	for i := 0; i < otpMaxAttempts; i++ {
+		if err := verifyOTP(testLink(db, "123456"), "000000"); err == nil || err.Error() != "验证码错误" {
+			t.Fatalf("第%d次错误的验证码: %v", i+1, err)
+		}
+	}
+	// 当前验证码的错误次数用完后，正确的验证码也不再接受
+	if err := verifyOTP(testLink(db, "123456"), "123456"); err == nil || !strings.Contains(err.Error(), "请重新获取") {
+		t.Fatalf("错误次数用完后应要求重新获取验证码: %v", err)
+	}
+	if db.attempts != otpMaxAttempts || db.revoked {
+		t.Errorf("尝试次数 = %d，链接作废 = %v", db.attempts, db.revoked)
+	}
+}
+
+func TestVerifyOTPFailureCap(t *testing.T) {
+	db := useLinkDB(t)
+	var err error
+	for i := 0; i < otpMaxFailures; i++ {
+		// 重新获取验证码清零当前验证码的错误次数，累计错误次数保留
+		if i%otpMaxAttempts == 0 {
+			db.attempts = 0
+		}
+		err = verifyOTP(testLink(db, "123456"), "000000")
+	}
+	if !db.revoked || err == nil || !strings.Contains(err.Error(), "签署链接已失效") {
+		t.Fatalf("累计错误%d次后链接应作废: 作废=%v %v", otpMaxFailures, db.revoked, err)
+	}
+	db.attempts = 0
+	if err := verifyOTP(testLink(db, "654321"), "654321"); err == nil {
+		t.Error("链接作废后正确的验证码也应校验失败")
+	}
+	if db.failures != otpMaxFailures {
+		t.Errorf("累计错误次数 = %d，应为%d", db.failures, otpMaxFailures)
+	}
+}
+
+func TestVerifyOTPConcurrentGuesses(t *testing.T) {
+	db := useLinkDB(t)
+	l := testLink(db, "123456")
+	var wg sync.WaitGroup
+	for i := 0; i < 4*otpMaxAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verifyOTP(l, "000000")
+		}()
+	}
+	wg.Wait()
+	if db.attempts != otpMaxAttempts || db.failures != otpMaxAttempts {
+		t.Errorf("并发猜测超出了次数限制: 尝试%d 累计错误%d", db.attempts, db.failures)
+	}
+}
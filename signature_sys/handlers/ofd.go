@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"signature_sys/ofdsign"
+	"signature_sys/pdfsign"
+	"strings"
+)
+
+// 实现OFD（GB/T 33190）文档的格式识别、签章坐标换算和签名，供文档、签章和验签处理逻辑使用。
+
+// 每点（1/72英寸）对应的毫米数
+const mmPerPoint = 25.4 / 72
+
+// 文档格式
+const (
+	formatPDF = "pdf"
+	formatOFD = "ofd"
+)
+
+// documentFormat 根据文件内容识别文档格式，不是PDF或OFD时返回空字符串
+func documentFormat(data []byte) string {
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if bytes.Contains(head, []byte("%PDF-")) {
+		return formatPDF
+	}
+	if ofdsign.IsOFD(data) {
+		return formatOFD
+	}
+	return ""
+}
+
+// isOFDDocument 判断文档文件是否为OFD（上传和签名时按格式保存扩展名）
+func isOFDDocument(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), "."+formatOFD)
+}
+
+// documentContentType 返回文档文件的MIME类型
+func documentContentType(path string) string {
+	if isOFDDocument(path) {
+		return "application/ofd"
+	}
+	return "application/pdf"
+}
+
+// ofdStamp 将签章页面提交的位置换算为OFD页面坐标
+// 签章页面与PDF一致：偏移相对页面左下角、单位为点，缩放比例按图片长边方向相对页面宽度或高度；
+// OFD坐标相对页面左上角、单位为毫米
+func ofdStamp(pkg *ofdsign.Package, st pdfsign.Stamp) (ofdsign.Stamp, error) {
+	if st.Page <= 0 {
+		st.Page = 1
+	}
+	if st.Scale <= 0 {
+		return ofdsign.Stamp{}, fmt.Errorf("签章缩放比例必须大于0")
+	}
+	page, err := pkg.Page(st.Page)
+	if err != nil {
+		return ofdsign.Stamp{}, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(st.Image))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return ofdsign.Stamp{}, fmt.Errorf("签章图片解析失败: %v", err)
+	}
+	var w, h float64
+	if cfg.Width >= cfg.Height {
+		w = st.Scale * page.Box[2]
+		h = w * float64(cfg.Height) / float64(cfg.Width)
+	} else {
+		h = st.Scale * page.Box[3]
+		w = h * float64(cfg.Width) / float64(cfg.Height)
+	}
+	return ofdsign.Stamp{
+		Page:     st.Page,
+		Image:    st.Image,
+		X:        page.Box[0] + st.X*mmPerPoint,
+		Y:        page.Box[1] + page.Box[3] - st.Y*mmPerPoint - h,
+		Width:    w,
+		Height:   h,
+		Rotation: st.Rotation,
+	}, nil
+}
+
+// signOFDFile 在inPath指向的OFD上按签章页面提交的位置盖章并签名，结果写入outPath
+func signOFDFile(inPath, outPath string, stamps []pdfsign.Stamp, opts ofdsign.Options) (*ofdsign.Result, error) {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := ofdsign.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stamps {
+		stamp, err := ofdStamp(pkg, st)
+		if err != nil {
+			return nil, err
+		}
+		opts.Stamps = append(opts.Stamps, stamp)
+	}
+	out, res, err := ofdsign.Sign(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outPath, out, 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// 实现组织管理：用户创建组织后成为管理员，管理员添加成员、分配部门和角色。
+// 组织的签章和证书按成员角色授权使用（见middleware/authz.go），组织至少保留一名管理员。
+
+// userOrgs 查询用户所在的组织，roles不为空时只返回角色为其中之一的组织
+func userOrgs(userID string, roles ...string) []models.Organization {
+	rows, err := config.DB.Query(`SELECT o.OrgID, o.Name, o.CreatedBy, o.CreatedAt, m.Role FROM [Organization] o
+    JOIN [OrgMember] m ON o.OrgID=m.OrgID WHERE m.UserID=@p1 ORDER BY o.Name`, userID)
+	if err != nil {
+		fmt.Println("查询组织失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var orgs []models.Organization
+	for rows.Next() {
+		var o models.Organization
+		var createdAt sql.NullTime
+		if err := rows.Scan(&o.OrgID, &o.Name, &o.CreatedBy, &createdAt, &o.Role); err != nil {
+			fmt.Println("扫描组织失败:", err)
+			continue
+		}
+		o.CreatedAt = createdAt.Time
+		if len(roles) > 0 && !containsString(roles, o.Role) {
+			continue
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs
+}
+
+// containsString 判断列表中是否包含s
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// orgMembers 查询组织成员，按角色和用户名排列
+func orgMembers(orgID string) []models.OrgMember {
+	rows, err := config.DB.Query(`SELECT m.OrgID, m.UserID, u.Username, ISNULL(m.DeptID, ''), ISNULL(d.Name, ''), m.Role, m.JoinedAt
+    FROM [OrgMember] m JOIN [User] u ON m.UserID=u.UserID LEFT JOIN [Department] d ON m.DeptID=d.DeptID
+    WHERE m.OrgID=@p1 ORDER BY CASE m.Role WHEN 'admin' THEN 0 WHEN 'seal_keeper' THEN 1 WHEN 'signer' THEN 2 ELSE 3 END, u.Username`, orgID)
+	if err != nil {
+		fmt.Println("查询组织成员失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var members []models.OrgMember
+	for rows.Next() {
+		var m models.OrgMember
+		var joinedAt sql.NullTime
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Username, &m.DeptID, &m.DeptName, &m.Role, &joinedAt); err != nil {
+			fmt.Println("扫描组织成员失败:", err)
+			continue
+		}
+		m.JoinedAt = joinedAt.Time
+		members = append(members, m)
+	}
+	return members
+}
+
+// orgDepartments 查询组织的部门
+func orgDepartments(orgID string) []models.Department {
+	rows, err := config.DB.Query("SELECT DeptID, OrgID, Name FROM [Department] WHERE OrgID=@p1 ORDER BY Name", orgID)
+	if err != nil {
+		fmt.Println("查询部门失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var depts []models.Department
+	for rows.Next() {
+		var d models.Department
+		rows.Scan(&d.DeptID, &d.OrgID, &d.Name)
+		depts = append(depts, d)
+	}
+	return depts
+}
+
+// orgDeptParam 读取表单中的部门ID，部门须属于该组织；未选择部门时为NULL
+func orgDeptParam(r *http.Request, orgID string) (sql.NullString, bool) {
+	deptID := r.FormValue("dept_id")
+	if deptID == "" {
+		return sql.NullString{}, true
+	}
+	var n int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [Department] WHERE DeptID=@p1 AND OrgID=@p2", deptID, orgID).Scan(&n)
+	return sql.NullString{String: deptID, Valid: true}, n > 0
+}
+
+// orgViewURL 组织详情页面地址
+func orgViewURL(orgID string) string {
+	return "/org/view?org_id=" + orgID
+}
+
+// OrgListHandler 我的组织页面：列出当前用户所在的组织和角色，提供创建组织表单
+func OrgListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	t, err := template.ParseFiles("templates/org_list.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{"Orgs": userOrgs(userID)})
+}
+
+// OrgCreateHandler 创建组织（仅POST），创建者成为组织管理员
+func OrgCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len([]rune(name)) > 128 {
+		http.Error(w, "组织名称不能为空且不超过128个字符", 400)
+		return
+	}
+	orgID := uuid.New().String()
+	tx, err := config.DB.Begin()
+	if err != nil {
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO [Organization] (OrgID, Name, CreatedBy, CreatedAt) VALUES (@p1, @p2, @p3, GETDATE())", orgID, name, userID); err != nil {
+		fmt.Println("[OrgCreateHandler] 创建组织失败:", err)
+		http.Error(w, "创建组织失败，名称可能已被使用", 400)
+		return
+	}
+	if _, err := tx.Exec("INSERT INTO [OrgMember] (OrgID, UserID, Role, JoinedAt) VALUES (@p1, @p2, @p3, GETDATE())", orgID, userID, models.RoleAdmin); err != nil {
+		fmt.Println("[OrgCreateHandler] 添加管理员失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	http.Redirect(w, r, orgViewURL(orgID), http.StatusSeeOther)
+}
+
+// OrgViewHandler 组织详情：成员可查看成员、部门和组织的签章、证书，管理员可管理成员和部门
+func OrgViewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	orgID := r.URL.Query().Get("org_id")
+	role := middleware.OrgRole(userID, orgID)
+	if role == "" {
+		http.Error(w, "未找到组织", 404)
+		return
+	}
+	var org models.Organization
+	var createdAt sql.NullTime
+	config.DB.QueryRow("SELECT OrgID, Name, CreatedBy, CreatedAt FROM [Organization] WHERE OrgID=@p1", orgID).Scan(&org.OrgID, &org.Name, &org.CreatedBy, &createdAt)
+	org.CreatedAt = createdAt.Time
+	org.Role = role
+	type resourceRow struct{ ID, Name, Extra string }
+	var seals, certs []resourceRow
+	sealRows, _ := config.DB.Query("SELECT SealID, ISNULL(NULLIF(SealName, ''), ISNULL(OriginalName, '')), CASE WHEN SESLocation IS NULL THEN '' ELSE '电子印章' END FROM [Seal] WHERE OrgID=@p1", orgID)
+	for sealRows.Next() {
+		var s resourceRow
+		sealRows.Scan(&s.ID, &s.Name, &s.Extra)
+		seals = append(seals, s)
+	}
+	sealRows.Close()
+	certRows, _ := config.DB.Query("SELECT CertID, ISNULL(Algo, ''), CASE WHEN RevokedAt IS NULL THEN '' ELSE '已吊销' END FROM [Cert] WHERE OrgID=@p1 ORDER BY ValidFrom DESC", orgID)
+	for certRows.Next() {
+		var c resourceRow
+		certRows.Scan(&c.ID, &c.Name, &c.Extra)
+		certs = append(certs, c)
+	}
+	certRows.Close()
+	t, err := template.ParseFiles("templates/org_view.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	type roleOption struct{ Value, Name string }
+	var roles []roleOption
+	for _, r := range models.Roles {
+		roles = append(roles, roleOption{r, models.RoleName(r)})
+	}
+	t.Execute(w, map[string]interface{}{
+		"Org":     org,
+		"IsAdmin": role == models.RoleAdmin,
+		"Members": orgMembers(orgID),
+		"Depts":   orgDepartments(orgID),
+		"Roles":   roles,
+		"Seals":   seals,
+		"Certs":   certs,
+	})
+}
+
+// OrgDepartmentAddHandler 添加部门（仅POST，仅管理员）
+func OrgDepartmentAddHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	orgID := r.FormValue("org_id")
+	if !middleware.HasOrgRole(userID, orgID, models.RoleAdmin) {
+		middleware.Forbidden(w)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len([]rune(name)) > 64 {
+		http.Error(w, "部门名称不能为空且不超过64个字符", 400)
+		return
+	}
+	_, err := config.DB.Exec("INSERT INTO [Department] (DeptID, OrgID, Name, CreatedAt) VALUES (@p1, @p2, @p3, GETDATE())", uuid.New().String(), orgID, name)
+	if err != nil {
+		fmt.Println("[OrgDepartmentAddHandler] 添加部门失败:", err)
+		http.Error(w, "添加部门失败，名称可能已存在", 400)
+		return
+	}
+	http.Redirect(w, r, orgViewURL(orgID), http.StatusSeeOther)
+}
+
+// OrgMemberAddHandler 按用户名添加成员（仅POST，仅管理员），同时指定角色和部门
+func OrgMemberAddHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	orgID := r.FormValue("org_id")
+	if !middleware.HasOrgRole(userID, orgID, models.RoleAdmin) {
+		middleware.Forbidden(w)
+		return
+	}
+	role := r.FormValue("role")
+	if !containsString(models.Roles, role) {
+		http.Error(w, "角色错误", 400)
+		return
+	}
+	dept, ok := orgDeptParam(r, orgID)
+	if !ok {
+		http.Error(w, "部门不属于该组织", 400)
+		return
+	}
+	var memberID string
+	if err := config.DB.QueryRow("SELECT UserID FROM [User] WHERE Username=@p1", strings.TrimSpace(r.FormValue("username"))).Scan(&memberID); err != nil {
+		http.Error(w, "未找到用户", 400)
+		return
+	}
+	res, err := config.DB.Exec(`INSERT INTO [OrgMember] (OrgID, UserID, DeptID, Role, JoinedAt) SELECT @p1, @p2, @p3, @p4, GETDATE()
+    WHERE NOT EXISTS (SELECT 1 FROM [OrgMember] WHERE OrgID=@p1 AND UserID=@p2)`, orgID, memberID, dept, role)
+	if err != nil {
+		fmt.Println("[OrgMemberAddHandler] 添加成员失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "该用户已是组织成员", 400)
+		return
+	}
+	http.Redirect(w, r, orgViewURL(orgID), http.StatusSeeOther)
+}
+
+// OrgMemberUpdateHandler 修改成员的角色和部门（仅POST，仅管理员），不能撤销最后一名管理员
+func OrgMemberUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	orgID := r.FormValue("org_id")
+	if !middleware.HasOrgRole(userID, orgID, models.RoleAdmin) {
+		middleware.Forbidden(w)
+		return
+	}
+	role := r.FormValue("role")
+	if !containsString(models.Roles, role) {
+		http.Error(w, "角色错误", 400)
+		return
+	}
+	dept, ok := orgDeptParam(r, orgID)
+	if !ok {
+		http.Error(w, "部门不属于该组织", 400)
+		return
+	}
+	// 降级管理员时须仍有其他管理员，条件写在同一条语句中避免并发降级后没有管理员
+	res, err := config.DB.Exec(`UPDATE [OrgMember] SET Role=@p1, DeptID=@p2 WHERE OrgID=@p3 AND UserID=@p4
+    AND (@p1='admin' OR Role<>'admin' OR EXISTS (SELECT 1 FROM [OrgMember] a WHERE a.OrgID=@p3 AND a.Role='admin' AND a.UserID<>@p4))`,
+		role, dept, orgID, r.FormValue("user_id"))
+	if err != nil {
+		fmt.Println("[OrgMemberUpdateHandler] 修改成员失败:", err)
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "未找到成员，或组织至少需要保留一名管理员", 400)
+		return
+	}
+	http.Redirect(w, r, orgViewURL(orgID), http.StatusSeeOther)
+}
+
+// OrgMemberRemoveHandler 移除成员（仅POST，仅管理员），不能移除最后一名管理员
+// 移除后该用户不能再使用组织的签章和证书，已授予的用印授权不受影响
+func OrgMemberRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	orgID := r.FormValue("org_id")
+	if !middleware.HasOrgRole(userID, orgID, models.RoleAdmin) {
+		middleware.Forbidden(w)
+		return
+	}
+	res, err := config.DB.Exec(`DELETE FROM [OrgMember] WHERE OrgID=@p1 AND UserID=@p2
+    AND (Role<>'admin' OR EXISTS (SELECT 1 FROM [OrgMember] a WHERE a.OrgID=@p1 AND a.Role='admin' AND a.UserID<>@p2))`, orgID, r.FormValue("user_id"))
+	if err != nil {
+		fmt.Println("[OrgMemberRemoveHandler] 移除成员失败:", err)
+		http.Error(w, "数据库删除失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "未找到成员，或组织至少需要保留一名管理员", 400)
+		return
+	}
+	http.Redirect(w, r, orgViewURL(orgID), http.StatusSeeOther)
+}
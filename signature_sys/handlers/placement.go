@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/ofdsign"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// 解析签章请求中的签章位置列表，并读取各处使用的签章图片和电子印章。
+
+// 一次签章请求最多包含的签章位置数
+const maxPlacements = 200
+
+// 签章缩放比例的取值范围
+const (
+	minStampScale = 0.2
+	maxStampScale = 2.0
+)
+
+// sealPlacement 一处签章：签章图片、页码、位置、缩放比例和旋转角度
+// 位置为签章左下角相对页面左下角的偏移（单位：点），缩放比例相对页面尺寸
+type sealPlacement struct {
+	SealID   string  `json:"seal_id"`
+	Page     int     `json:"page"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Scale    float64 `json:"scale"`
+	Rotation float64 `json:"rotation"`
+}
+
+// sealFiles 签章图片及其电子印章（没有电子印章时为nil）
+type sealFiles struct {
+	image []byte
+	ses   []byte
+}
+
+// signLogEntry 一条签章日志对应的签章图片和位置
+type signLogEntry struct {
+	sealID   string
+	x        sql.NullFloat64
+	y        float64
+	scale    float64
+	rotation float64
+}
+
+// parsePlacements 读取签章位置列表：placements为JSON数组时按列表处理，
+// 否则由seal_id、page、pos_x、pos_y、scale、rotation组成单处签章
+// 数值格式错误或页码、缩放比例、旋转角度超出范围时返回错误，不进入PIN码校验和签名
+func parsePlacements(r *http.Request) ([]sealPlacement, error) {
+	var list []sealPlacement
+	if raw := r.FormValue("placements"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &list); err != nil {
+			return nil, errors.New("签章位置列表格式错误")
+		}
+	} else {
+		p, err := formPlacement(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("至少需要一处签章")
+	}
+	if len(list) > maxPlacements {
+		return nil, fmt.Errorf("一次最多加盖%d处签章", maxPlacements)
+	}
+	for i := range list {
+		if list[i].SealID == "" {
+			return nil, fmt.Errorf("第%d处签章未选择签章图片", i+1)
+		}
+		if err := checkPlacement(&list[i]); err != nil {
+			return nil, fmt.Errorf("第%d处签章%s", i+1, err.Error())
+		}
+	}
+	return list, nil
+}
+
+// formPlacement 由表单中的seal_id、page、pos_x、pos_y、scale、rotation组成一处签章，未填页码时为第1页
+func formPlacement(r *http.Request) (sealPlacement, error) {
+	p := sealPlacement{SealID: r.FormValue("seal_id"), Page: 1}
+	if v := r.FormValue("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil {
+			return p, errors.New("页码格式错误")
+		}
+		p.Page = page
+	}
+	var err error
+	if p.X, err = formFloat(r, "pos_x", "横坐标"); err != nil {
+		return p, err
+	}
+	if p.Y, err = formFloat(r, "pos_y", "纵坐标"); err != nil {
+		return p, err
+	}
+	if p.Scale, err = formFloat(r, "scale", "缩放比例"); err != nil {
+		return p, err
+	}
+	if p.Rotation, err = formFloat(r, "rotation", "旋转角度"); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// formFloat 读取表单中的数值，为空时为0
+func formFloat(r *http.Request, name, label string) (float64, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New(label + "格式错误")
+	}
+	return f, nil
+}
+
+// checkPlacement 校验一处签章的页码、位置、缩放比例和旋转角度，并将旋转角度归一化到 -180~180
+// 缩放比例的范围与签章日志（SignLog）的约束一致
+func checkPlacement(p *sealPlacement) error {
+	if p.Page < 1 {
+		return errors.New("页码无效")
+	}
+	if math.IsNaN(p.X) || math.IsInf(p.X, 0) || math.IsNaN(p.Y) || math.IsInf(p.Y, 0) {
+		return errors.New("位置无效")
+	}
+	if !(p.Scale >= minStampScale && p.Scale <= maxStampScale) {
+		return fmt.Errorf("缩放比例应在%.1f~%.1f之间", minStampScale, maxStampScale)
+	}
+	if math.IsNaN(p.Rotation) || math.IsInf(p.Rotation, 0) {
+		return errors.New("旋转角度无效")
+	}
+	p.Rotation = math.Mod(math.Mod(p.Rotation+180, 360)+360, 360) - 180
+	return nil
+}
+
+// checkPlacementPages 校验签章所在页码不超过文档页数
+func checkPlacementPages(docPath string, placements []sealPlacement) error {
+	var count int
+	if isOFDDocument(docPath) {
+		data, err := os.ReadFile(docPath)
+		if err != nil {
+			return errors.New("文档读取失败")
+		}
+		pkg, err := ofdsign.Open(data)
+		if err != nil {
+			return errors.New("OFD文档解析失败")
+		}
+		count = len(pkg.Pages)
+	} else {
+		n, err := api.PageCountFile(docPath)
+		if err != nil {
+			return errors.New("PDF文档解析失败")
+		}
+		count = n
+	}
+	for i, p := range placements {
+		if p.Page > count {
+			return fmt.Errorf("第%d处签章页码超出范围，文档共%d页", i+1, count)
+		}
+	}
+	return nil
+}
+
+// loadSeals 读取各处签章使用的签章图片和电子印章，签章须为本人或所在组织允许使用且未停用的签章
+func loadSeals(userID string, placements []sealPlacement) (map[string]sealFiles, error) {
+	seals := map[string]sealFiles{}
+	for _, p := range placements {
+		if _, ok := seals[p.SealID]; ok {
+			continue
+		}
+		var sealPath, sesPath string
+		err := config.DB.QueryRow("SELECT Location, ISNULL(SESLocation, '') FROM [Seal] WHERE SealID=@p1 AND RetiredAt IS NULL AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionUse, "", "@p2"), p.SealID, userID).Scan(&sealPath, &sesPath)
+		if err != nil {
+			fmt.Println("[SignPDFHandler] 未找到签章图片:", p.SealID, err)
+			return nil, errors.New("未找到签章图片")
+		}
+		f, err := readSealFiles(sealPath, sesPath)
+		if err != nil {
+			return nil, err
+		}
+		seals[p.SealID] = f
+	}
+	return seals, nil
+}
+
+// readSealFiles 读取签章图片和电子印章文件
+func readSealFiles(sealPath, sesPath string) (sealFiles, error) {
+	var f sealFiles
+	var err error
+	if f.image, err = os.ReadFile(sealPath); err != nil {
+		return f, fmt.Errorf("无法打开印章图片: %v", err)
+	}
+	// 制作了电子印章的签章，签名时一并生成引用该印章的电子签章；早期上传的签章图片没有电子印章
+	if sesPath != "" {
+		if f.ses, err = os.ReadFile(sesPath); err != nil {
+			return f, fmt.Errorf("无法读取电子印章: %v", err)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// formRequest 构造表单POST请求
+func formRequest(values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/sign/pdf", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// placementsRequest 构造placements为JSON数组的请求
+func placementsRequest(t *testing.T, list []sealPlacement) *http.Request {
+	t.Helper()
+	raw, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return formRequest(url.Values{"placements": {string(raw)}})
+}
+
+func TestCheckPlacement(t *testing.T) {
+	valid := sealPlacement{SealID: "s", Page: 1, X: 100, Y: 200, Scale: 0.5}
+	if err := checkPlacement(&valid); err != nil {
+		t.Fatalf("有效的签章位置校验失败: %v", err)
+	}
+	for name, p := range map[string]sealPlacement{
+		"页码为0":    {Page: 0, Scale: 0.5},
+		"横坐标NaN":  {Page: 1, X: math.NaN(), Scale: 0.5},
+		"纵坐标无穷":   {Page: 1, Y: math.Inf(1), Scale: 0.5},
+		"缩放比例过小":  {Page: 1, Scale: minStampScale - 0.01},
+		"缩放比例过大":  {Page: 1, Scale: maxStampScale + 0.01},
+		"缩放比例NaN": {Page: 1, Scale: math.NaN()},
+		"旋转角度无穷":  {Page: 1, Scale: 0.5, Rotation: math.Inf(-1)},
+	} {
+		if err := checkPlacement(&p); err == nil {
+			t.Errorf("%s应校验失败", name)
+		}
+	}
+}
+
+func TestCheckPlacementRotation(t *testing.T) {
+	for in, want := range map[float64]float64{0: 0, 90: 90, 180: -180, -180: -180, 270: -90, -270: 90, 720: 0, 405: 45} {
+		p := sealPlacement{Page: 1, Scale: 1, Rotation: in}
+		if err := checkPlacement(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Rotation != want {
+			t.Errorf("旋转角度%v归一化为%v，应为%v", in, p.Rotation, want)
+		}
+	}
+}
+
+func TestParsePlacementsForm(t *testing.T) {
+	list, err := parsePlacements(formRequest(url.Values{
+		"seal_id": {"seal1"}, "page": {"2"}, "pos_x": {"10.5"}, "pos_y": {"20"}, "scale": {"0.8"}, "rotation": {"190"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sealPlacement{SealID: "seal1", Page: 2, X: 10.5, Y: 20, Scale: 0.8, Rotation: -170}
+	if len(list) != 1 || list[0] != want {
+		t.Fatalf("签章位置 = %+v，应为%+v", list, want)
+	}
+	// 未填页码时为第1页
+	list, err = parsePlacements(formRequest(url.Values{"seal_id": {"seal1"}, "scale": {"1"}}))
+	if err != nil || list[0].Page != 1 {
+		t.Fatalf("未填页码的签章位置 = %+v, %v", list, err)
+	}
+	for name, values := range map[string]url.Values{
+		"未选择签章":  {"scale": {"1"}},
+		"页码格式错误": {"seal_id": {"s"}, "page": {"一"}, "scale": {"1"}},
+		"坐标格式错误": {"seal_id": {"s"}, "pos_x": {"abc"}, "scale": {"1"}},
+		"坐标为NaN": {"seal_id": {"s"}, "pos_y": {"NaN"}, "scale": {"1"}},
+		"缩放比例为空": {"seal_id": {"s"}},
+	} {
+		if _, err := parsePlacements(formRequest(values)); err == nil {
+			t.Errorf("%s应解析失败", name)
+		}
+	}
+}
+
+func TestParsePlacementsList(t *testing.T) {
+	list, err := parsePlacements(placementsRequest(t, []sealPlacement{
+		{SealID: "a", Page: 1, X: 10, Y: 10, Scale: 0.5},
+		{SealID: "b", Page: 3, X: 20, Y: 30, Scale: 1.5, Rotation: -90},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[1].SealID != "b" || list[1].Page != 3 {
+		t.Fatalf("签章位置列表 = %+v", list)
+	}
+	if _, err := parsePlacements(formRequest(url.Values{"placements": {"[{"}})); err == nil {
+		t.Error("格式错误的签章位置列表应解析失败")
+	}
+	if _, err := parsePlacements(formRequest(url.Values{"placements": {"[]"}})); err == nil {
+		t.Error("空的签章位置列表应解析失败")
+	}
+	_, err = parsePlacements(placementsRequest(t, []sealPlacement{
+		{SealID: "a", Page: 1, Scale: 0.5},
+		{SealID: "a", Page: 1, Scale: 5},
+	}))
+	if err == nil || !strings.HasPrefix(err.Error(), "第2处签章") {
+		t.Errorf("第2处签章缩放比例超出范围应返回第2处的错误: %v", err)
+	}
+	_, err = parsePlacements(placementsRequest(t, []sealPlacement{{Page: 1, Scale: 0.5}}))
+	if err == nil {
+		t.Error("未选择签章图片的签章位置应解析失败")
+	}
+	tooMany := make([]sealPlacement, maxPlacements+1)
+	for i := range tooMany {
+		tooMany[i] = sealPlacement{SealID: "a", Page: 1, Scale: 1}
+	}
+	if _, err := parsePlacements(placementsRequest(t, tooMany)); err == nil {
+		t.Errorf("超过%d处签章应解析失败", maxPlacements)
+	}
+}
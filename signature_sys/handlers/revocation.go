@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"signature_sys/ca"
+	"signature_sys/config"
+	"signature_sys/pdfsign"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// 实现证书吊销信息的发布：定期重新生成的CRL和OCSP响应服务（RFC 6960），
+// 以及签名和验签时使用的吊销信息来源。国密签发CA只发布CRL。
+
+// crlCache 当前发布的CRL，键为CA名称（见crlAuthorities）
+var crlCache struct {
+	sync.RWMutex
+	der map[string][]byte
+}
+
+// crlAuthorities 发布CRL的CA，键为/ca/crl的ca参数：空为签发CA，sm2为国密签发CA
+func crlAuthorities() map[string]*ca.CA {
+	return map[string]*ca.CA{"": config.CA, "sm2": config.SM2CA}
+}
+
+// StartCRLJob 生成CRL并启动定期重新生成的后台任务，程序启动时调用
+func StartCRLJob() {
+	refreshCRL()
+	go func() {
+		for {
+			time.Sleep(config.CRLInterval)
+			refreshCRL()
+		}
+	}()
+}
+
+// refreshCRL 根据证书库中的吊销记录重新生成各签发CA的CRL
+func refreshCRL() {
+	for name, c := range crlAuthorities() {
+		refreshCAcrl(name, c)
+	}
+}
+
+// refreshCAcrl 重新生成一个签发CA的CRL
+func refreshCAcrl(name string, c *ca.CA) {
+	rows, err := config.DB.Query("SELECT SerialNumber, RevokedAt, ISNULL(RevocationReason, 0) FROM [Cert] WHERE RevokedAt IS NOT NULL AND SerialNumber IS NOT NULL AND IssuerDN=@p1",
+		c.Issuing.Subject.String())
+	if err != nil {
+		fmt.Println("[CRL] 查询吊销证书失败:", err)
+		return
+	}
+	var revoked []ca.RevokedCert
+	for rows.Next() {
+		var serial string
+		var rc ca.RevokedCert
+		if err := rows.Scan(&serial, &rc.RevokedAt, &rc.Reason); err != nil {
+			continue
+		}
+		if n, ok := new(big.Int).SetString(serial, 16); ok {
+			rc.SerialNumber = n
+			revoked = append(revoked, rc)
+		}
+	}
+	rows.Close()
+	now := time.Now()
+	// CRL编号使用生成时间，保证单调递增
+	der, err := c.CreateCRL(revoked, big.NewInt(now.UnixNano()), now, now.Add(config.CRLValidity))
+	if err != nil {
+		fmt.Println("[CRL] 生成CRL失败:", c.Issuing.Subject.CommonName, err)
+		return
+	}
+	crlCache.Lock()
+	if crlCache.der == nil {
+		crlCache.der = map[string][]byte{}
+	}
+	crlCache.der[name] = der
+	crlCache.Unlock()
+	fmt.Printf("[CRL] 已生成%s的CRL，吊销证书%d个\n", c.Issuing.Subject.CommonName, len(revoked))
+}
+
+// cachedCRL 返回CA当前发布的CRL，尚未生成时返回nil
+func cachedCRL(name string) []byte {
+	crlCache.RLock()
+	defer crlCache.RUnlock()
+	return crlCache.der[name]
+}
+
+// CRLHandler 下载签发CA的CRL（DER编码），ca=sm2时为国密签发CA的CRL
+func CRLHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("ca")
+	der := cachedCRL(name)
+	if der == nil {
+		http.Error(w, "CRL尚未生成", 503)
+		return
+	}
+	filename := "signature_sys_issuing_ca.crl"
+	if name == "sm2" {
+		filename = "signature_sys_sm2_issuing_ca.crl"
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	w.Write(der)
+}
+
+// OCSPHandler OCSP服务，支持POST（application/ocsp-request）和GET（/ocsp/{base64编码的请求}）
+func OCSPHandler(w http.ResponseWriter, r *http.Request) {
+	var reqDER []byte
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		reqDER, err = io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	case http.MethodGet:
+		reqDER, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/ocsp/"))
+	default:
+		http.Error(w, "仅支持GET和POST", 405)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+	w.Write(config.CA.OCSPResponse(reqDER, certStatus, config.OCSPValidity))
+}
+
+// certStatus 在证书库中按序列号查询签发CA所签发证书的吊销状态
+func certStatus(serial *big.Int) (*ca.RevokedCert, bool, error) {
+	var revokedAt sql.NullTime
+	var reason int
+	err := config.DB.QueryRow("SELECT RevokedAt, ISNULL(RevocationReason, 0) FROM [Cert] WHERE SerialNumber=@p1 AND IssuerDN=@p2",
+		strings.ToUpper(serial.Text(16)), config.CA.Issuing.Subject.String()).Scan(&revokedAt, &reason)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !revokedAt.Valid {
+		return nil, true, nil
+	}
+	return &ca.RevokedCert{SerialNumber: serial, RevokedAt: revokedAt.Time, Reason: reason}, true, nil
+}
+
+// localRevocation 吊销信息来源：本系统签发的证书直接在进程内生成OCSP响应（国密证书使用当前CRL），其他证书在线查询
+type localRevocation struct {
+	online pdfsign.OnlineRevocation
+}
+
+// Revocation 实现pdfsign.RevocationSource
+func (l *localRevocation) Revocation(cert, issuer *x509.Certificate) ([]byte, []byte, error) {
+	if issuer.Equal(config.SM2CA.Issuing) {
+		return cachedCRL("sm2"), nil, nil
+	}
+	if !issuer.Equal(config.CA.Issuing) {
+		return l.online.Revocation(cert, issuer)
+	}
+	req, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, config.CA.OCSPResponse(req, certStatus, config.OCSPValidity), nil
+}
+
+// revocationSource 返回签名（写入DSS）和验签时使用的吊销信息来源
+func revocationSource() pdfsign.RevocationSource {
+	return &localRevocation{}
+}
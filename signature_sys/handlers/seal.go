@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
 	"encoding/hex"
+	"fmt"
 	"html/template"
 	"io"
 	"net/http"
@@ -11,21 +13,43 @@ import (
 	"path/filepath"
 	"signature_sys/config"
 	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/ses"
+	"signature_sys/utils"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 // 实现签章图片相关的HTTP处理逻辑，包括签章图片上传、列表展示、删除等功能。
+// 上传签章图片时由制章人制作GB/T 38540电子印章（SES_Seal），签名时引用该印章生成电子签章。
 
 // 签章图片上传页面和处理
-// GET: 渲染上传页面
-// POST: 处理图片上传，计算哈希，保存图片，写入数据库
+// GET: 渲染上传页面，列出可绑定到电子印章的证书
+// POST: 处理图片上传，计算哈希，保存图片，由制章人制作电子印章（SES_Seal），写入数据库
 func SealUploadHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，返回401错误
+		http.Error(w, "请先登录", http.StatusUnauthorized)
+		return
+	}
 	if r.Method == http.MethodGet {
-		// 渲染上传页面
+		// 渲染上传页面，带上用户可使用的未吊销证书（含组织证书）供绑定，以及可管理签章的组织
+		certRows, _ := config.DB.Query(`SELECT c.CertID, c.Algo, ISNULL(o.Name, '') FROM [Cert] c LEFT JOIN [Organization] o ON c.OrgID=o.OrgID
+    WHERE c.RevokedAt IS NULL AND `+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "c", "@p1"), userID)
+		var certs []struct{ CertID, Algo, OrgName string }
+		for certRows.Next() {
+			var c struct{ CertID, Algo, OrgName string }
+			certRows.Scan(&c.CertID, &c.Algo, &c.OrgName)
+			certs = append(certs, c)
+		}
+		certRows.Close()
 		t, _ := template.ParseFiles("templates/seal_upload.html")
-		t.Execute(w, nil)
+		t.Execute(w, map[string]interface{}{"Certs": certs, "SizeMM": config.DefaultSealSizeMM, "Orgs": userOrgs(userID, models.RoleAdmin, models.RoleSealKeeper)})
 		return
 	}
 	if r.Method == http.MethodPost {
@@ -37,33 +61,110 @@ func SealUploadHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer file.Close() // 关闭文件句柄
+		img, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "文件上传失败", 400)
+			return
+		}
+		// 电子印章中须注明图片类型，只接受PNG/JPEG/GIF
+		imgType, ok := sealImageTypes[http.DetectContentType(img)]
+		if !ok {
+			http.Error(w, "仅支持PNG、JPEG、GIF格式的签章图片", 400)
+			return
+		}
+		// 印章属性：名称、类型、有效期和显示尺寸
+		sealName := strings.TrimSpace(r.FormValue("seal_name"))
+		if sealName == "" {
+			sealName = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+		}
+		sealType, _ := strconv.Atoi(r.FormValue("seal_type"))
+		if sealType != ses.TypeOrganization {
+			sealType = ses.TypePersonal
+		}
+		validYears, _ := strconv.Atoi(r.FormValue("valid_years"))
+		if validYears <= 0 || validYears > 10 {
+			validYears = 5
+		}
+		widthMM, _ := strconv.Atoi(r.FormValue("width_mm"))
+		heightMM, _ := strconv.Atoi(r.FormValue("height_mm"))
+		if widthMM <= 0 {
+			widthMM = config.DefaultSealSizeMM
+		}
+		if heightMM <= 0 {
+			heightMM = config.DefaultSealSizeMM
+		}
+		// 组织签章须由组织的管理员或印章管理员上传，归属组织后按角色授权使用
+		var orgID sql.NullString
+		if v := r.FormValue("org_id"); v != "" {
+			if !middleware.CanInOrg(userID, v, middleware.ResourceSeal, middleware.ActionManage) {
+				middleware.Forbidden(w)
+				return
+			}
+			orgID = sql.NullString{String: v, Valid: true}
+		}
+		// 绑定到印章的签章人证书，只能选择自己可使用的未吊销证书
+		var certs []*x509.Certificate
+		for _, certID := range r.Form["cert_id"] {
+			var certPath string
+			if err := config.DB.QueryRow("SELECT Location FROM [Cert] WHERE CertID=@p1 AND RevokedAt IS NULL AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "", "@p2"), certID, userID).Scan(&certPath); err != nil {
+				http.Error(w, "未找到证书", 400)
+				return
+			}
+			cert, err := utils.LoadCertificate(certPath)
+			if err != nil {
+				http.Error(w, "读取证书失败", 500)
+				return
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			http.Error(w, "请至少选择一个签章证书", 400)
+			return
+		}
 		// 计算图片哈希
-		hasher := sha256.New()            // 新建SHA256哈希器
-		tee := io.TeeReader(file, hasher) // tee用于边读边哈希
-		// 保存图片到 static/seals 目录
-		os.MkdirAll("static/seals", 0755)                                              // 确保目录存在
-		sealID := uuid.New().String()                                                  // 生成唯一签章ID
-		imgPath := filepath.Join("static/seals", sealID+filepath.Ext(header.Filename)) // 拼接图片保存路径
-		out, err := os.Create(imgPath)                                                 // 创建图片文件
+		sum := sha256.Sum256(img)
+		imgHash := hex.EncodeToString(sum[:]) // 获取图片哈希值
+		// 制章人制作电子印章，印章编码使用签章ID
+		sealID := uuid.New().String() // 生成唯一签章ID
+		validStart := time.Now()
+		validEnd := validStart.AddDate(validYears, 0, 0)
+		sealDER, err := config.SealMaker.CreateSeal(ses.SealParams{
+			ESID:       sealID,
+			Type:       sealType,
+			Name:       sealName,
+			Certs:      certs,
+			ValidStart: validStart,
+			ValidEnd:   validEnd,
+			Image:      img,
+			ImageType:  imgType,
+			Width:      widthMM,
+			Height:     heightMM,
+		})
 		if err != nil {
+			fmt.Println("[SealUploadHandler] 电子印章制作失败:", err)
+			http.Error(w, "电子印章制作失败", 500)
+			return
+		}
+		// 保存图片和电子印章到受保护存储目录
+		imgPath := filepath.Join(config.SealsDir, sealID+filepath.Ext(header.Filename)) // 拼接图片保存路径
+		sesPath := filepath.Join(config.SealsDir, sealID+".ses")                        // 电子印章保存路径
+		if err := os.WriteFile(imgPath, img, 0644); err != nil {
 			// 如果保存图片失败，返回500错误
 			http.Error(w, "保存图片失败", 500)
 			return
 		}
-		defer out.Close()                              // 关闭输出文件
-		io.Copy(out, tee)                              // 写入图片并计算哈希
-		imgHash := hex.EncodeToString(hasher.Sum(nil)) // 获取图片哈希值
-		// 获取当前登录用户ID
-		userID, _ := middleware.GetCurrentUser(r)
-		if userID == "" {
-			// 如果用户未登录，返回401错误
-			http.Error(w, "请先登录", http.StatusUnauthorized)
+		if err := os.WriteFile(sesPath, sealDER, 0644); err != nil {
+			os.Remove(imgPath)
+			http.Error(w, "保存电子印章失败", 500)
 			return
 		}
-		// 将签章信息写入数据库，增加OriginalName字段
-		_, err = config.DB.Exec("INSERT INTO [Seal] (SealID, UserID, ImageHash, Location, OriginalName) VALUES (@p1, @p2, @p3, @p4, @p5)", sealID, userID, imgHash, imgPath, header.Filename)
+		// 将签章信息写入数据库，增加OriginalName字段和电子印章属性
+		_, err = config.DB.Exec("INSERT INTO [Seal] (SealID, UserID, ImageHash, Location, OriginalName, SealName, SealType, ValidStart, ValidEnd, SESLocation, OrgID) VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11)",
+			sealID, userID, imgHash, imgPath, header.Filename, sealName, sealType, validStart, validEnd, sesPath, orgID)
 		if err != nil {
 			// 如果数据库写入失败，返回500错误
+			os.Remove(imgPath)
+			os.Remove(sesPath)
 			http.Error(w, "数据库写入失败", 500)
 			return
 		}
@@ -73,6 +174,18 @@ func SealUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sealImageTypes 支持的签章图片类型，键为内容类型，值为电子印章中的图片类型
+var sealImageTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+}
+
+// sealListQuery 查询用户可查看的签章图片（含所在组织的签章）及电子印章属性，早期上传的图片没有电子印章
+var sealListQuery = `SELECT s.SealID, s.ImageHash, s.Location, ISNULL(s.OriginalName, ''), ISNULL(s.SealName, ''), ISNULL(s.SealType, 0), s.ValidEnd, ISNULL(s.SESLocation, ''), ISNULL(o.Name, ''), s.RetiredAt,
+    CASE WHEN ` + middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p1") + ` THEN 1 ELSE 0 END
+    FROM [Seal] s LEFT JOIN [Organization] o ON s.OrgID=o.OrgID WHERE ` + middleware.Scope(middleware.ResourceSeal, middleware.ActionView, "s", "@p1")
+
 // 签章图片列表页面
 // 展示当前用户所有签章图片，支持前端查找（已在模板实现）
 func SealListHandler(w http.ResponseWriter, r *http.Request) {
@@ -89,9 +202,9 @@ func SealListHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if query != "" {
 		// 按原始文件名模糊查询
-		rows, err = config.DB.Query("SELECT SealID, ImageHash, Location, OriginalName FROM [Seal] WHERE UserID=@p1 AND OriginalName LIKE @p2", userID, "%"+query+"%")
+		rows, err = config.DB.Query(sealListQuery+" AND (s.OriginalName LIKE @p2 OR s.SealName LIKE @p2)", userID, "%"+query+"%")
 	} else {
-		rows, err = config.DB.Query("SELECT SealID, ImageHash, Location, OriginalName FROM [Seal] WHERE UserID=@p1", userID)
+		rows, err = config.DB.Query(sealListQuery, userID)
 	}
 	if err != nil {
 		// 如果数据库查询失败，返回500错误
@@ -99,21 +212,25 @@ func SealListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer rows.Close() // 关闭结果集
-	var seals []struct {
-		SealID       string // 签章ID
-		ImageHash    string // 图片哈希
-		Location     string // 图片路径
-		OriginalName string // 原始文件名
+	type sealRow struct {
+		SealID       string       // 签章ID
+		ImageHash    string       // 图片哈希
+		Location     string       // 图片路径
+		OriginalName string       // 原始文件名
+		SealName     string       // 印章名称
+		SealType     int          // 印章类型，早期上传的图片为0
+		ValidEnd     sql.NullTime // 电子印章有效期截止
+		HasSES       bool         // 是否已制作电子印章
+		OrgName      string       // 所属组织名称，个人签章为空
+		CanManage    bool         // 当前用户能否删除该签章
+		RetiredAt    sql.NullTime // 停用时间，停用的签章不能再盖章
 	}
+	var seals []sealRow
 	for rows.Next() {
-		var s struct {
-			SealID       string
-			ImageHash    string
-			Location     string
-			OriginalName string
-		}
-		rows.Scan(&s.SealID, &s.ImageHash, &s.Location, &s.OriginalName)
-		s.Location = strings.ReplaceAll(s.Location, "\\", "/")
+		var s sealRow
+		var sesPath string
+		rows.Scan(&s.SealID, &s.ImageHash, &s.Location, &s.OriginalName, &s.SealName, &s.SealType, &s.ValidEnd, &sesPath, &s.OrgName, &s.RetiredAt, &s.CanManage)
+		s.HasSES = sesPath != ""
 		seals = append(seals, s)
 	}
 	// 渲染签章列表页面，带上当前搜索关键字
@@ -121,8 +238,55 @@ func SealListHandler(w http.ResponseWriter, r *http.Request) {
 	t.Execute(w, map[string]interface{}{"Seals": seals, "Query": query})
 }
 
+// 查看签章图片
+// 只能查看自己可查看的签章图片（含所在组织的签章），或他人授权自己使用（授权未撤销）的签章图片
+func SealImageHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// 校验签章访问权限或用印授权，查询图片路径
+	var imgPath string
+	err := config.DB.QueryRow(`SELECT Location FROM [Seal] s WHERE SealID=@p1 AND (`+middleware.Scope(middleware.ResourceSeal, middleware.ActionView, "s", "@p2")+`
+    OR EXISTS (SELECT 1 FROM [SealGrant] g WHERE g.SealID=s.SealID AND g.GranteeID=@p2 AND g.RevokedAt IS NULL))`, r.URL.Query().Get("seal_id"), userID).Scan(&imgPath)
+	if err != nil {
+		// 如果未找到图片，返回404错误
+		http.Error(w, "未找到图片", 404)
+		return
+	}
+	// 按文件内容识别图片类型，不信任上传时的扩展名
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeFile(w, r, imgPath)
+}
+
+// 下载电子印章
+// 只能下载自己可查看的电子印章（含所在组织的电子印章），返回SES_Seal的DER编码
+func SealSESHandler(w http.ResponseWriter, r *http.Request) {
+	// 获取当前登录用户ID
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		// 如果用户未登录，重定向到登录页面
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	// 校验签章访问权限，查询电子印章路径
+	sealID := r.URL.Query().Get("seal_id")
+	var sesPath string
+	err := config.DB.QueryRow("SELECT ISNULL(SESLocation, '') FROM [Seal] WHERE SealID=@p1 AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionView, "", "@p2"), sealID, userID).Scan(&sesPath)
+	if err != nil || sesPath == "" {
+		http.Error(w, "未找到电子印章", 404)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+sealID+".ses")
+	http.ServeFile(w, r, sesPath)
+}
+
 // 删除签章图片
-// 仅支持POST，校验用户，删除数据库记录和图片文件
+// 仅支持POST，校验管理权限（个人签章为本人，组织签章为组织管理员或印章管理员），删除数据库记录和图片文件
 func SealDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	// 获取当前登录用户ID
 	userID, _ := middleware.GetCurrentUser(r)
@@ -143,16 +307,23 @@ func SealDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "参数错误", 400)
 		return
 	}
-	// 查询图片路径
-	var imgPath string
-	err := config.DB.QueryRow("SELECT Location FROM [Seal] WHERE SealID=@p1 AND UserID=@p2", sealID, userID).Scan(&imgPath)
+	// 查询图片和电子印章路径
+	var imgPath, sesPath string
+	err := config.DB.QueryRow("SELECT Location, ISNULL(SESLocation, '') FROM [Seal] WHERE SealID=@p1 AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "", "@p2"), sealID, userID).Scan(&imgPath, &sesPath)
 	if err != nil {
 		// 如果未找到图片，返回404错误
 		http.Error(w, "未找到图片", 404)
 		return
 	}
+	// 授权过他人使用的签章需保留，供用印审计
+	var grants int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [SealGrant] WHERE SealID=@p1", sealID).Scan(&grants)
+	if grants > 0 {
+		http.Error(w, "签章已授权他人使用，不能删除", 400)
+		return
+	}
 	// 删除数据库记录
-	_, err = config.DB.Exec("DELETE FROM [Seal] WHERE SealID=@p1 AND UserID=@p2", sealID, userID)
+	_, err = config.DB.Exec("DELETE FROM [Seal] WHERE SealID=@p1", sealID)
 	if err != nil {
 		// 如果数据库删除失败，返回500错误
 		http.Error(w, "数据库删除失败", 500)
@@ -160,6 +331,9 @@ func SealDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// 删除文件
 	os.Remove(imgPath)
+	if sesPath != "" {
+		os.Remove(sesPath)
+	}
 	// 重定向到签章列表页面
 	http.Redirect(w, r, "/seal/list", http.StatusSeeOther)
 }
@@ -0,0 +1,700 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"os"
+	"signature_sys/config"
+	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/ses"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 实现用印授权和用印申请：签章所有者（组织签章为组织管理员或印章管理员）授权其他用户使用签章，授权限定有效期、使用次数和可盖章的文档；
+// 需要审批的授权，被授权人每次用印前提交用印申请，所有者批准后才能在该文档上盖章，每次批准只能用印一次。
+// 被授权人使用自己的证书签名，证书不在电子印章绑定的证书列表中时只加盖签章图片，不生成电子签章。
+
+// 授权当前可用的条件：未撤销、在有效期内且未用完次数；条件中使用别名g（SealGrant）
+const grantUsableCond = "g.RevokedAt IS NULL AND g.ValidFrom<=GETDATE() AND g.ValidTo>GETDATE() AND (g.MaxUses IS NULL OR g.UsedCount<g.MaxUses)"
+
+// 用印授权查询的公共部分，附带签章名称、被授权人和授权人用户名
+const sealGrantQuery = `SELECT g.GrantID, g.SealID, ISNULL(NULLIF(s.SealName, ''), ISNULL(s.OriginalName, '')), g.GranteeID, ge.Username, g.GrantedBy, gr.Username,
+    g.ValidFrom, g.ValidTo, ISNULL(g.MaxUses, 0), g.UsedCount, g.RequireApproval, g.CreatedAt, g.RevokedAt
+    FROM [SealGrant] g JOIN [Seal] s ON g.SealID=s.SealID JOIN [User] ge ON g.GranteeID=ge.UserID JOIN [User] gr ON g.GrantedBy=gr.UserID`
+
+// 用印申请查询的公共部分，附带签章名称、申请人、文档和审批人
+const sealRequestQuery = `SELECT r.RequestID, r.GrantID, ISNULL(NULLIF(s.SealName, ''), ISNULL(s.OriginalName, '')), r.RequesterID, u.Username, r.DocID, ISNULL(d.OriginalName, ''),
+    r.Reason, r.Status, ISNULL(a.Username, ''), ISNULL(r.Comment, ''), r.CreatedAt, r.DecidedAt, r.UsedAt
+    FROM [SealRequest] r JOIN [SealGrant] g ON r.GrantID=g.GrantID JOIN [Seal] s ON g.SealID=s.SealID
+    JOIN [User] u ON r.RequesterID=u.UserID JOIN [Document] d ON r.DocID=d.DocID LEFT JOIN [User] a ON r.ApproverID=a.UserID`
+
+// grantDocument 授权范围内的一份文档
+type grantDocument struct {
+	DocID        string
+	OriginalName string
+}
+
+// sealUse 一处他人签章的使用依据：用印授权和已批准的用印申请（授权不需要审批时为空）
+type sealUse struct {
+	SealID    string
+	GrantID   string
+	RequestID string
+}
+
+// queryGrants 按条件查询用印授权，按创建时间倒序；where中可使用别名g（SealGrant）
+func queryGrants(where string, args ...interface{}) []models.SealGrant {
+	rows, err := config.DB.Query(sealGrantQuery+" WHERE "+where+" ORDER BY g.CreatedAt DESC", args...)
+	if err != nil {
+		fmt.Println("查询用印授权失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var grants []models.SealGrant
+	for rows.Next() {
+		g, err := scanGrant(rows)
+		if err != nil {
+			fmt.Println("扫描用印授权失败:", err)
+			continue
+		}
+		grants = append(grants, g)
+	}
+	return grants
+}
+
+// loadGrant 按ID读取用印授权
+func loadGrant(grantID string) (models.SealGrant, error) {
+	return scanGrant(config.DB.QueryRow(sealGrantQuery+" WHERE g.GrantID=@p1", grantID))
+}
+
+// scanGrant 读取sealGrantQuery的一行
+func scanGrant(row interface{ Scan(...interface{}) error }) (models.SealGrant, error) {
+	var g models.SealGrant
+	var createdAt, revokedAt sql.NullTime
+	err := row.Scan(&g.GrantID, &g.SealID, &g.SealName, &g.GranteeID, &g.GranteeName, &g.GrantedBy, &g.GrantorName,
+		&g.ValidFrom, &g.ValidTo, &g.MaxUses, &g.UsedCount, &g.RequireApproval, &createdAt, &revokedAt)
+	if err != nil {
+		return g, err
+	}
+	g.CreatedAt = createdAt.Time
+	if revokedAt.Valid {
+		g.RevokedAt = &revokedAt.Time
+	}
+	return g, nil
+}
+
+// grantDocuments 查询授权限定的文档，没有限定时为空（可在被授权人的任意文档上盖章）
+func grantDocuments(grantID string) []grantDocument {
+	rows, err := config.DB.Query(`SELECT gd.DocID, ISNULL(d.OriginalName, '') FROM [SealGrantDocument] gd JOIN [Document] d ON gd.DocID=d.DocID
+    WHERE gd.GrantID=@p1 ORDER BY d.OriginalName`, grantID)
+	if err != nil {
+		fmt.Println("查询授权文档失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var docs []grantDocument
+	for rows.Next() {
+		var d grantDocument
+		rows.Scan(&d.DocID, &d.OriginalName)
+		docs = append(docs, d)
+	}
+	return docs
+}
+
+// grantCovers 授权是否包含文档docID：没有限定文档时包含被授权人的全部文档
+func grantCovers(grantID, docID string) bool {
+	var total, matched int
+	config.DB.QueryRow("SELECT COUNT(*), ISNULL(SUM(CASE WHEN DocID=@p2 THEN 1 ELSE 0 END), 0) FROM [SealGrantDocument] WHERE GrantID=@p1", grantID, docID).Scan(&total, &matched)
+	return total == 0 || matched > 0
+}
+
+// querySealRequests 按条件查询用印申请，待审批的在前，其余按申请时间倒序；where中可使用别名r（SealRequest）和g（SealGrant）
+func querySealRequests(where string, args ...interface{}) []models.SealRequest {
+	rows, err := config.DB.Query(sealRequestQuery+" WHERE "+where+" ORDER BY CASE WHEN r.Status='pending' THEN 0 ELSE 1 END, r.CreatedAt DESC", args...)
+	if err != nil {
+		fmt.Println("查询用印申请失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var requests []models.SealRequest
+	for rows.Next() {
+		var q models.SealRequest
+		var createdAt, decidedAt, usedAt sql.NullTime
+		if err := rows.Scan(&q.RequestID, &q.GrantID, &q.SealName, &q.RequesterID, &q.RequesterName, &q.DocID, &q.DocName,
+			&q.Reason, &q.Status, &q.ApproverName, &q.Comment, &createdAt, &decidedAt, &usedAt); err != nil {
+			fmt.Println("扫描用印申请失败:", err)
+			continue
+		}
+		q.CreatedAt = createdAt.Time
+		if decidedAt.Valid {
+			q.DecidedAt = &decidedAt.Time
+		}
+		if usedAt.Valid {
+			q.UsedAt = &usedAt.Time
+		}
+		requests = append(requests, q)
+	}
+	return requests
+}
+
+// findSealUse 查找用户在文档上使用他人签章的依据：当前可用、包含该文档的授权，
+// 需要审批的授权还须有该文档已批准且未使用的用印申请
+func findSealUse(userID, sealID, docID string) (*sealUse, error) {
+	grants := queryGrants("g.SealID=@p1 AND g.GranteeID=@p2 AND "+grantUsableCond, sealID, userID)
+	if len(grants) == 0 {
+		return nil, errors.New("未获得该签章的使用授权，或授权已过期、次数已用完")
+	}
+	err := errors.New("该签章的使用授权不包含此文档")
+	for _, g := range grants {
+		if !grantCovers(g.GrantID, docID) {
+			continue
+		}
+		if !g.RequireApproval {
+			return &sealUse{SealID: sealID, GrantID: g.GrantID}, nil
+		}
+		var requestID string
+		e := config.DB.QueryRow("SELECT TOP 1 RequestID FROM [SealRequest] WHERE GrantID=@p1 AND RequesterID=@p2 AND DocID=@p3 AND Status=@p4 ORDER BY DecidedAt",
+			g.GrantID, userID, docID, models.SealRequestApproved).Scan(&requestID)
+		if e == nil {
+			return &sealUse{SealID: sealID, GrantID: g.GrantID, RequestID: requestID}, nil
+		}
+		err = errors.New("使用该签章需经所有者审批，请先提交用印申请并等待批准")
+	}
+	return nil, err
+}
+
+// loadAuthorizedSeals 读取各处签章使用的签章图片和电子印章：自己的签章和所在组织允许使用的签章直接使用，
+// 无权使用的签章须有在该文档上的使用依据，返回按签章ID索引的使用依据
+func loadAuthorizedSeals(userID, docID string, placements []sealPlacement) (map[string]sealFiles, map[string]*sealUse, error) {
+	seals := map[string]sealFiles{}
+	uses := map[string]*sealUse{}
+	for _, p := range placements {
+		if _, ok := seals[p.SealID]; ok {
+			continue
+		}
+		var sealPath, sesPath string
+		var retiredAt sql.NullTime
+		err := config.DB.QueryRow("SELECT Location, ISNULL(SESLocation, ''), RetiredAt FROM [Seal] WHERE SealID=@p1", p.SealID).Scan(&sealPath, &sesPath, &retiredAt)
+		if err != nil {
+			fmt.Println("[SignPDFHandler] 未找到签章图片:", p.SealID, err)
+			return nil, nil, errors.New("未找到签章图片")
+		}
+		if retiredAt.Valid {
+			return nil, nil, errors.New("签章已停用")
+		}
+		if !middleware.Authorize(userID, middleware.ResourceSeal, p.SealID, middleware.ActionUse) {
+			use, err := findSealUse(userID, p.SealID, docID)
+			if err != nil {
+				return nil, nil, err
+			}
+			uses[p.SealID] = use
+		}
+		f, err := readSealFiles(sealPath, sesPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		seals[p.SealID] = f
+	}
+	return seals, uses, nil
+}
+
+// reserveSealUses 签名前占用授权次数，并将已批准的用印申请标记为已用印；
+// 以条件更新占用，并发的签章请求不会超出次数或重复使用同一次批准，任一处失败时归还已占用的部分
+func reserveSealUses(uses map[string]*sealUse) error {
+	var reserved []*sealUse
+	for _, u := range uses {
+		res, err := config.DB.Exec("UPDATE g SET UsedCount=UsedCount+1 FROM [SealGrant] g WHERE g.GrantID=@p1 AND "+grantUsableCond, u.GrantID)
+		if err != nil {
+			releaseSealUses(reserved)
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			releaseSealUses(reserved)
+			return errors.New("用印授权已失效或次数已用完")
+		}
+		if u.RequestID != "" {
+			var n int64
+			res, err = config.DB.Exec("UPDATE [SealRequest] SET Status=@p1, UsedAt=GETDATE() WHERE RequestID=@p2 AND Status=@p3",
+				models.SealRequestUsed, u.RequestID, models.SealRequestApproved)
+			if err == nil {
+				n, _ = res.RowsAffected()
+			}
+			if n == 0 {
+				config.DB.Exec("UPDATE [SealGrant] SET UsedCount=UsedCount-1 WHERE GrantID=@p1 AND UsedCount>0", u.GrantID)
+				releaseSealUses(reserved)
+				return errors.New("用印申请已使用或已撤回")
+			}
+		}
+		reserved = append(reserved, u)
+	}
+	return nil
+}
+
+// releaseSealUses 签名失败时归还占用的授权次数和用印申请
+func releaseSealUses(uses []*sealUse) {
+	for _, u := range uses {
+		config.DB.Exec("UPDATE [SealGrant] SET UsedCount=UsedCount-1 WHERE GrantID=@p1 AND UsedCount>0", u.GrantID)
+		if u.RequestID != "" {
+			config.DB.Exec("UPDATE [SealRequest] SET Status=@p1, UsedAt=NULL WHERE RequestID=@p2 AND Status=@p3",
+				models.SealRequestApproved, u.RequestID, models.SealRequestUsed)
+		}
+	}
+}
+
+// sealUseList 按签章ID索引的使用依据转为列表
+func sealUseList(uses map[string]*sealUse) []*sealUse {
+	var list []*sealUse
+	for _, u := range uses {
+		list = append(list, u)
+	}
+	return list
+}
+
+// sesAllows 签名证书是否在电子印章绑定的证书列表中
+func sesAllows(sealDER []byte, cert *x509.Certificate) bool {
+	seal, err := ses.ParseSeal(sealDER)
+	if err != nil {
+		return false
+	}
+	ok, err := seal.Allows(cert)
+	return err == nil && ok
+}
+
+// grantedSeals 查询用户当前可用的他人签章（去重），用于签章页面的签章选择
+func grantedSeals(userID string) []struct{ SealID, Name string } {
+	rows, err := config.DB.Query(`SELECT DISTINCT s.SealID, ISNULL(NULLIF(s.SealName, ''), ISNULL(s.OriginalName, '')), u.Username
+    FROM [SealGrant] g JOIN [Seal] s ON g.SealID=s.SealID JOIN [User] u ON s.UserID=u.UserID
+    WHERE g.GranteeID=@p1 AND s.RetiredAt IS NULL AND NOT `+middleware.Scope(middleware.ResourceSeal, middleware.ActionUse, "s", "@p1")+" AND "+grantUsableCond, userID)
+	if err != nil {
+		fmt.Println("查询授权签章失败:", err)
+		return nil
+	}
+	defer rows.Close()
+	var seals []struct{ SealID, Name string }
+	for rows.Next() {
+		var s struct{ SealID, Name string }
+		var owner string
+		rows.Scan(&s.SealID, &s.Name, &owner)
+		s.Name = fmt.Sprintf("%s（%s授权）", s.Name, owner)
+		seals = append(seals, s)
+	}
+	return seals
+}
+
+// SealGrantListHandler 用印授权页面：列出当前用户可管理的签章（含组织签章）上的授权，提供新建授权表单
+func SealGrantListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	sealRows, _ := config.DB.Query("SELECT SealID, ISNULL(NULLIF(SealName, ''), ISNULL(OriginalName, '')) FROM [Seal] s WHERE s.RetiredAt IS NULL AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p1"), userID)
+	var seals []struct{ SealID, Name string }
+	for sealRows.Next() {
+		var s struct{ SealID, Name string }
+		sealRows.Scan(&s.SealID, &s.Name)
+		seals = append(seals, s)
+	}
+	sealRows.Close()
+	var pending int
+	config.DB.QueryRow("SELECT COUNT(*) FROM [SealRequest] r JOIN [SealGrant] g ON r.GrantID=g.GrantID JOIN [Seal] s ON g.SealID=s.SealID WHERE "+middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p1")+" AND r.Status=@p2",
+		userID, models.SealRequestPending).Scan(&pending)
+	t, err := template.ParseFiles("templates/seal_grant_list.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Grants":      queryGrants(middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p1"), userID),
+		"Seals":       seals,
+		"Pending":     pending,
+		"DefaultFrom": time.Now().Format("2006-01-02"),
+		"DefaultTo":   time.Now().AddDate(0, 1, 0).Format("2006-01-02"),
+	})
+}
+
+// SealGrantCreateHandler 新建用印授权（仅POST）：授权其他用户在有效期内使用自己可管理的签章
+// 有效期按日期填写，截止日期当天有效；使用次数为空或0表示不限
+func SealGrantCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	sealID := r.FormValue("seal_id")
+	if !middleware.Authorize(userID, middleware.ResourceSeal, sealID, middleware.ActionManage) {
+		http.Error(w, "未找到签章", 404)
+		return
+	}
+	var retiredAt sql.NullTime
+	config.DB.QueryRow("SELECT RetiredAt FROM [Seal] WHERE SealID=@p1", sealID).Scan(&retiredAt)
+	if retiredAt.Valid {
+		http.Error(w, "签章已停用，不能授权", 400)
+		return
+	}
+	var granteeID string
+	err := config.DB.QueryRow("SELECT UserID FROM [User] WHERE Username=@p1", strings.TrimSpace(r.FormValue("username"))).Scan(&granteeID)
+	if err != nil {
+		http.Error(w, "未找到被授权用户", 400)
+		return
+	}
+	if granteeID == userID {
+		http.Error(w, "不能授权给自己", 400)
+		return
+	}
+	from, err := time.ParseInLocation("2006-01-02", r.FormValue("valid_from"), time.Local)
+	if err != nil {
+		http.Error(w, "生效日期格式错误", 400)
+		return
+	}
+	to, err := time.ParseInLocation("2006-01-02", r.FormValue("valid_to"), time.Local)
+	if err != nil {
+		http.Error(w, "截止日期格式错误", 400)
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+	if !to.After(from) || !to.After(time.Now()) {
+		http.Error(w, "截止日期须晚于生效日期和今天", 400)
+		return
+	}
+	var maxUses sql.NullInt64
+	if v := strings.TrimSpace(r.FormValue("max_uses")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "使用次数格式错误", 400)
+			return
+		}
+		maxUses = sql.NullInt64{Int64: int64(n), Valid: n > 0}
+	}
+	grantID := uuid.New().String()
+	_, err = config.DB.Exec(`INSERT INTO [SealGrant] (GrantID, SealID, GranteeID, GrantedBy, ValidFrom, ValidTo, MaxUses, UsedCount, RequireApproval, CreatedAt)
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, 0, @p8, GETDATE())`,
+		grantID, sealID, granteeID, userID, from, to, maxUses, r.FormValue("require_approval") == "1")
+	if err != nil {
+		fmt.Println("[SealGrantCreateHandler] 数据库写入失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	http.Redirect(w, r, "/seal/grant/view?grant_id="+grantID, http.StatusSeeOther)
+}
+
+// SealGrantViewHandler 用印授权详情：签章的管理人查看授权范围内的文档和用印申请，并可限定可盖章的文档
+func SealGrantViewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	g, err := loadGrant(r.URL.Query().Get("grant_id"))
+	if err != nil || !middleware.Authorize(userID, middleware.ResourceSeal, g.SealID, middleware.ActionManage) {
+		http.Error(w, "未找到用印授权", 404)
+		return
+	}
+	docs := grantDocuments(g.GrantID)
+	// 可加入授权范围的文档：被授权人尚未加入的文档
+	docRows, _ := config.DB.Query(`SELECT DocID, OriginalName FROM [Document] d WHERE UserID=@p1
+    AND NOT EXISTS (SELECT 1 FROM [SealGrantDocument] gd WHERE gd.GrantID=@p2 AND gd.DocID=d.DocID) ORDER BY OriginalName`, g.GranteeID, g.GrantID)
+	var candidates []grantDocument
+	for docRows.Next() {
+		var d grantDocument
+		docRows.Scan(&d.DocID, &d.OriginalName)
+		candidates = append(candidates, d)
+	}
+	docRows.Close()
+	t, err := template.ParseFiles("templates/seal_grant_view.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Grant":      g,
+		"Docs":       docs,
+		"Candidates": candidates,
+		"Requests":   querySealRequests("r.GrantID=@p1", g.GrantID),
+	})
+}
+
+// SealGrantDocumentHandler 限定授权可盖章的文档（仅POST）：只能加入被授权人的文档，不能移除，
+// 避免移除最后一份文档后授权扩大到全部文档；需要调整范围时撤销后重新授权
+func SealGrantDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	g, err := loadGrant(r.FormValue("grant_id"))
+	if err != nil || !middleware.Authorize(userID, middleware.ResourceSeal, g.SealID, middleware.ActionManage) {
+		http.Error(w, "未找到用印授权", 404)
+		return
+	}
+	if g.RevokedAt != nil {
+		http.Error(w, "授权已撤销", 400)
+		return
+	}
+	for _, docID := range r.Form["doc_id"] {
+		var owner string
+		if err := config.DB.QueryRow("SELECT UserID FROM [Document] WHERE DocID=@p1", docID).Scan(&owner); err != nil || owner != g.GranteeID {
+			http.Error(w, "只能加入被授权人的文档", 400)
+			return
+		}
+		_, err = config.DB.Exec(`INSERT INTO [SealGrantDocument] (GrantID, DocID) SELECT @p1, @p2
+    WHERE NOT EXISTS (SELECT 1 FROM [SealGrantDocument] WHERE GrantID=@p1 AND DocID=@p2)`, g.GrantID, docID)
+		if err != nil {
+			fmt.Println("[SealGrantDocumentHandler] 数据库写入失败:", err)
+			http.Error(w, "数据库写入失败", 500)
+			return
+		}
+	}
+	http.Redirect(w, r, "/seal/grant/view?grant_id="+g.GrantID, http.StatusSeeOther)
+}
+
+// SealGrantRevokeHandler 撤销用印授权（仅POST），未使用的用印申请一并撤回
+func SealGrantRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	grantID := r.FormValue("grant_id")
+	g, err := loadGrant(grantID)
+	if err != nil || !middleware.Authorize(userID, middleware.ResourceSeal, g.SealID, middleware.ActionManage) {
+		http.Error(w, "未找到用印授权", 404)
+		return
+	}
+	res, err := config.DB.Exec("UPDATE [SealGrant] SET RevokedAt=GETDATE() WHERE GrantID=@p1 AND RevokedAt IS NULL", grantID)
+	if err != nil {
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		config.DB.Exec("UPDATE [SealRequest] SET Status=@p1, Comment=@p2, DecidedAt=GETDATE() WHERE GrantID=@p3 AND Status IN ('pending', 'approved')",
+			models.SealRequestCancelled, "授权已撤销", grantID)
+	}
+	http.Redirect(w, r, "/seal/grant/list", http.StatusSeeOther)
+}
+
+// SealGrantedHandler 我的用印授权：列出他人授予当前用户的授权和当前用户的用印申请，提供用印申请表单
+func SealGrantedHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	type grantRow struct {
+		models.SealGrant
+		Usable bool            // 当前是否可用
+		Docs   []grantDocument // 授权限定的文档，为空时不限
+	}
+	var grants []grantRow
+	now := time.Now()
+	for _, g := range queryGrants("g.GranteeID=@p1", userID) {
+		grants = append(grants, grantRow{SealGrant: g, Usable: g.Usable(now), Docs: grantDocuments(g.GrantID)})
+	}
+	docRows, _ := config.DB.Query("SELECT DocID, OriginalName FROM [Document] WHERE "+middleware.Scope(middleware.ResourceDocument, middleware.ActionUse, "", "@p1")+" ORDER BY OriginalName", userID)
+	var docs []grantDocument
+	for docRows.Next() {
+		var d grantDocument
+		docRows.Scan(&d.DocID, &d.OriginalName)
+		docs = append(docs, d)
+	}
+	docRows.Close()
+	t, err := template.ParseFiles("templates/seal_granted.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{
+		"Grants":   grants,
+		"Docs":     docs,
+		"Requests": querySealRequests("r.RequesterID=@p1", userID),
+	})
+}
+
+// SealRequestCreateHandler 提交用印申请（仅POST）：被授权人申请在自己的文档上使用需要审批的签章
+func SealRequestCreateHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	g, err := loadGrant(r.FormValue("grant_id"))
+	if err != nil || g.GranteeID != userID {
+		http.Error(w, "未找到用印授权", 404)
+		return
+	}
+	if !g.Usable(time.Now()) {
+		http.Error(w, "授权已失效，不能申请用印", 400)
+		return
+	}
+	if !g.RequireApproval {
+		http.Error(w, "该授权无需审批，可直接盖章", 400)
+		return
+	}
+	docID := r.FormValue("doc_id")
+	var owner string
+	if err := config.DB.QueryRow("SELECT UserID FROM [Document] WHERE DocID=@p1", docID).Scan(&owner); err != nil || owner != userID {
+		http.Error(w, "未找到文档", 404)
+		return
+	}
+	if !grantCovers(g.GrantID, docID) {
+		http.Error(w, "该授权不包含此文档", 400)
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		http.Error(w, "请填写用印事由", 400)
+		return
+	}
+	if len([]rune(reason)) > 255 {
+		reason = string([]rune(reason)[:255])
+	}
+	// 同一文档同时只能有一份待审批或已批准的申请
+	res, err := config.DB.Exec(`INSERT INTO [SealRequest] (RequestID, GrantID, RequesterID, DocID, Reason, Status, CreatedAt)
+    SELECT @p1, @p2, @p3, @p4, @p5, @p6, GETDATE()
+    WHERE NOT EXISTS (SELECT 1 FROM [SealRequest] WHERE GrantID=@p2 AND DocID=@p4 AND Status IN ('pending', 'approved'))`,
+		uuid.New().String(), g.GrantID, userID, docID, reason, models.SealRequestPending)
+	if err != nil {
+		fmt.Println("[SealRequestCreateHandler] 数据库写入失败:", err)
+		http.Error(w, "数据库写入失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "该文档已有待审批或已批准的用印申请", 400)
+		return
+	}
+	http.Redirect(w, r, "/seal/granted", http.StatusSeeOther)
+}
+
+// SealRequestCancelHandler 撤回用印申请（仅POST），只能撤回待审批或已批准未用印的申请
+func SealRequestCancelHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	_, err := config.DB.Exec("UPDATE [SealRequest] SET Status=@p1 WHERE RequestID=@p2 AND RequesterID=@p3 AND Status IN ('pending', 'approved')",
+		models.SealRequestCancelled, r.FormValue("request_id"), userID)
+	if err != nil {
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	http.Redirect(w, r, "/seal/granted", http.StatusSeeOther)
+}
+
+// SealApprovalListHandler 用印审批页面：列出当前用户可管理的签章上的用印申请，待审批的在前
+func SealApprovalListHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	t, err := template.ParseFiles("templates/seal_approvals.html")
+	if err != nil {
+		http.Error(w, "加载模板失败", 500)
+		fmt.Println("加载模板失败:", err)
+		return
+	}
+	t.Execute(w, map[string]interface{}{"Requests": querySealRequests(middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p1"), userID)})
+}
+
+// SealRequestDecideHandler 审批用印申请（仅POST）：decision为approve时批准，reject时驳回
+// 批准时授权须仍然可用；批准后申请人可在该文档上用印一次
+func SealRequestDecideHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	requestID := r.FormValue("request_id")
+	var grantID string
+	err := config.DB.QueryRow("SELECT r.GrantID FROM [SealRequest] r JOIN [SealGrant] g ON r.GrantID=g.GrantID JOIN [Seal] s ON g.SealID=s.SealID WHERE r.RequestID=@p1 AND "+
+		middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p2"),
+		requestID, userID).Scan(&grantID)
+	if err != nil {
+		http.Error(w, "未找到用印申请", 404)
+		return
+	}
+	status := models.SealRequestRejected
+	if r.FormValue("decision") == "approve" {
+		status = models.SealRequestApproved
+		if g, err := loadGrant(grantID); err != nil || !g.Usable(time.Now()) {
+			http.Error(w, "授权已失效，不能批准", 400)
+			return
+		}
+	}
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if len([]rune(comment)) > 255 {
+		comment = string([]rune(comment)[:255])
+	}
+	res, err := config.DB.Exec("UPDATE [SealRequest] SET Status=@p1, ApproverID=@p2, Comment=@p3, DecidedAt=GETDATE() WHERE RequestID=@p4 AND Status=@p5",
+		status, userID, comment, requestID, models.SealRequestPending)
+	if err != nil {
+		http.Error(w, "数据库更新失败", 500)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "申请已处理或已撤回", 400)
+		return
+	}
+	http.Redirect(w, r, "/seal/request/approvals", http.StatusSeeOther)
+}
+
+// SealRequestDocumentHandler 审批人查看用印申请的文档（最新修订），用于审批前确认文档内容
+func SealRequestDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	var location, name string
+	err := config.DB.QueryRow(`SELECT d.Location, d.OriginalName FROM [SealRequest] r JOIN [SealGrant] g ON r.GrantID=g.GrantID
+    JOIN [Seal] s ON g.SealID=s.SealID JOIN [Document] d ON r.DocID=d.DocID
+    WHERE r.RequestID=@p1 AND `+middleware.Scope(middleware.ResourceSeal, middleware.ActionManage, "s", "@p2"), r.URL.Query().Get("request_id"), userID).Scan(&location, &name)
+	if err != nil {
+		http.Error(w, "未找到用印申请", 404)
+		return
+	}
+	if _, err := os.Stat(location); err != nil {
+		http.Error(w, "文档文件不存在", 404)
+		return
+	}
+	w.Header().Set("Content-Type", documentContentType(location))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	http.ServeFile(w, r, location)
+}
@@ -2,23 +2,25 @@ package handlers
 
 import (
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"signature_sys/config"
 	"signature_sys/middleware"
+	"signature_sys/models"
+	"signature_sys/ofdsign"
+	"signature_sys/pdfsign"
 	"signature_sys/utils"
 	"strconv"
-	"strings"
-
-	"github.com/pdfcpu/pdfcpu/pkg/api"
-	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"time"
 )
 
 // 实现PDF签章相关的HTTP处理逻辑，包括签章页面、签章处理、证书/签章/文档选择等。
@@ -33,17 +35,21 @@ func SignPDFPageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	// 查询用户文档，带原始文件名
-	docsRows, _ := config.DB.Query("SELECT DocID, Location, OriginalName FROM [Document] WHERE UserID=@p1", userID)
-	var docs []struct{ DocID, Location, OriginalName string }
+	// 查询用户文档，带原始文件名和文档格式（OFD文档无法在页面中渲染）
+	docsRows, _ := config.DB.Query("SELECT DocID, Location, OriginalName FROM [Document] WHERE "+middleware.Scope(middleware.ResourceDocument, middleware.ActionUse, "", "@p1"), userID)
+	var docs []struct{ DocID, Location, OriginalName, Format string }
 	for docsRows.Next() {
-		var d struct{ DocID, Location, OriginalName string }
+		var d struct{ DocID, Location, OriginalName, Format string }
 		docsRows.Scan(&d.DocID, &d.Location, &d.OriginalName)
+		d.Format = formatPDF
+		if isOFDDocument(d.Location) {
+			d.Format = formatOFD
+		}
 		docs = append(docs, d)
 	}
 	docsRows.Close()
-	// 查询用户签章图片，带OriginalName
-	sealsRows, _ := config.DB.Query("SELECT SealID, Location, OriginalName FROM [Seal] WHERE UserID=@p1", userID)
+	// 查询用户可使用的签章图片（含所在组织的签章，不含已停用的签章），带OriginalName
+	sealsRows, _ := config.DB.Query("SELECT SealID, Location, OriginalName FROM [Seal] WHERE RetiredAt IS NULL AND "+middleware.Scope(middleware.ResourceSeal, middleware.ActionUse, "", "@p1"), userID)
 	var seals []struct{ SealID, Location, OriginalName string }
 	for sealsRows.Next() {
 		var s struct{ SealID, Location, OriginalName string }
@@ -51,12 +57,17 @@ func SignPDFPageHandler(w http.ResponseWriter, r *http.Request) {
 		seals = append(seals, s)
 	}
 	sealsRows.Close()
-	// 查询用户证书，直接用Algo字段
-	certRows, _ := config.DB.Query("SELECT CertID, Location, Algo FROM [Cert] WHERE UserID=@p1", userID)
-	var certs []struct{ CertID, Location, Algo string }
+	// 他人授权当前用户使用的签章，需要审批的签章在签章时校验是否已批准
+	for _, g := range grantedSeals(userID) {
+		seals = append(seals, struct{ SealID, Location, OriginalName string }{SealID: g.SealID, OriginalName: g.Name})
+	}
+	// 查询用户可使用的未吊销证书（含所在组织的证书），直接用Algo字段，并注明私钥所在的密钥后端
+	certRows, _ := config.DB.Query("SELECT CertID, Location, Algo, ISNULL(KeyBackend, 'file') FROM [Cert] WHERE RevokedAt IS NULL AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "", "@p1"), userID)
+	var certs []struct{ CertID, Location, Algo, Backend string }
 	for certRows.Next() {
-		var c struct{ CertID, Location, Algo string }
-		certRows.Scan(&c.CertID, &c.Location, &c.Algo)
+		var c struct{ CertID, Location, Algo, Backend string }
+		certRows.Scan(&c.CertID, &c.Location, &c.Algo, &c.Backend)
+		c.Backend = backendNames[c.Backend]
 		certs = append(certs, c)
 	}
 	certRows.Close()
@@ -66,10 +77,11 @@ func SignPDFPageHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // PDF文档签章处理
-// POST: 校验PIN码，获取PDF/图片/证书路径，生成签章PDF，返回JSON响应
+// POST: 校验PIN码，获取PDF/图片/证书路径，在文档最新修订上增量盖章并签名，返回JSON响应
+// OFD文档按同样的参数盖章，并添加OFD数字签名（有电子印章时为电子签章）
 func SignPDFHandler(w http.ResponseWriter, r *http.Request) {
-	// 获取当前登录用户ID
-	userID, _ := middleware.GetCurrentUser(r)
+	// 获取当前登录用户ID和用户名
+	userID, username := middleware.GetCurrentUser(r)
 	if userID == "" {
 		// 如果未登录，重定向到登录页面
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -82,183 +94,407 @@ func SignPDFHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// 获取表单参数
-	docID := r.FormValue("doc_id")      // 文档ID
-	sealID := r.FormValue("seal_id")    // 签章图片ID
-	scale := r.FormValue("scale")       // 缩放比例
-	rotation := r.FormValue("rotation") // 旋转角度
-	certID := r.FormValue("cert_id")    // 证书ID
-	pin := r.FormValue("pin")           // PIN码
-	page := r.FormValue("page")         // 页码
-	posX := r.FormValue("pos_x")        // X坐标
-	posY := r.FormValue("pos_y")        // Y坐标
-	// 校验PIN码
+	docID := r.FormValue("doc_id")              // 文档ID
+	certID := r.FormValue("cert_id")            // 证书ID
+	pin := r.FormValue("pin")                   // PIN码
+	stampMode := r.FormValue("stamp_mode")      // 盖章方式：普通签章、骑缝章或关键字定位
+	edge := r.FormValue("edge")                 // 骑缝章加盖的页面边缘
+	keyword := r.FormValue("keyword")           // 关键字定位的关键字
+	occurrence := r.FormValue("occurrence")     // 关键字的第几处匹配，为空或0时为全部匹配
+	visible := r.FormValue("visible") == "1"    // 第一处签章作为可见签名的外观
+	showInfo := r.FormValue("show_info") == "1" // 可见签名的签章下方显示签名信息
+	reason := r.FormValue("reason")             // 签名原因
+	location := r.FormValue("location")         // 签名地点
+	if stampMode == "" {
+		stampMode = models.StampModeNormal
+	}
+	if stampMode != models.StampModeNormal && stampMode != models.StampModeCrossPage && stampMode != models.StampModeKeyword {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"msg":"不支持的盖章方式"}`)
+		return
+	}
+	// 签章位置列表：一次请求可以在多个位置加盖不同的签章，全部盖完后只签名一次
+	placements, err := parsePlacements(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+		return
+	}
+	if len(placements) > 1 && stampMode != models.StampModeNormal {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":false,"msg":"多处签章仅支持普通签章方式"}`)
+		return
+	}
+	// 关键字定位的匹配序号和签章相对关键字的偏移
+	var n int
+	var ox, oy float64
+	if stampMode == models.StampModeKeyword {
+		if occurrence != "" {
+			n, err = strconv.Atoi(occurrence)
+			if err != nil || n < 0 {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"success":false,"msg":"关键字匹配序号格式错误"}`)
+				return
+			}
+		}
+		ox, err = formFloat(r, "offset_x", "横向偏移")
+		if err == nil {
+			oy, err = formFloat(r, "offset_y", "纵向偏移")
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
+			return
+		}
+	}
+	// 校验PIN码，整个请求只校验一次
 	var pinHash string
-	err := config.DB.QueryRow("SELECT PINHash FROM [User] WHERE UserID=@p1", userID).Scan(&pinHash)
+	err = config.DB.QueryRow("SELECT PINHash FROM [User] WHERE UserID=@p1", userID).Scan(&pinHash)
 	if err != nil || !utils.CheckPassword(pin, pinHash) {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Println("[SignPDFHandler] PIN码校验失败:", err)
 		fmt.Fprintf(w, `{"success":false,"msg":"PIN码错误"}`)
 		return
 	}
-	// 获取PDF和图片路径
-	var pdfPath, sealPath string
-	err = config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND UserID=@p2", docID, userID).Scan(&pdfPath)
+	// 获取PDF路径
+	var pdfPath string
+	err = config.DB.QueryRow("SELECT Location FROM [Document] WHERE DocID=@p1 AND "+middleware.Scope(middleware.ResourceDocument, middleware.ActionUse, "", "@p2"), docID, userID).Scan(&pdfPath)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Println("[SignPDFHandler] 未找到PDF:", err)
 		fmt.Fprintf(w, `{"success":false,"msg":"未找到PDF"}`)
 		return
 	}
-	err = config.DB.QueryRow("SELECT Location FROM [Seal] WHERE SealID=@p1 AND UserID=@p2", sealID, userID).Scan(&sealPath)
-	if err != nil {
+	if err := checkPlacementPages(pdfPath, placements); err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] 未找到签章图片:", err)
-		fmt.Fprintf(w, `{"success":false,"msg":"未找到签章图片"}`)
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
 		return
 	}
-	// 获取证书路径
-	var certPath string
-	err = config.DB.QueryRow("SELECT Location FROM [Cert] WHERE CertID=@p1 AND UserID=@p2", certID, userID).Scan(&certPath)
+	// 获取证书路径，须为本人或所在组织允许使用的证书，已吊销的证书不能用于签名
+	var certPath, keyBackend, keyRef string
+	var revokedAt sql.NullTime
+	err = config.DB.QueryRow("SELECT Location, RevokedAt, ISNULL(KeyBackend, ''), ISNULL(KeyRef, '') FROM [Cert] WHERE CertID=@p1 AND "+middleware.Scope(middleware.ResourceCert, middleware.ActionUse, "", "@p2"), certID, userID).Scan(&certPath, &revokedAt, &keyBackend, &keyRef)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		fmt.Println("[SignPDFHandler] 未找到证书:", err)
 		fmt.Fprintf(w, `{"success":false,"msg":"未找到证书"}`)
 		return
 	}
-	// 生成新PDF路径
-	var outputPath string
-	preview := r.FormValue("preview") == "1" // 是否预览
-	if preview {
-		outputPath = pdfPath + ".preview.pdf"
-	} else {
-		outputPath = pdfPath[:len(certPath)-4] + "+img.pdf"
-	}
-	// 解析参数
-	s, _ := strconv.ParseFloat(scale, 64)     // 缩放比例
-	rf, _ := strconv.ParseFloat(rotation, 64) // 旋转角度
-	// 归一化旋转角度到 -180~180
-	rf = math.Mod(rf+180, 360) - 180
-	pageNum := page                      // 页码
-	x, _ := strconv.ParseFloat(posX, 64) // X坐标
-	y, _ := strconv.ParseFloat(posY, 64) // Y坐标
-	// 构造水印参数，使用相对位置，offset 用空格分隔两个数值
-	wmParam := fmt.Sprintf("pos:bl,offset:%d %d,scale:%.2f,rot:%.2f", int(x), int(y), s, rf)
-	conf := model.NewDefaultConfiguration()
-	err = api.AddImageWatermarksFile(
-		pdfPath,
-		outputPath,
-		[]string{pageNum}, // 只在选定的页面添加水印
-		false,             // 单独处理每页
-		sealPath,
-		wmParam,
-		conf,
-	)
-	if err != nil {
+	if revokedAt.Valid {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] PDF盖章失败:", err)
-		fmt.Fprintf(w, `{"success":false,"msg":"PDF盖章失败: %s"}`, err.Error())
+		fmt.Println("[SignPDFHandler] 证书已吊销:", certID)
+		fmt.Fprintf(w, `{"success":false,"msg":"证书已吊销，不能用于签名"}`)
 		return
 	}
-	if preview {
-		// 如果是预览模式，直接返回预览PDF路径
+	// [Document].Location始终指向最新修订，新的签章和签名以增量更新方式追加在其后，已有签名保持有效
+	// 他人的签章须有该文档的用印授权，需要审批的还须有已批准的用印申请
+	seals, uses, err := loadAuthorizedSeals(userID, docID, placements)
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"preview":"/%s"}`, outputPath)
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
 		return
 	}
-	// ----------- PDF签名域定义（签名前必须有签名域） --------------
-	// 生成带签名域的PDF，供后续数字签名使用
-	withFieldPath := outputPath[:len(certPath)-8] + "+addfield.pdf"
-	sigFieldName := "Signature1" // 签名域名称，可自定义
-	// 读取印章图片宽高（像素），如需可用于签名域定位
-	sealFile, err := os.Open(sealPath)
-	if err != nil {
+	// 电子签章引用第一处签章的电子印章
+	first := placements[0]
+	sealDER := seals[first.SealID].ses
+	// 签章位置：相对页面左下角的偏移，缩放比例相对页面尺寸；每处签章对应一条签章日志
+	var stamps []pdfsign.Stamp
+	var logs []signLogEntry
+	for _, p := range placements {
+		stamps = append(stamps, pdfsign.Stamp{Page: p.Page, Image: seals[p.SealID].image, X: p.X, Y: p.Y, Scale: p.Scale, Rotation: p.Rotation})
+		logs = append(logs, signLogEntry{sealID: p.SealID, x: sql.NullFloat64{Float64: p.X, Valid: true}, y: p.Y, scale: p.Scale, rotation: p.Rotation})
+	}
+	isOFD := isOFDDocument(pdfPath)
+	if isOFD && stampMode != models.StampModeNormal {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"success":false,"msg":"无法打开印章图片: %s"}`, err.Error())
+		fmt.Fprintf(w, `{"success":false,"msg":"OFD文档暂不支持骑缝章和关键字定位"}`)
 		return
 	}
-	sealFile.Close()
-	if err != nil {
+	// 骑缝章：印章切分后盖在全部页面的同一侧边缘，只使用纵向偏移，不旋转，不记录横坐标
+	var crossPage *pdfsign.CrossPageStamp
+	if stampMode == models.StampModeCrossPage {
+		crossPage = &pdfsign.CrossPageStamp{Image: seals[first.SealID].image, Edge: edge, Y: first.Y, Scale: first.Scale}
+		stamps = nil
+		logs = []signLogEntry{{sealID: first.SealID, y: first.Y, scale: first.Scale}}
+	}
+	// 关键字定位：在文字层中查找关键字，签章左下角相对每处（或第N处）匹配的左下角偏移
+	if stampMode == models.StampModeKeyword {
+		matches, err := pdfsign.FindTextFile(pdfPath, keyword)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Println("[SignPDFHandler] 关键字查找失败:", err)
+			fmt.Fprintf(w, `{"success":false,"msg":"关键字查找失败: %s"}`, err.Error())
+			return
+		}
+		if len(matches) == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"success":false,"msg":"未在文档中找到关键字"}`)
+			return
+		}
+		if n > len(matches) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"success":false,"msg":"文档中找到%d处关键字，没有第%d处"}`, len(matches), n)
+			return
+		}
+		if n > 0 {
+			matches = matches[n-1 : n]
+		}
+		stamps, logs = nil, nil
+		for _, m := range matches {
+			st := pdfsign.Stamp{Page: m.Page, Image: seals[first.SealID].image, X: m.X + ox, Y: m.Y + oy, Scale: first.Scale, Rotation: first.Rotation}
+			stamps = append(stamps, st)
+			logs = append(logs, signLogEntry{sealID: first.SealID, x: sql.NullFloat64{Float64: st.X, Valid: true}, y: st.Y, scale: st.Scale, rotation: st.Rotation})
+		}
+	}
+	// 签名域位于第一处签章所在页
+	pageInt := first.Page
+	if len(stamps) > 0 {
+		pageInt = stamps[0].Page
+	}
+	// 可见签名：第一处签章不再单独盖章，而是作为签名域控件的外观，点击签章即可查看签名信息
+	// OFD的签章本身就是签名的外观（签章注释关联签名），不需要单独处理
+	signingTime := time.Now()
+	var appearance *pdfsign.Appearance
+	if visible && !isOFD {
+		if crossPage != nil {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"success":false,"msg":"骑缝章不支持可见签名"}`)
+			return
+		}
+		appearance = &pdfsign.Appearance{Stamp: stamps[0]}
+		if showInfo {
+			if config.SignFont == nil {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"success":false,"msg":"服务器未配置可见签名字体，无法显示签名信息"}`)
+				return
+			}
+			appearance.Font = config.SignFont
+			appearance.Lines = signatureInfoLines(username, signingTime, reason, location)
+		}
+	}
+	// 签章实际加盖的位置，随响应返回
+	applied := []map[string]interface{}{}
+	for _, st := range stamps {
+		applied = append(applied, map[string]interface{}{"page": st.Page, "x": st.X, "y": st.Y})
+	}
+	if r.FormValue("preview") == "1" {
+		if isOFD {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"success":false,"msg":"OFD文档暂不支持签章预览"}`)
+			return
+		}
+		// 如果是预览模式，只盖章不签名，直接返回预览PDF路径
+		outputPath := pdfPath + ".preview.pdf"
+		if crossPage != nil {
+			err = pdfsign.StampCrossPageFile(pdfPath, outputPath, *crossPage)
+		} else if appearance != nil {
+			err = pdfsign.StampAppearanceFile(pdfPath, outputPath, stamps[1:], *appearance)
+		} else {
+			err = pdfsign.StampFile(pdfPath, outputPath, stamps)
+		}
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"success":false,"msg":"无法解析印章图片: %s"}`, err.Error())
+		if err != nil {
+			fmt.Println("[SignPDFHandler] PDF盖章失败:", err)
+			fmt.Fprintf(w, `{"success":false,"msg":"PDF盖章失败: %s"}`, err.Error())
+			return
+		}
+		jsonStr, _ := json.Marshal(map[string]interface{}{
+			"preview":    "/document/preview?doc_id=" + url.QueryEscape(docID),
+			"placements": applied,
+		})
+		w.Write(jsonStr)
 		return
 	}
-	// 签名域区域参数（此处为不可见签名域，可根据需要调整为可见并精确定位）
-	fieldSpec := fmt.Sprintf("%s/%d,%d,%d,%d/%s", pageNum, 0, 0, 0, 0, sigFieldName)
-	cmdField := exec.Command(
-		"pyhanko", "sign", "addfields",
-		"--field", fieldSpec,
-		outputPath, withFieldPath,
-	)
-	if out, err := cmdField.CombinedOutput(); err != nil {
+	// ----------- PDF数字签名（PAdES，签章图片、签名域和CMS签名在同一次增量更新中写入） --------------
+	// 签名后再追加一个修订，写入证书链和吊销信息（DSS）并加盖文档时间戳，保证证书过期后仍可验证
+	// 加载签名证书，打开证书对应密钥后端中的签名密钥
+	cert, err := utils.LoadCertificate(certPath)
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] 创建签名域失败:", err, string(out))
-		fmt.Fprintf(w, `{"success":false,"msg":"创建签名域失败: %s, %s"}`, err.Error(), string(out))
+		fmt.Println("[SignPDFHandler] 读取证书失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"读取证书失败: %s"}`, err.Error())
 		return
 	}
-	outputPath = withFieldPath
-	// ----------- PDF数字签名（标准PDF签名，写入PDF结构） --------------
-	privPath := certPath[:len(certPath)-4] + "_private.pem"
-	signedPath := outputPath[:len(certPath)-13] + "SIGNED.pdf"
-	cmd := exec.Command(
-		"pyhanko", "sign", "addsig", "pemder",
-		"--cert", certPath,
-		"--key", privPath,
-		"--no-pass",
-		outputPath, signedPath,
-	)
-	out, err := cmd.CombinedOutput()
+	// 本地文件私钥以PIN码加密保存，仅在本次签名期间解密在内存中；HSM和KMS中的私钥不离开设备
+	privKey, err := openCertSigner(certID, keyBackend, keyRef, pin)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] PDF数字签名失败:", err, string(out))
-		fmt.Fprintf(w, `{"success":false,"msg":"PDF数字签名失败: %s, %s"}`, err.Error(), string(out))
+		fmt.Println("[SignPDFHandler] 打开签名密钥失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"打开签名密钥失败: %s"}`, err.Error())
 		return
 	}
-	// 计算签名后PDF的哈希
-	pdfFile, err := os.Open(signedPath)
-	if err != nil {
+	defer privKey.Close()
+	// 使用他人签章时，本人证书不在电子印章绑定的证书列表中则只加盖签章图片，不生成电子签章
+	if uses[first.SealID] != nil && sealDER != nil && !sesAllows(sealDER, cert) {
+		sealDER = nil
+	}
+	// 占用授权次数和已批准的用印申请，签名失败时归还
+	if err := reserveSealUses(uses); err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] 签章PDF读取失败:", err)
-		fmt.Fprintf(w, `{"success":false,"msg":"签章PDF读取失败"}`)
+		fmt.Println("[SignPDFHandler] 占用用印授权失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
 		return
 	}
-	hasher := sha256.New()
-	io.Copy(hasher, pdfFile)
-	pdfFile.Close()
-	hashSum := hasher.Sum(nil)
-	fileHash := hex.EncodeToString(hashSum)
-	// 更新[Document]表的FileHash字段
-	_, err = config.DB.Exec("UPDATE [Document] SET FileHash=@p1 WHERE DocID=@p2", fileHash, docID)
+	// 签名域默认为不可见签名域，位于盖章页；每次签名生成新的文件，文件名带时间戳避免覆盖，扩展名与文档格式一致
+	signedPath := filepath.Join(filepath.Dir(pdfPath), fmt.Sprintf("%s_%d_SIGNED%s", docID, time.Now().UnixNano(), filepath.Ext(pdfPath)))
+	var signResult *pdfsign.Result
+	if isOFD {
+		// OFD签名记录的签名算法和签名值与PDF签名一致
+		var ofdResult *ofdsign.Result
+		ofdResult, err = signOFDFile(pdfPath, signedPath, stamps, ofdsign.Options{
+			Signer:      privKey,
+			Certificate: cert,
+			Chain:       config.ChainFor(cert),
+			Seal:        sealDER,
+		})
+		if err == nil {
+			signResult = &pdfsign.Result{FieldName: ofdResult.SignatureID, Signature: ofdResult.Signature, Algorithm: ofdResult.Algorithm}
+		}
+	} else {
+		opts := pdfsign.Options{
+			Signer:      privKey,
+			Certificate: cert,
+			Chain:       config.ChainFor(cert),
+			Page:        pageInt,
+			Name:        username,
+			Reason:      reason,
+			Location:    location,
+			SigningTime: signingTime,
+			Stamps:      stamps,
+			Timestamper: config.Timestamper(),
+			LTV:         true,
+			Revocation:  revocationSource(),
+			Seal:        sealDER,
+		}
+		if crossPage != nil {
+			// 骑缝章与签名写入同一修订，签名覆盖全部页面上的切片
+			opts.CrossPage = crossPage
+		}
+		if appearance != nil {
+			opts.Appearance = appearance
+			opts.Stamps = stamps[1:]
+		}
+		signResult, err = pdfsign.SignFile(pdfPath, signedPath, opts)
+	}
 	if err != nil {
+		releaseSealUses(sealUseList(uses))
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Println("[SignPDFHandler] 更新文档哈希失败:", err)
-		fmt.Fprintf(w, `{"success":false,"msg":"更新文档哈希失败"}`)
+		fmt.Println("[SignPDFHandler] PDF数字签名失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"PDF数字签名失败: %s"}`, err.Error())
 		return
 	}
-	// 写入签章日志
-	rotationInt := int(rf) + 180
-	fmt.Printf("[SignPDFHandler] SQL: %s\n", `INSERT INTO SignLog 
-    (UserID, DocID, SealID, CertID, SignAlgorithm, SignatureValue, PositionX, PositionY, Scale, Rotation, SignTime) 
-    VALUES (@p1, @p2, @p3, @p4, @p5, NULL, @p6, @p7, @p8, @p9, GETDATE())`)
-	fmt.Printf("[SignPDFHandler] PARAMS: userID=%v, docID=%v, sealID=%v, certID=%v, SignAlgorithm=%v, x=%v, y=%v, s=%v, rf=%v\n",
-		userID, docID, sealID, certID, "pyHanko", x, y, s, rotationInt)
-	_, err = config.DB.Exec(`INSERT INTO SignLog 
-    (UserID, DocID, SealID, CertID, SignAlgorithm, SignatureValue, PositionX, PositionY, Scale, Rotation, SignTime) 
-    VALUES (@p1, @p2, @p3, @p4, @p5, NULL, @p6, @p7, @p8, @p9, GETDATE())`,
-		userID, docID, sealID, certID, "pyHanko", x, y, s, rotationInt)
+	// 更新[Document]表指向签名后的文件并记录签名版本，同一事务中写入签章日志：
+	// 每处签章一条，共用同一个签名值；骑缝章只有一条；使用他人签章时记录依据的授权
+	err = storeSignedVersion(docID, pdfPath, signedPath, userID, func(tx *sql.Tx) error {
+		for _, entry := range logs {
+			rotationInt := int(entry.rotation) + 180
+			var grantID sql.NullString
+			if u := uses[entry.sealID]; u != nil {
+				grantID = sql.NullString{String: u.GrantID, Valid: true}
+			}
+			fmt.Printf("[SignPDFHandler] SQL: %s\n", `INSERT INTO SignLog 
+    (UserID, DocID, SealID, CertID, SignAlgorithm, SignatureValue, PositionX, PositionY, Scale, Rotation, StampMode, Action, GrantID, SignTime) 
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12, @p13, GETDATE())`)
+			fmt.Printf("[SignPDFHandler] PARAMS: userID=%v, docID=%v, sealID=%v, certID=%v, SignAlgorithm=%v, x=%v, y=%v, s=%v, rf=%v, mode=%v\n",
+				userID, docID, entry.sealID, certID, signResult.Algorithm, entry.x.Float64, entry.y, entry.scale, rotationInt, stampMode)
+			if _, err := tx.Exec(`INSERT INTO SignLog 
+    (UserID, DocID, SealID, CertID, SignAlgorithm, SignatureValue, PositionX, PositionY, Scale, Rotation, StampMode, Action, GrantID, SignTime) 
+    VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, @p11, @p12, @p13, GETDATE())`,
+				userID, docID, entry.sealID, certID, signResult.Algorithm, signResult.Signature, entry.x, entry.y, entry.scale, rotationInt, stampMode, models.ActionSign, grantID); err != nil {
+				fmt.Printf("[SignPDFHandler] 签章日志写入失败: %v\n", err)
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
+		releaseSealUses(sealUseList(uses))
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Printf("[SignPDFHandler] 签章日志写入失败: %v\n", err)
-		fmt.Fprintf(w, `{"success":false,"msg":"签章日志写入失败: %s"}`, err.Error())
+		fmt.Println("[SignPDFHandler] 保存签名版本失败:", err)
+		fmt.Fprintf(w, `{"success":false,"msg":"%s"}`, err.Error())
 		return
 	}
 	// 返回成功响应，始终返回JSON格式
 	w.Header().Set("Content-Type", "application/json")
-	pdfUrl := "/" + strings.ReplaceAll(signedPath, "\\", "/")
+	pdfUrl := "/document/download?inline=1&doc_id=" + url.QueryEscape(docID)
+	msg := "签章成功，已生成新PDF"
+	if isOFD {
+		msg = "签章成功，已生成新OFD"
+	}
 	resp := map[string]interface{}{
 		"success": true,
-		"msg":     "签章成功，已生成新PDF",
+		"msg":     msg,
 		"pdf_url": pdfUrl,
 	}
+	if crossPage == nil {
+		resp["placements"] = applied
+	}
+	if !signResult.Timestamp.IsZero() {
+		resp["timestamp"] = signResult.Timestamp
+	}
+	if !signResult.Archived.IsZero() {
+		resp["archive_timestamp"] = signResult.Archived
+	}
 	jsonStr, _ := json.Marshal(resp)
 	fmt.Fprint(w, string(jsonStr))
 }
+
+// signatureInfoLines 可见签名签章下方显示的签名信息，原因和地点为空时不显示
+func signatureInfoLines(name string, t time.Time, reason, location string) []string {
+	lines := []string{"签名人：" + name, "时间：" + t.Format("2006-01-02 15:04:05")}
+	if reason != "" {
+		lines = append(lines, "原因："+reason)
+	}
+	if location != "" {
+		lines = append(lines, "地点："+location)
+	}
+	return lines
+}
+
+// storeSignedVersion 计算签名后文件的哈希，将[Document]指向签名后的文件并记录签名版本，
+// logs在同一事务中写入签章日志，任一步失败时整体回滚
+// 仅当文档未被并发签署（Location仍为签名前的文件）时更新成功，失败时删除签名后的文件，避免丢失他人的签名
+func storeSignedVersion(docID, pdfPath, signedPath, userID string, logs func(tx *sql.Tx) error) error {
+	pdfFile, err := os.Open(signedPath)
+	if err != nil {
+		return errors.New("签章PDF读取失败")
+	}
+	hasher := sha256.New()
+	io.Copy(hasher, pdfFile)
+	pdfFile.Close()
+	fileHash := hex.EncodeToString(hasher.Sum(nil))
+	tx, err := config.DB.Begin()
+	if err != nil {
+		os.Remove(signedPath)
+		fmt.Println("[storeSignedVersion] 开启事务失败:", err)
+		return errors.New("数据库连接失败")
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE [Document] SET FileHash=@p1, Location=@p2 WHERE DocID=@p3 AND Location=@p4", fileHash, signedPath, docID, pdfPath)
+	if err != nil {
+		os.Remove(signedPath)
+		fmt.Println("[storeSignedVersion] 更新文档哈希失败:", err)
+		return errors.New("更新文档哈希失败")
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		os.Remove(signedPath)
+		fmt.Println("[storeSignedVersion] 文档已被并发签署:", docID)
+		return errors.New("文档已有新的签署版本，请刷新后重试")
+	}
+	// 记录签名版本，父版本为签名前的最新版本
+	if _, err := addDocumentVersion(tx, docID, latestDocumentVersionID(tx, docID), signedPath, fileHash, models.VersionOpSign, userID); err != nil {
+		os.Remove(signedPath)
+		fmt.Println("[storeSignedVersion] 文档版本写入失败:", err)
+		return errors.New("文档版本写入失败")
+	}
+	if err := logs(tx); err != nil {
+		os.Remove(signedPath)
+		return errors.New("签章日志写入失败")
+	}
+	if err := tx.Commit(); err != nil {
+		os.Remove(signedPath)
+		fmt.Println("[storeSignedVersion] 提交事务失败:", err)
+		return errors.New("保存签名版本失败")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+	"signature_sys/config"
+)
+
+// 实现内置时间戳服务的HTTP接口。
+
+// TSAHandler 内置时间戳服务（RFC 3161），接收application/timestamp-query请求，返回application/timestamp-reply
+func TSAHandler(w http.ResponseWriter, r *http.Request) {
+	config.TSA.ServeHTTP(w, r)
+}
@@ -1,24 +1,17 @@
 package handlers
 
 import (
-	"crypto/ecdsa"         // ECC加密算法
-	"crypto/elliptic"      // 椭圆曲线
-	"crypto/rand"          // 随机数生成器
-	"crypto/rsa"           // RSA加密算法
-	"crypto/x509"          // X.509证书标准
-	"crypto/x509/pkix"     // X.509证书主题
-	"database/sql"         // 数据库操作
-	"encoding/pem"         // PEM格式编码
-	"fmt"                  // 格式化输出
-	"html/template"        // HTML模板渲染
-	"math/big"             // 大整数运算
-	"net/http"             // HTTP协议
-	"os"                   // 文件操作
-	"path/filepath"        // 文件路径操作
-	"signature_sys/config" // 配置模块
-	"signature_sys/models" // 数据模型模块
-	"signature_sys/utils"  // 工具模块
-	"time"                 // 时间操作
+	"database/sql"             // 数据库操作
+	"fmt"                      // 格式化输出
+	"html/template"            // HTML模板渲染
+	"net/http"                 // HTTP协议
+	"os"                       // 文件操作
+	"signature_sys/config"     // 配置模块
+	"signature_sys/middleware" // 中间件模块
+	"signature_sys/models"     // 数据模型模块
+	"signature_sys/signer"     // 签名密钥后端
+	"signature_sys/utils"      // 工具模块
+	"time"                     // 时间操作
 
 	"github.com/google/uuid"      // UUID生成器
 	"github.com/gorilla/sessions" // 会话管理
@@ -29,14 +22,15 @@ var store = sessions.NewCookieStore([]byte("signsys-secret-key"))
 
 // RegisterHandler 处理用户注册页面的GET和POST请求
 // GET: 渲染注册页面
-// POST: 校验参数，写入用户表，自动生成ECC和RSA证书并写入证书表
+// POST: 校验参数，写入用户表，自动生成ECC、RSA和SM2证书并写入证书表
 // 注册流程：
 // 1. 校验用户名、密码、PIN码等参数
 // 2. 生成用户ID，对密码和PIN码进行哈希加密
 // 3. 写入用户表
-// 4. 自动生成ECC证书（P256曲线），生成私钥、证书、公钥，写入文件和数据库
-// 5. 自动生成RSA证书（2048位），生成私钥、证书、公钥，写入文件和数据库
-// 6. 注册成功后跳转到登录页
+// 4. 自动生成ECC证书（P256曲线），私钥以PIN码加密保存，由签发CA签发证书，写入文件和数据库
+// 5. 自动生成RSA证书（2048位），私钥以PIN码加密保存，由签发CA签发证书，写入文件和数据库
+// 6. 自动生成SM2证书（国密），私钥以PIN码加密保存，由国密签发CA签发证书，写入文件和数据库
+// 7. 注册成功后跳转到登录页
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// 渲染注册页面
@@ -65,64 +59,13 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "注册失败", 500)
 			return
 		}
-		// 注册后自动为用户生成ECC和RSA证书
-		// --- ECC证书生成 ---
-		certID_ECC := uuid.New().String()                                        // 证书ID
-		certPath_ECC := filepath.Join("static/certs", certID_ECC+".pem")         // 证书文件路径
-		privPath_ECC := filepath.Join("static/certs", certID_ECC+"_private.pem") // 私钥文件路径
-		validFrom := time.Now()                                                  // 有效期起始
-		validTo := validFrom.AddDate(5, 0, 0)                                    // 有效期5年
-		privECC, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)            // 生成ECC私钥
-		tmplECC := x509.Certificate{
-			SerialNumber: big.NewInt(time.Now().UnixNano()),      // 序列号
-			Subject:      pkix.Name{CommonName: "User ECC Cert"}, // 主题
-			NotBefore:    validFrom,
-			NotAfter:     validTo,
-			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, // 用途：数字签名和不可否认性
-		}
-		certDER_ECC, _ := x509.CreateCertificate(rand.Reader, &tmplECC, &tmplECC, &privECC.PublicKey, privECC) // 生成自签名证书
-		bECC, _ := x509.MarshalECPrivateKey(privECC)                                                           // 编码私钥
-		privPEM_ECC := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: bECC})                     // PEM格式私钥
-		certPEM_ECC := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER_ECC})                 // PEM格式证书
-		pubKeyDER_ECC, _ := x509.MarshalPKIXPublicKey(&privECC.PublicKey)                                      // 公钥DER
-		pubKeyPEM_ECC := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyDER_ECC}))      // PEM格式公钥
-		issuerDN_ECC := tmplECC.Subject.String()                                                               // 颁发者信息
-		os.MkdirAll("static/certs", 0755)                                                                      // 确保目录存在
-		os.WriteFile(certPath_ECC, certPEM_ECC, 0644)                                                          // 写入证书文件
-		os.WriteFile(privPath_ECC, privPEM_ECC, 0600)                                                          // 写入私钥文件
-		algoECC := "ECC"                                                                                       // 算法类型
-		// 写入证书表
-		_, err = config.DB.Exec("INSERT INTO [Cert] (CertID, UserID, Location, IssuerDN, ValidFrom, ValidTo, PublicKey, Algo) VALUES (@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8)",
-			certID_ECC, userID, certPath_ECC, issuerDN_ECC, validFrom, validTo, pubKeyPEM_ECC, algoECC)
-		if err != nil {
-			fmt.Println("注册ECC证书写入失败：", err)
-		}
-		// --- RSA证书生成 ---
-		certID_RSA := uuid.New().String()                                        // 证书ID
-		certPath_RSA := filepath.Join("static/certs", certID_RSA+".pem")         // 证书文件路径
-		privPath_RSA := filepath.Join("static/certs", certID_RSA+"_private.pem") // 私钥文件路径
-		privRSA, _ := rsa.GenerateKey(rand.Reader, 2048)                         // 生成RSA私钥
-		tmplRSA := x509.Certificate{
-			SerialNumber: big.NewInt(time.Now().UnixNano()),      // 序列号
-			Subject:      pkix.Name{CommonName: "User RSA Cert"}, // 主题
-			NotBefore:    validFrom,
-			NotAfter:     validTo,
-			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, // 用途：数字签名和不可否认性
-		}
-		certDER_RSA, _ := x509.CreateCertificate(rand.Reader, &tmplRSA, &tmplRSA, &privRSA.PublicKey, privRSA)              // 生成自签名证书
-		privPEM_RSA := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privRSA)}) // PEM格式私钥
-		certPEM_RSA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER_RSA})                              // PEM格式证书
-		pubKeyDER_RSA, _ := x509.MarshalPKIXPublicKey(&privRSA.PublicKey)                                                   // 公钥DER
-		pubKeyPEM_RSA := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyDER_RSA}))                   // PEM格式公钥
-		issuerDN_RSA := tmplRSA.Subject.String()                                                                            // 颁发者信息
-		os.WriteFile(certPath_RSA, certPEM_RSA, 0644)                                                                       // 写入证书文件
-		os.WriteFile(privPath_RSA, privPEM_RSA, 0600)                                                                       // 写入私钥文件
-		algoRSA := "RSA"                                                                                                    // 算法类型
-		// 写入证书表
-		_, err = config.DB.Exec("INSERT INTO [Cert] (CertID, UserID, Location, IssuerDN, ValidFrom, ValidTo, PublicKey, Algo) VALUES (@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8)",
-			certID_RSA, userID, certPath_RSA, issuerDN_RSA, validFrom, validTo, pubKeyPEM_RSA, algoRSA)
-		if err != nil {
-			fmt.Println("注册RSA证书写入失败：", err)
+		// 注册后自动为用户生成ECC、RSA和SM2证书，私钥以PIN码加密保存在本地文件中
+		for _, algo := range []string{signer.AlgoECC, signer.AlgoRSA, signer.AlgoSM2} {
+			if _, err := issueCertificate(userID, "", username, email, signer.BackendFile, algo, pin); err != nil {
+				fmt.Println("注册"+algo+"证书生成失败：", err)
+				http.Error(w, "证书签发失败", 500)
+				return
+			}
 		}
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
@@ -133,7 +76,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 // POST: 校验用户名密码，生成JWT写入cookie
 // 登录流程：
 // 1. 校验用户名、密码
-// 2. 查询用户表，校验密码哈希
+// 2. 查询用户表，校验密码哈希，拒绝已停用的用户
 // 3. 生成JWT Token，写入cookie
 // 4. 跳转到首页
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -147,9 +90,10 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username") // 用户名
 		password := r.FormValue("password") // 密码
 		var user models.User
+		var disabledAt sql.NullTime
 		// 查询用户信息
-		row := config.DB.QueryRow("SELECT UserID, PasswordHash FROM [User] WHERE Username=@p1", username)
-		err := row.Scan(&user.UserID, &user.PasswordHash)
+		row := config.DB.QueryRow("SELECT UserID, PasswordHash, DisabledAt FROM [User] WHERE Username=@p1", username)
+		err := row.Scan(&user.UserID, &user.PasswordHash, &disabledAt)
 		if err == sql.ErrNoRows || !utils.CheckPassword(password, user.PasswordHash) {
 			http.Error(w, "用户名或密码错误", 401)
 			return
@@ -158,6 +102,11 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "登录失败", 500)
 			return
 		}
+		// 被管理员停用的用户不能登录
+		if disabledAt.Valid {
+			http.Error(w, "账号已停用，请联系管理员", 403)
+			return
+		}
 		// 生成JWT
 		token, err := utils.GenerateJWT(user.UserID, username)
 		if err != nil {
@@ -191,23 +140,141 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// PINChangeHandler 处理修改签名PIN码页面的GET和POST请求
+// GET: 渲染修改PIN码页面
+// POST: 校验原PIN码，用原PIN码解密用户全部私钥并以新PIN码重新加密，再更新PIN码哈希
+// 新私钥先写入临时文件，PIN码哈希更新成功后再替换原文件
+func PINChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetCurrentUser(r)
+	if userID == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method == http.MethodGet {
+		// 渲染修改PIN码页面
+		t, _ := template.ParseFiles("templates/pin_change.html")
+		t.Execute(w, nil)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持GET和POST", 405)
+		return
+	}
+	oldPIN := r.FormValue("old_pin")
+	newPIN := r.FormValue("new_pin")
+	if newPIN == "" || newPIN != r.FormValue("confirm_pin") {
+		http.Error(w, "新PIN码为空或两次输入不一致", 400)
+		return
+	}
+	// 校验原PIN码
+	var pinHash string
+	err := config.DB.QueryRow("SELECT PINHash FROM [User] WHERE UserID=@p1", userID).Scan(&pinHash)
+	if err != nil || !utils.CheckPassword(oldPIN, pinHash) {
+		http.Error(w, "原PIN码错误", 403)
+		return
+	}
+	// 只有本地文件后端的私钥由PIN码加密，HSM和KMS中的密钥不受影响
+	rows, err := config.DB.Query("SELECT CertID, ISNULL(KeyRef, '') FROM [Cert] WHERE UserID=@p1 AND ISNULL(KeyBackend, 'file')='file'", userID)
+	if err != nil {
+		http.Error(w, "数据库查询失败", 500)
+		return
+	}
+	var keyPaths []string
+	for rows.Next() {
+		var certID, keyRef string
+		if rows.Scan(&certID, &keyRef) == nil {
+			keyPaths = append(keyPaths, certKeyRef(certID, keyRef))
+		}
+	}
+	rows.Close()
+	// 用新PIN码重新加密全部私钥，写入临时文件
+	var staged []string
+	cleanup := func() {
+		for _, p := range staged {
+			os.Remove(p + ".new")
+		}
+	}
+	for _, privPath := range keyPaths {
+		if _, err := os.Stat(privPath); os.IsNotExist(err) {
+			continue
+		}
+		key, err := utils.LoadEncryptedPrivateKey(privPath, oldPIN)
+		if err != nil {
+			cleanup()
+			fmt.Println("[PINChangeHandler] 私钥解密失败:", privPath, err)
+			http.Error(w, "私钥解密失败", 500)
+			return
+		}
+		data, err := utils.EncryptPrivateKey(key, newPIN)
+		if err == nil {
+			err = os.WriteFile(privPath+".new", data, 0600)
+		}
+		if err != nil {
+			cleanup()
+			fmt.Println("[PINChangeHandler] 私钥重新加密失败:", privPath, err)
+			http.Error(w, "私钥重新加密失败", 500)
+			return
+		}
+		staged = append(staged, privPath)
+	}
+	// 先替换全部私钥文件，原文件保留为.old备份；任一步失败都恢复备份，PIN码保持不变
+	var backedUp []string
+	restore := func() {
+		for _, p := range backedUp {
+			if err := os.Rename(p+".old", p); err != nil {
+				fmt.Println("[PINChangeHandler] 私钥恢复失败:", p, err)
+			}
+		}
+		cleanup()
+	}
+	for _, p := range staged {
+		if err := os.Rename(p, p+".old"); err != nil {
+			restore()
+			fmt.Println("[PINChangeHandler] 私钥备份失败:", p, err)
+			http.Error(w, "私钥替换失败", 500)
+			return
+		}
+		backedUp = append(backedUp, p)
+		if err := os.Rename(p+".new", p); err != nil {
+			restore()
+			fmt.Println("[PINChangeHandler] 私钥替换失败:", p, err)
+			http.Error(w, "私钥替换失败", 500)
+			return
+		}
+	}
+	if _, err := config.DB.Exec("UPDATE [User] SET PINHash=@p1 WHERE UserID=@p2", utils.HashPassword(newPIN), userID); err != nil {
+		restore()
+		fmt.Println("[PINChangeHandler] PIN码更新失败:", err)
+		http.Error(w, "PIN码更新失败", 500)
+		return
+	}
+	for _, p := range backedUp {
+		os.Remove(p + ".old")
+	}
+	fmt.Printf("[PINChangeHandler] 用户%s已修改PIN码，重新加密私钥%d个\n", userID, len(staged))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 // IndexHandler 处理首页展示，根据token判断是否登录，渲染用户名
 // 首页流程：
 // 1. 检查token cookie，解析JWT
-// 2. 判断是否登录，渲染用户名
+// 2. 判断是否登录，渲染用户名，系统管理员显示管理后台入口
 func IndexHandler(w http.ResponseWriter, r *http.Request) {
 	// 恢复正常首页逻辑：根据token判断是否登录
 	isLogin := false
+	isAdmin := false
 	username := ""
 	if cookie, err := r.Cookie("token"); err == nil && cookie.Value != "" {
 		if claims, err := utils.ParseJWT(cookie.Value); err == nil {
 			isLogin = true
 			username = claims.Username
+			isAdmin = middleware.IsAdmin(claims.UserID)
 		}
 	}
 	t, _ := template.ParseFiles("templates/index.html")
 	t.Execute(w, map[string]interface{}{
 		"IsLogin":  isLogin,
+		"IsAdmin":  isAdmin,
 		"Username": username,
 	})
 }
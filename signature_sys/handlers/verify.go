@@ -1,77 +1,393 @@
 package handlers
 
 import (
-	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"os/exec"
+	"signature_sys/ca"
 	"signature_sys/config"
+	"signature_sys/models"
+	"signature_sys/ofdsign"
+	"signature_sys/pdfsign"
+	"signature_sys/ses"
+	"signature_sys/utils"
+	"strings"
+	"time"
+
+	"github.com/emmansun/gmsm/smx509"
 )
 
+// 实现PDF验签相关的HTTP处理逻辑，验签结果以结构化JSON报告返回。
+
+// VerifyReport 验签报告，/verify/pdf接口的响应
+type VerifyReport struct {
+	Success    bool              `json:"success"`    // 全部签名完整且可信
+	Msg        string            `json:"msg"`        // 结论描述
+	FileName   string            `json:"file_name"`  // 上传的文件名
+	FileSize   int64             `json:"file_size"`  // 文件大小（字节）
+	Revisions  int               `json:"revisions"`  // 文档修订数量
+	Signatures []SignatureReport `json:"signatures"` // 各签名的验证结果
+	DSS        *DSSReport        `json:"dss"`        // 文档安全存储中的验证数据，没有时为null
+}
+
+// DSSReport 文档安全存储（长期验证数据）概况
+type DSSReport struct {
+	Certificates int `json:"certificates"` // 证书数量
+	CRLs         int `json:"crls"`         // CRL数量
+	OCSPs        int `json:"ocsps"`        // OCSP响应数量
+	VRI          int `json:"vri"`          // 登记了验证数据的签名数量
+}
+
+// SignatureReport 单个签名的验证结果
+type SignatureReport struct {
+	FieldName          string            `json:"field_name"`          // 签名域名称
+	Type               string            `json:"type"`                // 类型：signature/doc_timestamp
+	SignerName         string            `json:"signer_name"`         // 签名字典中的签名人名称
+	Reason             string            `json:"reason"`              // 签名原因
+	Location           string            `json:"location"`            // 签名地点
+	SubFilter          string            `json:"sub_filter"`          // 签名格式
+	Subject            string            `json:"subject"`             // 签名证书主题
+	Issuer             string            `json:"issuer"`              // 签名证书颁发者
+	SerialNumber       string            `json:"serial_number"`       // 签名证书序列号（十六进制）
+	SigningTime        *time.Time        `json:"signing_time"`        // 声明的签名时间
+	DigestAlgorithm    string            `json:"digest_algorithm"`    // 摘要算法
+	SignatureAlgorithm string            `json:"signature_algorithm"` // 签名算法
+	ByteRange          []int64           `json:"byte_range"`          // 签名覆盖的字节范围
+	Coverage           string            `json:"coverage"`            // 覆盖情况：whole_file/earlier_revision
+	Integrity          IntegrityStatus   `json:"integrity"`           // 完整性状态
+	Trust              TrustStatus       `json:"trust"`               // 信任状态
+	Timestamp          *TimestampReport  `json:"timestamp"`           // 签名时间戳，无时间戳时为null
+	LTV                bool              `json:"ltv"`                 // 文档中是否保存了该签名的长期验证数据
+	Revocation         *RevocationReport `json:"revocation"`          // 签名证书吊销检查结果，自签名证书为null
+	ArchiveTime        *time.Time        `json:"archive_time"`        // 覆盖该签名的最早文档时间戳时间
+	Seal               *SealReport       `json:"seal"`                // 电子签章，签名中没有电子签章时为null
+	Cert               *models.Cert      `json:"cert"`                // 匹配到的证书记录
+	Errors             []string          `json:"errors"`              // 失败原因
+	Warnings           []string          `json:"warnings"`            // 提示信息
+}
+
+// IntegrityStatus 签名完整性状态
+type IntegrityStatus struct {
+	Status               string `json:"status"`                 // valid/invalid
+	DigestValid          bool   `json:"digest_valid"`           // 文档摘要一致
+	SignatureValid       bool   `json:"signature_valid"`        // 签名值正确
+	ModifiedAfterSigning bool   `json:"modified_after_signing"` // 签名后被修改
+}
+
+// TimestampReport 签名时间戳（RFC 3161）的验证结果
+type TimestampReport struct {
+	Time         time.Time `json:"time"`          // 时间戳时间
+	Authority    string    `json:"authority"`     // 签发时间戳的TSA证书主题
+	SerialNumber string    `json:"serial_number"` // 令牌序列号（十六进制）
+	Valid        bool      `json:"valid"`         // 令牌签名有效且盖在签名值上
+	Builtin      bool      `json:"builtin"`       // 是否由系统内置时间戳服务签发
+	Errors       []string  `json:"errors"`        // 失败原因
+}
+
+// SealReport 电子签章（GB/T 38540）及其引用的电子印章的验证结果
+type SealReport struct {
+	ESID       string      `json:"es_id"`       // 印章编码
+	Name       string      `json:"name"`        // 印章名称
+	Type       string      `json:"type"`        // 印章类型：organization/personal
+	ValidStart time.Time   `json:"valid_start"` // 印章有效期起始
+	ValidEnd   time.Time   `json:"valid_end"`   // 印章有效期截止
+	Maker      string      `json:"maker"`       // 制章人证书主题
+	SignTime   time.Time   `json:"sign_time"`   // 签章时间
+	Valid      bool        `json:"valid"`       // 电子签章和电子印章均校验通过
+	Trust      TrustStatus `json:"trust"`       // 制章人信任状态
+	Errors     []string    `json:"errors"`      // 失败原因
+}
+
+// RevocationReport 签名证书吊销检查结果
+type RevocationReport struct {
+	Status     string     `json:"status"`      // good/revoked/unknown
+	Source     string     `json:"source"`      // 依据：dss_ocsp/dss_crl/ocsp/crl/database
+	ThisUpdate *time.Time `json:"this_update"` // 依据的生成时间
+	RevokedAt  *time.Time `json:"revoked_at"`  // 吊销时间
+	Reason     string     `json:"reason"`      // 吊销原因
+	Errors     []string   `json:"errors"`      // 查询失败原因
+}
+
+// TrustStatus 签名证书信任状态
+type TrustStatus struct {
+	Status string `json:"status"` // trusted/untrusted/unknown
+	Reason string `json:"reason"` // 判定原因
+}
+
 // VerifyPDFHandler 处理PDF验签请求
-// 前端上传PDF和证书ID，后端查找证书，调用pyHanko命令行进行PDF数字签名验签，返回验签结果
+// 前端上传PDF，后端在进程内逐个验证PDF中的数字签名，
+// 并根据CMS中内嵌的签名证书自动在证书库中查找对应记录，返回JSON验签报告
+// 上传OFD文档时按OFD签名验证，报告结构与PDF相同
 func VerifyPDFHandler(w http.ResponseWriter, r *http.Request) {
 	// 只允许POST请求，确保接口安全性
 	if r.Method != http.MethodPost {
-		http.Error(w, "仅支持POST", 405)
+		writeVerifyReport(w, 405, &VerifyReport{Msg: "仅支持POST"})
 		return
 	}
 	// 获取上传的PDF文件，使用FormFile方法解析multipart/form-data
-	file, _, err := r.FormFile("pdf")
+	file, header, err := r.FormFile("pdf")
 	if err != nil {
-		http.Error(w, "PDF文件上传失败", 400)
+		writeVerifyReport(w, 400, &VerifyReport{Msg: "PDF文件上传失败"})
 		return
 	}
 	defer file.Close() // 关闭文件句柄，防止资源泄漏
-	// 获取证书ID，确保前端传递了必要参数
-	certID := r.FormValue("cert_id")
-	if certID == "" {
-		http.Error(w, "证书ID缺失", 400)
-		return
-	}
-	// 计算PDF哈希（SHA256），用于后续签名验证（可选，便于日志或溯源）
-	hasher := sha256.New()
 	pdfBytes, err := io.ReadAll(file)
 	if err != nil {
-		http.Error(w, "读取PDF失败", 500)
+		writeVerifyReport(w, 500, &VerifyReport{Msg: "读取PDF失败"})
 		return
 	}
-	hasher.Write(pdfBytes)
-	// 将上传的PDF保存为临时文件，供pyHanko命令行使用
-	tmpPdf := "verify_tmp.pdf"
-	os.WriteFile(tmpPdf, pdfBytes, 0644)
-	// 查找证书路径，从数据库中获取证书文件的存储位置
-	var certPath string
-	err = config.DB.QueryRow("SELECT Location FROM [Cert] WHERE CertID=@p1", certID).Scan(&certPath)
-	if err != nil {
-		http.Error(w, "未找到证书", 404)
-		return
+	report := &VerifyReport{FileName: header.Filename, FileSize: int64(len(pdfBytes))}
+	// 逐个验证文档中的签名：ByteRange摘要、CMS签名值、签名后修改；OFD为各文件摘要和签名值
+	isOFD := ofdsign.IsOFD(pdfBytes)
+	var result *pdfsign.Report
+	if isOFD {
+		result, err = ofdsign.Verify(pdfBytes)
+	} else {
+		result, err = pdfsign.Verify(pdfBytes)
 	}
-	// 读取证书文件，解析PEM格式，确保证书文件有效
-	certData, err := os.ReadFile(certPath)
 	if err != nil {
-		http.Error(w, "读取证书文件失败", 500)
+		report.Msg = "验签失败！" + err.Error()
+		writeVerifyReport(w, 200, report)
 		return
 	}
-	block, _ := pem.Decode(certData)
-	if block == nil || block.Type != "CERTIFICATE" {
-		http.Error(w, "证书文件格式错误", 500)
+	report.Revisions = result.Revisions
+	if dss := result.DSS; dss != nil {
+		report.DSS = &DSSReport{Certificates: len(dss.Certificates), CRLs: len(dss.CRLs), OCSPs: len(dss.OCSPs), VRI: len(dss.VRI)}
+	}
+	if len(result.Signatures) == 0 {
+		report.Msg = "验签失败！文档中没有数字签名"
+		writeVerifyReport(w, 200, report)
 		return
 	}
-	// 用pyHanko命令行直接验签，--no-strict-syntax参数可兼容部分PDF语法问题
-	cmd := exec.Command("pyhanko", "sign", "validate", tmpPdf, "--trust", certPath, "--no-strict-syntax")
-	verifyOut, err := cmd.CombinedOutput()
-	println("[VerifyPDFHandler] pyHanko输出:", string(verifyOut))
-	os.Remove(tmpPdf) // 删除临时文件，保持环境整洁
-	// 处理验签结果，返回提示
+	// 汇总每个签名的结论，签名证书须为本系统证书库中的证书
+	report.Success = true
+	for _, sig := range result.Signatures {
+		sr := newSignatureReport(sig)
+		if sig.DocTimestamp {
+			// 文档时间戳由TSA签发，只要求完整有效；内置TSA签发的视为可信
+			sr.Trust = TrustStatus{Status: "unknown", Reason: "由外部时间戳服务签发"}
+			if sr.Timestamp != nil && sr.Timestamp.Builtin {
+				sr.Trust = TrustStatus{Status: "trusted", Reason: "由系统内置时间戳服务签发"}
+			}
+			if sr.Integrity.Status != "valid" {
+				report.Success = false
+			}
+			report.Signatures = append(report.Signatures, sr)
+			continue
+		}
+		// OFD电子签章只内嵌签章人证书，补充本系统的证书链用于查找颁发者
+		if isOFD && sig.Signer != nil && len(sig.Certificates) == 1 {
+			sig.Certificates = append(sig.Certificates, config.ChainFor(sig.Signer)...)
+			pdfsign.CheckRevocation(sig, nil, nil)
+		}
+		// DSS中没有足够的吊销信息时在线查询
+		if sig.Revocation != nil && sig.Revocation.Status == pdfsign.RevocationUnknown {
+			pdfsign.CheckRevocation(sig, result.DSS, revocationSource())
+			sr = newSignatureReport(sig)
+		}
+		sr.Cert, sr.Trust = lookupSignerCert(sig.Signer)
+		sr.Trust = chainTrust(sig, sr.Trust)
+		// 早期的自签名证书没有CRL和OCSP，按证书库中的吊销记录判定
+		if sr.Revocation == nil && sr.Cert != nil && sr.Cert.RevokedAt != nil {
+			sr.Revocation = &RevocationReport{Status: pdfsign.RevocationRevoked, Source: "database", RevokedAt: sr.Cert.RevokedAt, Reason: sr.Cert.ReasonName()}
+			sr.Trust = TrustStatus{Status: "untrusted", Reason: "签名证书已在证书库中吊销"}
+		}
+		if sr.Integrity.Status != "valid" || sr.Trust.Status != "trusted" || (sr.Timestamp != nil && !sr.Timestamp.Valid) ||
+			(sr.Seal != nil && (!sr.Seal.Valid || sr.Seal.Trust.Status != "trusted")) {
+			report.Success = false
+		}
+		report.Signatures = append(report.Signatures, sr)
+	}
+	if report.Success {
+		report.Msg = "验签成功！"
+	} else {
+		report.Msg = "验签失败！"
+	}
+	fmt.Printf("[VerifyPDFHandler] 验签结果: %s 签名数=%d\n", report.Msg, len(report.Signatures))
+	writeVerifyReport(w, 200, report)
+}
+
+// lookupSignerCert 在证书库（不限用户）中查找签名证书
+// 先按公钥匹配，再按序列号匹配；找到记录后比对证书文件，确认与签名中内嵌的证书一致
+func lookupSignerCert(signer *x509.Certificate) (*models.Cert, TrustStatus) {
+	if signer == nil {
+		return nil, TrustStatus{Status: "unknown", Reason: "未能确定签名证书"}
+	}
+	const query = "SELECT CertID, ISNULL(UserID, ''), Location, IssuerDN, ValidFrom, ValidTo, PublicKey, Algo, ISNULL(SerialNumber, ''), RevokedAt, ISNULL(RevocationReason, 0) FROM [Cert] "
+	var candidates []models.Cert
+	pubDER, err := smx509.MarshalPKIXPublicKey(signer.PublicKey)
+	if err == nil {
+		pubPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+		candidates = append(candidates, queryCerts(query+"WHERE CAST(PublicKey AS VARCHAR(MAX))=@p1", pubPEM)...)
+	}
+	candidates = append(candidates, queryCerts(query+"WHERE SerialNumber=@p1", strings.ToUpper(signer.SerialNumber.Text(16)))...)
+	if len(candidates) == 0 {
+		return nil, TrustStatus{Status: "untrusted", Reason: "签名证书不在本系统证书库中"}
+	}
+	for i := range candidates {
+		cert, err := utils.LoadCertificate(candidates[i].Location)
+		if err != nil {
+			fmt.Println("[VerifyPDFHandler] 证书文件读取失败：", candidates[i].CertID, err)
+			continue
+		}
+		if cert.Equal(signer) {
+			return &candidates[i], TrustStatus{Status: "trusted", Reason: "签名证书为本系统签发的证书"}
+		}
+	}
+	return nil, TrustStatus{Status: "untrusted", Reason: "证书库中存在公钥或序列号相同的记录，但证书内容不一致"}
+}
+
+// chainTrust 以本系统根CA（SM2证书为国密根CA）为信任锚校验签名证书链
+// 证书过期后按时间戳时间校验；早期注册的自签名证书没有证书链，仍按证书库匹配结果判定
+func chainTrust(sig *pdfsign.SignatureInfo, stored TrustStatus) TrustStatus {
+	if sig.Signer == nil {
+		return stored
+	}
+	at := time.Now()
+	if ts := sig.Timestamp; ts != nil && ts.Valid {
+		at = ts.Time
+	} else if !sig.ArchiveTime.IsZero() {
+		at = sig.ArchiveTime
+	}
+	root := config.CAFor(sig.Signer.PublicKey)
+	err := root.Verify(sig.Signer, sig.Certificates, at)
+	if err == nil {
+		if root.SM2 {
+			return TrustStatus{Status: "trusted", Reason: "证书链可追溯到本系统国密根CA"}
+		}
+		return TrustStatus{Status: "trusted", Reason: "证书链可追溯到本系统根CA"}
+	}
+	if utils.CheckSignatureFrom(sig.Signer, sig.Signer) == nil {
+		if stored.Status == "trusted" {
+			stored.Reason = "本系统早期签发的自签名证书（证书库中存在）"
+		}
+		return stored
+	}
+	return TrustStatus{Status: "untrusted", Reason: "证书链校验失败: " + err.Error()}
+}
+
+// makerTrust 电子印章须由本系统制章人制作
+func makerTrust(seal *pdfsign.SealInfo) TrustStatus {
+	if seal.Maker == nil {
+		return TrustStatus{Status: "unknown", Reason: "电子印章中没有制章人证书"}
+	}
+	if config.SealMaker != nil && seal.Maker.Equal(config.SealMaker.Certificate) {
+		return TrustStatus{Status: "trusted", Reason: "由本系统制章人制作"}
+	}
+	return TrustStatus{Status: "untrusted", Reason: "制章人不是本系统制章人"}
+}
+
+// queryCerts 执行证书查询，返回匹配的证书记录
+func queryCerts(query string, args ...interface{}) []models.Cert {
+	rows, err := config.DB.Query(query, args...)
 	if err != nil {
-		w.WriteHeader(200)
-		fmt.Fprintf(w, "验签失败！")
-		return
+		fmt.Println("[VerifyPDFHandler] 查询证书失败：", err)
+		return nil
+	}
+	defer rows.Close()
+	var certs []models.Cert
+	for rows.Next() {
+		var c models.Cert
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&c.CertID, &c.UserID, &c.Location, &c.IssuerDN, &c.ValidFrom, &c.ValidTo, &c.PublicKey, &c.Algo, &c.SerialNumber, &revokedAt, &c.RevocationReason); err == nil {
+			if revokedAt.Valid {
+				c.RevokedAt = &revokedAt.Time
+			}
+			certs = append(certs, c)
+		}
 	}
-	w.WriteHeader(200)
-	fmt.Fprintf(w, "验签成功！")
+	return certs
+}
+
+// newSignatureReport 将签名验证结果转换为报告条目
+func newSignatureReport(sig *pdfsign.SignatureInfo) SignatureReport {
+	sr := SignatureReport{
+		FieldName:          sig.FieldName,
+		Type:               "signature",
+		LTV:                sig.LTV,
+		SignerName:         sig.Name,
+		Reason:             sig.Reason,
+		Location:           sig.Location,
+		SubFilter:          sig.SubFilter,
+		DigestAlgorithm:    sig.DigestAlgorithm,
+		SignatureAlgorithm: sig.SignatureAlgorithm,
+		ByteRange:          sig.ByteRange,
+		Errors:             append([]string{}, sig.Errors...),
+		Warnings:           append([]string{}, sig.Warnings...),
+		Integrity: IntegrityStatus{
+			Status:               "invalid",
+			DigestValid:          sig.DigestValid,
+			SignatureValid:       sig.SignatureValid,
+			ModifiedAfterSigning: sig.ModifiedAfterSigning,
+		},
+	}
+	if sig.Valid() {
+		sr.Integrity.Status = "valid"
+	}
+	if sig.Signer != nil {
+		sr.Subject = sig.Signer.Subject.String()
+		sr.Issuer = sig.Signer.Issuer.String()
+		sr.SerialNumber = strings.ToUpper(sig.Signer.SerialNumber.Text(16))
+	}
+	if sig.DocTimestamp {
+		sr.Type = "doc_timestamp"
+	}
+	if !sig.SigningTime.IsZero() {
+		t := sig.SigningTime
+		sr.SigningTime = &t
+	}
+	if !sig.ArchiveTime.IsZero() {
+		t := sig.ArchiveTime
+		sr.ArchiveTime = &t
+	}
+	if ts := sig.Timestamp; ts != nil {
+		sr.Timestamp = &TimestampReport{Time: ts.Time, Valid: ts.Valid, Errors: append([]string{}, ts.Errors...)}
+		if ts.Authority != nil {
+			sr.Timestamp.Authority = ts.Authority.Subject.String()
+			sr.Timestamp.Builtin = config.TSA != nil && ts.Authority.Equal(config.TSA.Certificate)
+		}
+		if ts.SerialNumber != nil {
+			sr.Timestamp.SerialNumber = strings.ToUpper(ts.SerialNumber.Text(16))
+		}
+	}
+	if sl := sig.Seal; sl != nil {
+		sr.Seal = &SealReport{ESID: sl.ESID, Name: sl.Name, Type: "personal", ValidStart: sl.ValidStart, ValidEnd: sl.ValidEnd,
+			SignTime: sl.SignTime, Valid: sl.Valid, Trust: makerTrust(sl), Errors: append([]string{}, sl.Errors...)}
+		if sl.Type == ses.TypeOrganization {
+			sr.Seal.Type = "organization"
+		}
+		if sl.Maker != nil {
+			sr.Seal.Maker = sl.Maker.Subject.String()
+		}
+	}
+	if rv := sig.Revocation; rv != nil {
+		sr.Revocation = &RevocationReport{Status: rv.Status, Source: rv.Source, Errors: append([]string{}, rv.Errors...)}
+		if !rv.ThisUpdate.IsZero() {
+			t := rv.ThisUpdate
+			sr.Revocation.ThisUpdate = &t
+		}
+		if rv.Status == pdfsign.RevocationRevoked {
+			t := rv.RevokedAt
+			sr.Revocation.RevokedAt = &t
+			sr.Revocation.Reason = ca.ReasonName(rv.Reason)
+		}
+	}
+	if sig.CoversWholeFile {
+		sr.Coverage = "whole_file"
+	} else if len(sig.ByteRange) == 4 {
+		sr.Coverage = "earlier_revision"
+	}
+	return sr
+}
+
+// writeVerifyReport 以JSON格式输出验签报告
+func writeVerifyReport(w http.ResponseWriter, status int, report *VerifyReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	jsonStr, _ := json.Marshal(report)
+	fmt.Fprint(w, string(jsonStr))
 }
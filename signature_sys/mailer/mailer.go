@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"log"
+)
+
+// mailer/mailer.go
+// 本文件定义了邮件发送的抽象：业务代码只依赖Sender接口，部署时通过配置选择SMTP服务器，
+// 本地联调和测试时可以使用mailer/smtpmock提供的SMTP模拟服务，或只在日志中输出邮件内容的Log。
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string // 收件人邮箱地址
+	Subject string // 主题
+	Body    string // 正文（纯文本）
+}
+
+// Sender 邮件发送接口
+type Sender interface {
+	Send(msg Message) error
+}
+
+// Log 不发送邮件，只在日志中输出邮件内容，用于未配置SMTP服务器的开发环境
+type Log struct{}
+
+// Send 在日志中输出邮件内容
+func (Log) Send(msg Message) error {
+	log.Printf("[mailer] 未配置SMTP服务器，邮件未发送\n收件人: %s\n主题: %s\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,174 @@
+package smtpmock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mailer/smtpmock/smtpmock.go
+// 本文件实现了SMTP服务器的本地模拟，用于本地联调和测试邮件发送：收到的邮件保存在内存中，
+// 设置Dir时同时保存为.eml文件，便于查看邮件中的链接和验证码。不支持加密连接和认证，只应监听本机地址。
+// 可通过New().ListenAndServe("127.0.0.1:2525")启动，或运行cmd/smtpmock。
+
+// Message 收到的一封邮件
+type Message struct {
+	From string   // 信封发件人（MAIL FROM）
+	To   []string // 信封收件人（RCPT TO）
+	Data []byte   // 邮件原文
+}
+
+// Subject 返回解码后的邮件主题
+func (m Message) Subject() string {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	s, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return msg.Header.Get("Subject")
+	}
+	return s
+}
+
+// Body 返回解码后的邮件正文，支持base64和未编码的正文
+func (m Message) Body() string {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		return ""
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "base64") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(string(body)))
+		if err == nil {
+			return string(decoded)
+		}
+	}
+	return string(body)
+}
+
+// Server SMTP模拟服务
+type Server struct {
+	Dir      string // 邮件保存目录，为空时只保存在内存中
+	mu       sync.Mutex
+	messages []Message
+}
+
+// New 创建SMTP模拟服务
+func New() *Server {
+	return &Server{}
+}
+
+// Messages 返回已收到的全部邮件
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// ListenAndServe 在addr上监听并处理SMTP连接
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 处理l上的SMTP连接，直到l关闭
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle 处理一个SMTP会话，只实现发送邮件所需的命令
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost smtpmock ready")
+	var from string
+	var to []string
+	for {
+		conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "HELO", "EHLO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			from, to = addrArg(arg), nil
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, addrArg(arg))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			if len(to) == 0 {
+				tp.PrintfLine("503 RCPT first")
+				continue
+			}
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.store(Message{From: from, To: to, Data: data})
+			from, to = "", nil
+			tp.PrintfLine("250 OK")
+		case "RSET":
+			from, to = "", nil
+			tp.PrintfLine("250 OK")
+		case "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// store 保存收到的邮件
+func (s *Server) store(m Message) {
+	s.mu.Lock()
+	s.messages = append(s.messages, m)
+	n := len(s.messages)
+	s.mu.Unlock()
+	log.Printf("[smtpmock] 收到邮件: %s -> %s 主题: %s\n", m.From, strings.Join(m.To, ", "), m.Subject())
+	if s.Dir == "" {
+		return
+	}
+	name := filepath.Join(s.Dir, fmt.Sprintf("%s_%03d.eml", time.Now().Format("20060102150405"), n))
+	if err := os.WriteFile(name, m.Data, 0600); err != nil {
+		log.Println("[smtpmock] 邮件保存失败:", err)
+	}
+}
+
+// addrArg 从"FROM:<a@b>"或"TO:<a@b>"中取出地址
+func addrArg(arg string) string {
+	_, addr, _ := strings.Cut(arg, ":")
+	addr = strings.TrimSpace(addr)
+	if i := strings.IndexByte(addr, '>'); i >= 0 {
+		addr = addr[:i]
+	}
+	return strings.TrimPrefix(addr, "<")
+}
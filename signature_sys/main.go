@@ -11,6 +11,27 @@ import (
 func main() {
 	// 初始化数据库连接，确保全局可用
 	config.InitDB()
+	// 创建受保护存储目录，迁移早期保存在static目录下的文件
+	config.InitStorage()
+	// 初始化签名密钥后端（本地文件、PKCS#11、KMS）
+	config.InitSigners()
+	// 加载内置证书颁发机构和时间戳服务密钥
+	config.InitCA()
+	config.InitTSA()
+	// 加载电子印章制章人
+	config.InitSealMaker()
+	// 加载可见签名说明文字使用的字体
+	config.InitFont()
+	// 初始化邮件发送（访客签署链接和验证码）
+	config.InitMail()
+	// 按ADMIN_USERS设置系统管理员
+	config.InitAdmins()
+	// 生成CRL并启动定期更新任务
+	handlers.StartCRLJob()
+	// 启动已签名文档的归档时间戳续期任务
+	handlers.StartArchiveJob()
+	// 启动信封过期检查任务
+	handlers.StartEnvelopeJob()
 
 	// 路由注册，绑定URL到对应的处理函数
 	// 首页
@@ -19,22 +40,95 @@ func main() {
 	http.HandleFunc("/register", handlers.RegisterHandler)
 	http.HandleFunc("/login", handlers.LoginHandler)
 	http.HandleFunc("/logout", handlers.LogoutHandler)
+	http.HandleFunc("/user/pin", middleware.AuthMiddleware(handlers.PINChangeHandler))
 	// 签章图片相关，需登录后才能访问
 	http.HandleFunc("/seal/upload", middleware.AuthMiddleware(handlers.SealUploadHandler))
 	http.HandleFunc("/seal/list", middleware.AuthMiddleware(handlers.SealListHandler))
 	http.HandleFunc("/seal/delete", middleware.AuthMiddleware(handlers.SealDeleteHandler))
+	http.HandleFunc("/seal/image", middleware.AuthMiddleware(handlers.SealImageHandler))
+	http.HandleFunc("/seal/ses", middleware.AuthMiddleware(handlers.SealSESHandler))
+	// 用印授权和用印申请，需登录
+	http.HandleFunc("/seal/grant/list", middleware.AuthMiddleware(handlers.SealGrantListHandler))
+	http.HandleFunc("/seal/grant/create", middleware.AuthMiddleware(handlers.SealGrantCreateHandler))
+	http.HandleFunc("/seal/grant/view", middleware.AuthMiddleware(handlers.SealGrantViewHandler))
+	http.HandleFunc("/seal/grant/document", middleware.AuthMiddleware(handlers.SealGrantDocumentHandler))
+	http.HandleFunc("/seal/grant/revoke", middleware.AuthMiddleware(handlers.SealGrantRevokeHandler))
+	http.HandleFunc("/seal/granted", middleware.AuthMiddleware(handlers.SealGrantedHandler))
+	http.HandleFunc("/seal/request/create", middleware.AuthMiddleware(handlers.SealRequestCreateHandler))
+	http.HandleFunc("/seal/request/cancel", middleware.AuthMiddleware(handlers.SealRequestCancelHandler))
+	http.HandleFunc("/seal/request/approvals", middleware.AuthMiddleware(handlers.SealApprovalListHandler))
+	http.HandleFunc("/seal/request/decide", middleware.AuthMiddleware(handlers.SealRequestDecideHandler))
+	http.HandleFunc("/seal/request/document", middleware.AuthMiddleware(handlers.SealRequestDocumentHandler))
+	// 组织、部门和成员管理，需登录
+	http.HandleFunc("/org/list", middleware.AuthMiddleware(handlers.OrgListHandler))
+	http.HandleFunc("/org/create", middleware.AuthMiddleware(handlers.OrgCreateHandler))
+	http.HandleFunc("/org/view", middleware.AuthMiddleware(handlers.OrgViewHandler))
+	http.HandleFunc("/org/department/add", middleware.AuthMiddleware(handlers.OrgDepartmentAddHandler))
+	http.HandleFunc("/org/member/add", middleware.AuthMiddleware(handlers.OrgMemberAddHandler))
+	http.HandleFunc("/org/member/update", middleware.AuthMiddleware(handlers.OrgMemberUpdateHandler))
+	http.HandleFunc("/org/member/remove", middleware.AuthMiddleware(handlers.OrgMemberRemoveHandler))
+	// 用户证书管理，需登录
+	http.HandleFunc("/cert/list", middleware.AuthMiddleware(handlers.CertListHandler))
+	http.HandleFunc("/cert/revoke", middleware.AuthMiddleware(handlers.CertRevokeHandler))
+	http.HandleFunc("/cert/download", middleware.AuthMiddleware(handlers.CertDownloadHandler))
+	http.HandleFunc("/cert/create", middleware.AuthMiddleware(handlers.CertCreateHandler))
 	// PDF文档相关，需登录
 	http.HandleFunc("/document/upload", middleware.AuthMiddleware(handlers.DocumentUploadHandler))
 	http.HandleFunc("/document/list", middleware.AuthMiddleware(handlers.DocumentListHandler))
 	http.HandleFunc("/document/delete", middleware.AuthMiddleware(handlers.DocumentDeleteHandler))
+	http.HandleFunc("/document/versions", middleware.AuthMiddleware(handlers.DocumentVersionsHandler))
+	http.HandleFunc("/document/download", middleware.AuthMiddleware(handlers.DocumentDownloadHandler))
+	http.HandleFunc("/document/preview", middleware.AuthMiddleware(handlers.DocumentPreviewHandler))
 	// PDF签章相关，需登录
 	http.HandleFunc("/sign/pdf", middleware.AuthMiddleware(handlers.SignPDFHandler))          // 盖章处理
 	http.HandleFunc("/sign/pdf/form", middleware.AuthMiddleware(handlers.SignPDFPageHandler)) // 盖章页面
-	http.HandleFunc("/sign/preview", handlers.SignPDFPreviewHandler)
+	http.HandleFunc("/sign/preview", middleware.AuthMiddleware(handlers.SignPDFPreviewHandler))
+	// 多方签署（信封），需登录
+	http.HandleFunc("/envelope/list", middleware.AuthMiddleware(handlers.EnvelopeListHandler))
+	http.HandleFunc("/envelope/create", middleware.AuthMiddleware(handlers.EnvelopeCreateHandler))
+	http.HandleFunc("/envelope/view", middleware.AuthMiddleware(handlers.EnvelopeViewHandler))
+	http.HandleFunc("/envelope/signer/add", middleware.AuthMiddleware(handlers.EnvelopeSignerAddHandler))
+	http.HandleFunc("/envelope/signer/delete", middleware.AuthMiddleware(handlers.EnvelopeSignerDeleteHandler))
+	http.HandleFunc("/envelope/field/add", middleware.AuthMiddleware(handlers.EnvelopeFieldAddHandler))
+	http.HandleFunc("/envelope/field/delete", middleware.AuthMiddleware(handlers.EnvelopeFieldDeleteHandler))
+	http.HandleFunc("/envelope/send", middleware.AuthMiddleware(handlers.EnvelopeSendHandler))
+	http.HandleFunc("/envelope/inbox", middleware.AuthMiddleware(handlers.EnvelopeInboxHandler))
+	http.HandleFunc("/envelope/document", middleware.AuthMiddleware(handlers.EnvelopeDocumentHandler))
+	http.HandleFunc("/envelope/sign", middleware.AuthMiddleware(handlers.EnvelopeSignHandler))          // 签署处理
+	http.HandleFunc("/envelope/sign/form", middleware.AuthMiddleware(handlers.EnvelopeSignPageHandler)) // 签署页面
+	http.HandleFunc("/envelope/decline", middleware.AuthMiddleware(handlers.EnvelopeDeclineHandler))
+	http.HandleFunc("/envelope/signer/resend", middleware.AuthMiddleware(handlers.EnvelopeGuestLinkHandler))
+	// 管理后台，仅系统管理员可访问
+	http.HandleFunc("/admin", middleware.AdminMiddleware(handlers.AdminIndexHandler))
+	http.HandleFunc("/admin/users", middleware.AdminMiddleware(handlers.AdminUserListHandler))
+	http.HandleFunc("/admin/user/disable", middleware.AdminMiddleware(handlers.AdminUserDisableHandler))
+	http.HandleFunc("/admin/user/enable", middleware.AdminMiddleware(handlers.AdminUserEnableHandler))
+	http.HandleFunc("/admin/user/role", middleware.AdminMiddleware(handlers.AdminUserRoleHandler))
+	http.HandleFunc("/admin/certs", middleware.AdminMiddleware(handlers.AdminCertListHandler))
+	http.HandleFunc("/admin/cert/download", middleware.AdminMiddleware(handlers.AdminCertDownloadHandler))
+	http.HandleFunc("/admin/cert/revoke", middleware.AdminMiddleware(handlers.AdminCertRevokeHandler))
+	http.HandleFunc("/admin/seals", middleware.AdminMiddleware(handlers.AdminSealListHandler))
+	http.HandleFunc("/admin/seal/image", middleware.AdminMiddleware(handlers.AdminSealImageHandler))
+	http.HandleFunc("/admin/seal/retire", middleware.AdminMiddleware(handlers.AdminSealRetireHandler))
+	http.HandleFunc("/admin/signlog", middleware.AdminMiddleware(handlers.AdminSignLogHandler))
+	http.HandleFunc("/admin/log", middleware.AdminMiddleware(handlers.AdminLogHandler))
+	// 访客签署，凭邮件中的签署链接访问，无需登录
+	http.HandleFunc("/guest/sign", handlers.GuestSignHandler)
+	http.HandleFunc("/guest/otp", handlers.GuestOTPHandler)
+	http.HandleFunc("/guest/decline", handlers.GuestDeclineHandler)
+	http.HandleFunc("/guest/document", handlers.GuestDocumentHandler)
 	// PDF验签相关
 	http.HandleFunc("/verify/pdf/page", handlers.VerifyPDFPageHandler)
 	http.HandleFunc("/verify/pdf", handlers.VerifyPDFHandler)
-	// 静态资源（CSS、图片、证书、文档等）
+	// CA证书链下载
+	http.HandleFunc("/ca/chain", handlers.CAChainHandler)
+	// 证书吊销信息发布（CRL和OCSP）
+	http.HandleFunc("/ca/crl", handlers.CRLHandler)
+	http.HandleFunc("/ocsp", handlers.OCSPHandler)
+	http.HandleFunc("/ocsp/", handlers.OCSPHandler)
+	// 内置时间戳服务（RFC 3161）
+	http.HandleFunc("/tsa", handlers.TSAHandler)
+	// 静态资源（仅样式表等公开文件；文档、签章图片和证书保存在storage目录，通过上面的接口下载）
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	// 启动Web服务，监听8080端口
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+
+	"signature_sys/models"
+)
+
+// scopeRoles 返回Scope条件中允许的组织角色，条件不含组织授权时返回nil
+func scopeRoles(t *testing.T, cond string) []string {
+	t.Helper()
+	i := strings.Index(cond, "m.Role IN ('")
+	if i < 0 {
+		return nil
+	}
+	rest := cond[i+len("m.Role IN ('"):]
+	j := strings.Index(rest, "')")
+	if j < 0 {
+		t.Fatalf("角色列表不完整: %s", cond)
+	}
+	return strings.Split(rest[:j], "', '")
+}
+
+func TestScopePersonalResource(t *testing.T) {
+	for _, action := range []Action{ActionView, ActionUse, ActionManage} {
+		if got := Scope(ResourceDocument, action, "", "@p2"); got != "UserID=@p2" {
+			t.Errorf("文档%s条件 = %s", action, got)
+		}
+		if got := Scope(ResourceDocument, action, "d", "@p1"); got != "d.UserID=@p1" {
+			t.Errorf("带别名的文档%s条件 = %s", action, got)
+		}
+	}
+}
+
+func TestScopeOrgResource(t *testing.T) {
+	cond := Scope(ResourceSeal, ActionUse, "s", "@p3")
+	for _, part := range []string{"(s.OrgID IS NULL AND s.UserID=@p3)", "s.OrgID IN (SELECT m.OrgID FROM [OrgMember] m WHERE m.UserID=@p3"} {
+		if !strings.Contains(cond, part) {
+			t.Errorf("签章使用条件缺少%q: %s", part, cond)
+		}
+	}
+	if strings.Contains(cond, "@p1") || strings.Contains(cond, "@p2") {
+		t.Errorf("签章使用条件引用了其他参数: %s", cond)
+	}
+	if cond := Scope(ResourceCert, ActionView, "", "@p2"); !strings.HasPrefix(cond, "((OrgID IS NULL AND UserID=@p2) OR OrgID IN (") {
+		t.Errorf("不带别名的证书查看条件 = %s", cond)
+	}
+}
+
+func TestScopeRoles(t *testing.T) {
+	tests := []struct {
+		res    Resource
+		action Action
+		roles  []string
+	}{
+		{ResourceSeal, ActionView, []string{models.RoleAdmin, models.RoleSealKeeper, models.RoleSigner, models.RoleViewer}},
+		{ResourceSeal, ActionUse, []string{models.RoleAdmin, models.RoleSealKeeper, models.RoleSigner}},
+		{ResourceSeal, ActionManage, []string{models.RoleAdmin, models.RoleSealKeeper}},
+		{ResourceCert, ActionView, []string{models.RoleAdmin, models.RoleSealKeeper, models.RoleSigner, models.RoleViewer}},
+		{ResourceCert, ActionUse, []string{models.RoleAdmin, models.RoleSealKeeper, models.RoleSigner}},
+		{ResourceCert, ActionManage, []string{models.RoleAdmin}},
+	}
+	for _, tt := range tests {
+		got := scopeRoles(t, Scope(tt.res, tt.action, "", "@p2"))
+		if strings.Join(got, ",") != strings.Join(tt.roles, ",") {
+			t.Errorf("%s %s允许的角色 = %v，应为%v", tt.res, tt.action, got, tt.roles)
+		}
+	}
+}
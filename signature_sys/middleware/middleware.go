@@ -3,11 +3,12 @@ package middleware
 import (
 	"net/http"
 	"signature_sys/utils"
+	"time"
 )
 
 // middleware/middleware.go
 // 本文件实现了JWT认证相关的中间件和工具函数。
-// 包括登录校验、用户信息获取等，供各业务Handler调用。
+// 包括登录校验、管理员校验、用户信息获取等，供各业务Handler调用。
 
 // JWT认证中间件，未登录则跳转到登录页
 // 用法：在需要登录的路由上包裹此中间件
@@ -21,17 +22,36 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 		// 解析JWT，验证其合法性
-		_, err = utils.ParseJWT(cookie.Value)
+		claims, err := utils.ParseJWT(cookie.Value)
 		if err != nil {
 			// 如果JWT解析失败，跳转到登录页
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		// 用户被管理员停用后，已签发的Token立即失效
+		if !userActive(claims.UserID) {
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "", Path: "/", Expires: time.Unix(0, 0), HttpOnly: true})
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
 		// 如果验证通过，执行下一个Handler
 		next(w, r)
 	}
 }
 
+// 管理后台中间件，在登录校验之上要求当前用户为系统管理员，否则返回403
+// 用法：在/admin下的路由上包裹此中间件
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := GetCurrentUser(r)
+		if !IsAdmin(userID) {
+			Forbidden(w)
+			return
+		}
+		next(w, r)
+	})
+}
+
 // 获取当前登录用户信息（未登录返回空字符串）
 // 返回值：userID, username
 func GetCurrentUser(r *http.Request) (userID, username string) {
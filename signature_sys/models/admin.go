@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// models/admin.go
+// 本文件定义了管理后台操作日志的数据结构，对应数据库AdminLog表。
+// 管理员对用户、证书和签章的每次操作都记录一条日志，供事后审计。
+
+// 管理操作，对应[AdminLog].Action
+const (
+	AdminUserDisable     = "user_disable"      // 停用用户
+	AdminUserEnable      = "user_enable"       // 启用用户
+	AdminUserGrantAdmin  = "user_grant_admin"  // 设为系统管理员
+	AdminUserRevokeAdmin = "user_revoke_admin" // 取消系统管理员
+	AdminCertRevoke      = "cert_revoke"       // 吊销证书
+	AdminSealRetire      = "seal_retire"       // 停用签章
+)
+
+// 管理操作的对象类型，对应[AdminLog].TargetType
+const (
+	AdminTargetUser = "user"
+	AdminTargetCert = "cert"
+	AdminTargetSeal = "seal"
+)
+
+type AdminLog struct {
+	LogID      int64     `json:"log_id"`
+	AdminName  string    `json:"admin_name"`  // 操作的管理员用户名
+	Action     string    `json:"action"`      // 操作
+	TargetType string    `json:"target_type"` // 对象类型
+	TargetID   string    `json:"target_id"`   // 对象ID
+	TargetName string    `json:"target_name"` // 对象名称（用户名、证书序列号或签章名称），对象已删除时为空
+	Detail     string    `json:"detail"`      // 说明，如停用原因、吊销原因
+	Time       time.Time `json:"time"`        // 操作时间
+}
+
+// ActionName 返回管理操作的中文名称
+func (l AdminLog) ActionName() string {
+	switch l.Action {
+	case AdminUserDisable:
+		return "停用用户"
+	case AdminUserEnable:
+		return "启用用户"
+	case AdminUserGrantAdmin:
+		return "设为管理员"
+	case AdminUserRevokeAdmin:
+		return "取消管理员"
+	case AdminCertRevoke:
+		return "吊销证书"
+	case AdminSealRetire:
+		return "停用签章"
+	}
+	return l.Action
+}
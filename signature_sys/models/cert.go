@@ -0,0 +1,35 @@
+package models
+
+import (
+	"signature_sys/ca"
+	"time"
+)
+
+// models/cert.go
+// 本文件定义了Cert证书数据结构，对应数据库Cert表。
+// 用于验签报告中返回匹配到的证书记录，以及证书列表和吊销管理。
+
+type Cert struct {
+	CertID           string     `json:"cert_id"`           // 证书ID
+	UserID           string     `json:"user_id"`           // 所属用户ID（组织证书为申请人）
+	OrgID            string     `json:"org_id"`            // 所属组织ID，个人证书为空
+	OrgName          string     `json:"org_name"`          // 所属组织名称，个人证书为空
+	Location         string     `json:"-"`                 // 证书文件路径（不对外输出）
+	IssuerDN         string     `json:"issuer_dn"`         // 颁发者
+	ValidFrom        time.Time  `json:"valid_from"`        // 有效期起始
+	ValidTo          time.Time  `json:"valid_to"`          // 有效期截止
+	PublicKey        string     `json:"public_key"`        // PEM格式公钥
+	Algo             string     `json:"algo"`              // 算法类型（ECC/RSA/SM2）
+	SerialNumber     string     `json:"serial_number"`     // 证书序列号（十六进制）
+	RevokedAt        *time.Time `json:"revoked_at"`        // 吊销时间，未吊销为null
+	RevocationReason int        `json:"revocation_reason"` // 吊销原因（RFC 5280）
+	KeyBackend       string     `json:"key_backend"`       // 私钥所在的密钥后端（file/pkcs11/kms）
+}
+
+// ReasonName 返回吊销原因的中文名称，未吊销时返回空字符串
+func (c Cert) ReasonName() string {
+	if c.RevokedAt == nil {
+		return ""
+	}
+	return ca.ReasonName(c.RevocationReason)
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// models/document.go
+// 本文件定义了DocumentVersion文档版本数据结构，对应数据库DocumentVersion表。
+// 每次上传、签名或归档续期生成的文件都记录为文档的一个版本。
+// 签章图片和签名域与数字签名写入同一修订，不单独生成版本，统一记录为sign版本。
+
+// 文档版本的创建操作
+const (
+	VersionOpUpload    = "upload"    // 上传原始文件
+	VersionOpSign      = "sign"      // 数字签名（含同一修订中加盖的签章和签名域）
+	VersionOpTimestamp = "timestamp" // 追加验证数据和文档时间戳（归档续期）
+)
+
+type DocumentVersion struct {
+	VersionID       string    `json:"version_id"`        // 版本ID
+	DocID           string    `json:"doc_id"`            // 所属文档ID
+	VersionNo       int       `json:"version_no"`        // 版本号，从1开始递增
+	ParentVersionID string    `json:"parent_version_id"` // 上一版本ID，原始上传版本为空
+	Location        string    `json:"-"`                 // 文件路径（不对外输出）
+	FileHash        string    `json:"file_hash"`         // 文件SHA256哈希
+	Operation       string    `json:"operation"`         // 创建该版本的操作
+	CreatedBy       string    `json:"created_by"`        // 创建者用户ID
+	CreatorName     string    `json:"creator_name"`      // 创建者用户名
+	CreatedAt       time.Time `json:"created_at"`        // 创建时间
+}
+
+// OperationName 返回创建操作的中文名称，用于页面展示
+func (v DocumentVersion) OperationName() string {
+	switch v.Operation {
+	case VersionOpUpload:
+		return "上传"
+	case VersionOpSign:
+		return "签名"
+	case VersionOpTimestamp:
+		return "归档时间戳"
+	}
+	return v.Operation
+}
@@ -0,0 +1,135 @@
+package models
+
+import "time"
+
+// models/envelope.go
+// 本文件定义了信封（多方签署流程）相关的数据结构，对应数据库Envelope、EnvelopeSigner和EnvelopeField表。
+// 发起人把一份或多份文档放入信封，添加签署人并为每个签署人预置签章位置，发送后各签署人按顺序签署。
+// 签署人可以是系统用户，也可以是没有账号的访客：访客通过邮件中的一次性签署链接（SigningLink表）签署。
+
+// 信封状态
+const (
+	EnvelopeDraft      = "draft"       // 草稿：可编辑签署人和签章位置
+	EnvelopeSent       = "sent"        // 已发送，尚无人签署
+	EnvelopeInProgress = "in_progress" // 签署中：已有签署人完成签署
+	EnvelopeCompleted  = "completed"   // 全部签署人已签署
+	EnvelopeDeclined   = "declined"    // 有签署人拒签，流程终止
+	EnvelopeExpired    = "expired"     // 超过截止时间仍未完成，流程终止
+)
+
+// 签署顺序
+const (
+	SigningOrderSerial   = "serial"   // 依次签署：签署人按添加顺序逐个签署
+	SigningOrderParallel = "parallel" // 同时签署：全部签署人可同时签署
+)
+
+// 签署人状态
+const (
+	SignerPending  = "pending"  // 待签署
+	SignerSigned   = "signed"   // 已签署
+	SignerDeclined = "declined" // 已拒签
+)
+
+type Envelope struct {
+	EnvelopeID   string     `json:"envelope_id"`   // 信封ID
+	OwnerID      string     `json:"owner_id"`      // 发起人用户ID
+	OwnerName    string     `json:"owner_name"`    // 发起人用户名
+	Subject      string     `json:"subject"`       // 主题
+	Message      string     `json:"message"`       // 给签署人的说明
+	SigningOrder string     `json:"signing_order"` // 签署顺序
+	Status       string     `json:"status"`        // 信封状态
+	ExpiresAt    time.Time  `json:"expires_at"`    // 截止时间，超过后未完成的信封过期
+	CreatedAt    time.Time  `json:"created_at"`    // 创建时间
+	SentAt       *time.Time `json:"sent_at"`       // 发送时间，草稿为null
+	CompletedAt  *time.Time `json:"completed_at"`  // 完成时间，未完成为null
+}
+
+// StatusName 返回信封状态的中文名称，用于页面展示
+func (e Envelope) StatusName() string {
+	switch e.Status {
+	case EnvelopeDraft:
+		return "草稿"
+	case EnvelopeSent:
+		return "已发送"
+	case EnvelopeInProgress:
+		return "签署中"
+	case EnvelopeCompleted:
+		return "已完成"
+	case EnvelopeDeclined:
+		return "已拒签"
+	case EnvelopeExpired:
+		return "已过期"
+	}
+	return e.Status
+}
+
+// OrderName 返回签署顺序的中文名称
+func (e Envelope) OrderName() string {
+	if e.SigningOrder == SigningOrderParallel {
+		return "同时签署"
+	}
+	return "依次签署"
+}
+
+// Active 信封已发送且尚未结束，签署人可以签署或拒签
+func (e Envelope) Active() bool {
+	return e.Status == EnvelopeSent || e.Status == EnvelopeInProgress
+}
+
+type EnvelopeSigner struct {
+	SignerID      string     `json:"signer_id"`      // 签署人记录ID
+	EnvelopeID    string     `json:"envelope_id"`    // 所属信封ID
+	UserID        string     `json:"user_id"`        // 签署人用户ID，访客签署人为空
+	Username      string     `json:"username"`       // 签署人用户名，访客签署人为其姓名
+	GuestEmail    string     `json:"guest_email"`    // 访客签署人的邮箱，用于接收签署链接和验证码
+	RoutingOrder  int        `json:"routing_order"`  // 签署顺序号，顺序号相同的签署人可同时签署
+	Status        string     `json:"status"`         // 签署状态
+	SignedAt      *time.Time `json:"signed_at"`      // 签署或拒签时间
+	DeclineReason string     `json:"decline_reason"` // 拒签原因
+}
+
+// IsGuest 是否为没有账号的访客签署人
+func (s EnvelopeSigner) IsGuest() bool {
+	return s.UserID == ""
+}
+
+// StatusName 返回签署状态的中文名称
+func (s EnvelopeSigner) StatusName() string {
+	switch s.Status {
+	case SignerPending:
+		return "待签署"
+	case SignerSigned:
+		return "已签署"
+	case SignerDeclined:
+		return "已拒签"
+	}
+	return s.Status
+}
+
+type EnvelopeField struct {
+	FieldID    string     `json:"field_id"`    // 签章位置ID
+	EnvelopeID string     `json:"envelope_id"` // 所属信封ID
+	SignerID   string     `json:"signer_id"`   // 负责签署的签署人记录ID
+	DocID      string     `json:"doc_id"`      // 所在文档ID
+	DocName    string     `json:"doc_name"`    // 所在文档的原始文件名
+	Page       int        `json:"page"`        // 页码
+	X          float64    `json:"x"`           // 签章左下角相对页面左下角的偏移
+	Y          float64    `json:"y"`
+	Scale      float64    `json:"scale"`     // 缩放比例，相对页面尺寸
+	Rotation   float64    `json:"rotation"`  // 顺时针旋转角度
+	SignedAt   *time.Time `json:"signed_at"` // 签署时间，未签署为null
+}
+
+type SigningLink struct {
+	LinkID       string     `json:"link_id"`        // 链接ID
+	SignerID     string     `json:"signer_id"`      // 访客签署人记录ID
+	ExpiresAt    time.Time  `json:"expires_at"`     // 链接失效时间
+	UsedAt       *time.Time `json:"used_at"`        // 签署或拒签后链接作废的时间
+	RevokedAt    *time.Time `json:"revoked_at"`     // 重新发送链接或验证码累计错误过多时链接作废的时间
+	OTPHash      string     `json:"-"`              // 邮件验证码的哈希
+	OTPSentAt    *time.Time `json:"otp_sent_at"`    // 最近一次发送验证码的时间
+	OTPExpiresAt *time.Time `json:"otp_expires_at"` // 验证码失效时间
+	OTPAttempts  int        `json:"otp_attempts"`   // 当前验证码的错误尝试次数
+	OTPFailures  int        `json:"otp_failures"`   // 链接累计的验证码错误次数，重新获取验证码不清零
+	OTPSends     int        `json:"otp_sends"`      // 链接累计发送验证码的次数
+}
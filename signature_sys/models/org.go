@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// models/org.go
+// 本文件定义了组织、部门和组织成员的数据结构，对应数据库Organization、Department和OrgMember表。
+// 组织拥有的签章和证书（[Seal].OrgID、[Cert].OrgID不为空）按成员在组织中的角色授权使用，而不是按UserID归属。
+
+// 组织成员角色，对应[OrgMember].Role
+const (
+	RoleAdmin      = "admin"       // 管理员：管理成员和部门，管理组织的签章和证书
+	RoleSealKeeper = "seal_keeper" // 印章管理员：管理组织的签章（上传、删除、授权、审批用印），可使用组织签章和证书签名
+	RoleSigner     = "signer"      // 签署人：可使用组织的签章和证书签名
+	RoleViewer     = "viewer"      // 查看者：只能查看组织的签章和证书
+)
+
+// Roles 全部角色，按权限从高到低排列，用于页面选择
+var Roles = []string{RoleAdmin, RoleSealKeeper, RoleSigner, RoleViewer}
+
+// RoleName 返回角色的中文名称
+func RoleName(role string) string {
+	switch role {
+	case RoleAdmin:
+		return "管理员"
+	case RoleSealKeeper:
+		return "印章管理员"
+	case RoleSigner:
+		return "签署人"
+	case RoleViewer:
+		return "查看者"
+	}
+	return role
+}
+
+type Organization struct {
+	OrgID     string    `json:"org_id"`     // 组织ID
+	Name      string    `json:"name"`       // 组织名称，全局唯一
+	CreatedBy string    `json:"created_by"` // 创建者用户ID，创建时成为管理员
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+	Role      string    `json:"role"`       // 当前用户在组织中的角色（查询时填充）
+}
+
+// RoleName 返回当前用户角色的中文名称
+func (o Organization) RoleName() string {
+	return RoleName(o.Role)
+}
+
+type Department struct {
+	DeptID string `json:"dept_id"` // 部门ID
+	OrgID  string `json:"org_id"`  // 所属组织ID
+	Name   string `json:"name"`    // 部门名称，组织内唯一
+}
+
+type OrgMember struct {
+	OrgID    string    `json:"org_id"`    // 组织ID
+	UserID   string    `json:"user_id"`   // 成员用户ID
+	Username string    `json:"username"`  // 成员用户名
+	DeptID   string    `json:"dept_id"`   // 所属部门ID，未分配部门为空
+	DeptName string    `json:"dept_name"` // 所属部门名称
+	Role     string    `json:"role"`      // 角色
+	JoinedAt time.Time `json:"joined_at"` // 加入时间
+}
+
+// RoleName 返回成员角色的中文名称
+func (m OrgMember) RoleName() string {
+	return RoleName(m.Role)
+}
@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// models/seal.go
+// 本文件定义了用印授权和用印申请的数据结构，对应数据库SealGrant、SealGrantDocument和SealRequest表。
+// 签章的所有者可以授权其他用户使用自己的签章，授权限定有效期、使用次数和可盖章的文档；
+// 需要审批的授权每次用印前由被授权人提交用印申请，所有者批准后才能盖章。
+
+// 用印申请状态
+const (
+	SealRequestPending   = "pending"   // 待审批
+	SealRequestApproved  = "approved"  // 已批准，尚未用印
+	SealRequestRejected  = "rejected"  // 已驳回
+	SealRequestUsed      = "used"      // 已用印，每次批准只能用印一次
+	SealRequestCancelled = "cancelled" // 申请人撤回
+)
+
+type SealGrant struct {
+	GrantID         string     `json:"grant_id"`         // 授权ID
+	SealID          string     `json:"seal_id"`          // 被授权使用的签章ID
+	SealName        string     `json:"seal_name"`        // 签章名称（印章名称或原始文件名）
+	GranteeID       string     `json:"grantee_id"`       // 被授权人用户ID
+	GranteeName     string     `json:"grantee_name"`     // 被授权人用户名
+	GrantedBy       string     `json:"granted_by"`       // 授权人（签章所有者）用户ID
+	GrantorName     string     `json:"grantor_name"`     // 授权人用户名
+	ValidFrom       time.Time  `json:"valid_from"`       // 授权生效时间
+	ValidTo         time.Time  `json:"valid_to"`         // 授权截止时间
+	MaxUses         int        `json:"max_uses"`         // 最多使用次数，0表示不限
+	UsedCount       int        `json:"used_count"`       // 已使用次数，每次签章请求计一次
+	RequireApproval bool       `json:"require_approval"` // 每次用印是否需要所有者审批
+	CreatedAt       time.Time  `json:"created_at"`       // 创建时间
+	RevokedAt       *time.Time `json:"revoked_at"`       // 撤销时间，未撤销为null
+}
+
+// Usable 授权在t时是否可用：未撤销、在有效期内且未用完次数
+func (g SealGrant) Usable(t time.Time) bool {
+	if g.RevokedAt != nil || t.Before(g.ValidFrom) || !t.Before(g.ValidTo) {
+		return false
+	}
+	return g.MaxUses == 0 || g.UsedCount < g.MaxUses
+}
+
+// StatusName 返回授权状态的中文名称，用于页面展示
+func (g SealGrant) StatusName() string {
+	now := time.Now()
+	switch {
+	case g.RevokedAt != nil:
+		return "已撤销"
+	case now.Before(g.ValidFrom):
+		return "未生效"
+	case !now.Before(g.ValidTo):
+		return "已过期"
+	case g.MaxUses > 0 && g.UsedCount >= g.MaxUses:
+		return "次数已用完"
+	}
+	return "有效"
+}
+
+type SealRequest struct {
+	RequestID     string     `json:"request_id"`     // 申请ID
+	GrantID       string     `json:"grant_id"`       // 依据的用印授权ID
+	SealName      string     `json:"seal_name"`      // 签章名称
+	RequesterID   string     `json:"requester_id"`   // 申请人用户ID
+	RequesterName string     `json:"requester_name"` // 申请人用户名
+	DocID         string     `json:"doc_id"`         // 申请盖章的文档ID
+	DocName       string     `json:"doc_name"`       // 文档原始文件名
+	Reason        string     `json:"reason"`         // 用印事由
+	Status        string     `json:"status"`         // 申请状态
+	ApproverName  string     `json:"approver_name"`  // 审批人用户名，未审批为空
+	Comment       string     `json:"comment"`        // 审批意见
+	CreatedAt     time.Time  `json:"created_at"`     // 申请时间
+	DecidedAt     *time.Time `json:"decided_at"`     // 审批时间，未审批为null
+	UsedAt        *time.Time `json:"used_at"`        // 用印时间，未用印为null
+}
+
+// StatusName 返回申请状态的中文名称，用于页面展示
+func (r SealRequest) StatusName() string {
+	switch r.Status {
+	case SealRequestPending:
+		return "待审批"
+	case SealRequestApproved:
+		return "已批准"
+	case SealRequestRejected:
+		return "已驳回"
+	case SealRequestUsed:
+		return "已用印"
+	case SealRequestCancelled:
+		return "已撤回"
+	}
+	return r.Status
+}
@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// models/signlog.go
+// 本文件定义了签章日志中记录的盖章方式和操作，对应数据库SignLog表的StampMode和Action字段。
+
+// 盖章方式
+const (
+	StampModeNormal    = "normal"     // 普通签章：整枚印章盖在一页上
+	StampModeCrossPage = "cross_page" // 骑缝章：印章切分后盖在各页边缘，PositionX不记录，PositionY为纵向偏移
+	StampModeKeyword   = "keyword"    // 关键字定位：在关键字的每处（或第N处）匹配旁盖章，每处签章记录一条日志
+)
+
+// 签章日志记录的操作：数字签名以外，信封流程的每一步也记录在签章日志中
+const (
+	ActionCreate   = "create"   // 创建信封
+	ActionSend     = "send"     // 发送信封
+	ActionSign     = "sign"     // 签章签名
+	ActionDecline  = "decline"  // 拒签
+	ActionExpire   = "expire"   // 信封过期
+	ActionComplete = "complete" // 信封全部签署完成
+)
+
+// AuditEntry 信封的一条审计记录（签章日志）
+type AuditEntry struct {
+	LogID    int64     `json:"log_id"`
+	Username string    `json:"username"` // 操作人用户名，系统任务为空
+	DocName  string    `json:"doc_name"` // 相关文档的原始文件名，与文档无关的操作为空
+	Action   string    `json:"action"`   // 操作
+	Detail   string    `json:"detail"`   // 说明
+	Time     time.Time `json:"time"`     // 操作时间
+}
+
+// ActionName 返回操作的中文名称
+func (a AuditEntry) ActionName() string {
+	switch a.Action {
+	case ActionCreate:
+		return "创建"
+	case ActionSend:
+		return "发送"
+	case ActionSign:
+		return "签署"
+	case ActionDecline:
+		return "拒签"
+	case ActionExpire:
+		return "过期"
+	case ActionComplete:
+		return "完成"
+	}
+	return a.Action
+}
+
+// SignLogEntry 管理后台浏览的一条签章日志，在审计记录之外附带签章、证书和所属信封、用印授权
+type SignLogEntry struct {
+	AuditEntry
+	DocID      string `json:"doc_id"`      // 文档ID
+	SealID     string `json:"seal_id"`     // 签章ID，未盖章的操作为空
+	SealName   string `json:"seal_name"`   // 签章名称
+	CertID     string `json:"cert_id"`     // 签名证书ID
+	Algorithm  string `json:"algorithm"`   // 签名算法
+	StampMode  string `json:"stamp_mode"`  // 盖章方式
+	EnvelopeID string `json:"envelope_id"` // 所属信封ID，单独签章为空
+	GrantID    string `json:"grant_id"`    // 使用他人签章时依据的用印授权ID
+}
@@ -0,0 +1,359 @@
+package ofdsign
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ofdsign/package.go
+// 本文件实现了OFD（GB/T 33190）文件包的读取和改写。
+// OFD文件是ZIP包，入口OFD.xml指向各文档的Document.xml，页面、资源、注释和签名均为包内的XML文件。
+// 改写时只在原XML中插入新元素，不重新序列化，保持原有内容和命名空间前缀不变；包内文件顺序保持不变，新文件追加在最后。
+
+// Namespace OFD命名空间
+const Namespace = "http://www.ofdspec.org/2016"
+
+// Package 已打开的OFD文件包
+type Package struct {
+	files map[string][]byte // 包内文件内容，键为不带前导/的路径
+	order []string          // 包内文件顺序
+
+	DocRoot    string  // 第一个文档的Document.xml路径
+	Signatures string  // 签名列表文件路径，尚未签名时为空
+	Pages      []*Page // 页面，按页码顺序
+	maxUnitID  int     // 文档中已使用的最大对象标识
+}
+
+// Page 页面
+type Page struct {
+	ID      int        // 页面对象标识
+	Content string     // 页面描述文件路径
+	Box     [4]float64 // 页面物理区域（x y 宽 高，毫米）
+}
+
+// ofdXML 入口文件OFD.xml
+type ofdXML struct {
+	DocBodies []struct {
+		DocRoot    string `xml:"DocRoot"`
+		Signatures string `xml:"Signatures"`
+	} `xml:"DocBody"`
+}
+
+// pageArea 页面区域
+type pageArea struct {
+	PhysicalBox string `xml:"PhysicalBox"`
+}
+
+// documentXML 文档根节点Document.xml
+type documentXML struct {
+	CommonData struct {
+		MaxUnitID int      `xml:"MaxUnitID"`
+		PageArea  pageArea `xml:"PageArea"`
+	} `xml:"CommonData"`
+	Pages []struct {
+		ID      string `xml:"ID,attr"`
+		BaseLoc string `xml:"BaseLoc,attr"`
+	} `xml:"Pages>Page"`
+	Annotations string `xml:"Annotations"`
+}
+
+// pageXML 页面描述文件
+type pageXML struct {
+	Area *pageArea `xml:"Area"`
+}
+
+// IsOFD 判断data是否为OFD文件包（ZIP且包含OFD.xml）
+func IsOFD(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return false
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	for _, f := range zr.File {
+		if strings.EqualFold(strings.TrimPrefix(f.Name, "/"), "OFD.xml") {
+			return true
+		}
+	}
+	return false
+}
+
+// Open 解析OFD文件包，读取第一个文档的页面信息
+func Open(data []byte) (*Package, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("OFD文件包解析失败: %w", err)
+	}
+	p := &Package{files: map[string][]byte{}}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("OFD文件包解析失败: %w", err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("OFD文件包解析失败: %w", err)
+		}
+		name := strings.TrimPrefix(f.Name, "/")
+		if _, dup := p.files[name]; !dup {
+			p.order = append(p.order, name)
+		}
+		p.files[name] = b
+	}
+	var entry ofdXML
+	if err := p.decode("OFD.xml", &entry); err != nil {
+		return nil, err
+	}
+	if len(entry.DocBodies) == 0 || strings.TrimSpace(entry.DocBodies[0].DocRoot) == "" {
+		return nil, errors.New("OFD.xml缺少文档入口（DocRoot）")
+	}
+	p.DocRoot = resolve("OFD.xml", entry.DocBodies[0].DocRoot)
+	if loc := strings.TrimSpace(entry.DocBodies[0].Signatures); loc != "" {
+		p.Signatures = resolve("OFD.xml", loc)
+	}
+	var doc documentXML
+	if err := p.decode(p.DocRoot, &doc); err != nil {
+		return nil, err
+	}
+	p.maxUnitID = doc.CommonData.MaxUnitID
+	defaultBox, _ := parseBox(doc.CommonData.PageArea.PhysicalBox)
+	for i, pg := range doc.Pages {
+		id, err := strconv.Atoi(strings.TrimSpace(pg.ID))
+		if err != nil {
+			return nil, fmt.Errorf("第%d页的对象标识无效", i+1)
+		}
+		page := &Page{ID: id, Content: resolve(p.DocRoot, pg.BaseLoc), Box: defaultBox}
+		var px pageXML
+		if err := p.decode(page.Content, &px); err != nil {
+			return nil, err
+		}
+		if px.Area != nil {
+			if box, err := parseBox(px.Area.PhysicalBox); err == nil {
+				page.Box = box
+			}
+		}
+		if page.Box[2] <= 0 || page.Box[3] <= 0 {
+			return nil, fmt.Errorf("第%d页缺少页面区域（PhysicalBox）", i+1)
+		}
+		p.Pages = append(p.Pages, page)
+	}
+	if len(p.Pages) == 0 {
+		return nil, errors.New("OFD文档没有页面")
+	}
+	return p, nil
+}
+
+// Bytes 将文件包重新打包为OFD文件
+func (p *Package) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range p.order {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(p.files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// File 返回包内文件内容，name可带前导/
+func (p *Package) File(name string) ([]byte, bool) {
+	b, ok := p.files[strings.TrimPrefix(name, "/")]
+	return b, ok
+}
+
+// Files 按包内顺序返回全部文件路径
+func (p *Package) Files() []string {
+	return append([]string{}, p.order...)
+}
+
+// Page 返回页码（从1开始）对应的页面
+func (p *Package) Page(n int) (*Page, error) {
+	if n <= 0 || n > len(p.Pages) {
+		return nil, fmt.Errorf("页码%d不存在", n)
+	}
+	return p.Pages[n-1], nil
+}
+
+// put 写入包内文件，新文件追加在最后
+func (p *Package) put(name string, data []byte) {
+	name = strings.TrimPrefix(name, "/")
+	if _, ok := p.files[name]; !ok {
+		p.order = append(p.order, name)
+	}
+	p.files[name] = data
+}
+
+// decode 解析包内的XML文件
+func (p *Package) decode(name string, v interface{}) error {
+	b, ok := p.File(name)
+	if !ok {
+		return fmt.Errorf("OFD文件包中缺少%s", name)
+	}
+	if err := xml.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s解析失败: %w", name, err)
+	}
+	return nil
+}
+
+// nextID 分配新的对象标识
+func (p *Package) nextID() int {
+	p.maxUnitID++
+	return p.maxUnitID
+}
+
+// updateMaxUnitID 将分配过的最大对象标识写回Document.xml
+func (p *Package) updateMaxUnitID() error {
+	doc, _ := p.File(p.DocRoot)
+	prefix := rootPrefix(doc)
+	re := regexp.MustCompile(`<` + regexp.QuoteMeta(prefix) + `MaxUnitID>\s*\d+\s*</` + regexp.QuoteMeta(prefix) + `MaxUnitID>`)
+	if !re.Match(doc) {
+		return errors.New("Document.xml缺少MaxUnitID")
+	}
+	doc = re.ReplaceAll(doc, []byte(fmt.Sprintf("<%sMaxUnitID>%d</%sMaxUnitID>", prefix, p.maxUnitID, prefix)))
+	p.put(p.DocRoot, doc)
+	return nil
+}
+
+// dir 返回文档所在目录（如Doc_0）
+func (p *Package) dir() string {
+	return path.Dir(p.DocRoot)
+}
+
+// resolve 解析包内路径：以/开头的为包内绝对路径，否则相对于引用它的文件base所在目录
+func resolve(base, loc string) string {
+	loc = strings.TrimSpace(loc)
+	if strings.HasPrefix(loc, "/") {
+		return path.Clean(strings.TrimPrefix(loc, "/"))
+	}
+	return path.Clean(path.Join(path.Dir(base), loc))
+}
+
+// parseBox 解析"x y 宽 高"格式的区域
+func parseBox(s string) ([4]float64, error) {
+	var box [4]float64
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return box, fmt.Errorf("区域格式错误: %q", s)
+	}
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return box, fmt.Errorf("区域格式错误: %q", s)
+		}
+		box[i] = v
+	}
+	return box, nil
+}
+
+// rootElement 匹配XML根元素的开始标签，捕获命名空间前缀
+var rootElement = regexp.MustCompile(`<(?:([A-Za-z_][\w.-]*):)?[A-Za-z_][\w.-]*[\s/>]`)
+
+// rootPrefix 返回XML文件根元素使用的命名空间前缀（含冒号），没有前缀时为空
+func rootPrefix(doc []byte) string {
+	body := doc
+	// 跳过XML声明、注释等
+	for {
+		i := bytes.IndexByte(body, '<')
+		if i < 0 || i+1 >= len(body) {
+			return ""
+		}
+		if body[i+1] == '?' || body[i+1] == '!' {
+			body = body[i+1:]
+			continue
+		}
+		m := rootElement.FindSubmatch(body[i:])
+		if m == nil || len(m[1]) == 0 {
+			return ""
+		}
+		return string(m[1]) + ":"
+	}
+}
+
+// withPrefix 将以ofd:为前缀编写的XML片段换成目标文件使用的前缀
+func withPrefix(fragment, prefix string) string {
+	if prefix == "ofd:" {
+		return fragment
+	}
+	fragment = strings.ReplaceAll(fragment, "<ofd:", "<"+prefix)
+	return strings.ReplaceAll(fragment, "</ofd:", "</"+prefix)
+}
+
+// insertElement 在doc中parent元素内插入以ofd:前缀编写的片段：
+// 插入到before中最先出现的子元素之前，这些子元素都不存在时插入到parent的结束标签之前
+func insertElement(doc []byte, parent string, before []string, fragment string) ([]byte, error) {
+	prefix := rootPrefix(doc)
+	closing := []byte("</" + prefix + parent + ">")
+	end := bytes.LastIndex(doc, closing)
+	if end < 0 {
+		// 空元素<parent/>展开为开始和结束标签
+		re := regexp.MustCompile(`<` + regexp.QuoteMeta(prefix+parent) + `(\s[^>]*)?/>`)
+		loc := re.FindIndex(doc)
+		if loc == nil {
+			return nil, fmt.Errorf("缺少%s元素", parent)
+		}
+		open := bytes.TrimSuffix(doc[loc[0]:loc[1]], []byte("/>"))
+		var out []byte
+		out = append(out, doc[:loc[0]]...)
+		out = append(out, open...)
+		out = append(out, '>')
+		out = append(out, withPrefix(fragment, prefix)...)
+		out = append(out, closing...)
+		return append(out, doc[loc[1]:]...), nil
+	}
+	// 只在parent元素内查找before中的子元素
+	start := 0
+	if loc := regexp.MustCompile(`<` + regexp.QuoteMeta(prefix+parent) + `[\s>]`).FindIndex(doc[:end]); loc != nil {
+		start = loc[1]
+	}
+	pos := end
+	for _, name := range before {
+		re := regexp.MustCompile(`<` + regexp.QuoteMeta(prefix+name) + `[\s/>]`)
+		if loc := re.FindIndex(doc[start:end]); loc != nil && start+loc[0] < pos {
+			pos = start + loc[0]
+		}
+	}
+	var out []byte
+	out = append(out, doc[:pos]...)
+	out = append(out, withPrefix(fragment, prefix)...)
+	return append(out, doc[pos:]...), nil
+}
+
+// xmlHeader 新建XML文件的声明
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+// escapeAttr 转义XML属性值
+func escapeAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// fmtMM 格式化毫米数值，保留3位小数并去掉多余的0
+func fmtMM(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-0" {
+		return "0"
+	}
+	return s
+}
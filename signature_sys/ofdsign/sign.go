@@ -0,0 +1,309 @@
+package ofdsign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/pdfsign"
+	"signature_sys/ses"
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ofdsign/sign.go
+// 本文件实现了OFD数字签名（GB/T 33190第18章）。
+// 签名时在Doc_N/Signs下新建签名描述文件Signature.xml，其中的References列出包内除签名列表外全部文件的摘要；
+// 签名值SignedValue对Signature.xml的摘要签名：使用电子印章时为GB/T 38540电子签章（Type="Seal"），
+// 否则为CMS分离式签名（Type="Sign"）。SM2证书使用SM3摘要，其他证书使用SHA256。
+// 已签名文档再次签名时只新增签名文件并更新签名列表，不改动已有签名覆盖的文件。
+
+// 签名类型（Signatures.xml中Signature的Type属性）
+const (
+	TypeSeal = "Seal" // 电子签章
+	TypeSign = "Sign" // 数字签名
+)
+
+// SignatureDateTime的时间格式
+const dateTimeLayout = "20060102150405Z"
+
+// 签名提供者信息
+const (
+	providerName    = "signature_sys"
+	providerVersion = "1.0"
+)
+
+// Options OFD签名参数
+type Options struct {
+	Signer      crypto.Signer       // 签名私钥
+	Certificate *x509.Certificate   // 签名证书
+	Chain       []*x509.Certificate // 需要内嵌到CMS签名中的证书链（可选）
+	SigningTime time.Time           // 签名时间，默认当前时间
+	Stamps      []Stamp             // 签章外观（可选）
+	Seal        []byte              // GB/T 38540电子印章（SES_Seal的DER编码，可选），设置后生成电子签章
+}
+
+// Result 签名结果
+type Result struct {
+	SignatureID string // 签名标识
+	Type        string // 签名类型：Seal/Sign
+	Signature   []byte // 签名值（电子签章中的签章人签名或CMS中的signature）
+	Algorithm   string // 签名算法，如SHA256-ECC、SM3-SM2
+}
+
+// signaturesXML 签名列表文件
+type signaturesXML struct {
+	MaxSignID  string `xml:"MaxSignId"`
+	Signatures []struct {
+		ID      string `xml:"ID,attr"`
+		Type    string `xml:"Type,attr"`
+		BaseLoc string `xml:"BaseLoc,attr"`
+	} `xml:"Signature"`
+}
+
+// signatureXML 签名描述文件
+type signatureXML struct {
+	SignedInfo struct {
+		Provider struct {
+			ProviderName string `xml:"ProviderName,attr"`
+		} `xml:"Provider"`
+		SignatureMethod   string `xml:"SignatureMethod"`
+		SignatureDateTime string `xml:"SignatureDateTime"`
+		References        struct {
+			CheckMethod string `xml:"CheckMethod,attr"`
+			Items       []struct {
+				FileRef    string `xml:"FileRef,attr"`
+				CheckValue string `xml:"CheckValue"`
+			} `xml:"Reference"`
+		} `xml:"References"`
+		StampAnnots []struct {
+			PageRef  string `xml:"PageRef,attr"`
+			Boundary string `xml:"Boundary,attr"`
+		} `xml:"StampAnnot"`
+		Seal struct {
+			BaseLoc string `xml:"BaseLoc"`
+		} `xml:"Seal"`
+	} `xml:"SignedInfo"`
+	SignedValue string `xml:"SignedValue"`
+}
+
+// SignFile 对inPath指向的OFD签名，结果写入outPath
+func SignFile(inPath, outPath string, opts Options) (*Result, error) {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+	out, res, err := Sign(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outPath, out, 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Sign 对OFD字节签名，返回签名后的完整OFD
+func Sign(src []byte, opts Options) ([]byte, *Result, error) {
+	if opts.Signer == nil || opts.Certificate == nil {
+		return nil, nil, errors.New("缺少签名私钥或证书")
+	}
+	if opts.SigningTime.IsZero() {
+		opts.SigningTime = time.Now()
+	}
+	p, err := Open(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigType := TypeSign
+	if opts.Seal != nil {
+		sigType = TypeSeal
+	}
+	// 签章外观：电子签章由阅读器按StampAnnot绘制电子印章中的图片；数字签名需在签名前以页面注释加盖签章图片
+	var stampAnnots []string
+	for i, st := range opts.Stamps {
+		if st.Page <= 0 {
+			st.Page = 1
+		}
+		page, err := p.Page(st.Page)
+		if err != nil {
+			return nil, nil, err
+		}
+		var boundary [4]float64
+		if sigType == TypeSeal {
+			_, w, h, err := stampSize(st)
+			if err != nil {
+				return nil, nil, err
+			}
+			boundary, _ = stampGeometry(st.X, st.Y, w, h, st.Rotation)
+		} else {
+			// 页面注释会改动已有签名覆盖的文件
+			if p.Signatures != "" {
+				return nil, nil, errors.New("OFD文档已有签名，继续签署须使用电子印章，否则会破坏已有签名")
+			}
+			if boundary, err = p.AddStamp(st); err != nil {
+				return nil, nil, err
+			}
+		}
+		stampAnnots = append(stampAnnots, fmt.Sprintf(`<ofd:StampAnnot ID="%d" PageRef="%d" Boundary="%s"/>`, i+1, page.ID, boxString(boundary)))
+	}
+	listPath, signID, err := p.prepareSignatures()
+	if err != nil {
+		return nil, nil, err
+	}
+	sigDir := path.Join(path.Dir(listPath), "Sign_"+strconv.Itoa(signID-1))
+	for i := 1; ; i++ {
+		if _, exists := p.File(path.Join(sigDir, "Signature.xml")); !exists {
+			break
+		}
+		sigDir = path.Join(path.Dir(listPath), fmt.Sprintf("Sign_%d_%d", signID-1, i))
+	}
+	sigPath := path.Join(sigDir, "Signature.xml")
+	valuePath := path.Join(sigDir, "SignedValue.dat")
+	// 签名描述文件：列出签名时包内全部文件（签名列表除外）的摘要
+	newHash, checkMethod := digestFor(opts.Certificate.PublicKey)
+	var sb strings.Builder
+	sb.WriteString(xmlHeader)
+	sb.WriteString(`<ofd:Signature xmlns:ofd="` + Namespace + `"><ofd:SignedInfo>`)
+	fmt.Fprintf(&sb, `<ofd:Provider ProviderName="%s" Version="%s"/>`, providerName, providerVersion)
+	fmt.Fprintf(&sb, `<ofd:SignatureMethod>%s</ofd:SignatureMethod>`, signatureMethod(opts.Certificate.PublicKey))
+	fmt.Fprintf(&sb, `<ofd:SignatureDateTime>%s</ofd:SignatureDateTime>`, opts.SigningTime.UTC().Format(dateTimeLayout))
+	fmt.Fprintf(&sb, `<ofd:References CheckMethod="%s">`, checkMethod)
+	for _, name := range p.order {
+		if name == listPath {
+			continue
+		}
+		h := newHash()
+		h.Write(p.files[name])
+		fmt.Fprintf(&sb, `<ofd:Reference FileRef="/%s"><ofd:CheckValue>%s</ofd:CheckValue></ofd:Reference>`,
+			escapeAttr(name), base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+	sb.WriteString(`</ofd:References>`)
+	for _, a := range stampAnnots {
+		sb.WriteString(a)
+	}
+	fmt.Fprintf(&sb, `</ofd:SignedInfo><ofd:SignedValue>/%s</ofd:SignedValue></ofd:Signature>`, valuePath)
+	sigXML := []byte(sb.String())
+	h := newHash()
+	h.Write(sigXML)
+	digest := h.Sum(nil)
+	// 签名值：电子签章的原文属性为签名描述文件的路径
+	var value, signature []byte
+	if sigType == TypeSeal {
+		if value, err = ses.Sign(opts.Seal, digest, "/"+sigPath, opts.Signer, opts.Certificate, opts.SigningTime); err != nil {
+			return nil, nil, fmt.Errorf("生成电子签章失败: %w", err)
+		}
+		sesSig, err := ses.ParseSignature(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		signature = sesSig.Signature.RightAlign()
+	} else {
+		sd, err := cms.Sign(digest, opts.Signer, opts.Certificate, opts.Chain, cms.SignOptions{Hash: crypto.SHA256, SigningTime: opts.SigningTime})
+		if err != nil {
+			return nil, nil, err
+		}
+		if value, err = sd.Marshal(); err != nil {
+			return nil, nil, err
+		}
+		signature = sd.Signers[0].Signature
+	}
+	p.put(sigPath, sigXML)
+	p.put(valuePath, value)
+	// 登记到签名列表
+	list, _ := p.File(listPath)
+	list, err = insertElement(list, "Signatures", nil, fmt.Sprintf(`<ofd:Signature ID="%d" Type="%s" BaseLoc="/%s"/>`, signID, sigType, sigPath))
+	if err != nil {
+		return nil, nil, fmt.Errorf("签名列表更新失败: %w", err)
+	}
+	p.put(listPath, setMaxSignID(list, signID))
+	out, err := p.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, &Result{
+		SignatureID: strconv.Itoa(signID),
+		Type:        sigType,
+		Signature:   signature,
+		Algorithm:   pdfsign.AlgorithmName(opts.Certificate),
+	}, nil
+}
+
+// prepareSignatures 返回签名列表文件路径和新签名的标识；文档首次签名时创建签名列表并登记到OFD.xml
+func (p *Package) prepareSignatures() (string, int, error) {
+	if p.Signatures != "" {
+		var list signaturesXML
+		if err := p.decode(p.Signatures, &list); err != nil {
+			return "", 0, err
+		}
+		maxID, _ := strconv.Atoi(strings.TrimSpace(list.MaxSignID))
+		for _, s := range list.Signatures {
+			if id, err := strconv.Atoi(strings.TrimSpace(s.ID)); err == nil && id > maxID {
+				maxID = id
+			}
+		}
+		return p.Signatures, maxID + 1, nil
+	}
+	listPath := path.Join(p.dir(), "Signs", "Signatures.xml")
+	p.put(listPath, []byte(xmlHeader+`<ofd:Signatures xmlns:ofd="`+Namespace+`"><ofd:MaxSignId>0</ofd:MaxSignId></ofd:Signatures>`))
+	// Signatures位于DocBody的最后，须在计算摘要前写入OFD.xml
+	entry, _ := p.File("OFD.xml")
+	entry, err := insertElement(entry, "DocBody", nil, "<ofd:Signatures>/"+listPath+"</ofd:Signatures>")
+	if err != nil {
+		return "", 0, fmt.Errorf("OFD.xml更新失败: %w", err)
+	}
+	p.put("OFD.xml", entry)
+	p.Signatures = listPath
+	return listPath, 1, nil
+}
+
+// setMaxSignID 更新签名列表中的MaxSignId，缺少该元素时补充
+func setMaxSignID(list []byte, id int) []byte {
+	prefix := rootPrefix(list)
+	open, closing := "<"+prefix+"MaxSignId>", "</"+prefix+"MaxSignId>"
+	s := string(list)
+	if i := strings.Index(s, open); i >= 0 {
+		if j := strings.Index(s[i:], closing); j >= 0 {
+			return []byte(s[:i] + open + strconv.Itoa(id) + s[i+j:])
+		}
+	}
+	if out, err := insertElement(list, "Signatures", []string{"Signature"}, fmt.Sprintf("<ofd:MaxSignId>%d</ofd:MaxSignId>", id)); err == nil {
+		return out
+	}
+	return list
+}
+
+// digestFor 返回签名证书对应的摘要算法及其OID：SM2证书为SM3，其他为SHA256
+func digestFor(pub crypto.PublicKey) (func() hash.Hash, string) {
+	if utils.IsSM2PublicKey(pub) {
+		return sm3.New, cms.OIDDigestSM3.String()
+	}
+	return sha256.New, cms.OIDDigestSHA256.String()
+}
+
+// signatureMethod 返回签名算法OID
+func signatureMethod(pub crypto.PublicKey) string {
+	if utils.IsSM2PublicKey(pub) {
+		return cms.OIDSignatureSM2WithSM3.String()
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return cms.OIDSignatureECDSASHA256.String()
+	case *rsa.PublicKey:
+		return cms.OIDSignatureSHA256WithRSA.String()
+	}
+	return ""
+}
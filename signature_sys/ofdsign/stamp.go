@@ -0,0 +1,216 @@
+package ofdsign
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"  // 注册GIF解码器
+	_ "image/jpeg" // 注册JPEG解码器
+	_ "image/png"  // 注册PNG解码器
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ofdsign/stamp.go
+// 本文件实现了在OFD页面上加盖签章图片：图片作为多媒体资源登记在单独的资源文件中，
+// 以印章类型（Stamp）的页面注释引用，原有页面内容不改动。
+
+// 签章图片资源文件（相对于Document.xml）及其资源目录
+const (
+	sealResFile = "SealRes.xml"
+	sealResDir  = "SealRes"
+)
+
+// Stamp 一次盖章的图片和位置，坐标采用OFD页面坐标系（原点在页面左上角，单位毫米）
+type Stamp struct {
+	Page          int     // 页码，从1开始
+	Image         []byte  // 签章图片文件内容（PNG/JPEG/GIF）
+	X, Y          float64 // 图片左上角相对页面左上角的偏移
+	Width, Height float64 // 图片显示尺寸，Height为0时按图片宽高比计算
+	Rotation      float64 // 绕图片中心顺时针旋转的角度
+}
+
+// StampFile 在inPath指向的OFD上盖章（不签名），结果写入outPath
+func StampFile(inPath, outPath string, stamps []Stamp) error {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	p, err := Open(src)
+	if err != nil {
+		return err
+	}
+	for _, st := range stamps {
+		if _, err := p.AddStamp(st); err != nil {
+			return err
+		}
+	}
+	out, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, out, 0644)
+}
+
+// AddStamp 在页面上添加签章注释，返回签章外接矩形（x y 宽 高）
+func (p *Package) AddStamp(st Stamp) ([4]float64, error) {
+	if st.Page <= 0 {
+		st.Page = 1
+	}
+	page, err := p.Page(st.Page)
+	if err != nil {
+		return [4]float64{}, err
+	}
+	format, w, h, err := stampSize(st)
+	if err != nil {
+		return [4]float64{}, err
+	}
+	boundary, ctm := stampGeometry(st.X, st.Y, w, h, st.Rotation)
+	resID, err := p.addImage(st.Image, format)
+	if err != nil {
+		return boundary, err
+	}
+	annot := fmt.Sprintf(`<ofd:Annot ID="%d" Type="Stamp" Creator="signature_sys" LastModDate="%s" ReadOnly="true"><ofd:Appearance Boundary="%s"><ofd:ImageObject ID="%d" ResourceID="%d" Boundary="0 0 %s %s" CTM="%s"/></ofd:Appearance></ofd:Annot>`,
+		p.nextID(), time.Now().Format("2006-01-02"), boxString(boundary), p.nextID(), resID, fmtMM(boundary[2]), fmtMM(boundary[3]), ctm)
+	if err := p.addPageAnnot(page, annot); err != nil {
+		return boundary, err
+	}
+	return boundary, p.updateMaxUnitID()
+}
+
+// stampSize 解析签章图片，返回图片格式和显示尺寸
+func stampSize(st Stamp) (string, float64, float64, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(st.Image))
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("签章图片解析失败: %w", err)
+	}
+	if st.Width <= 0 || cfg.Width == 0 || cfg.Height == 0 {
+		return "", 0, 0, fmt.Errorf("签章尺寸必须大于0")
+	}
+	h := st.Height
+	if h <= 0 {
+		h = st.Width * float64(cfg.Height) / float64(cfg.Width)
+	}
+	return format, st.Width, h, nil
+}
+
+// stampGeometry 计算旋转后签章的外接矩形，以及图片在外接矩形内的变换矩阵
+// OFD中图片为单位正方形，经CTM映射到外接矩形坐标系；绕图片中心顺时针旋转（y轴向下）
+func stampGeometry(x, y, w, h, rotation float64) ([4]float64, string) {
+	rad := rotation * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	bw := math.Abs(w*cos) + math.Abs(h*sin)
+	bh := math.Abs(w*sin) + math.Abs(h*cos)
+	cx, cy := x+w/2, y+h/2
+	boundary := [4]float64{cx - bw/2, cy - bh/2, bw, bh}
+	a, b, c, d := w*cos, w*sin, -h*sin, h*cos
+	e := -w/2*cos + h/2*sin + bw/2
+	f := -w/2*sin - h/2*cos + bh/2
+	ctm := strings.Join([]string{fmtMM(a), fmtMM(b), fmtMM(c), fmtMM(d), fmtMM(e), fmtMM(f)}, " ")
+	return boundary, ctm
+}
+
+// boxString 将区域格式化为"x y 宽 高"
+func boxString(box [4]float64) string {
+	return fmt.Sprintf("%s %s %s %s", fmtMM(box[0]), fmtMM(box[1]), fmtMM(box[2]), fmtMM(box[3]))
+}
+
+// addImage 将图片登记为文档资源，返回资源标识
+// 签章图片统一放在单独的资源文件中，首次盖章时创建并登记到Document.xml的CommonData
+func (p *Package) addImage(data []byte, format string) (int, error) {
+	resPath := path.Join(p.dir(), sealResFile)
+	res, ok := p.File(resPath)
+	if !ok {
+		res = []byte(xmlHeader + `<ofd:Res xmlns:ofd="` + Namespace + `" BaseLoc="` + sealResDir + `"><ofd:MultiMedias></ofd:MultiMedias></ofd:Res>`)
+		doc, _ := p.File(p.DocRoot)
+		doc, err := insertElement(doc, "CommonData", []string{"TemplatePage", "DefaultCS"}, "<ofd:DocumentRes>"+sealResFile+"</ofd:DocumentRes>")
+		if err != nil {
+			return 0, fmt.Errorf("Document.xml更新失败: %w", err)
+		}
+		p.put(p.DocRoot, doc)
+	}
+	id := p.nextID()
+	ext := map[string]string{"jpeg": "jpg"}[format]
+	if ext == "" {
+		ext = format
+	}
+	name := fmt.Sprintf("Image_%d.%s", id, ext)
+	p.put(path.Join(p.dir(), sealResDir, name), data)
+	media := fmt.Sprintf(`<ofd:MultiMedia ID="%d" Type="Image" Format="%s"><ofd:MediaFile>%s</ofd:MediaFile></ofd:MultiMedia>`, id, strings.ToUpper(ext), name)
+	res, err := insertElement(res, "MultiMedias", nil, media)
+	if err != nil {
+		return 0, fmt.Errorf("签章资源文件更新失败: %w", err)
+	}
+	p.put(resPath, res)
+	return id, nil
+}
+
+// annotationsXML 注释索引文件
+type annotationsXML struct {
+	Pages []struct {
+		PageID  string `xml:"PageID,attr"`
+		FileLoc string `xml:"FileLoc"`
+	} `xml:"Page"`
+}
+
+// addPageAnnot 将注释追加到页面的注释文件，页面或文档还没有注释时创建对应文件
+func (p *Package) addPageAnnot(page *Page, annot string) error {
+	var doc documentXML
+	if err := p.decode(p.DocRoot, &doc); err != nil {
+		return err
+	}
+	indexPath := ""
+	if loc := strings.TrimSpace(doc.Annotations); loc != "" {
+		indexPath = resolve(p.DocRoot, loc)
+	}
+	if indexPath == "" {
+		// 文档还没有注释，新建注释索引并登记到Document.xml（Annotations位于Pages之后）
+		indexPath = path.Join(p.dir(), "Annots", "Annotations.xml")
+		p.put(indexPath, []byte(xmlHeader+`<ofd:Annotations xmlns:ofd="`+Namespace+`"></ofd:Annotations>`))
+		root, _ := p.File(p.DocRoot)
+		root, err := insertElement(root, "Document", []string{"Attachments", "CustomTags", "Extensions"}, "<ofd:Annotations>/"+indexPath+"</ofd:Annotations>")
+		if err != nil {
+			return fmt.Errorf("Document.xml更新失败: %w", err)
+		}
+		p.put(p.DocRoot, root)
+	}
+	var index annotationsXML
+	if err := p.decode(indexPath, &index); err != nil {
+		return err
+	}
+	for _, pg := range index.Pages {
+		if strings.TrimSpace(pg.PageID) != fmt.Sprint(page.ID) || strings.TrimSpace(pg.FileLoc) == "" {
+			continue
+		}
+		annotPath := resolve(indexPath, pg.FileLoc)
+		b, ok := p.File(annotPath)
+		if !ok {
+			return fmt.Errorf("OFD文件包中缺少%s", annotPath)
+		}
+		b, err := insertElement(b, "PageAnnot", nil, annot)
+		if err != nil {
+			return fmt.Errorf("%s更新失败: %w", annotPath, err)
+		}
+		p.put(annotPath, b)
+		return nil
+	}
+	// 页面还没有注释，新建页面注释文件并登记到注释索引
+	annotPath := path.Join(path.Dir(indexPath), fmt.Sprintf("Page_%d", page.ID), "Annotation.xml")
+	for i := 1; ; i++ {
+		if _, exists := p.File(annotPath); !exists {
+			break
+		}
+		annotPath = path.Join(path.Dir(indexPath), fmt.Sprintf("Page_%d_%d", page.ID, i), "Annotation.xml")
+	}
+	p.put(annotPath, []byte(xmlHeader+`<ofd:PageAnnot xmlns:ofd="`+Namespace+`">`+annot+`</ofd:PageAnnot>`))
+	b, _ := p.File(indexPath)
+	b, err := insertElement(b, "Annotations", nil, fmt.Sprintf(`<ofd:Page PageID="%d"><ofd:FileLoc>/%s</ofd:FileLoc></ofd:Page>`, page.ID, annotPath))
+	if err != nil {
+		return fmt.Errorf("%s更新失败: %w", indexPath, err)
+	}
+	p.put(indexPath, b)
+	return nil
+}
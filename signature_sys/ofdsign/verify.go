@@ -0,0 +1,273 @@
+package ofdsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/pdfsign"
+	"signature_sys/ses"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ofdsign/verify.go
+// 本文件实现了OFD数字签名的验证，验证结果使用与PDF签名相同的结构（pdfsign.Report）。
+// 对签名列表中的每个签名：校验References中各文件的摘要、签名值对签名描述文件的签名，
+// 电子签章同时校验其引用的电子印章；并判断签名后是否新增了签名以外的文件。
+
+// 签名描述文件中可能出现的时间格式
+var dateTimeLayouts = []string{dateTimeLayout, "2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "20060102150405"}
+
+// ofdSignature 签名列表中的一个签名
+type ofdSignature struct {
+	sigType   string
+	sigPath   string // 签名描述文件路径
+	valuePath string // 签名值文件路径
+	desc      *signatureXML
+	err       error
+}
+
+// VerifyFile 验证path指向的OFD中的全部签名
+func VerifyFile(path string) (*pdfsign.Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(data)
+}
+
+// Verify 验证OFD字节中的全部签名，每个签名视为一个修订
+func Verify(data []byte) (*pdfsign.Report, error) {
+	p, err := Open(data)
+	if err != nil {
+		return nil, err
+	}
+	report := &pdfsign.Report{FileSize: int64(len(data)), Revisions: 1}
+	if p.Signatures == "" {
+		return report, nil
+	}
+	var list signaturesXML
+	if err := p.decode(p.Signatures, &list); err != nil {
+		return nil, err
+	}
+	// 先收集全部签名文件，用于判断签名后新增的文件是否只是其他签名
+	sigFiles := map[string]bool{p.Signatures: true}
+	var sigs []*ofdSignature
+	for _, entry := range list.Signatures {
+		s := &ofdSignature{sigType: strings.TrimSpace(entry.Type), sigPath: resolve(p.Signatures, entry.BaseLoc)}
+		if s.sigType == "" {
+			s.sigType = TypeSeal
+		}
+		sigFiles[s.sigPath] = true
+		var desc signatureXML
+		if s.err = p.decode(s.sigPath, &desc); s.err == nil {
+			s.desc = &desc
+			if v := strings.TrimSpace(desc.SignedValue); v != "" {
+				s.valuePath = resolve(s.sigPath, v)
+				sigFiles[s.valuePath] = true
+			}
+			if loc := strings.TrimSpace(desc.SignedInfo.Seal.BaseLoc); loc != "" {
+				sigFiles[resolve(s.sigPath, loc)] = true
+			}
+		}
+		sigs = append(sigs, s)
+	}
+	for _, s := range sigs {
+		info := p.verifySignature(s, sigFiles)
+		pdfsign.CheckRevocation(info, nil, nil)
+		report.Signatures = append(report.Signatures, info)
+	}
+	report.Revisions += len(sigs)
+	return report, nil
+}
+
+// verifySignature 验证单个签名
+func (p *Package) verifySignature(s *ofdSignature, sigFiles map[string]bool) *pdfsign.SignatureInfo {
+	info := &pdfsign.SignatureInfo{FieldName: path.Base(path.Dir(s.sigPath)), SubFilter: "OFD." + s.sigType}
+	fail := func(format string, args ...interface{}) *pdfsign.SignatureInfo {
+		info.Errors = append(info.Errors, fmt.Sprintf(format, args...))
+		return info
+	}
+	if s.err != nil {
+		return fail("%v", s.err)
+	}
+	desc := s.desc
+	info.Name = desc.SignedInfo.Provider.ProviderName
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(desc.SignedInfo.SignatureDateTime)); err == nil {
+			info.SigningTime = t
+			break
+		}
+	}
+	// 校验References中各文件的摘要
+	refsValid, covered := p.checkReferences(info, desc)
+	sigXML, _ := p.File(s.sigPath)
+	value, ok := p.File(s.valuePath)
+	if s.valuePath == "" || !ok {
+		return fail("签名值文件缺失")
+	}
+	switch s.sigType {
+	case TypeSeal:
+		sesSig, err := ses.ParseSignature(value)
+		if err != nil {
+			return fail("%v", err)
+		}
+		cert, err := sesSig.SignerCertificate()
+		if err != nil {
+			return fail("签章人证书解析失败: %v", err)
+		}
+		info.Signer = cert
+		info.Certificates = []*x509.Certificate{cert}
+		info.Signature = sesSig.Signature.RightAlign()
+		info.SignatureAlgorithm = pdfsign.AlgorithmName(cert)
+		if t := sesSig.ToSign.TimeInfo; !t.IsZero() {
+			info.SigningTime = t
+		}
+		newHash, _ := digestFor(cert.PublicKey)
+		h := newHash()
+		h.Write(sigXML)
+		digest := h.Sum(nil)
+		info.DigestAlgorithm = hashName(cert.PublicKey)
+		info.DigestValid = refsValid && bytes.Equal(sesSig.ToSign.DataHash.RightAlign(), digest)
+		// 电子签章即签名值，其签章人签名、原文摘要和电子印章的校验结果决定签名是否有效
+		info.Seal = pdfsign.VerifySeal(value, digest, cert)
+		info.SignatureValid = info.Seal.Valid
+		info.Errors = append(info.Errors, info.Seal.Errors...)
+	case TypeSign:
+		sd, err := cms.Parse(value)
+		if err != nil {
+			return fail("%v", err)
+		}
+		signer := sd.Signers[0]
+		info.Certificates = sd.Certificates
+		info.Signature = signer.Signature
+		info.DigestAlgorithm = cms.HashName(signer.DigestAlgorithm)
+		info.SignatureAlgorithm = signer.AlgorithmName()
+		cert, err := sd.SignerCertificate(signer)
+		if err != nil {
+			return fail("%v", err)
+		}
+		info.Signer = cert
+		if t := signer.SigningTime(); !t.IsZero() {
+			info.SigningTime = t
+		}
+		h, err := signer.NewHash()
+		if err != nil {
+			return fail("%v", err)
+		}
+		h.Write(sigXML)
+		digest := h.Sum(nil)
+		if err := signer.VerifyDigest(digest); err != nil {
+			return fail("%v，签名描述文件已被篡改", err)
+		}
+		info.DigestValid = refsValid
+		if err := signer.VerifySignature(cert, digest); err != nil {
+			return fail("%v", err)
+		}
+		info.SignatureValid = true
+	default:
+		return fail("不支持的签名类型: %s", s.sigType)
+	}
+	if info.Signer != nil && info.Signer.Subject.CommonName != "" {
+		info.Name = info.Signer.Subject.CommonName
+	}
+	checkLaterFiles(info, s, p.order, covered, sigFiles, p.Signatures)
+	if !info.SigningTime.IsZero() && (info.SigningTime.Before(info.Signer.NotBefore) || info.SigningTime.After(info.Signer.NotAfter)) {
+		info.Warnings = append(info.Warnings, "声明的签名时间不在签名证书有效期内")
+	}
+	return info
+}
+
+// checkReferences 按References校验各文件的摘要，返回是否全部一致及签名覆盖的文件
+func (p *Package) checkReferences(info *pdfsign.SignatureInfo, desc *signatureXML) (bool, map[string]bool) {
+	covered := map[string]bool{}
+	refs := desc.SignedInfo.References
+	newHash, ok := checkMethodHash(refs.CheckMethod)
+	if !ok {
+		info.Errors = append(info.Errors, fmt.Sprintf("不支持的摘要算法: %s", refs.CheckMethod))
+		return false, covered
+	}
+	if len(refs.Items) == 0 {
+		info.Errors = append(info.Errors, "签名没有引用任何文件")
+		return false, covered
+	}
+	valid := true
+	for _, ref := range refs.Items {
+		name := resolve("", ref.FileRef)
+		covered[name] = true
+		b, ok := p.File(name)
+		if !ok {
+			valid = false
+			info.Errors = append(info.Errors, fmt.Sprintf("签名覆盖的文件%s已被删除", name))
+			continue
+		}
+		want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ref.CheckValue))
+		h := newHash()
+		h.Write(b)
+		if err != nil || !bytes.Equal(h.Sum(nil), want) {
+			valid = false
+			info.Errors = append(info.Errors, fmt.Sprintf("文件%s在签名后被修改", name))
+		}
+	}
+	return valid, covered
+}
+
+// checkLaterFiles 判断签名后新增的文件：只有其他签名时给出提示，存在其他文件时视为签名后被修改
+func checkLaterFiles(info *pdfsign.SignatureInfo, s *ofdSignature, files []string, covered, sigFiles map[string]bool, listPath string) {
+	var later, modified []string
+	for _, name := range files {
+		if covered[name] || name == listPath || name == s.sigPath || name == s.valuePath {
+			continue
+		}
+		if sigFiles[name] {
+			later = append(later, name)
+		} else {
+			modified = append(modified, name)
+		}
+	}
+	info.CoversWholeFile = len(later) == 0 && len(modified) == 0
+	if len(modified) > 0 {
+		sort.Strings(modified)
+		info.ModifiedAfterSigning = true
+		info.Errors = append(info.Errors, "签名后文档新增了文件: "+strings.Join(modified, "、"))
+		return
+	}
+	if len(later) > 0 {
+		info.LaterSignaturesOnly = true
+		info.Warnings = append(info.Warnings, "签名后文档追加了其他签名")
+	}
+}
+
+// checkMethodHash 返回References的摘要算法，支持OID和算法名称两种写法
+func checkMethodHash(method string) (func() hash.Hash, bool) {
+	switch strings.ToUpper(strings.TrimSpace(method)) {
+	case cms.OIDDigestSM3.String(), "SM3":
+		return sm3.New, true
+	case cms.OIDDigestSHA256.String(), "SHA256", "SHA-256":
+		return sha256.New, true
+	case cms.OIDDigestSHA1.String(), "SHA1", "SHA-1":
+		return sha1.New, true
+	}
+	return nil, false
+}
+
+// hashName 返回证书对应摘要算法的名称
+func hashName(pub crypto.PublicKey) string {
+	_, oid := digestFor(pub)
+	if oid == cms.OIDDigestSM3.String() {
+		return "SM3"
+	}
+	return crypto.SHA256.String()
+}
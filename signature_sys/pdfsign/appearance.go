@@ -0,0 +1,169 @@
+package pdfsign
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/appearance.go
+// 本文件实现了可见签名的外观：签章图片和其下方的说明文字（签名人、日期、原因、地点等）
+// 绘制在一个表单XObject中，作为签名域控件的正常外观（/AP /N）。
+// 签章即签名域本身，在阅读器中点击签章可查看签名详情。
+
+// 说明文字默认字号和行距（相对字号）
+const (
+	defaultAppearanceFontSize = 8
+	appearanceLineSpacing     = 1.25
+)
+
+// Appearance 可见签名的外观
+type Appearance struct {
+	Stamp    Stamp    // 签章图片及其在页面上的位置和旋转角度，页码即签名域所在页
+	Lines    []string // 图片下方居中显示的说明文字，每项一行（可选）
+	Font     *Font    // 说明文字使用的字体，Lines非空时必须设置，须包含文字中的全部字符
+	FontSize float64  // 说明文字字号，默认8
+}
+
+// StampAppearanceFile 在inPath指向的PDF上绘制签章和可见签名外观（不签名），结果写入outPath，用于签章预览
+func StampAppearanceFile(inPath, outPath string, stamps []Stamp, app Appearance) error {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	u, err := newIncrementalUpdate(src)
+	if err != nil {
+		return err
+	}
+	for _, st := range stamps {
+		if err := u.addStamp(st); err != nil {
+			return err
+		}
+	}
+	rect, formRef, err := u.addAppearance(app)
+	if err != nil {
+		return err
+	}
+	// 外观表单的坐标原点为签名域左下角，按单位尺寸绘制即为平移到签名域位置
+	err = u.drawImage(app.Stamp.Page, formRef, 0, func(box *types.Rectangle) (float64, float64, float64, float64) {
+		return rect[0], rect[1], 1, 1
+	})
+	if err != nil {
+		return err
+	}
+	out, _, err := u.write()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, out, 0644)
+}
+
+// addAppearance 生成可见签名外观的表单XObject，返回签名域在页面默认坐标系中的区域和表单引用
+func (u *incrementalUpdate) addAppearance(app Appearance) ([4]float64, types.IndirectRef, error) {
+	var rect [4]float64
+	st := app.Stamp
+	if st.Page <= 0 {
+		st.Page = 1
+	}
+	if st.Scale <= 0 {
+		return rect, types.IndirectRef{}, errors.New("签章缩放比例必须大于0")
+	}
+	if len(app.Lines) > 0 && app.Font == nil {
+		return rect, types.IndirectRef{}, errors.New("可见签名说明文字缺少字体")
+	}
+	size := app.FontSize
+	if size <= 0 {
+		size = defaultAppearanceFontSize
+	}
+	// 说明文字先转换为字形，字体中缺字时不生成外观
+	lines := make([][]uint16, len(app.Lines))
+	textW := 0.0
+	for i, s := range app.Lines {
+		gids, err := app.Font.glyphs(s)
+		if err != nil {
+			return rect, types.IndirectRef{}, err
+		}
+		lines[i] = gids
+		textW = math.Max(textW, app.Font.textWidth(gids, size))
+	}
+	pageDict, _, err := u.page(st.Page)
+	if err != nil {
+		return rect, types.IndirectRef{}, err
+	}
+	box, _, err := u.pageBoxAndResources(st.Page, pageDict)
+	if err != nil {
+		return rect, types.IndirectRef{}, err
+	}
+	imgRef, imgW, imgH, err := u.addImage(st.Image)
+	if err != nil {
+		return rect, types.IndirectRef{}, err
+	}
+	// 图片尺寸与普通签章相同，旋转后取外接矩形
+	w, h := stampSize(box, imgW, imgH, st.Scale)
+	rad := -st.Rotation * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	bw := math.Abs(w*cos) + math.Abs(h*sin)
+	bh := math.Abs(w*sin) + math.Abs(h*cos)
+	cx, cy := box.LL.X+st.X+w/2, box.LL.Y+st.Y+h/2
+	// 签名域：图片外接矩形和下方文字区域的并集
+	textH := float64(len(lines)) * size * appearanceLineSpacing
+	halfW := math.Max(bw, textW) / 2
+	rect = [4]float64{cx - halfW, cy - bh/2 - textH, cx + halfW, cy + bh/2}
+	fw, fh := rect[2]-rect[0], rect[3]-rect[1]
+
+	var sb strings.Builder
+	sb.WriteString("q\n")
+	fmt.Fprintf(&sb, "1 0 0 1 %s %s cm\n", fmtNum(cx-rect[0]), fmtNum(cy-rect[1]))
+	fmt.Fprintf(&sb, "%s %s %s %s 0 0 cm\n", fmtNum(cos), fmtNum(sin), fmtNum(-sin), fmtNum(cos))
+	fmt.Fprintf(&sb, "%s 0 0 %s %s %s cm\n", fmtNum(w), fmtNum(h), fmtNum(-w/2), fmtNum(-h/2))
+	sb.WriteString("/Img Do\nQ\n")
+	resources := types.Dict{"XObject": types.Dict{"Img": imgRef}}
+	if len(lines) > 0 {
+		used := map[uint16]rune{}
+		sb.WriteString("BT\n0 g\n")
+		fmt.Fprintf(&sb, "/F1 %s Tf\n", fmtNum(size))
+		for i, gids := range lines {
+			runes := []rune(app.Lines[i])
+			for j, g := range gids {
+				used[g] = runes[j]
+			}
+			// 每行水平居中，基线位于行框底部上方约0.25个字号处
+			x := (fw - app.Font.textWidth(gids, size)) / 2
+			y := textH - float64(i+1)*size*appearanceLineSpacing + size*0.25
+			fmt.Fprintf(&sb, "1 0 0 1 %s %s Tm %s Tj\n", fmtNum(x), fmtNum(y), hexGlyphs(gids))
+		}
+		sb.WriteString("ET\n")
+		resources["Font"] = types.Dict{"F1": u.addFont(app.Font, used)}
+	}
+	form := types.Dict{
+		"Type":      types.Name("XObject"),
+		"Subtype":   types.Name("Form"),
+		"BBox":      types.Array{types.Integer(0), types.Integer(0), number(roundPt(fw)), number(roundPt(fh))},
+		"Resources": resources,
+		"Filter":    types.Name("FlateDecode"),
+	}
+	formRef := u.addRaw(streamObject(form, deflate([]byte(sb.String()))))
+	for i := range rect {
+		rect[i] = roundPt(rect[i])
+	}
+	return rect, formRef, nil
+}
+
+// stampSize 计算签章图片在页面上的尺寸：按图片长边方向相对页面可视区域缩放
+func stampSize(box *types.Rectangle, imgW, imgH, scale float64) (float64, float64) {
+	if imgW >= imgH {
+		w := scale * box.Width()
+		return w, w * imgH / imgW
+	}
+	h := scale * box.Height()
+	return h * imgW / imgH, h
+}
+
+// roundPt 坐标保留4位小数，与内容流中的数字精度一致
+func roundPt(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
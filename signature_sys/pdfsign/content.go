@@ -0,0 +1,284 @@
+package pdfsign
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"strconv"
+)
+
+// pdfsign/content.go
+// 本文件实现了PDF内容流（以及ToUnicode等CMap文件）的词法分析，供页面文字提取使用。
+// 只识别操作数和操作符，内联图片的数据直接跳过。
+
+// pdfName 名称对象，不含前导斜杠
+type pdfName string
+
+// pdfKeyword 操作符或PostScript关键字
+type pdfKeyword string
+
+// contentLexer 内容流词法分析器
+type contentLexer struct {
+	data []byte
+	pos  int
+}
+
+// isWhitespace 判断是否为PDF空白字符
+func isWhitespace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isDelimiter 判断是否为PDF分隔符
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// skipSpace 跳过空白和注释
+func (l *contentLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isWhitespace(c) {
+			l.pos++
+			continue
+		}
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		return
+	}
+}
+
+// next 返回下一个对象：float64、pdfName、[]byte（字符串）、[]interface{}（数组）、
+// map[string]interface{}（字典）或pdfKeyword，结束时返回io.EOF
+func (l *contentLexer) next() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		return pdfName(l.regular()), nil
+	case c == '(':
+		l.pos++
+		return l.literalString(), nil
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		return l.dict()
+	case c == '<':
+		l.pos++
+		return l.hexString(), nil
+	case c == '[':
+		l.pos++
+		return l.array()
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		// 不成对的结束符和PostScript过程的花括号作为关键字返回，由调用方忽略
+		l.pos++
+		if c == '>' && l.pos < len(l.data) && l.data[l.pos] == '>' {
+			l.pos++
+			return pdfKeyword(">>"), nil
+		}
+		return pdfKeyword([]byte{c}), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		s := l.regular()
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			// 个别生成器会写出"--5"之类的数字，按0处理
+			return 0.0, nil
+		}
+		return v, nil
+	}
+	kw := l.regular()
+	if kw == "" {
+		// 无法识别的字符，跳过
+		l.pos++
+		return l.next()
+	}
+	if kw == "BI" {
+		l.skipInlineImage()
+	}
+	return pdfKeyword(kw), nil
+}
+
+// regular 读取连续的常规字符
+func (l *contentLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	s := string(l.data[start:l.pos])
+	if bytes.IndexByte(l.data[start:l.pos], '#') >= 0 {
+		// 名称中的#xx转义
+		var b []byte
+		for i := 0; i < len(s); i++ {
+			if s[i] == '#' && i+2 < len(s) {
+				if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					b = append(b, byte(v))
+					i += 2
+					continue
+				}
+			}
+			b = append(b, s[i])
+		}
+		s = string(b)
+	}
+	return s
+}
+
+// literalString 读取字面字符串（左括号已读取），处理转义和嵌套括号
+func (l *contentLexer) literalString() []byte {
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return b
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// 行尾续行
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// hexString 读取十六进制字符串（左尖括号已读取）
+func (l *contentLexer) hexString() []byte {
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		if isWhitespace(c) {
+			continue
+		}
+		digits = append(digits, c)
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	if _, err := hex.Decode(b, digits); err != nil {
+		return nil
+	}
+	return b
+}
+
+// array 读取数组（左方括号已读取）
+func (l *contentLexer) array() ([]interface{}, error) {
+	var a []interface{}
+	for {
+		v, err := l.next()
+		if err != nil {
+			return a, err
+		}
+		if v == pdfKeyword("]") {
+			return a, nil
+		}
+		a = append(a, v)
+	}
+}
+
+// dict 读取字典（<<已读取），键为名称
+func (l *contentLexer) dict() (map[string]interface{}, error) {
+	d := map[string]interface{}{}
+	var key pdfName
+	hasKey := false
+	for {
+		v, err := l.next()
+		if err != nil {
+			return d, err
+		}
+		if v == pdfKeyword(">>") {
+			return d, nil
+		}
+		if !hasKey {
+			key, hasKey = v.(pdfName)
+			continue
+		}
+		d[string(key)] = v
+		hasKey = false
+	}
+}
+
+// skipInlineImage 跳过内联图片的参数和数据，停在EI之后
+func (l *contentLexer) skipInlineImage() {
+	for {
+		v, err := l.next()
+		if err != nil {
+			return
+		}
+		if v == pdfKeyword("ID") {
+			break
+		}
+	}
+	// ID后有一个空白字符，之后是图片数据，数据以空白加EI结束
+	l.pos++
+	for l.pos+2 <= len(l.data) {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		end := l.pos + i
+		l.pos = end + 2
+		if end > 0 && isWhitespace(l.data[end-1]) && (l.pos == len(l.data) || isWhitespace(l.data[l.pos])) {
+			return
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"signature_sys/utils"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/crypto/ocsp"
+)
+
+// pdfsign/dss.go
+// 本文件实现了文档安全存储（DSS，Document Security Store）的写入和读取。
+// DSS保存验证签名所需的证书链、CRL和OCSP响应，使签名在证书过期或CA下线后仍可验证（PAdES-B-LT）。
+// VRI按签名索引，键为签名Contents的SHA1摘要（十六进制大写）。
+
+// ValidationData 签名的验证数据
+type ValidationData struct {
+	Certificates []*x509.Certificate // 证书链
+	CRLs         [][]byte            // DER编码的CRL
+	OCSPs        [][]byte            // DER编码的OCSP响应
+}
+
+// RevocationSource 证书吊销信息来源
+type RevocationSource interface {
+	// Revocation 获取cert的吊销信息，issuer为其颁发者证书，返回DER编码的CRL或OCSP响应（可为空）
+	Revocation(cert, issuer *x509.Certificate) (crl, ocspResp []byte, err error)
+}
+
+// OnlineRevocation 按证书中的AIA（OCSP）和CRL分发点扩展在线获取吊销信息
+type OnlineRevocation struct {
+	HTTPClient *http.Client // 为空时使用30秒超时的默认客户端
+}
+
+// Revocation 优先请求OCSP，失败时下载CRL
+func (o *OnlineRevocation) Revocation(cert, issuer *x509.Certificate) ([]byte, []byte, error) {
+	client := o.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	var errs []string
+	for _, server := range cert.OCSPServer {
+		resp, err := fetchOCSP(client, server, cert, issuer)
+		if err == nil {
+			return nil, resp, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	for _, dp := range cert.CRLDistributionPoints {
+		crl, err := fetchCRL(client, dp, issuer)
+		if err == nil {
+			return crl, nil, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.New(strings.Join(errs, "；"))
+	}
+	return nil, nil, nil
+}
+
+// fetchOCSP 向OCSP服务请求证书状态，返回校验通过的响应
+func fetchOCSP(client *http.Client, server string, cert, issuer *x509.Certificate) ([]byte, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP请求失败: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP服务返回HTTP %d", httpResp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ocsp.ParseResponseForCert(der, cert, issuer); err != nil {
+		return nil, fmt.Errorf("OCSP响应无效: %w", err)
+	}
+	return der, nil
+}
+
+// fetchCRL 下载CRL并校验颁发者签名
+func fetchCRL(client *http.Client, url string, issuer *x509.Certificate) ([]byte, error) {
+	httpResp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("CRL下载失败: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL服务返回HTTP %d", httpResp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(httpResp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := utils.ParseRevocationList(der, issuer); err != nil {
+		return nil, fmt.Errorf("CRL无效: %w", err)
+	}
+	return der, nil
+}
+
+// collectValidationData 为证书链中的每张证书收集吊销信息
+// pool为可用于查找颁发者的证书，自签名的根证书无需吊销信息
+func collectValidationData(certs, pool []*x509.Certificate, src RevocationSource) (*ValidationData, []string) {
+	vd := &ValidationData{}
+	var warnings []string
+	seen := map[string]bool{}
+	var add func(cert *x509.Certificate)
+	add = func(cert *x509.Certificate) {
+		key := string(cert.Raw)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		vd.Certificates = append(vd.Certificates, cert)
+		if utils.CheckSignatureFrom(cert, cert) == nil {
+			return
+		}
+		issuer := findIssuer(cert, pool)
+		if issuer == nil {
+			return
+		}
+		add(issuer)
+		if src == nil {
+			return
+		}
+		crl, resp, err := src.Revocation(cert, issuer)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("证书%s的吊销信息获取失败: %v", cert.Subject.CommonName, err))
+		}
+		if crl != nil {
+			vd.CRLs = append(vd.CRLs, crl)
+		}
+		if resp != nil {
+			vd.OCSPs = append(vd.OCSPs, resp)
+		}
+	}
+	for _, cert := range certs {
+		add(cert)
+	}
+	return vd, warnings
+}
+
+// findIssuer 在pool中查找签发cert的证书
+func findIssuer(cert *x509.Certificate, pool []*x509.Certificate) *x509.Certificate {
+	for _, c := range pool {
+		if !bytes.Equal(c.RawSubject, cert.RawIssuer) || c.Equal(cert) {
+			continue
+		}
+		if utils.CheckSignatureFrom(cert, c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// vriKey 返回签名在VRI字典中的键：签名Contents的SHA1摘要（十六进制大写）
+func vriKey(contents []byte) string {
+	sum := sha1.Sum(contents)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// dssWriter 向增量更新中写入DSS，相同内容的证书、CRL、OCSP响应只写入一次
+type dssWriter struct {
+	u      *incrementalUpdate
+	dss    types.Dict
+	dssRef *types.IndirectRef
+	refs   map[string]types.IndirectRef // 内容SHA256 -> 流对象引用
+	vri    types.Dict
+}
+
+// newDSSWriter 读取目录中已有的DSS，新的验证数据追加在已有内容之后
+func newDSSWriter(u *incrementalUpdate) (*dssWriter, error) {
+	root, err := u.catalog()
+	if err != nil {
+		return nil, err
+	}
+	w := &dssWriter{u: u, dss: types.Dict{"Type": types.Name("DSS")}, refs: map[string]types.IndirectRef{}, vri: types.Dict{}}
+	if o, found := root.Find("DSS"); found && o != nil {
+		if ref, ok := o.(types.IndirectRef); ok {
+			w.dssRef = &ref
+		}
+		if w.dss, err = u.resolveDict(o); err != nil {
+			return nil, err
+		}
+		if o, found := w.dss.Find("VRI"); found && o != nil {
+			if w.vri, err = u.resolveDict(o); err != nil {
+				return nil, err
+			}
+		}
+		// 登记已有流的内容摘要，避免重复写入
+		for _, key := range []string{"Certs", "CRLs", "OCSPs"} {
+			arr, err := u.resolveArray(w.dss[key])
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range arr {
+				ref, ok := item.(types.IndirectRef)
+				if !ok {
+					continue
+				}
+				if data, err := streamContent(u.ctx, ref); err == nil {
+					w.refs[contentKey(data)] = ref
+				}
+			}
+		}
+	}
+	return w, nil
+}
+
+// addStreams 将数据以流对象写入DSS的key数组，返回各数据对应的引用
+func (w *dssWriter) addStreams(key string, items [][]byte) (types.Array, error) {
+	var refs types.Array
+	for _, data := range items {
+		k := contentKey(data)
+		ref, ok := w.refs[k]
+		if !ok {
+			ref = w.u.addRaw(streamObject(types.Dict{"Filter": types.Name("FlateDecode")}, deflate(data)))
+			w.refs[k] = ref
+			if _, err := w.u.appendToArrayEntry(w.dss, key, ref); err != nil {
+				return nil, err
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// add 写入一组验证数据，key非空时同时登记为该签名的VRI条目
+func (w *dssWriter) add(vd *ValidationData, key string) error {
+	var certs [][]byte
+	for _, c := range vd.Certificates {
+		certs = append(certs, c.Raw)
+	}
+	certRefs, err := w.addStreams("Certs", certs)
+	if err != nil {
+		return err
+	}
+	crlRefs, err := w.addStreams("CRLs", vd.CRLs)
+	if err != nil {
+		return err
+	}
+	ocspRefs, err := w.addStreams("OCSPs", vd.OCSPs)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+	entry := types.Dict{"TU": types.StringLiteral(pdfDate(time.Now()))}
+	if len(certRefs) > 0 {
+		entry["Cert"] = certRefs
+	}
+	if len(crlRefs) > 0 {
+		entry["CRL"] = crlRefs
+	}
+	if len(ocspRefs) > 0 {
+		entry["OCSP"] = ocspRefs
+	}
+	w.vri[key] = entry
+	return nil
+}
+
+// finish 将DSS写回文档目录
+func (w *dssWriter) finish() error {
+	if len(w.vri) > 0 {
+		w.dss["VRI"] = w.vri
+	}
+	if w.dssRef != nil {
+		w.u.set(*w.dssRef, w.dss)
+		return nil
+	}
+	root, err := w.u.catalog()
+	if err != nil {
+		return err
+	}
+	root["DSS"] = w.u.add(w.dss)
+	w.u.set(*w.u.ctx.XRefTable.Root, root)
+	return nil
+}
+
+// contentKey 返回数据的SHA256摘要，用于去重
+func contentKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return string(sum[:])
+}
+
+// streamContent 读取流对象解码后的内容
+func streamContent(ctx *model.Context, o types.Object) ([]byte, error) {
+	sd, _, err := ctx.XRefTable.DereferenceStreamDict(o)
+	if err != nil {
+		return nil, err
+	}
+	if sd == nil {
+		return nil, errors.New("流对象不存在")
+	}
+	if err := sd.Decode(); err != nil {
+		return nil, err
+	}
+	return sd.Content, nil
+}
+
+// DSSInfo 文档中DSS的内容概况
+type DSSInfo struct {
+	Certificates []*x509.Certificate // DSS中的证书
+	CRLs         [][]byte            // DER编码的CRL
+	OCSPs        [][]byte            // DER编码的OCSP响应
+	VRI          map[string]bool     // 已登记验证数据的签名（VRI键）
+}
+
+// readDSS 读取文档目录中的DSS，没有DSS时返回nil
+func readDSS(ctx *model.Context) (*DSSInfo, error) {
+	root, err := ctx.XRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	o, found := root.Find("DSS")
+	if !found || o == nil {
+		return nil, nil
+	}
+	dss, err := ctx.XRefTable.DereferenceDict(o)
+	if err != nil || dss == nil {
+		return nil, err
+	}
+	info := &DSSInfo{VRI: map[string]bool{}}
+	certs, err := ctx.XRefTable.DereferenceArray(dss["Certs"])
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range certs {
+		data, err := streamContent(ctx, item)
+		if err != nil {
+			continue
+		}
+		if cert, err := utils.ParseCertificate(data); err == nil {
+			info.Certificates = append(info.Certificates, cert)
+		}
+	}
+	if arr, err := ctx.XRefTable.DereferenceArray(dss["CRLs"]); err == nil {
+		for _, item := range arr {
+			if data, err := streamContent(ctx, item); err == nil {
+				info.CRLs = append(info.CRLs, data)
+			}
+		}
+	}
+	if arr, err := ctx.XRefTable.DereferenceArray(dss["OCSPs"]); err == nil {
+		for _, item := range arr {
+			if data, err := streamContent(ctx, item); err == nil {
+				info.OCSPs = append(info.OCSPs, data)
+			}
+		}
+	}
+	if vri, err := ctx.XRefTable.DereferenceDict(dss["VRI"]); err == nil {
+		for k := range vri {
+			info.VRI[strings.ToUpper(k)] = true
+		}
+	}
+	return info, nil
+}
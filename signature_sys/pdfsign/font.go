@@ -0,0 +1,427 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/font/sfnt"
+)
+
+// pdfsign/font.go
+// 本文件实现了可见签名说明文字使用的TrueType字体嵌入。
+// 字体以Type0（Identity-H）+ CIDFontType2方式嵌入，CID即字形编号；只保留用到的字形（子集化），
+// 未用到的字形清空但保留编号，因此无需重排cmap，中文字体嵌入后也只有几十KB。
+
+// 子集字体保留的表，其余表（cmap、name、post、位图等）PDF不需要
+var subsetTables = []string{"OS/2", "cvt ", "fpgm", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "prep"}
+
+// Font 可嵌入PDF的TrueType字体（TTF，或TTC中的第一个字体）
+type Font struct {
+	sfnt       *sfnt.Font
+	tables     map[string][]byte
+	name       string // PostScript名称
+	unitsPerEm int
+	numGlyphs  int
+	numHMetric int
+	longLoca   bool
+}
+
+// LoadFont 读取TrueType字体文件（.ttf或.ttc）
+func LoadFont(path string) (*Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFont(data)
+}
+
+// ParseFont 解析TrueType字体，TTC字体集取第一个字体；CFF轮廓（OpenType .otf）字体不支持
+func ParseFont(data []byte) (*Font, error) {
+	var sf *sfnt.Font
+	dirOffset := 0
+	if len(data) >= 16 && string(data[:4]) == "ttcf" {
+		c, err := sfnt.ParseCollection(data)
+		if err != nil {
+			return nil, fmt.Errorf("字体解析失败: %w", err)
+		}
+		if sf, err = c.Font(0); err != nil {
+			return nil, fmt.Errorf("字体解析失败: %w", err)
+		}
+		dirOffset = int(binary.BigEndian.Uint32(data[12:]))
+	} else {
+		var err error
+		if sf, err = sfnt.Parse(data); err != nil {
+			return nil, fmt.Errorf("字体解析失败: %w", err)
+		}
+	}
+	// 表目录：sfnt版本、表数量等12字节，之后每个表16字节（标签、校验和、偏移、长度），偏移相对文件开头
+	if dirOffset+12 > len(data) {
+		return nil, errors.New("字体表目录不完整")
+	}
+	n := int(binary.BigEndian.Uint16(data[dirOffset+4:]))
+	if dirOffset+12+16*n > len(data) {
+		return nil, errors.New("字体表目录不完整")
+	}
+	f := &Font{sfnt: sf, tables: map[string][]byte{}}
+	for i := 0; i < n; i++ {
+		rec := data[dirOffset+12+16*i:]
+		tag := string(rec[:4])
+		off := int(binary.BigEndian.Uint32(rec[8:]))
+		length := int(binary.BigEndian.Uint32(rec[12:]))
+		if off < 0 || length < 0 || off+length > len(data) {
+			return nil, fmt.Errorf("字体表%s越界", tag)
+		}
+		f.tables[tag] = data[off : off+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "loca", "glyf"} {
+		if f.tables[tag] == nil {
+			if tag == "glyf" || tag == "loca" {
+				return nil, errors.New("仅支持TrueType轮廓字体，不支持CFF轮廓的OpenType字体")
+			}
+			return nil, fmt.Errorf("字体缺少%s表", tag)
+		}
+	}
+	head, hhea, maxp := f.tables["head"], f.tables["hhea"], f.tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errors.New("字体头部表不完整")
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	f.longLoca = binary.BigEndian.Uint16(head[50:]) == 1
+	f.numGlyphs = int(binary.BigEndian.Uint16(maxp[4:]))
+	f.numHMetric = int(binary.BigEndian.Uint16(hhea[34:]))
+	if f.unitsPerEm == 0 || f.numHMetric == 0 || len(f.tables["hmtx"]) < 4*f.numHMetric {
+		return nil, errors.New("字体度量表无效")
+	}
+	var buf sfnt.Buffer
+	f.name, _ = sf.Name(&buf, sfnt.NameIDPostScript)
+	if f.name == "" {
+		f.name = "Font"
+	}
+	// PostScript名称中不应出现空格等分隔字符
+	f.name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune("()<>[]{}/%#", r) {
+			return -1
+		}
+		return r
+	}, f.name)
+	return f, nil
+}
+
+// glyphs 返回文字对应的字形编号，字体中没有的字符返回错误
+func (f *Font) glyphs(s string) ([]uint16, error) {
+	var buf sfnt.Buffer
+	var gids []uint16
+	for _, r := range s {
+		gid, err := f.sfnt.GlyphIndex(&buf, r)
+		if err != nil {
+			return nil, err
+		}
+		if gid == 0 {
+			return nil, fmt.Errorf("字体%s中没有字符“%c”", f.name, r)
+		}
+		gids = append(gids, uint16(gid))
+	}
+	return gids, nil
+}
+
+// advance 字形的前进宽度（字体单位）
+func (f *Font) advance(gid uint16) int {
+	i := int(gid)
+	if i >= f.numHMetric {
+		i = f.numHMetric - 1
+	}
+	return int(binary.BigEndian.Uint16(f.tables["hmtx"][4*i:]))
+}
+
+// scaled 将字体单位换算为PDF字形空间（1000单位每em）
+func (f *Font) scaled(v int) float64 {
+	return float64(v) * 1000 / float64(f.unitsPerEm)
+}
+
+// textWidth 文字按size字号排版时的宽度
+func (f *Font) textWidth(gids []uint16, size float64) float64 {
+	w := 0
+	for _, g := range gids {
+		w += f.advance(g)
+	}
+	return float64(w) * size / float64(f.unitsPerEm)
+}
+
+// glyph 返回字形的glyf数据
+func (f *Font) glyph(gid uint16) []byte {
+	loca, glyf := f.tables["loca"], f.tables["glyf"]
+	i := int(gid)
+	var start, end int
+	if f.longLoca {
+		if 4*i+8 > len(loca) {
+			return nil
+		}
+		start = int(binary.BigEndian.Uint32(loca[4*i:]))
+		end = int(binary.BigEndian.Uint32(loca[4*i+4:]))
+	} else {
+		if 2*i+4 > len(loca) {
+			return nil
+		}
+		start = 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+		end = 2 * int(binary.BigEndian.Uint16(loca[2*i+2:]))
+	}
+	if start >= end || end > len(glyf) {
+		return nil
+	}
+	return glyf[start:end]
+}
+
+// components 返回复合字形引用的字形编号
+func components(g []byte) []uint16 {
+	// numberOfContours为负表示复合字形，组件记录从第10字节开始
+	if len(g) < 10 || int16(binary.BigEndian.Uint16(g)) >= 0 {
+		return nil
+	}
+	var gids []uint16
+	for p := 10; p+4 <= len(g); {
+		flags := binary.BigEndian.Uint16(g[p:])
+		gids = append(gids, binary.BigEndian.Uint16(g[p+2:]))
+		p += 4
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+			p += 2
+		case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+			p += 4
+		case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+			p += 8
+		}
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			break
+		}
+	}
+	return gids
+}
+
+// subset 生成只含used字形（及其复合字形组件）的字体文件，字形编号不变
+func (f *Font) subset(used map[uint16]bool) []byte {
+	keep := map[uint16]bool{}
+	var walk func(gid uint16)
+	walk = func(gid uint16) {
+		if keep[gid] || int(gid) >= f.numGlyphs {
+			return
+		}
+		keep[gid] = true
+		for _, c := range components(f.glyph(gid)) {
+			walk(c)
+		}
+	}
+	// 0号字形（.notdef）必须保留
+	walk(0)
+	for gid := range used {
+		walk(gid)
+	}
+	// glyf和长格式loca：未保留的字形长度为0
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(f.numGlyphs+1))
+	for i := 0; i < f.numGlyphs; i++ {
+		binary.BigEndian.PutUint32(loca[4*i:], uint32(glyf.Len()))
+		if keep[uint16(i)] {
+			glyf.Write(f.glyph(uint16(i)))
+			for glyf.Len()%4 != 0 {
+				glyf.WriteByte(0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(loca[4*f.numGlyphs:], uint32(glyf.Len()))
+	// hmtx：未保留字形的度量清零，结构不变
+	hmtx := make([]byte, len(f.tables["hmtx"]))
+	src := f.tables["hmtx"]
+	for i := 0; i < f.numGlyphs; i++ {
+		if !keep[uint16(i)] {
+			continue
+		}
+		if i < f.numHMetric {
+			copy(hmtx[4*i:4*i+4], src[4*i:])
+		} else if p := 4*f.numHMetric + 2*(i-f.numHMetric); p+2 <= len(src) {
+			copy(hmtx[p:p+2], src[p:])
+		}
+	}
+	// head：改为长格式loca，整体校验和不再有意义，置零
+	head := append([]byte(nil), f.tables["head"]...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	tables := map[string][]byte{"glyf": glyf.Bytes(), "loca": loca, "hmtx": hmtx, "head": head}
+	for _, tag := range subsetTables {
+		if tables[tag] == nil && f.tables[tag] != nil {
+			tables[tag] = f.tables[tag]
+		}
+	}
+	return buildSFNT(tables)
+}
+
+// buildSFNT 按表标签顺序写出TrueType字体文件
+func buildSFNT(tables map[string][]byte) []byte {
+	var tags []string
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	n := len(tags)
+	entrySelector := 0
+	for 1<<(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << entrySelector
+	header := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(header, 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(n))
+	binary.BigEndian.PutUint16(header[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(header[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(header[10:], uint16(16*n-searchRange))
+	var body bytes.Buffer
+	for i, tag := range tags {
+		data := tables[tag]
+		rec := header[12+16*i:]
+		copy(rec, tag)
+		binary.BigEndian.PutUint32(rec[4:], tableChecksum(data))
+		binary.BigEndian.PutUint32(rec[8:], uint32(len(header)+body.Len()))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(data)))
+		body.Write(data)
+		for body.Len()%4 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	return append(header, body.Bytes()...)
+}
+
+// tableChecksum 按uint32累加计算表校验和，末尾不足4字节补零
+func tableChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var b [4]byte
+		copy(b[:], data[i:])
+		sum += binary.BigEndian.Uint32(b[:])
+	}
+	return sum
+}
+
+// hexGlyphs 将字形编号编码为Identity-H的十六进制字符串（每个字形2字节）
+func hexGlyphs(gids []uint16) string {
+	var sb strings.Builder
+	sb.WriteByte('<')
+	for _, g := range gids {
+		fmt.Fprintf(&sb, "%04X", g)
+	}
+	sb.WriteByte('>')
+	return sb.String()
+}
+
+// addFont 嵌入字体子集，used为用到的字形编号及其对应的字符；
+// 依次写入字体程序、字体描述符、CIDFont、ToUnicode和Type0字体字典，返回Type0字体的引用
+func (u *incrementalUpdate) addFont(f *Font, used map[uint16]rune) types.IndirectRef {
+	var gids []int
+	keep := map[uint16]bool{}
+	for g := range used {
+		gids = append(gids, int(g))
+		keep[g] = true
+	}
+	sort.Ints(gids)
+	// 子集字体名前缀：由用到的字形计算的6个大写字母
+	h := sha256.New()
+	for _, g := range gids {
+		h.Write([]byte{byte(g >> 8), byte(g)})
+	}
+	sum := h.Sum(nil)
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = 'A' + sum[i]%26
+	}
+	baseName := types.Name(string(tag) + "+" + f.name)
+
+	program := f.subset(keep)
+	fontFile := u.addRaw(streamObject(types.Dict{
+		"Length1": types.Integer(len(program)),
+		"Filter":  types.Name("FlateDecode"),
+	}, deflate(program)))
+	head, hhea := f.tables["head"], f.tables["hhea"]
+	s16 := func(b []byte, off int) float64 {
+		return f.scaled(int(int16(binary.BigEndian.Uint16(b[off:]))))
+	}
+	ascent, descent := s16(hhea, 4), s16(hhea, 6)
+	capHeight := ascent
+	if os2 := f.tables["OS/2"]; len(os2) >= 90 && binary.BigEndian.Uint16(os2) >= 2 {
+		capHeight = s16(os2, 88)
+	}
+	descriptor := u.add(types.Dict{
+		"Type":        types.Name("FontDescriptor"),
+		"FontName":    baseName,
+		"Flags":       types.Integer(4), // Symbolic：字符不在标准拉丁字符集内
+		"FontBBox":    types.Array{number(s16(head, 36)), number(s16(head, 38)), number(s16(head, 40)), number(s16(head, 42))},
+		"ItalicAngle": types.Integer(0),
+		"Ascent":      number(ascent),
+		"Descent":     number(descent),
+		"CapHeight":   number(capHeight),
+		"StemV":       types.Integer(80),
+		"FontFile2":   fontFile,
+	})
+	// 字形宽度：每个用到的字形单独列出
+	widths := types.Array{}
+	for _, g := range gids {
+		widths = append(widths, types.Integer(g), types.Array{number(float64(int(f.scaled(f.advance(uint16(g)))*100+0.5)) / 100)})
+	}
+	cidFont := u.add(types.Dict{
+		"Type":     types.Name("Font"),
+		"Subtype":  types.Name("CIDFontType2"),
+		"BaseFont": baseName,
+		"CIDSystemInfo": types.Dict{
+			"Registry":   types.StringLiteral("Adobe"),
+			"Ordering":   types.StringLiteral("Identity"),
+			"Supplement": types.Integer(0),
+		},
+		"FontDescriptor": descriptor,
+		"DW":             types.Integer(1000),
+		"W":              widths,
+		"CIDToGIDMap":    types.Name("Identity"),
+	})
+	// ToUnicode：字形编号到Unicode的映射，使说明文字可以复制和搜索
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cmap.WriteString("/CIDSystemInfo <</Registry (Adobe) /Ordering (UCS) /Supplement 0>> def\n")
+	cmap.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	cmap.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for i := 0; i < len(gids); i += 100 {
+		chunk := gids[i:min(i+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+		for _, g := range chunk {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", g, utf16Hex(used[uint16(g)]))
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	toUnicode := u.addRaw(streamObject(types.Dict{}, []byte(cmap.String())))
+	return u.add(types.Dict{
+		"Type":            types.Name("Font"),
+		"Subtype":         types.Name("Type0"),
+		"BaseFont":        baseName,
+		"Encoding":        types.Name("Identity-H"),
+		"DescendantFonts": types.Array{cidFont},
+		"ToUnicode":       toUnicode,
+	})
+}
+
+// utf16Hex 字符的UTF-16BE十六进制表示
+func utf16Hex(r rune) string {
+	var sb strings.Builder
+	for _, c := range utf16.Encode([]rune{r}) {
+		fmt.Fprintf(&sb, "%04X", c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,297 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/incremental.go
+// 本文件实现了PDF增量更新（incremental update）的写入。
+// 原文件字节保持不变，新增或修改的对象连同新的交叉引用表追加到文件末尾，
+// 这样已有签名覆盖的字节范围不会被破坏。
+
+// pendingObject 待写入的对象
+type pendingObject struct {
+	gen int          // 生成号
+	obj types.Object // 字典/数组等普通对象
+	raw []byte       // 已序列化的对象内容（流对象使用）
+}
+
+// incrementalUpdate 一次增量更新
+type incrementalUpdate struct {
+	src    []byte                 // 原PDF字节
+	ctx    *model.Context         // pdfcpu解析出的文档结构
+	objs   map[int]*pendingObject // 本次新增/修改的对象，按对象号索引
+	nextNr int                    // 下一个可用对象号
+}
+
+// newIncrementalUpdate 解析原PDF，准备增量更新
+func newIncrementalUpdate(src []byte) (*incrementalUpdate, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(src), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, fmt.Errorf("PDF解析失败: %w", err)
+	}
+	if ctx.XRefTable.Encrypt != nil {
+		return nil, errors.New("暂不支持对加密PDF签名")
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return nil, fmt.Errorf("PDF页面树解析失败: %w", err)
+	}
+	next := ctx.XRefTable.MaxObjNr + 1
+	if ctx.XRefTable.Size != nil && *ctx.XRefTable.Size > next {
+		next = *ctx.XRefTable.Size
+	}
+	return &incrementalUpdate{src: src, ctx: ctx, objs: map[int]*pendingObject{}, nextNr: next}, nil
+}
+
+// add 新增对象，返回其间接引用
+func (u *incrementalUpdate) add(obj types.Object) types.IndirectRef {
+	nr := u.nextNr
+	u.nextNr++
+	u.objs[nr] = &pendingObject{obj: obj}
+	return *types.NewIndirectRef(nr, 0)
+}
+
+// addRaw 新增已序列化的对象（如流对象），返回其间接引用
+func (u *incrementalUpdate) addRaw(raw []byte) types.IndirectRef {
+	nr := u.nextNr
+	u.nextNr++
+	u.objs[nr] = &pendingObject{raw: raw}
+	return *types.NewIndirectRef(nr, 0)
+}
+
+// set 修改已有对象
+func (u *incrementalUpdate) set(ref types.IndirectRef, obj types.Object) {
+	u.objs[ref.ObjectNumber.Value()] = &pendingObject{gen: ref.GenerationNumber.Value(), obj: obj}
+}
+
+// resolve 解引用对象，优先返回本次更新中已修改的版本
+func (u *incrementalUpdate) resolve(o types.Object) (types.Object, error) {
+	ref, ok := o.(types.IndirectRef)
+	if !ok {
+		return o, nil
+	}
+	if p, ok := u.objs[ref.ObjectNumber.Value()]; ok && p.obj != nil {
+		return p.obj, nil
+	}
+	obj, err := u.ctx.XRefTable.Dereference(ref)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	return obj.Clone(), nil
+}
+
+// resolveDict 解引用字典对象
+func (u *incrementalUpdate) resolveDict(o types.Object) (types.Dict, error) {
+	obj, err := u.resolve(o)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	switch d := obj.(type) {
+	case types.Dict:
+		return d, nil
+	case types.StreamDict:
+		return d.Dict, nil
+	}
+	return nil, fmt.Errorf("对象类型错误，期望字典: %T", obj)
+}
+
+// resolveArray 解引用数组对象
+func (u *incrementalUpdate) resolveArray(o types.Object) (types.Array, error) {
+	obj, err := u.resolve(o)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, nil
+	}
+	a, ok := obj.(types.Array)
+	if !ok {
+		return nil, fmt.Errorf("对象类型错误，期望数组: %T", obj)
+	}
+	return a, nil
+}
+
+// catalog 返回文档目录字典（可能已在本次更新中修改）
+func (u *incrementalUpdate) catalog() (types.Dict, error) {
+	return u.resolveDict(*u.ctx.XRefTable.Root)
+}
+
+// page 返回指定页码的页面字典及其间接引用
+func (u *incrementalUpdate) page(pageNr int) (types.Dict, types.IndirectRef, error) {
+	_, ref, _, err := u.ctx.XRefTable.PageDict(pageNr, false)
+	if err != nil {
+		return nil, types.IndirectRef{}, fmt.Errorf("页码%d不存在", pageNr)
+	}
+	d, err := u.resolveDict(*ref)
+	if err != nil {
+		return nil, types.IndirectRef{}, err
+	}
+	return d, *ref, nil
+}
+
+// appendToArrayEntry 向字典d的数组项key中追加元素
+// 数组为间接对象时修改该数组对象，返回值表示d本身是否被修改
+func (u *incrementalUpdate) appendToArrayEntry(d types.Dict, key string, item types.Object) (bool, error) {
+	entry, found := d.Find(key)
+	if !found || entry == nil {
+		d[key] = types.Array{item}
+		return true, nil
+	}
+	if ref, ok := entry.(types.IndirectRef); ok {
+		arr, err := u.resolveArray(ref)
+		if err != nil {
+			return false, err
+		}
+		u.set(ref, append(arr, item))
+		return false, nil
+	}
+	arr, ok := entry.(types.Array)
+	if !ok {
+		return false, fmt.Errorf("%s不是数组", key)
+	}
+	d[key] = append(arr, item)
+	return true, nil
+}
+
+// lastXRefOffset 查找原文件最后一个startxref指向的偏移
+func lastXRefOffset(src []byte) (int64, error) {
+	i := bytes.LastIndex(src, []byte("startxref"))
+	if i < 0 {
+		return 0, errors.New("PDF缺少startxref")
+	}
+	fields := bytes.Fields(src[i+len("startxref"):])
+	if len(fields) == 0 {
+		return 0, errors.New("PDF的startxref格式错误")
+	}
+	return strconv.ParseInt(string(fields[0]), 10, 64)
+}
+
+// fileID 生成新的文件标识，第一个元素保持原值，第二个元素更新
+func (u *incrementalUpdate) fileID() types.Array {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := u.ctx.XRefTable.ID
+	if len(id) == 2 {
+		return types.Array{id[0], types.NewHexLiteral(b)}
+	}
+	return types.Array{types.NewHexLiteral(b), types.NewHexLiteral(b)}
+}
+
+// write 生成增量更新后的完整文件，并返回每个新对象在输出中的偏移
+func (u *incrementalUpdate) write() ([]byte, map[int]int64, error) {
+	prev, err := lastXRefOffset(u.src)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 原文件最后一个交叉引用节是流形式时，本次也使用交叉引用流
+	useStream := prev >= 0 && prev < int64(len(u.src)) && !bytes.HasPrefix(u.src[prev:], []byte("xref"))
+	var buf bytes.Buffer
+	buf.Write(u.src)
+	if len(u.src) > 0 && u.src[len(u.src)-1] != '\n' && u.src[len(u.src)-1] != '\r' {
+		buf.WriteByte('\n')
+	}
+	nrs := make([]int, 0, len(u.objs))
+	for nr := range u.objs {
+		nrs = append(nrs, nr)
+	}
+	sort.Ints(nrs)
+	offsets := map[int]int64{}
+	for _, nr := range nrs {
+		p := u.objs[nr]
+		offsets[nr] = int64(buf.Len())
+		fmt.Fprintf(&buf, "%d %d obj\n", nr, p.gen)
+		if p.raw != nil {
+			buf.Write(p.raw)
+		} else {
+			buf.WriteString(p.obj.PDFString())
+		}
+		buf.WriteString("\nendobj\n")
+	}
+	trailer := types.Dict{
+		"Root": *u.ctx.XRefTable.Root,
+		"ID":   u.fileID(),
+		"Prev": types.Integer(prev),
+	}
+	if u.ctx.XRefTable.Info != nil {
+		trailer["Info"] = *u.ctx.XRefTable.Info
+	}
+	xrefOffset := int64(buf.Len())
+	if useStream {
+		// 交叉引用流对象本身也要登记在流中
+		xrefNr := u.nextNr
+		u.nextNr++
+		nrs = append(nrs, xrefNr)
+		offsets[xrefNr] = xrefOffset
+		var data bytes.Buffer
+		for _, nr := range nrs {
+			gen := 0
+			if p, ok := u.objs[nr]; ok {
+				gen = p.gen
+			}
+			data.WriteByte(1)
+			binary.Write(&data, binary.BigEndian, uint32(offsets[nr]))
+			binary.Write(&data, binary.BigEndian, uint16(gen))
+		}
+		trailer["Type"] = types.Name("XRef")
+		trailer["Size"] = types.Integer(u.nextNr)
+		trailer["W"] = types.Array{types.Integer(1), types.Integer(4), types.Integer(2)}
+		trailer["Index"] = xrefIndex(nrs)
+		trailer["Length"] = types.Integer(data.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nstream\n", xrefNr, trailer.PDFString())
+		buf.Write(data.Bytes())
+		buf.WriteString("\nendstream\nendobj\n")
+	} else {
+		buf.WriteString("xref\n")
+		for _, sec := range xrefSections(nrs) {
+			fmt.Fprintf(&buf, "%d %d\n", sec[0], len(sec))
+			for _, nr := range sec {
+				fmt.Fprintf(&buf, "%010d %05d n\r\n", offsets[nr], u.objs[nr].gen)
+			}
+		}
+		trailer["Size"] = types.Integer(u.nextNr)
+		fmt.Fprintf(&buf, "trailer\n%s\n", trailer.PDFString())
+	}
+	fmt.Fprintf(&buf, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+	return buf.Bytes(), offsets, nil
+}
+
+// xrefSections 将对象号按连续区间分组
+func xrefSections(nrs []int) [][]int {
+	var secs [][]int
+	for _, nr := range nrs {
+		if n := len(secs); n > 0 {
+			last := secs[n-1]
+			if last[len(last)-1]+1 == nr {
+				secs[n-1] = append(last, nr)
+				continue
+			}
+		}
+		secs = append(secs, []int{nr})
+	}
+	return secs
+}
+
+// xrefIndex 生成交叉引用流的Index数组
+func xrefIndex(nrs []int) types.Array {
+	var idx types.Array
+	for _, sec := range xrefSections(nrs) {
+		idx = append(idx, types.Integer(sec[0]), types.Integer(len(sec)))
+	}
+	return idx
+}
@@ -0,0 +1,139 @@
+package pdfsign
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/tsp"
+)
+
+// pdfsign/ltv.go
+// 本文件实现了签名的长期验证（PAdES-B-LT/B-LTA）。
+// 在新的增量更新中写入文档全部签名和时间戳的验证数据（DSS），并在同一修订中加盖文档时间戳，
+// 文档时间戳覆盖DSS，之后可在时间戳证书到期前重复本操作，形成时间戳链以延长文档的可验证期限。
+
+// LTVOptions 长期验证参数
+type LTVOptions struct {
+	Timestamper  tsp.Timestamper     // 文档时间戳服务（必填）
+	Revocation   RevocationSource    // 吊销信息来源（可选），为空时只写入证书
+	Certificates []*x509.Certificate // 额外的证书（如CA证书），用于构建证书链
+	ContentsSize int                 // 为文档时间戳预留的字节数，默认16384
+}
+
+// LTVResult 长期验证结果
+type LTVResult struct {
+	PDF          []byte    // 追加验证数据和文档时间戳后的完整PDF
+	FieldName    string    // 文档时间戳的签名域名称
+	Timestamp    time.Time // 文档时间戳时间
+	Certificates int       // 本次写入VRI的证书数量
+	CRLs         int       // 本次写入VRI的CRL数量
+	OCSPs        int       // 本次写入VRI的OCSP响应数量
+	Warnings     []string  // 吊销信息获取失败等提示
+}
+
+// AddLTV 为PDF中的全部签名和文档时间戳写入验证数据，并加盖新的文档时间戳
+func AddLTV(src []byte, opts LTVOptions) (*LTVResult, error) {
+	if opts.Timestamper == nil {
+		return nil, errors.New("长期验证需要时间戳服务")
+	}
+	if opts.ContentsSize <= 0 {
+		opts.ContentsSize = defaultContentsSize
+	}
+	u, err := newIncrementalUpdate(src)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := signatureFields(u.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("文档中没有签名")
+	}
+	dss, err := newDSSWriter(u)
+	if err != nil {
+		return nil, err
+	}
+	result := &LTVResult{}
+	for _, f := range fields {
+		contents, err := contentsBytes(u.ctx, f.sig)
+		if err != nil {
+			return nil, fmt.Errorf("签名%s的Contents读取失败: %w", f.name, err)
+		}
+		certs, pool, err := signatureCertificates(contents)
+		if err != nil {
+			return nil, fmt.Errorf("签名%s解析失败: %w", f.name, err)
+		}
+		vd, warnings := collectValidationData(certs, append(pool, opts.Certificates...), opts.Revocation)
+		if err := dss.add(vd, vriKey(contents)); err != nil {
+			return nil, err
+		}
+		result.Certificates += len(vd.Certificates)
+		result.CRLs += len(vd.CRLs)
+		result.OCSPs += len(vd.OCSPs)
+		result.Warnings = append(result.Warnings, warnings...)
+	}
+	if err := dss.finish(); err != nil {
+		return nil, err
+	}
+	// 文档时间戳：不可见签名域，签名字典的Contents为时间戳令牌
+	var sb strings.Builder
+	sb.WriteString("<</Type/DocTimeStamp/Filter/Adobe.PPKLite/SubFilter/ETSI.RFC3161")
+	sb.WriteString("/ByteRange " + byteRangePlaceholder)
+	sb.WriteString("/Contents <" + strings.Repeat("0", 2*opts.ContentsSize) + ">>>")
+	fieldName, sigRef, err := addSignatureField(u, Options{Page: 1}, sb.String(), "DocTimeStamp", nil)
+	if err != nil {
+		return nil, err
+	}
+	out, offsets, err := u.write()
+	if err != nil {
+		return nil, err
+	}
+	_, err = fillSignature(out, offsets[sigRef.ObjectNumber.Value()], opts.ContentsSize, crypto.SHA256.New(), func(digest []byte) ([]byte, error) {
+		token, err := opts.Timestamper.Timestamp(digest, crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("申请文档时间戳失败: %w", err)
+		}
+		ts, err := tsp.Parse(token)
+		if err != nil {
+			return nil, fmt.Errorf("文档时间戳令牌无效: %w", err)
+		}
+		result.Timestamp = ts.Time
+		return token, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.PDF = out
+	result.FieldName = fieldName
+	return result, nil
+}
+
+// signatureCertificates 返回签名需要验证的证书（签名证书及各时间戳的TSA证书）和内嵌的全部证书
+// contents为CMS签名或文档时间戳令牌
+func signatureCertificates(contents []byte) ([]*x509.Certificate, []*x509.Certificate, error) {
+	sd, err := cms.Parse(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := sd.SignerCertificate(sd.Signers[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	certs := []*x509.Certificate{signer}
+	pool := append([]*x509.Certificate{}, sd.Certificates...)
+	if raw, ok := sd.Signers[0].UnsignedAttribute(cms.OIDAttrTimeStampToken); ok {
+		if ts, err := cms.Parse(raw.FullBytes); err == nil {
+			if tsa, err := ts.SignerCertificate(ts.Signers[0]); err == nil {
+				certs = append(certs, tsa)
+			}
+			pool = append(pool, ts.Certificates...)
+		}
+	}
+	return certs, pool, nil
+}
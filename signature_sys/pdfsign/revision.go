@@ -0,0 +1,429 @@
+package pdfsign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/revision.go
+// 本文件实现了签名之后增量更新内容的检查。
+// 比较每个修订前后的交叉引用表，找出该修订新增或改写的对象，
+// 只允许签名域/控件及其外观、AcroForm、DSS/VRI、签名字典和文档时间戳字典，
+// 改写页面内容、资源或其他任何对象的修订都视为签名后被修改。
+
+// revisionDiff 一个修订前后的文档
+type revisionDiff struct {
+	prev, cur *model.Context
+	changed   map[int]bool // 本修订新增或改写的对象
+	added     map[int]bool // 本修订新增的对象
+	handled   map[int]bool // 已确认属于允许修改的对象
+}
+
+// checkRevisionsAfter 检查from之后的每个修订，按修订结束偏移返回不允许的修改，nil表示只包含允许的修改
+func checkRevisionsAfter(data []byte, revEnds []int64, from int64) map[int64]error {
+	result := map[int64]error{}
+	var prev *model.Context
+	var prevErr error
+	for i, end := range revEnds {
+		if end <= from {
+			continue
+		}
+		if i == 0 {
+			result[end] = errors.New("签名所在修订无法确定")
+			continue
+		}
+		if prev == nil && prevErr == nil {
+			prev, prevErr = readRevision(data, revEnds[i-1])
+		}
+		cur, err := readRevision(data, end)
+		switch {
+		case prevErr != nil:
+			result[end] = fmt.Errorf("修订解析失败: %w", prevErr)
+		case err != nil:
+			result[end] = fmt.Errorf("修订解析失败: %w", err)
+		default:
+			result[end] = checkRevision(prev, cur)
+		}
+		prev, prevErr = cur, err
+	}
+	return result
+}
+
+// readRevision 解析以end结束的修订（文件前end个字节）
+func readRevision(data []byte, end int64) (*model.Context, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(data[:end]), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// checkRevision 检查cur相对prev新增或改写的对象是否都属于签名、表单签名域或验证数据
+func checkRevision(prev, cur *model.Context) error {
+	d := &revisionDiff{prev: prev, cur: cur, changed: map[int]bool{}, added: map[int]bool{}, handled: map[int]bool{}}
+	if prev.XRefTable.Root == nil || cur.XRefTable.Root == nil || *prev.XRefTable.Root != *cur.XRefTable.Root {
+		return errors.New("文档目录被替换")
+	}
+	for nr, e := range prev.XRefTable.Table {
+		if e == nil || e.Free {
+			continue
+		}
+		ne, ok := cur.XRefTable.Table[nr]
+		if !ok || ne == nil || ne.Free {
+			return fmt.Errorf("删除了对象%d", nr)
+		}
+		if entryChanged(e, ne) {
+			d.changed[nr] = true
+		}
+	}
+	for nr, e := range cur.XRefTable.Table {
+		if e == nil || e.Free {
+			continue
+		}
+		if old, ok := prev.XRefTable.Table[nr]; !ok || old == nil || old.Free {
+			d.changed[nr] = true
+			d.added[nr] = true
+		}
+	}
+	if len(d.changed) == 0 {
+		return nil
+	}
+	// 交叉引用流和对象流只是存储结构
+	for nr := range d.changed {
+		if sd, ok := cur.XRefTable.Table[nr].Object.(types.StreamDict); ok {
+			if t := sd.Type(); t != nil && (*t == "XRef" || *t == "ObjStm") {
+				d.handled[nr] = true
+			}
+		}
+	}
+	if err := d.checkCatalog(); err != nil {
+		return err
+	}
+	if err := d.checkPages(); err != nil {
+		return err
+	}
+	// 签名已有的空签名域
+	for nr := range d.changed {
+		if d.handled[nr] || d.added[nr] {
+			continue
+		}
+		if err := d.checkFilledField(nr); err != nil {
+			return err
+		}
+	}
+	for nr := range d.changed {
+		if !d.handled[nr] {
+			return fmt.Errorf("对象%d不属于签名、表单签名域或验证数据", nr)
+		}
+	}
+	return nil
+}
+
+// entryChanged 判断交叉引用表项是否指向了新的对象位置
+func entryChanged(old, cur *model.XRefTableEntry) bool {
+	return !sameInt64(old.Offset, cur.Offset) || !sameInt(old.Generation, cur.Generation) ||
+		old.Compressed != cur.Compressed || !sameInt(old.ObjectStream, cur.ObjectStream) ||
+		!sameInt(old.ObjectStreamInd, cur.ObjectStreamInd)
+}
+
+func sameInt64(a, b *int64) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameInt(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// pdfString 返回对象的PDF表示，用于比较前后两个修订中的值
+func pdfString(o types.Object) string {
+	if o == nil {
+		return "null"
+	}
+	return o.PDFString()
+}
+
+// sameEntries 比较两个字典中除skip之外的全部键值
+func sameEntries(old, cur types.Dict, skip ...string) error {
+	ignored := map[string]bool{}
+	for _, k := range skip {
+		ignored[k] = true
+	}
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range cur {
+		keys[k] = true
+	}
+	for k := range keys {
+		if !ignored[k] && pdfString(old[k]) != pdfString(cur[k]) {
+			return fmt.Errorf("修改了%s", k)
+		}
+	}
+	return nil
+}
+
+// mark 将o为间接引用且在本修订中改写的对象标记为允许的修改
+func (d *revisionDiff) mark(o types.Object) {
+	if ref, ok := o.(types.IndirectRef); ok && d.changed[ref.ObjectNumber.Value()] {
+		d.handled[ref.ObjectNumber.Value()] = true
+	}
+}
+
+// newObject 返回o引用的本修订新增对象
+func (d *revisionDiff) newObject(o types.Object) (int, types.Object, error) {
+	ref, ok := o.(types.IndirectRef)
+	if !ok || !d.added[ref.ObjectNumber.Value()] {
+		return 0, nil, errors.New("引用了修订之前已存在的对象")
+	}
+	obj, err := d.cur.XRefTable.Dereference(ref)
+	return ref.ObjectNumber.Value(), obj, err
+}
+
+// walk 将从o出发可达的、本修订改写的对象标记为允许的修改
+// existing为false时只标记本修订新增的对象，不经过已有对象
+func (d *revisionDiff) walk(o types.Object, existing bool) {
+	switch v := o.(type) {
+	case types.IndirectRef:
+		nr := v.ObjectNumber.Value()
+		if !d.changed[nr] || d.handled[nr] || (!existing && !d.added[nr]) {
+			return
+		}
+		d.handled[nr] = true
+		if obj, err := d.cur.XRefTable.Dereference(v); err == nil {
+			d.walk(obj, existing)
+		}
+	case types.Dict:
+		for _, item := range v {
+			d.walk(item, existing)
+		}
+	case types.StreamDict:
+		d.walk(v.Dict, existing)
+	case types.Array:
+		for _, item := range v {
+			d.walk(item, existing)
+		}
+	}
+}
+
+// appended 比较前后两个修订中的同一数组，已有元素必须保持不变，返回新追加的元素
+func (d *revisionDiff) appended(old, cur types.Object) (types.Array, error) {
+	oldArr, err := d.prev.XRefTable.DereferenceArray(old)
+	if err != nil {
+		return nil, err
+	}
+	curArr, err := d.cur.XRefTable.DereferenceArray(cur)
+	if err != nil {
+		return nil, err
+	}
+	d.mark(cur)
+	if len(curArr) < len(oldArr) {
+		return nil, errors.New("删除了已有元素")
+	}
+	for i := range oldArr {
+		if pdfString(oldArr[i]) != pdfString(curArr[i]) {
+			return nil, errors.New("修改了已有元素")
+		}
+	}
+	return curArr[len(oldArr):], nil
+}
+
+// checkCatalog 文档目录只允许修改AcroForm和DSS
+func (d *revisionDiff) checkCatalog() error {
+	oldRoot, err := d.prev.XRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+	root, err := d.cur.XRefTable.Catalog()
+	if err != nil {
+		return err
+	}
+	if d.changed[d.cur.XRefTable.Root.ObjectNumber.Value()] {
+		if err := sameEntries(oldRoot, root, "AcroForm", "DSS"); err != nil {
+			return fmt.Errorf("文档目录%w", err)
+		}
+		d.mark(*d.cur.XRefTable.Root)
+	}
+	if err := d.checkAcroForm(oldRoot["AcroForm"], root["AcroForm"]); err != nil {
+		return fmt.Errorf("AcroForm%w", err)
+	}
+	// DSS只包含验证数据，其中改写的对象均允许
+	if o, found := root.Find("DSS"); found && o != nil {
+		d.walk(o, true)
+	}
+	return nil
+}
+
+// checkAcroForm AcroForm只允许追加签名域，以及修改SigFlags和DR
+func (d *revisionDiff) checkAcroForm(old, cur types.Object) error {
+	oldForm, err := d.prev.XRefTable.DereferenceDict(old)
+	if err != nil {
+		return err
+	}
+	form, err := d.cur.XRefTable.DereferenceDict(cur)
+	if err != nil {
+		return err
+	}
+	if form == nil {
+		if oldForm != nil {
+			return errors.New("被删除")
+		}
+		return nil
+	}
+	d.mark(cur)
+	if err := sameEntries(oldForm, form, "Fields", "SigFlags", "DR"); err != nil {
+		return err
+	}
+	d.walk(form["DR"], false)
+	fields, err := d.appended(oldForm["Fields"], form["Fields"])
+	if err != nil {
+		return fmt.Errorf("的Fields%w", err)
+	}
+	for _, f := range fields {
+		if err := d.checkNewField(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPages 页面只允许在Annots中追加签名控件
+func (d *revisionDiff) checkPages() error {
+	if d.prev.PageCount != d.cur.PageCount {
+		return errors.New("页数发生变化")
+	}
+	for i := 1; i <= d.cur.PageCount; i++ {
+		oldPage, oldRef, _, err := d.prev.XRefTable.PageDict(i, false)
+		if err != nil {
+			return err
+		}
+		page, ref, _, err := d.cur.XRefTable.PageDict(i, false)
+		if err != nil {
+			return err
+		}
+		if oldRef == nil || ref == nil || *oldRef != *ref {
+			return fmt.Errorf("第%d页被替换", i)
+		}
+		if d.changed[ref.ObjectNumber.Value()] {
+			if err := sameEntries(oldPage, page, "Annots"); err != nil {
+				return fmt.Errorf("第%d页%w", i, err)
+			}
+			d.mark(*ref)
+		}
+		annots, err := d.appended(oldPage["Annots"], page["Annots"])
+		if err != nil {
+			return fmt.Errorf("第%d页的Annots%w", i, err)
+		}
+		for _, a := range annots {
+			if err := d.checkNewWidget(a); err != nil {
+				return fmt.Errorf("第%d页%w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkNewField 检查AcroForm中新增的签名域
+func (d *revisionDiff) checkNewField(o types.Object) error {
+	nr, obj, err := d.newObject(o)
+	if err != nil {
+		return fmt.Errorf("新增的表单域%w", err)
+	}
+	field, ok := obj.(types.Dict)
+	if !ok || field.NameEntry("FT") == nil || *field.NameEntry("FT") != "Sig" {
+		return fmt.Errorf("新增的表单域%d不是签名域", nr)
+	}
+	d.handled[nr] = true
+	if v, found := field.Find("V"); found && v != nil {
+		if err := d.checkSignatureValue(v); err != nil {
+			return err
+		}
+	}
+	kids, err := d.cur.XRefTable.DereferenceArray(field["Kids"])
+	if err != nil {
+		return err
+	}
+	for _, k := range kids {
+		if err := d.checkNewWidget(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNewWidget 检查页面Annots中新增的控件，只允许签名域控件，其外观中新增的对象均允许
+func (d *revisionDiff) checkNewWidget(o types.Object) error {
+	nr, obj, err := d.newObject(o)
+	if err != nil {
+		return fmt.Errorf("新增的注释%w", err)
+	}
+	widget, ok := obj.(types.Dict)
+	if !ok || widget.Subtype() == nil || *widget.Subtype() != "Widget" {
+		return fmt.Errorf("新增的注释%d不是表单控件", nr)
+	}
+	field := widget
+	if widget.NameEntry("FT") == nil {
+		if field, err = d.cur.XRefTable.DereferenceDict(widget["Parent"]); err != nil {
+			return err
+		}
+	}
+	if field == nil || field.NameEntry("FT") == nil || *field.NameEntry("FT") != "Sig" {
+		return fmt.Errorf("新增的控件%d不属于签名域", nr)
+	}
+	d.handled[nr] = true
+	if v, found := widget.Find("V"); found && v != nil {
+		if err := d.checkSignatureValue(v); err != nil {
+			return err
+		}
+	}
+	d.walk(widget["AP"], false)
+	return nil
+}
+
+// checkSignatureValue 签名域的值必须是本修订新增的签名字典或文档时间戳字典
+func (d *revisionDiff) checkSignatureValue(o types.Object) error {
+	nr, obj, err := d.newObject(o)
+	if err != nil {
+		return fmt.Errorf("签名域的值%w", err)
+	}
+	sig, ok := obj.(types.Dict)
+	if !ok || sig.Type() == nil || (*sig.Type() != "Sig" && *sig.Type() != "DocTimeStamp") {
+		return fmt.Errorf("签名域的值%d不是签名字典", nr)
+	}
+	d.handled[nr] = true
+	return nil
+}
+
+// checkFilledField 已有的空签名域被签名时，只允许写入V和外观
+func (d *revisionDiff) checkFilledField(nr int) error {
+	old, ok := d.prev.XRefTable.Table[nr].Object.(types.Dict)
+	if !ok || old.NameEntry("FT") == nil || *old.NameEntry("FT") != "Sig" {
+		return nil
+	}
+	if v, found := old.Find("V"); found && v != nil {
+		return fmt.Errorf("已签名的签名域%d被改写", nr)
+	}
+	field, ok := d.cur.XRefTable.Table[nr].Object.(types.Dict)
+	if !ok {
+		return fmt.Errorf("签名域%d被替换", nr)
+	}
+	if err := sameEntries(old, field, "V", "AP"); err != nil {
+		return fmt.Errorf("签名域%d%w", nr, err)
+	}
+	if v, found := field.Find("V"); found && v != nil {
+		if err := d.checkSignatureValue(v); err != nil {
+			return err
+		}
+	}
+	d.walk(field["AP"], false)
+	d.handled[nr] = true
+	return nil
+}
@@ -0,0 +1,145 @@
+package pdfsign
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"signature_sys/utils"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// pdfsign/revocation.go
+// 本文件实现了签名证书的吊销检查。
+// 优先使用文档DSS中保存的OCSP响应和CRL，不足以证明签名时证书状态时再通过吊销信息来源在线查询。
+// 证书在签名时间（有时间戳时取时间戳时间）之前被吊销的，签名无效；之后被吊销的只给出提示。
+
+// 吊销状态
+const (
+	RevocationGood    = "good"    // 未吊销
+	RevocationRevoked = "revoked" // 已吊销
+	RevocationUnknown = "unknown" // 无法确定
+)
+
+// RevocationInfo 签名证书的吊销检查结果
+type RevocationInfo struct {
+	Status     string    // good/revoked/unknown
+	Source     string    // 依据：dss_ocsp/dss_crl/ocsp/crl
+	ThisUpdate time.Time // 依据的生成时间
+	RevokedAt  time.Time // 吊销时间
+	Reason     int       // 吊销原因（RFC 5280）
+	Errors     []string  // 查询失败原因
+}
+
+// CheckRevocation 检查签名证书的吊销状态并写入sig.Revocation
+// dss为文档中的DSS（可为nil），src为在线吊销信息来源（可为nil）；自签名证书不检查
+func CheckRevocation(sig *SignatureInfo, dss *DSSInfo, src RevocationSource) {
+	if sig.Signer == nil || sig.DocTimestamp || utils.CheckSignatureFrom(sig.Signer, sig.Signer) == nil {
+		return
+	}
+	pool := append([]*x509.Certificate{}, sig.Certificates...)
+	if dss != nil {
+		pool = append(pool, dss.Certificates...)
+	}
+	issuer := findIssuer(sig.Signer, pool)
+	if issuer == nil {
+		sig.Revocation = &RevocationInfo{Status: RevocationUnknown, Errors: []string{"未找到签名证书的颁发者证书"}}
+		return
+	}
+	at := sig.proofTime()
+	info := &RevocationInfo{Status: RevocationUnknown}
+	// DSS中的依据须在签名之后生成，才能证明签名时证书未被吊销（OCSP和CRL时间精确到秒）
+	after := at.Truncate(time.Second)
+	if dss != nil {
+		for _, der := range dss.OCSPs {
+			if r := fromOCSP(der, sig.Signer, issuer, "dss_ocsp"); r != nil && (r.Status == RevocationRevoked || !r.ThisUpdate.Before(after)) {
+				info = r
+				break
+			}
+		}
+		if info.Status == RevocationUnknown {
+			for _, der := range dss.CRLs {
+				if r := fromCRL(der, sig.Signer, issuer, "dss_crl"); r != nil && (r.Status == RevocationRevoked || !r.ThisUpdate.Before(after)) {
+					info = r
+					break
+				}
+			}
+		}
+	}
+	if info.Status == RevocationUnknown && src != nil {
+		crl, resp, err := src.Revocation(sig.Signer, issuer)
+		if err != nil {
+			info.Errors = append(info.Errors, err.Error())
+		}
+		if r := fromOCSP(resp, sig.Signer, issuer, "ocsp"); resp != nil && r != nil {
+			info = r
+		} else if r := fromCRL(crl, sig.Signer, issuer, "crl"); crl != nil && r != nil {
+			info = r
+		}
+	}
+	sig.Revocation = info
+	if info.Status != RevocationRevoked {
+		return
+	}
+	if !info.RevokedAt.After(at) {
+		sig.Errors = append(sig.Errors, fmt.Sprintf("签名证书已于%s被吊销，早于签名时间", info.RevokedAt.Local().Format("2006-01-02 15:04:05")))
+	} else {
+		sig.Warnings = append(sig.Warnings, fmt.Sprintf("签名证书已于%s被吊销，晚于签名时间", info.RevokedAt.Local().Format("2006-01-02 15:04:05")))
+	}
+}
+
+// proofTime 返回可证明的签名时间：有效签名时间戳的时间，其次为文档时间戳时间，否则为当前时间
+func (s *SignatureInfo) proofTime() time.Time {
+	if ts := s.Timestamp; ts != nil && ts.Valid {
+		return ts.Time
+	}
+	if !s.ArchiveTime.IsZero() {
+		return s.ArchiveTime
+	}
+	return time.Now()
+}
+
+// fromOCSP 解析并校验OCSP响应，响应不针对该证书或无效时返回nil
+func fromOCSP(der []byte, cert, issuer *x509.Certificate, source string) *RevocationInfo {
+	if der == nil {
+		return nil
+	}
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil
+	}
+	info := &RevocationInfo{Source: source, ThisUpdate: resp.ThisUpdate}
+	switch resp.Status {
+	case ocsp.Good:
+		info.Status = RevocationGood
+	case ocsp.Revoked:
+		info.Status = RevocationRevoked
+		info.RevokedAt = resp.RevokedAt
+		info.Reason = resp.RevocationReason
+	default:
+		info.Status = RevocationUnknown
+	}
+	return info
+}
+
+// fromCRL 解析并校验CRL，CRL不是由issuer签发时返回nil
+func fromCRL(der []byte, cert, issuer *x509.Certificate, source string) *RevocationInfo {
+	if der == nil {
+		return nil
+	}
+	crl, err := utils.ParseRevocationList(der, issuer)
+	if err != nil {
+		return nil
+	}
+	info := &RevocationInfo{Status: RevocationGood, Source: source, ThisUpdate: crl.ThisUpdate}
+	for _, e := range crl.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			info.Status = RevocationRevoked
+			info.RevokedAt = e.RevocationTime
+			info.Reason = e.ReasonCode
+			break
+		}
+	}
+	return info
+}
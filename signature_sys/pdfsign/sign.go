@@ -0,0 +1,432 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/ses"
+	"signature_sys/tsp"
+	"signature_sys/utils"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/sign.go
+// 本文件实现了纯Go的PDF数字签名（PAdES-B-B，可选B-T时间戳和B-LTA长期验证）。
+// 流程：以增量更新方式添加签章图片、签名域和签名字典，预留Contents空间，
+// 按ByteRange计算摘要后生成CMS分离式签名（可附带签名时间戳和GB/T 38540电子签章）并回填。
+
+// 默认为签名值（CMS的DER编码）预留的字节数
+const defaultContentsSize = 16384
+
+// 电子签章中除电子印章以外的数据（签章人证书、签名值等）预留的字节数
+const sealOverhead = 4096
+
+// ByteRange占位符，回填时用空格补齐到相同长度
+const byteRangePlaceholder = "[0 ********** ********** **********]"
+
+// Options PDF签名参数
+type Options struct {
+	Signer       crypto.Signer       // 签名私钥
+	Certificate  *x509.Certificate   // 签名证书
+	Chain        []*x509.Certificate // 需要内嵌的证书链（可选）
+	FieldName    string              // 签名域名称，为空时自动生成Signature1、Signature2...
+	Page         int                 // 签名域所在页码，默认第1页
+	Rect         [4]float64          // 签名域区域（左下x,y，右上x,y），全零为不可见签名
+	Name         string              // 签名人名称
+	Reason       string              // 签名原因
+	Location     string              // 签名地点
+	ContactInfo  string              // 联系方式
+	SigningTime  time.Time           // 声明的签名时间，默认当前时间
+	ContentsSize int                 // 为签名值预留的字节数，默认16384（带电子签章时按电子印章大小增加）
+	Stamps       []Stamp             // 与签名写入同一修订的签章图片（可选）
+	CrossPage    *CrossPageStamp     // 与签名写入同一修订的骑缝章（可选）
+	Appearance   *Appearance         // 可见签名外观（可选），设置后签名域位于签章所在页和位置，忽略Page和Rect
+	Timestamper  tsp.Timestamper     // 时间戳服务（可选），为签名值申请RFC 3161时间戳（PAdES-B-T）
+	LTV          bool                // 签名后追加验证数据和文档时间戳（PAdES-B-LTA），须同时设置Timestamper
+	Revocation   RevocationSource    // 长期验证时获取证书吊销信息的来源（可选）
+	Seal         []byte              // GB/T 38540电子印章（SES_Seal的DER编码，可选），设置后生成引用该印章的电子签章
+}
+
+// Result 签名结果
+type Result struct {
+	FieldName string    // 实际使用的签名域名称
+	ByteRange [4]int64  // 签名覆盖的字节范围
+	Signature []byte    // 签名值（SignerInfo中的signature）
+	Algorithm string    // 签名算法，如SHA256-ECC、SM3-SM2
+	Timestamp time.Time // 时间戳时间，未申请时间戳时为零值
+	Archived  time.Time // 文档时间戳时间，未启用长期验证时为零值
+}
+
+// SignFile 对inPath指向的PDF签名，结果写入outPath
+func SignFile(inPath, outPath string, opts Options) (*Result, error) {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+	out, res, err := Sign(src, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outPath, out, 0644); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Sign 对PDF字节签名，返回签名后的完整PDF
+func Sign(src []byte, opts Options) ([]byte, *Result, error) {
+	if opts.Signer == nil || opts.Certificate == nil {
+		return nil, nil, errors.New("缺少签名私钥或证书")
+	}
+	if opts.Page <= 0 {
+		opts.Page = 1
+	}
+	if opts.SigningTime.IsZero() {
+		opts.SigningTime = time.Now()
+	}
+	if opts.ContentsSize <= 0 {
+		// 电子签章内含完整的电子印章（含印章图片）和签章人证书，需额外预留空间
+		opts.ContentsSize = defaultContentsSize
+		if opts.Seal != nil {
+			opts.ContentsSize += len(opts.Seal) + sealOverhead
+		}
+	}
+	u, err := newIncrementalUpdate(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 签章图片与签名在同一次增量更新中写入，签名覆盖盖章后的页面
+	for _, st := range opts.Stamps {
+		if err := u.addStamp(st); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.CrossPage != nil {
+		if err := u.addCrossPageStamp(*opts.CrossPage); err != nil {
+			return nil, nil, err
+		}
+	}
+	// 可见签名：签章图片和说明文字作为签名域控件的外观
+	var ap *types.IndirectRef
+	if opts.Appearance != nil {
+		rect, formRef, err := u.addAppearance(*opts.Appearance)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Page, opts.Rect, ap = max(opts.Appearance.Stamp.Page, 1), rect, &formRef
+	}
+	fieldName, sigRef, err := addSignatureField(u, opts, signatureDict(opts), "Signature", ap)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, offsets, err := u.write()
+	if err != nil {
+		return nil, nil, err
+	}
+	// 按ByteRange计算摘要并生成CMS签名
+	var sd *cms.SignedData
+	var tsTime time.Time
+	// SM2密钥按GM/T 0010使用SM3摘要，其他密钥使用SHA256
+	h := cms.NewDigest(opts.Signer.Public(), crypto.SHA256)
+	byteRange, err := fillSignature(out, offsets[sigRef.ObjectNumber.Value()], opts.ContentsSize, h, func(digest []byte) ([]byte, error) {
+		// 电子签章（SES_Signature）对同一原文摘要签名，作为签名属性写入CMS
+		var extra []cms.Attribute
+		if opts.Seal != nil {
+			sesSig, err := ses.Sign(opts.Seal, digest, fieldName, opts.Signer, opts.Certificate, opts.SigningTime)
+			if err != nil {
+				return nil, fmt.Errorf("生成电子签章失败: %w", err)
+			}
+			a, err := cms.NewAttribute(ses.OIDAttrSESSignature, asn1.RawValue{FullBytes: sesSig})
+			if err != nil {
+				return nil, err
+			}
+			extra = append(extra, a)
+		}
+		var err error
+		sd, err = cms.Sign(digest, opts.Signer, opts.Certificate, opts.Chain, cms.SignOptions{Hash: crypto.SHA256, ExtraSignedAttrs: extra})
+		if err != nil {
+			return nil, err
+		}
+		// 对签名值申请时间戳，作为非签名属性signature-time-stamp写入
+		if opts.Timestamper != nil {
+			sum := sha256.Sum256(sd.Signers[0].Signature)
+			token, err := opts.Timestamper.Timestamp(sum[:], crypto.SHA256)
+			if err != nil {
+				return nil, fmt.Errorf("申请时间戳失败: %w", err)
+			}
+			ts, err := tsp.Parse(token)
+			if err != nil {
+				return nil, fmt.Errorf("时间戳令牌无效: %w", err)
+			}
+			tsTime = ts.Time
+			if err := sd.AddUnsignedAttribute(cms.OIDAttrTimeStampToken, asn1.RawValue{FullBytes: token}); err != nil {
+				return nil, err
+			}
+		}
+		return sd.Marshal()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// 长期验证：在新的修订中写入验证数据（DSS）并加盖文档时间戳
+	var archiveTime time.Time
+	if opts.LTV {
+		ltv, err := AddLTV(out, LTVOptions{Timestamper: opts.Timestamper, Revocation: opts.Revocation, Certificates: opts.Chain})
+		if err != nil {
+			return nil, nil, err
+		}
+		out = ltv.PDF
+		archiveTime = ltv.Timestamp
+	}
+	return out, &Result{
+		FieldName: fieldName,
+		ByteRange: byteRange,
+		Signature: sd.Signers[0].Signature,
+		Algorithm: AlgorithmName(opts.Certificate),
+		Timestamp: tsTime,
+		Archived:  archiveTime,
+	}, nil
+}
+
+// fillSignature 回填签名字典的ByteRange和Contents
+// sigStart为签名字典在out中的偏移，sign根据ByteRange覆盖内容按h计算的摘要生成签名值（DER编码）
+func fillSignature(out []byte, sigStart int64, contentsSize int, h hash.Hash, sign func(digest []byte) ([]byte, error)) ([4]int64, error) {
+	var byteRange [4]int64
+	// 定位签名字典中的Contents和ByteRange占位符
+	brPos := bytes.Index(out[sigStart:], []byte(byteRangePlaceholder))
+	ctPos := bytes.Index(out[sigStart:], []byte("/Contents <"))
+	if brPos < 0 || ctPos < 0 {
+		return byteRange, errors.New("签名字典占位符定位失败")
+	}
+	brPos += int(sigStart)
+	contentsStart := int64(ctPos) + sigStart + int64(len("/Contents "))
+	contentsEnd := contentsStart + int64(2*contentsSize) + 2
+	byteRange = [4]int64{0, contentsStart, contentsEnd, int64(len(out)) - contentsEnd}
+	brStr := fmt.Sprintf("[0 %d %d %d]", byteRange[1], byteRange[2], byteRange[3])
+	if len(brStr) > len(byteRangePlaceholder) {
+		return byteRange, errors.New("文件过大，ByteRange超出预留长度")
+	}
+	copy(out[brPos:], brStr+strings.Repeat(" ", len(byteRangePlaceholder)-len(brStr)))
+	h.Write(out[:byteRange[1]])
+	h.Write(out[byteRange[2]:])
+	der, err := sign(h.Sum(nil))
+	if err != nil {
+		return byteRange, err
+	}
+	if len(der) > contentsSize {
+		return byteRange, fmt.Errorf("签名值长度%d超出预留空间%d", len(der), contentsSize)
+	}
+	copy(out[contentsStart+1:], strings.ToUpper(hex.EncodeToString(der)))
+	return byteRange, nil
+}
+
+// AlgorithmName 返回证书对应的签名算法名称，用于签章日志
+func AlgorithmName(cert *x509.Certificate) string {
+	if utils.IsSM2PublicKey(cert.PublicKey) {
+		return "SM3-SM2"
+	}
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return "SHA256-ECC"
+	case x509.RSA:
+		return "SHA256-RSA"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// signatureDict 生成签名字典，ByteRange和Contents先写占位符，签名后回填
+func signatureDict(opts Options) string {
+	var sb strings.Builder
+	sb.WriteString("<</Type/Sig/Filter/Adobe.PPKLite/SubFilter/ETSI.CAdES.detached")
+	sb.WriteString("/M" + pdfText(pdfDate(opts.SigningTime)))
+	if opts.Name != "" {
+		sb.WriteString("/Name" + pdfText(opts.Name))
+	}
+	if opts.Reason != "" {
+		sb.WriteString("/Reason" + pdfText(opts.Reason))
+	}
+	if opts.Location != "" {
+		sb.WriteString("/Location" + pdfText(opts.Location))
+	}
+	if opts.ContactInfo != "" {
+		sb.WriteString("/ContactInfo" + pdfText(opts.ContactInfo))
+	}
+	sb.WriteString("/ByteRange " + byteRangePlaceholder)
+	sb.WriteString("/Contents <" + strings.Repeat("0", 2*opts.ContentsSize) + ">>>")
+	return sb.String()
+}
+
+// addSignatureField 添加签名字典、签名域（域与控件合并），并登记到页面和AcroForm
+// 未指定域名称时按prefix自动编号，ap为可见签名的外观表单，不可见签名为nil
+func addSignatureField(u *incrementalUpdate, opts Options, sigDict, prefix string, ap *types.IndirectRef) (string, types.IndirectRef, error) {
+	root, err := u.catalog()
+	if err != nil {
+		return "", types.IndirectRef{}, err
+	}
+	pageDict, pageRef, err := u.page(opts.Page)
+	if err != nil {
+		return "", types.IndirectRef{}, err
+	}
+	// AcroForm可能直接内嵌在目录中，也可能是间接对象
+	var acroForm types.Dict
+	var acroRef *types.IndirectRef
+	if o, found := root.Find("AcroForm"); found && o != nil {
+		if ref, ok := o.(types.IndirectRef); ok {
+			acroRef = &ref
+		}
+		if acroForm, err = u.resolveDict(o); err != nil {
+			return "", types.IndirectRef{}, err
+		}
+	}
+	if acroForm == nil {
+		acroForm = types.Dict{}
+	}
+	fieldName := opts.FieldName
+	existing, err := fieldNames(u, acroForm)
+	if err != nil {
+		return "", types.IndirectRef{}, err
+	}
+	if fieldName == "" {
+		for i := 1; ; i++ {
+			fieldName = fmt.Sprintf("%s%d", prefix, i)
+			if !existing[fieldName] {
+				break
+			}
+		}
+	} else if existing[fieldName] {
+		return "", types.IndirectRef{}, fmt.Errorf("签名域%s已存在", fieldName)
+	}
+	sigRef := u.addRaw([]byte(sigDict))
+	// 签名域控件，F=132（打印+锁定）
+	rect := types.Array{}
+	for _, v := range opts.Rect {
+		rect = append(rect, number(v))
+	}
+	widget := types.Dict{
+		"Type":    types.Name("Annot"),
+		"Subtype": types.Name("Widget"),
+		"FT":      types.Name("Sig"),
+		"T":       textObject(fieldName),
+		"V":       sigRef,
+		"F":       types.Integer(132),
+		"Rect":    rect,
+		"P":       pageRef,
+	}
+	if ap != nil {
+		widget["AP"] = types.Dict{"N": *ap}
+	}
+	widgetRef := u.add(widget)
+	// 登记到页面Annots
+	changed, err := u.appendToArrayEntry(pageDict, "Annots", widgetRef)
+	if err != nil {
+		return "", types.IndirectRef{}, err
+	}
+	if changed {
+		u.set(pageRef, pageDict)
+	}
+	// 登记到AcroForm Fields，并设置SigFlags（SignaturesExist|AppendOnly）
+	if _, err := u.appendToArrayEntry(acroForm, "Fields", widgetRef); err != nil {
+		return "", types.IndirectRef{}, err
+	}
+	acroForm["SigFlags"] = types.Integer(3)
+	if acroRef != nil {
+		u.set(*acroRef, acroForm)
+	} else {
+		root["AcroForm"] = acroForm
+		u.set(*u.ctx.XRefTable.Root, root)
+	}
+	return fieldName, sigRef, nil
+}
+
+// fieldNames 收集AcroForm中已有的顶层域名称
+func fieldNames(u *incrementalUpdate, acroForm types.Dict) (map[string]bool, error) {
+	names := map[string]bool{}
+	o, found := acroForm.Find("Fields")
+	if !found || o == nil {
+		return names, nil
+	}
+	fields, err := u.resolveArray(o)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		d, err := u.resolveDict(f)
+		if err != nil || d == nil {
+			continue
+		}
+		if t, found := d.Find("T"); found {
+			names[textValue(t)] = true
+		}
+	}
+	return names, nil
+}
+
+// textValue 将PDF字符串对象转换为Go字符串
+func textValue(o types.Object) string {
+	switch v := o.(type) {
+	case types.StringLiteral:
+		s, err := types.StringLiteralToString(v)
+		if err == nil {
+			return s
+		}
+		return v.Value()
+	case types.HexLiteral:
+		s, err := types.HexLiteralToString(v)
+		if err == nil {
+			return s
+		}
+		return v.Value()
+	case types.Name:
+		return v.Value()
+	}
+	return ""
+}
+
+// number 将数值转换为PDF数字对象，整数不带小数部分
+func number(v float64) types.Object {
+	if v == float64(int64(v)) {
+		return types.Integer(int(v))
+	}
+	return types.Float(v)
+}
+
+// pdfDate 按PDF日期格式输出时间，时区偏移含分钟（如+05'30'），UTC输出为Z
+// Go的时间格式没有单独表示时区偏移分钟的占位符（"04"为时间的分钟），须由"-07:00"拆分
+func pdfDate(t time.Time) string {
+	z := t.Format("-07:00")
+	s := t.Format("D:20060102150405") + z[:3] + "'" + z[4:] + "'"
+	return strings.Replace(s, "+00'00'", "Z", 1)
+}
+
+// pdfText 将文本编码为PDF字符串
+func pdfText(s string) string {
+	return textObject(s).PDFString()
+}
+
+// textObject 将文本转换为PDF字符串对象：ASCII使用字面量，其他使用UTF-16BE十六进制串
+func textObject(s string) types.Object {
+	for _, r := range s {
+		if r > 0x7e || r < 0x20 {
+			return types.HexLiteral(strings.ToUpper(hex.EncodeToString([]byte(types.EncodeUTF16String(s)))))
+		}
+	}
+	return types.StringLiteral(escapeLiteral(s))
+}
+
+// escapeLiteral 转义字面量字符串中的特殊字符
+func escapeLiteral(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	return r.Replace(s)
+}
@@ -0,0 +1,435 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"signature_sys/ca"
+	"signature_sys/signer"
+	"signature_sys/tsp"
+)
+
+// testPDF 生成pages页A4空白PDF
+func testPDF(t *testing.T, pages int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	buf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := ""
+	for i := 0; i < pages; i++ {
+		kids += fmt.Sprintf("%d 0 R ", 3+i*2)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids, pages))
+	for i := 0; i < pages; i++ {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents %d 0 R /Resources << >> >>", 4+i*2))
+		content := "0 0 m 595 842 l S"
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// testImage 生成带透明背景的红色圆形签章图片（PNG）
+func testImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 120, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 120; x++ {
+			if (x-60)*(x-60)+(y-60)*(y-60) < 55*55 {
+				img.Set(x, y, color.NRGBA{R: 220, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testCA 测试CA，OCSP和CRL服务由httptest提供（国密CA不提供OCSP，只能下载CRL）
+type testCA struct {
+	*ca.CA
+	srv *httptest.Server
+}
+
+func newTestCA(t *testing.T, sm2 bool) *testCA {
+	t.Helper()
+	load := ca.LoadOrCreate
+	if sm2 {
+		load = ca.LoadOrCreateSM2
+	}
+	c, err := load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testCA{CA: c}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ocsp", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(c.OCSPResponse(req, func(serial *big.Int) (*ca.RevokedCert, bool, error) {
+			return nil, true, nil
+		}, time.Hour))
+	})
+	mux.HandleFunc("/crl", func(w http.ResponseWriter, r *http.Request) {
+		crl, err := c.CreateCRL(nil, big.NewInt(1), time.Now(), time.Now().Add(time.Hour))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		w.Write(crl)
+	})
+	tc.srv = httptest.NewServer(mux)
+	t.Cleanup(tc.srv.Close)
+	c.OCSPURL = tc.srv.URL + "/ocsp"
+	c.CRLURL = tc.srv.URL + "/crl"
+	return tc
+}
+
+// issue 生成签名密钥并签发用户证书
+func (tc *testCA) issue(t *testing.T, algo, name string) (crypto.Signer, *x509.Certificate) {
+	t.Helper()
+	key, err := signer.GenerateSoftwareKey(algo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tc.IssueUserCertificate(key.Public(), name, name+"@example.com", time.Now().Add(-time.Hour), time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// testAuthority 测试用时间戳服务
+func testAuthority(t *testing.T) *tsp.Authority {
+	t.Helper()
+	dir := t.TempDir()
+	a, err := tsp.LoadOrCreateAuthority(filepath.Join(dir, "tsa_cert.pem"), filepath.Join(dir, "tsa_private.pem"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// requireValid 要求全部签名有效
+func requireValid(t *testing.T, rep *Report) {
+	t.Helper()
+	for _, s := range rep.Signatures {
+		if !s.Valid() {
+			t.Fatalf("签名%s无效: %v", s.FieldName, s.Errors)
+		}
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	tests := []struct {
+		algo string
+		sm2  bool
+		name string
+	}{
+		{signer.AlgoECC, false, "SHA256-ECC"},
+		{signer.AlgoRSA, false, "SHA256-RSA"},
+		{signer.AlgoSM2, true, "SM3-SM2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algo, func(t *testing.T) {
+			tc := newTestCA(t, tt.sm2)
+			key, cert := tc.issue(t, tt.algo, "signer")
+			src := testPDF(t, 2)
+			signingTime := time.Now().Truncate(time.Second)
+			out, res, err := Sign(src, Options{
+				Signer:      key,
+				Certificate: cert,
+				Chain:       tc.ChainFor(cert),
+				Name:        "签名人",
+				Reason:      "合同签署",
+				Location:    "北京",
+				SigningTime: signingTime,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Algorithm != tt.name {
+				t.Errorf("签名算法 = %s，应为%s", res.Algorithm, tt.name)
+			}
+			if !bytes.HasPrefix(out, src) {
+				t.Fatal("签名应以增量更新方式追加在原文件之后")
+			}
+			rep, err := Verify(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rep.Signatures) != 1 {
+				t.Fatalf("签名数量 = %d，应为1", len(rep.Signatures))
+			}
+			requireValid(t, rep)
+			s := rep.Signatures[0]
+			if s.FieldName != res.FieldName || !s.CoversWholeFile {
+				t.Errorf("签名域 = %s，覆盖整个文件 = %v", s.FieldName, s.CoversWholeFile)
+			}
+			if s.Name != "签名人" || s.Reason != "合同签署" || s.Location != "北京" {
+				t.Errorf("签名信息 = %s/%s/%s", s.Name, s.Reason, s.Location)
+			}
+			if !s.SigningTime.Equal(signingTime) {
+				t.Errorf("签名时间 = %v，应为%v", s.SigningTime, signingTime)
+			}
+			if !s.Signer.Equal(cert) {
+				t.Error("签名者证书与签名证书不一致")
+			}
+			if err := tc.Verify(s.Signer, s.Certificates, time.Now()); err != nil {
+				t.Errorf("证书链校验失败: %v", err)
+			}
+			// 篡改签名覆盖范围内的字节后摘要校验失败
+			tampered := append([]byte{}, out...)
+			i := bytes.Index(tampered, []byte("595 842 l"))
+			tampered[i] = '4'
+			rep, err = Verify(tampered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Signatures[0].Valid() {
+				t.Fatal("篡改后的文档签名不应有效")
+			}
+		})
+	}
+}
+
+func TestSignMultipleStamps(t *testing.T) {
+	tc := newTestCA(t, false)
+	img := testImage(t)
+	src := testPDF(t, 3)
+	// 第一次签名：同一修订中在两页上加盖三处签章
+	key1, cert1 := tc.issue(t, signer.AlgoECC, "first")
+	out, res1, err := Sign(src, Options{
+		Signer:      key1,
+		Certificate: cert1,
+		Chain:       tc.ChainFor(cert1),
+		Page:        1,
+		Stamps: []Stamp{
+			{Page: 1, Image: img, X: 100, Y: 100, Scale: 0.2},
+			{Page: 1, Image: img, X: 300, Y: 100, Scale: 0.3, Rotation: 30},
+			{Page: 3, Image: img, X: 200, Y: 400, Scale: 0.25, Rotation: -45},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 另一签名人追加签名时在页面内容中盖章，改写了第一个签名覆盖的页面，第一个签名失效
+	key2, cert2 := tc.issue(t, signer.AlgoRSA, "second")
+	stampedSign, _, err := Sign(out, Options{
+		Signer:      key2,
+		Certificate: cert2,
+		Chain:       tc.ChainFor(cert2),
+		Page:        2,
+		Stamps:      []Stamp{{Page: 2, Image: img, X: 50, Y: 50, Scale: 0.2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err := Verify(stampedSign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := rep.Signatures[0]; !first.ModifiedAfterSigning || first.LaterSignaturesOnly || first.Valid() {
+		t.Error("签名时在页面内容中盖章应使之前的签名提示签名后被修改")
+	}
+	if !rep.Signatures[1].Valid() {
+		t.Errorf("第二个签名应有效: %v", rep.Signatures[1].Errors)
+	}
+	// 第二次签名：签章作为签名域外观，第一个签名保持有效
+	out, res2, err := Sign(out, Options{
+		Signer:      key2,
+		Certificate: cert2,
+		Chain:       tc.ChainFor(cert2),
+		Appearance:  &Appearance{Stamp: Stamp{Page: 2, Image: img, X: 50, Y: 50, Scale: 0.2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1.FieldName == res2.FieldName {
+		t.Fatalf("两次签名使用了相同的签名域%s", res1.FieldName)
+	}
+	rep, err = Verify(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Signatures) != 2 {
+		t.Fatalf("签名数量 = %d，应为2", len(rep.Signatures))
+	}
+	requireValid(t, rep)
+	first, second := rep.Signatures[0], rep.Signatures[1]
+	if first.CoversWholeFile || !first.LaterSignaturesOnly {
+		t.Errorf("第一个签名之后应只追加了签名: 覆盖整个文件=%v 仅追加签名=%v", first.CoversWholeFile, first.LaterSignaturesOnly)
+	}
+	if !second.CoversWholeFile {
+		t.Error("第二个签名应覆盖整个文件")
+	}
+	// 签章写入页面后，单独盖章（不签名）的修订会使签名后出现非签名修改
+	stamped, err := stampBytes(t, out, []Stamp{{Page: 1, Image: img, X: 400, Y: 600, Scale: 0.2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, err = Verify(stamped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.Signatures[1].ModifiedAfterSigning || rep.Signatures[1].Valid() {
+		t.Error("签名后追加签章的文档应提示签名后被修改")
+	}
+}
+
+func TestSignInvalidStamp(t *testing.T) {
+	tc := newTestCA(t, false)
+	key, cert := tc.issue(t, signer.AlgoECC, "signer")
+	src := testPDF(t, 1)
+	img := testImage(t)
+	for _, st := range []Stamp{
+		{Page: 1, Image: img, Scale: 0},
+		{Page: 2, Image: img, Scale: 0.2},
+		{Page: 1, Image: []byte("not an image"), Scale: 0.2},
+	} {
+		if _, _, err := Sign(src, Options{Signer: key, Certificate: cert, Stamps: []Stamp{st}}); err == nil {
+			t.Errorf("签章%+v应签名失败", st)
+		}
+	}
+}
+
+func TestSignLTV(t *testing.T) {
+	for _, sm2 := range []bool{false, true} {
+		algo := signer.AlgoECC
+		if sm2 {
+			algo = signer.AlgoSM2
+		}
+		t.Run(algo, func(t *testing.T) {
+			tc := newTestCA(t, sm2)
+			tsa := testAuthority(t)
+			key, cert := tc.issue(t, algo, "signer")
+			out, res, err := Sign(testPDF(t, 1), Options{
+				Signer:      key,
+				Certificate: cert,
+				Chain:       tc.ChainFor(cert),
+				Stamps:      []Stamp{{Page: 1, Image: testImage(t), X: 100, Y: 100, Scale: 0.2}},
+				Timestamper: tsa,
+				LTV:         true,
+				Revocation:  &OnlineRevocation{},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Timestamp.IsZero() || res.Archived.IsZero() {
+				t.Fatalf("签名时间戳 = %v，文档时间戳 = %v", res.Timestamp, res.Archived)
+			}
+			rep, err := Verify(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			requireValid(t, rep)
+			if len(rep.Signatures) != 2 {
+				t.Fatalf("签名数量 = %d，应为2（签名和文档时间戳）", len(rep.Signatures))
+			}
+			sig, docTS := rep.Signatures[0], rep.Signatures[1]
+			if sig.Timestamp == nil || !sig.Timestamp.Valid {
+				t.Fatal("签名缺少有效的签名时间戳")
+			}
+			if !sig.LTV {
+				t.Error("签名的验证数据未登记到DSS")
+			}
+			if sig.ArchiveTime.IsZero() {
+				t.Error("签名未被文档时间戳覆盖")
+			}
+			if !docTS.DocTimestamp {
+				t.Error("最后一个签名应为文档时间戳")
+			}
+			if rep.DSS == nil || len(rep.DSS.Certificates) == 0 {
+				t.Fatal("DSS中缺少证书")
+			}
+			if len(rep.DSS.OCSPs)+len(rep.DSS.CRLs) == 0 {
+				t.Error("DSS中缺少吊销信息")
+			}
+			if sig.Revocation == nil || sig.Revocation.Status != RevocationGood {
+				t.Errorf("依据DSS的吊销检查结果 = %+v，应为未吊销", sig.Revocation)
+			}
+			// 再次追加验证数据和文档时间戳（归档时间戳续期）
+			ltv, err := AddLTV(out, LTVOptions{Timestamper: tsa, Revocation: &OnlineRevocation{}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep, err = Verify(ltv.PDF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			requireValid(t, rep)
+			if len(rep.Signatures) != 3 {
+				t.Fatalf("续期后签名数量 = %d，应为3", len(rep.Signatures))
+			}
+		})
+	}
+}
+
+func TestSignLTVRequiresTimestamper(t *testing.T) {
+	tc := newTestCA(t, false)
+	key, cert := tc.issue(t, signer.AlgoECC, "signer")
+	if _, _, err := Sign(testPDF(t, 1), Options{Signer: key, Certificate: cert, LTV: true}); err == nil {
+		t.Fatal("未设置时间戳服务时长期验证应失败")
+	}
+	if _, err := AddLTV(testPDF(t, 1), LTVOptions{Timestamper: testAuthority(t)}); err == nil {
+		t.Fatal("没有签名的文档不应追加长期验证数据")
+	}
+}
+
+func TestPDFDate(t *testing.T) {
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, "D:20261018093007Z"},
+		{8 * 3600, "D:20261018093007+08'00'"},
+		{5*3600 + 30*60, "D:20261018093007+05'30'"},
+		{-(3*3600 + 30*60), "D:20261018093007-03'30'"},
+		{5*3600 + 45*60, "D:20261018093007+05'45'"},
+	}
+	for _, tt := range tests {
+		tm := time.Date(2026, 10, 18, 9, 30, 7, 0, time.FixedZone("", tt.offset))
+		if got := pdfDate(tm); got != tt.want {
+			t.Errorf("pdfDate(%v) = %s，应为%s", tm, got, tt.want)
+		}
+	}
+}
+
+// stampBytes 通过StampFile在PDF上盖章（不签名）
+func stampBytes(t *testing.T, src []byte, stamps []Stamp) ([]byte, error) {
+	t.Helper()
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.pdf"), filepath.Join(dir, "out.pdf")
+	if err := os.WriteFile(in, src, 0600); err != nil {
+		return nil, err
+	}
+	if err := StampFile(in, out, stamps); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
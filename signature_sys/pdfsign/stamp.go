@@ -0,0 +1,275 @@
+package pdfsign
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"  // 注册GIF解码器
+	_ "image/jpeg" // 注册JPEG解码器
+	_ "image/png"  // 注册PNG解码器
+	"math"
+	"os"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/stamp.go
+// 本文件实现了以增量更新方式在页面上加盖签章图片。
+// 图片作为XObject加入页面资源，绘制指令追加到页面内容流之后，
+// 原有内容流和已有签名覆盖的字节均不改动。
+
+// Stamp 一次盖章的图片和位置
+type Stamp struct {
+	Page     int     // 页码，从1开始
+	Image    []byte  // 签章图片文件内容（PNG/JPEG/GIF）
+	X, Y     float64 // 图片左下角相对页面可视区域左下角的偏移
+	Scale    float64 // 缩放比例：横向图片为相对页面宽度，纵向图片为相对页面高度
+	Rotation float64 // 绕图片中心顺时针旋转的角度，与签章页面拖拽预览一致
+}
+
+// StampFile 在inPath指向的PDF上盖章（不签名），结果写入outPath
+func StampFile(inPath, outPath string, stamps []Stamp) error {
+	src, err := os.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+	u, err := newIncrementalUpdate(src)
+	if err != nil {
+		return err
+	}
+	for _, st := range stamps {
+		if err := u.addStamp(st); err != nil {
+			return err
+		}
+	}
+	out, _, err := u.write()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, out, 0644)
+}
+
+// addStamp 将签章图片绘制到指定页面
+func (u *incrementalUpdate) addStamp(st Stamp) error {
+	if st.Page <= 0 {
+		st.Page = 1
+	}
+	if st.Scale <= 0 {
+		return errors.New("签章缩放比例必须大于0")
+	}
+	imgRef, imgW, imgH, err := u.addImage(st.Image)
+	if err != nil {
+		return err
+	}
+	return u.drawImage(st.Page, imgRef, st.Rotation, func(box *types.Rectangle) (float64, float64, float64, float64) {
+		// 计算图片在页面上的尺寸：与原水印方式一致，按图片长边方向相对页面缩放
+		w, h := stampSize(box, imgW, imgH, st.Scale)
+		return box.LL.X + st.X, box.LL.Y + st.Y, w, h
+	})
+}
+
+// drawImage 在页面上绘制已添加的图片，place根据页面可视区域返回图片左下角坐标和尺寸，
+// rotation为绕图片中心顺时针旋转的角度
+func (u *incrementalUpdate) drawImage(pageNr int, imgRef types.IndirectRef, rotation float64, place func(box *types.Rectangle) (llx, lly, w, h float64)) error {
+	pageDict, pageRef, err := u.page(pageNr)
+	if err != nil {
+		return err
+	}
+	box, resources, err := u.pageBoxAndResources(pageNr, pageDict)
+	if err != nil {
+		return err
+	}
+	// 在页面资源的XObject中登记图片，资源字典复制到页面自身，避免影响共用资源的其他页面
+	xobjects := types.Dict{}
+	if o, found := resources.Find("XObject"); found && o != nil {
+		d, err := u.resolveDict(o)
+		if err != nil {
+			return err
+		}
+		if d != nil {
+			xobjects = d
+		}
+	}
+	name := ""
+	for i := 1; ; i++ {
+		name = fmt.Sprintf("SealImg%d", i)
+		if _, found := xobjects.Find(name); !found {
+			break
+		}
+	}
+	xobjects[name] = imgRef
+	resources["XObject"] = xobjects
+	pageDict["Resources"] = resources
+	llx, lly, w, h := place(box)
+	// 绕图片中心旋转：先平移到中心，旋转后再平移回左下角
+	rad := -rotation * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	cx, cy := llx+w/2, lly+h/2
+	var sb strings.Builder
+	sb.WriteString("Q\nq\n")
+	fmt.Fprintf(&sb, "1 0 0 1 %s %s cm\n", fmtNum(cx), fmtNum(cy))
+	fmt.Fprintf(&sb, "%s %s %s %s 0 0 cm\n", fmtNum(cos), fmtNum(sin), fmtNum(-sin), fmtNum(cos))
+	fmt.Fprintf(&sb, "%s 0 0 %s %s %s cm\n", fmtNum(w), fmtNum(h), fmtNum(-w/2), fmtNum(-h/2))
+	fmt.Fprintf(&sb, "/%s Do\nQ\n", name)
+	// 原内容流前后包裹q/Q，保证原有图形状态不影响签章
+	before := u.addRaw(streamObject(types.Dict{}, []byte("q\n")))
+	after := u.addRaw(streamObject(types.Dict{}, []byte(sb.String())))
+	contents := types.Array{before}
+	if o, found := pageDict.Find("Contents"); found && o != nil {
+		obj, err := u.resolve(o)
+		if err != nil {
+			return err
+		}
+		if arr, ok := obj.(types.Array); ok {
+			contents = append(contents, arr...)
+		} else {
+			contents = append(contents, o)
+		}
+	}
+	contents = append(contents, after)
+	pageDict["Contents"] = contents
+	u.set(pageRef, pageDict)
+	return nil
+}
+
+// pageBoxAndResources 返回页面可视区域（CropBox，缺省为MediaBox）和生效的资源字典副本
+func (u *incrementalUpdate) pageBoxAndResources(pageNr int, pageDict types.Dict) (*types.Rectangle, types.Dict, error) {
+	_, _, attrs, err := u.ctx.XRefTable.PageDict(pageNr, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("页码%d不存在", pageNr)
+	}
+	box := attrs.CropBox
+	if box == nil {
+		box = attrs.MediaBox
+	}
+	if box == nil {
+		return nil, nil, fmt.Errorf("第%d页缺少MediaBox", pageNr)
+	}
+	// 页面自身的资源可能已在本次更新中修改，优先使用；否则使用继承的资源
+	var resources types.Dict
+	if o, found := pageDict.Find("Resources"); found && o != nil {
+		if resources, err = u.resolveDict(o); err != nil {
+			return nil, nil, err
+		}
+	} else if attrs.Resources != nil {
+		resources = attrs.Resources.Clone().(types.Dict)
+	}
+	if resources == nil {
+		resources = types.Dict{}
+	}
+	return box, resources, nil
+}
+
+// addImage 将图片转换为PDF图像XObject，返回其引用和像素尺寸
+// JPEG直接使用DCTDecode内嵌，其他格式解码后以Flate压缩，透明通道写入SMask
+func (u *incrementalUpdate) addImage(data []byte) (types.IndirectRef, float64, float64, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return types.IndirectRef{}, 0, 0, fmt.Errorf("签章图片解析失败: %w", err)
+	}
+	if format == "jpeg" {
+		colorSpace := ""
+		switch cfg.ColorModel {
+		case color.GrayModel:
+			colorSpace = "DeviceGray"
+		case color.YCbCrModel:
+			colorSpace = "DeviceRGB"
+		}
+		if colorSpace != "" {
+			dict := types.Dict{
+				"Type":             types.Name("XObject"),
+				"Subtype":          types.Name("Image"),
+				"Width":            types.Integer(cfg.Width),
+				"Height":           types.Integer(cfg.Height),
+				"ColorSpace":       types.Name(colorSpace),
+				"BitsPerComponent": types.Integer(8),
+				"Filter":           types.Name("DCTDecode"),
+			}
+			return u.addRaw(streamObject(dict, data)), float64(cfg.Width), float64(cfg.Height), nil
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return types.IndirectRef{}, 0, 0, fmt.Errorf("签章图片解码失败: %w", err)
+	}
+	ref, w, h := u.addDecodedImage(img)
+	return ref, w, h, nil
+}
+
+// addDecodedImage 将解码后的图片以Flate压缩写入图像XObject，透明通道写入SMask，返回其引用和像素尺寸
+func (u *incrementalUpdate) addDecodedImage(img image.Image) (types.IndirectRef, float64, float64) {
+	b := img.Bounds()
+	rgb := make([]byte, 0, b.Dx()*b.Dy()*3)
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			// RGBA返回预乘透明度的值，还原为非预乘颜色
+			if a > 0 && a < 0xffff {
+				r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+			}
+			rgb = append(rgb, byte(r>>8), byte(g>>8), byte(bl>>8))
+			alpha = append(alpha, byte(a>>8))
+			if a != 0xffff {
+				transparent = true
+			}
+		}
+	}
+	dict := types.Dict{
+		"Type":             types.Name("XObject"),
+		"Subtype":          types.Name("Image"),
+		"Width":            types.Integer(b.Dx()),
+		"Height":           types.Integer(b.Dy()),
+		"ColorSpace":       types.Name("DeviceRGB"),
+		"BitsPerComponent": types.Integer(8),
+		"Filter":           types.Name("FlateDecode"),
+	}
+	if transparent {
+		smask := types.Dict{
+			"Type":             types.Name("XObject"),
+			"Subtype":          types.Name("Image"),
+			"Width":            types.Integer(b.Dx()),
+			"Height":           types.Integer(b.Dy()),
+			"ColorSpace":       types.Name("DeviceGray"),
+			"BitsPerComponent": types.Integer(8),
+			"Filter":           types.Name("FlateDecode"),
+		}
+		dict["SMask"] = u.addRaw(streamObject(smask, deflate(alpha)))
+	}
+	return u.addRaw(streamObject(dict, deflate(rgb))), float64(b.Dx()), float64(b.Dy())
+}
+
+// streamObject 序列化流对象，自动设置Length
+func streamObject(dict types.Dict, data []byte) []byte {
+	dict["Length"] = types.Integer(len(data))
+	var buf bytes.Buffer
+	buf.WriteString(dict.PDFString())
+	buf.WriteString("\nstream\n")
+	buf.Write(data)
+	buf.WriteString("\nendstream")
+	return buf.Bytes()
+}
+
+// deflate 以zlib格式压缩数据，用于FlateDecode流
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// fmtNum 格式化内容流中的数字，去掉多余的零
+func fmtNum(v float64) string {
+	if math.Abs(v) < 1e-9 {
+		return "0"
+	}
+	s := fmt.Sprintf("%.4f", v)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,407 @@
+package pdfsign
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/text.go
+// 本文件实现了在PDF文字层中查找关键字：解释页面内容流（含表单XObject）中的文字操作符，
+// 得到每个字形的文字和位置，再按内容流顺序拼接后匹配关键字，用于按关键字定位签章。
+
+// 表单XObject的最大嵌套层数
+const maxFormDepth = 8
+
+// TextMatch 关键字在页面上的一处匹配，坐标与Stamp一致：相对页面可视区域左下角，单位为点
+type TextMatch struct {
+	Page          int     // 页码，从1开始
+	X, Y          float64 // 关键字外接矩形左下角
+	Width, Height float64 // 关键字外接矩形尺寸
+}
+
+// matrix PDF变换矩阵[a b c d e f]
+type matrix [6]float64
+
+// identity 单位矩阵
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul 矩阵乘法，先应用m再应用n
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// apply 变换点坐标
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// glyph 页面上的一个字形
+type glyph struct {
+	text               string  // 字形对应的文字
+	llx, lly, urx, ury float64 // 字形外接矩形（页面坐标）
+}
+
+// graphicsState 文字提取关心的图形状态
+type graphicsState struct {
+	ctm       matrix
+	font      *textFont
+	size      float64 // 字号
+	charSpace float64 // 字间距Tc
+	wordSpace float64 // 词间距Tw
+	hScale    float64 // 水平缩放Tz/100
+	leading   float64 // 行距TL
+	rise      float64 // 上标偏移Ts
+}
+
+// textExtractor 单个页面的文字提取
+type textExtractor struct {
+	ctx    *model.Context
+	fonts  map[string]*textFont // 按字体对象号（直接对象按资源名）缓存的字体
+	glyphs []glyph
+}
+
+// FindTextFile 在path指向的PDF中查找关键字
+func FindTextFile(path, keyword string) ([]TextMatch, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FindText(src, keyword)
+}
+
+// FindText 在PDF各页的文字层中按页码和内容流顺序查找关键字的全部匹配。
+// 匹配时忽略空白字符，全角字母、数字和标点视同半角
+func FindText(src []byte, keyword string) ([]TextMatch, error) {
+	want := normalizeText(keyword)
+	if len(want) == 0 {
+		return nil, errors.New("关键字不能为空")
+	}
+	ctx, err := api.ReadContext(bytes.NewReader(src), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, fmt.Errorf("PDF解析失败: %w", err)
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		return nil, fmt.Errorf("PDF页面树解析失败: %w", err)
+	}
+	var matches []TextMatch
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		glyphs, box, err := pageGlyphs(ctx, pageNr)
+		if err != nil {
+			return nil, fmt.Errorf("第%d页文字提取失败: %w", pageNr, err)
+		}
+		for _, m := range matchGlyphs(glyphs, want) {
+			m.Page = pageNr
+			m.X -= box.LL.X
+			m.Y -= box.LL.Y
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// pageGlyphs 提取页面上的全部字形，返回字形和页面可视区域
+func pageGlyphs(ctx *model.Context, pageNr int) ([]glyph, *types.Rectangle, error) {
+	pageDict, _, attrs, err := ctx.XRefTable.PageDict(pageNr, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	box := attrs.CropBox
+	if box == nil {
+		box = attrs.MediaBox
+	}
+	if box == nil {
+		return nil, nil, fmt.Errorf("第%d页缺少MediaBox", pageNr)
+	}
+	resources := attrs.Resources
+	if o, found := pageDict.Find("Resources"); found {
+		if d, err := ctx.XRefTable.DereferenceDict(o); err == nil && d != nil {
+			resources = d
+		}
+	}
+	content, err := ctx.XRefTable.PageContent(pageDict)
+	if err != nil {
+		if errors.Is(err, model.ErrNoContent) {
+			return nil, box, nil
+		}
+		return nil, nil, err
+	}
+	e := &textExtractor{ctx: ctx, fonts: map[string]*textFont{}}
+	e.run(content, resources, graphicsState{ctm: identity, hScale: 1}, 0)
+	return e.glyphs, box, nil
+}
+
+// run 解释一段内容流，gs为进入时的图形状态
+func (e *textExtractor) run(content []byte, resources types.Dict, gs graphicsState, depth int) {
+	var stack []graphicsState
+	var operands []interface{}
+	tm, tlm := identity, identity
+	l := &contentLexer{data: content}
+	for {
+		v, err := l.next()
+		if err == io.EOF {
+			return
+		}
+		op, ok := v.(pdfKeyword)
+		if !ok || op == "true" || op == "false" || op == "null" {
+			operands = append(operands, v)
+			continue
+		}
+		nums := numbers(operands)
+		switch op {
+		case "q":
+			stack = append(stack, gs)
+		case "Q":
+			if len(stack) > 0 {
+				gs = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+		case "cm":
+			if len(nums) == 6 {
+				gs.ctm = matrix(nums).mul(gs.ctm)
+			}
+		case "BT":
+			tm, tlm = identity, identity
+		case "Tc":
+			if len(nums) == 1 {
+				gs.charSpace = nums[0]
+			}
+		case "Tw":
+			if len(nums) == 1 {
+				gs.wordSpace = nums[0]
+			}
+		case "Tz":
+			if len(nums) == 1 {
+				gs.hScale = nums[0] / 100
+			}
+		case "TL":
+			if len(nums) == 1 {
+				gs.leading = nums[0]
+			}
+		case "Ts":
+			if len(nums) == 1 {
+				gs.rise = nums[0]
+			}
+		case "Tf":
+			if len(operands) == 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					gs.font = e.font(resources, string(name))
+				}
+				if size, ok := operands[1].(float64); ok {
+					gs.size = size
+				}
+			}
+		case "Td", "TD":
+			if len(nums) == 2 {
+				if op == "TD" {
+					gs.leading = -nums[1]
+				}
+				tlm = matrix{1, 0, 0, 1, nums[0], nums[1]}.mul(tlm)
+				tm = tlm
+			}
+		case "Tm":
+			if len(nums) == 6 {
+				tlm = matrix(nums)
+				tm = tlm
+			}
+		case "T*":
+			tlm = matrix{1, 0, 0, 1, 0, -gs.leading}.mul(tlm)
+			tm = tlm
+		case "Tj":
+			if len(operands) == 1 {
+				e.showText(&tm, gs, operands[0])
+			}
+		case "'", "\"":
+			if op == "\"" && len(operands) == 3 {
+				gs.wordSpace, _ = operands[0].(float64)
+				gs.charSpace, _ = operands[1].(float64)
+				operands = operands[2:]
+			}
+			tlm = matrix{1, 0, 0, 1, 0, -gs.leading}.mul(tlm)
+			tm = tlm
+			if len(operands) == 1 {
+				e.showText(&tm, gs, operands[0])
+			}
+		case "TJ":
+			if len(operands) == 1 {
+				if arr, ok := operands[0].([]interface{}); ok {
+					for _, item := range arr {
+						if adj, ok := item.(float64); ok {
+							tm = matrix{1, 0, 0, 1, -adj / 1000 * gs.size * gs.hScale, 0}.mul(tm)
+							continue
+						}
+						e.showText(&tm, gs, item)
+					}
+				}
+			}
+		case "Do":
+			if len(operands) == 1 && depth < maxFormDepth {
+				if name, ok := operands[0].(pdfName); ok {
+					e.form(resources, string(name), gs, depth)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// showText 显示字符串，记录各字形并推进文字矩阵
+func (e *textExtractor) showText(tm *matrix, gs graphicsState, s interface{}) {
+	b, ok := s.([]byte)
+	if !ok || gs.font == nil {
+		return
+	}
+	for _, c := range gs.font.codes(b) {
+		w := gs.font.width(c.code) * gs.size
+		adv := (w + gs.charSpace) * gs.hScale
+		if c.n == 1 && c.code == 32 {
+			adv += gs.wordSpace * gs.hScale
+		}
+		trm := tm.mul(gs.ctm)
+		// 字形外接矩形取字宽和字号围成的矩形，经文字矩阵和CTM变换到页面坐标
+		g := glyph{text: gs.font.text(c), llx: math.Inf(1), lly: math.Inf(1), urx: math.Inf(-1), ury: math.Inf(-1)}
+		for _, p := range [][2]float64{{0, gs.rise}, {w * gs.hScale, gs.rise}, {0, gs.rise + gs.size}, {w * gs.hScale, gs.rise + gs.size}} {
+			x, y := trm.apply(p[0], p[1])
+			g.llx, g.lly = math.Min(g.llx, x), math.Min(g.lly, y)
+			g.urx, g.ury = math.Max(g.urx, x), math.Max(g.ury, y)
+		}
+		e.glyphs = append(e.glyphs, g)
+		*tm = matrix{1, 0, 0, 1, adv, 0}.mul(*tm)
+	}
+}
+
+// font 按资源名称返回字体，解析结果按字体对象缓存
+func (e *textExtractor) font(resources types.Dict, name string) *textFont {
+	fonts, err := e.ctx.XRefTable.DereferenceDict(resources["Font"])
+	if err != nil || fonts == nil {
+		return nil
+	}
+	o, found := fonts.Find(name)
+	if !found {
+		return nil
+	}
+	key := "/" + name
+	if ref, ok := o.(types.IndirectRef); ok {
+		key = ref.String()
+	}
+	if f, ok := e.fonts[key]; ok {
+		return f
+	}
+	f, err := loadTextFont(e.ctx, o)
+	if err != nil {
+		f = nil
+	}
+	e.fonts[key] = f
+	return f
+}
+
+// form 解释表单XObject的内容流，图片等其他XObject忽略
+func (e *textExtractor) form(resources types.Dict, name string, gs graphicsState, depth int) {
+	xobjects, err := e.ctx.XRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return
+	}
+	o, found := xobjects.Find(name)
+	if !found {
+		return
+	}
+	sd, _, err := e.ctx.XRefTable.DereferenceStreamDict(o)
+	if err != nil || sd == nil {
+		return
+	}
+	if s := sd.NameEntry("Subtype"); s == nil || *s != "Form" {
+		return
+	}
+	if err := sd.Decode(); err != nil {
+		return
+	}
+	if m, err := e.ctx.XRefTable.DereferenceArray(sd.Dict["Matrix"]); err == nil && len(m) == 6 {
+		var fm matrix
+		for i, v := range m {
+			fm[i], _ = e.ctx.XRefTable.DereferenceNumber(v)
+		}
+		gs.ctm = fm.mul(gs.ctm)
+	}
+	// 表单没有自己的资源时使用所在页面（或外层表单）的资源
+	formRes := resources
+	if d, err := e.ctx.XRefTable.DereferenceDict(sd.Dict["Resources"]); err == nil && d != nil {
+		formRes = d
+	}
+	e.run(sd.Content, formRes, gs, depth+1)
+}
+
+// numbers 操作数全部为数字时返回其值，否则返回nil
+func numbers(operands []interface{}) []float64 {
+	nums := make([]float64, 0, len(operands))
+	for _, o := range operands {
+		v, ok := o.(float64)
+		if !ok {
+			return nil
+		}
+		nums = append(nums, v)
+	}
+	return nums
+}
+
+// normalizeText 规范化用于匹配的文字：去掉空白，全角字符转为半角
+func normalizeText(s string) []rune {
+	var out []rune
+	for _, r := range s {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) || r == 0x3000 {
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// matchGlyphs 在字形序列中查找关键字，返回各处匹配的外接矩形（页面坐标），匹配之间不重叠
+func matchGlyphs(glyphs []glyph, want []rune) []TextMatch {
+	// 拼接规范化后的文字，记录每个字符所属的字形
+	var text []rune
+	var owner []int
+	for i, g := range glyphs {
+		for _, r := range normalizeText(g.text) {
+			text = append(text, r)
+			owner = append(owner, i)
+		}
+	}
+	var matches []TextMatch
+	for i := 0; i+len(want) <= len(text); {
+		if string(text[i:i+len(want)]) != string(want) {
+			i++
+			continue
+		}
+		llx, lly, urx, ury := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for j := owner[i]; j <= owner[i+len(want)-1]; j++ {
+			g := glyphs[j]
+			if strings.TrimSpace(g.text) == "" {
+				continue
+			}
+			llx, lly = math.Min(llx, g.llx), math.Min(lly, g.lly)
+			urx, ury = math.Max(urx, g.urx), math.Max(ury, g.ury)
+		}
+		matches = append(matches, TextMatch{X: llx, Y: lly, Width: urx - llx, Height: ury - lly})
+		i += len(want)
+	}
+	return matches
+}
@@ -0,0 +1,436 @@
+package pdfsign
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// pdfsign/textfont.go
+// 本文件实现了页面文字提取所需的字体解析：字符编码的切分、到Unicode的映射和字形宽度。
+// 优先使用字体的ToUnicode映射；没有时复合字体按预定义CMap（UCS2、GBK等）解码，
+// 简单字体按Encoding和Differences解码。
+
+// codeRange 编码空间范围，n为编码的字节数
+type codeRange struct {
+	n      int
+	lo, hi uint32
+}
+
+// textFont 页面文字提取使用的字体信息
+type textFont struct {
+	composite    bool               // Type0复合字体
+	codespace    []codeRange        // 编码空间，为空时复合字体按2字节、简单字体按1字节切分
+	toUnicode    map[uint32]string  // ToUnicode映射
+	ucs2         bool               // 编码CMap为UCS2/UTF16，编码值即Unicode
+	gbk          bool               // 编码CMap为GBK/EUC系列，按GBK解码
+	encoding     [256]rune          // 简单字体的编码表
+	widths       map[uint32]float64 // 字形宽度（字形空间单位）
+	defaultWidth float64            // 没有宽度信息的字形使用的宽度
+	widthScale   float64            // 字形空间到文字空间的比例，Type3字体取FontMatrix，其余为1/1000
+}
+
+// fontCode 字符串中的一个字符编码
+type fontCode struct {
+	code uint32
+	n    int // 编码的字节数
+}
+
+// 常见字形名称对应的字符，其余名称按uniXXXX、uXXXX和单字母规则解析
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$', "percent": '%',
+	"ampersand": '&', "quotesingle": '\'', "parenleft": '(', "parenright": ')', "asterisk": '*',
+	"plus": '+', "comma": ',', "hyphen": '-', "period": '.', "slash": '/', "zero": '0', "one": '1',
+	"two": '2', "three": '3', "four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8',
+	"nine": '9', "colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\', "bracketright": ']',
+	"asciicircum": '^', "underscore": '_', "grave": '`', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~', "quoteleft": '‘', "quoteright": '’', "quotedblleft": '“',
+	"quotedblright": '”', "endash": '–', "emdash": '—', "bullet": '•', "ellipsis": '…',
+}
+
+// loadTextFont 解析字体字典
+func loadTextFont(ctx *model.Context, o types.Object) (*textFont, error) {
+	d, err := ctx.XRefTable.DereferenceDict(o)
+	if err != nil {
+		return nil, err
+	}
+	f := &textFont{widths: map[uint32]float64{}, widthScale: 0.001}
+	if d == nil {
+		return f, nil
+	}
+	if o, found := d.Find("ToUnicode"); found {
+		if data, err := streamContent(ctx, o); err == nil {
+			f.toUnicode, f.codespace = parseCMap(data)
+		}
+	}
+	subtype := ""
+	if s := d.NameEntry("Subtype"); s != nil {
+		subtype = *s
+	}
+	if subtype == "Type0" {
+		f.composite = true
+		f.loadCompositeEncoding(ctx, d)
+		f.loadCIDWidths(ctx, d)
+		return f, nil
+	}
+	// 简单字体的编码固定为单字节，不使用ToUnicode中的编码空间
+	f.codespace = nil
+	if subtype == "Type3" {
+		if m, err := ctx.XRefTable.DereferenceArray(d["FontMatrix"]); err == nil && len(m) > 0 {
+			if v, err := ctx.XRefTable.DereferenceNumber(m[0]); err == nil {
+				f.widthScale = v
+			}
+		}
+	}
+	f.loadSimpleEncoding(ctx, d)
+	f.loadSimpleWidths(ctx, d)
+	return f, nil
+}
+
+// loadCompositeEncoding 解析复合字体的编码CMap，确定编码空间和无ToUnicode时的解码方式
+func (f *textFont) loadCompositeEncoding(ctx *model.Context, d types.Dict) {
+	o, found := d.Find("Encoding")
+	if !found {
+		return
+	}
+	o, err := ctx.XRefTable.Dereference(o)
+	if err != nil {
+		return
+	}
+	switch enc := o.(type) {
+	case types.Name:
+		name := enc.Value()
+		switch {
+		case strings.HasPrefix(name, "Identity"):
+			f.codespace = []codeRange{{n: 2, lo: 0, hi: 0xFFFF}}
+		case strings.Contains(name, "UCS2") || strings.Contains(name, "UTF16"):
+			f.ucs2 = true
+			f.codespace = []codeRange{{n: 2, lo: 0, hi: 0xFFFF}}
+		case strings.Contains(name, "EUC") || strings.HasPrefix(name, "GBK"):
+			f.gbk = true
+			f.codespace = []codeRange{{n: 1, lo: 0, hi: 0x80}, {n: 2, lo: 0x8140, hi: 0xFEFE}}
+		}
+	case types.StreamDict:
+		if err := enc.Decode(); err == nil {
+			if _, cs := parseCMap(enc.Content); len(cs) > 0 {
+				f.codespace = cs
+			}
+		}
+	}
+}
+
+// loadCIDWidths 解析复合字体后代字体的DW和W
+func (f *textFont) loadCIDWidths(ctx *model.Context, d types.Dict) {
+	f.defaultWidth = 1000
+	kids, err := ctx.XRefTable.DereferenceArray(d["DescendantFonts"])
+	if err != nil || len(kids) == 0 {
+		return
+	}
+	cid, err := ctx.XRefTable.DereferenceDict(kids[0])
+	if err != nil || cid == nil {
+		return
+	}
+	if o, found := cid.Find("DW"); found {
+		if v, err := ctx.XRefTable.DereferenceNumber(o); err == nil {
+			f.defaultWidth = v
+		}
+	}
+	w, err := ctx.XRefTable.DereferenceArray(cid["W"])
+	if err != nil {
+		return
+	}
+	// W的两种写法：c [w1 w2 ...] 和 cfirst clast w
+	for i := 0; i+1 < len(w); {
+		first, err := ctx.XRefTable.DereferenceNumber(w[i])
+		if err != nil {
+			return
+		}
+		next, _ := ctx.XRefTable.Dereference(w[i+1])
+		if arr, ok := next.(types.Array); ok {
+			for j, o := range arr {
+				if v, err := ctx.XRefTable.DereferenceNumber(o); err == nil {
+					f.widths[uint32(first)+uint32(j)] = v
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		last, err1 := ctx.XRefTable.DereferenceNumber(w[i+1])
+		v, err2 := ctx.XRefTable.DereferenceNumber(w[i+2])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		for c := uint32(first); c <= uint32(last) && c-uint32(first) < 0x10000; c++ {
+			f.widths[c] = v
+		}
+		i += 3
+	}
+}
+
+// loadSimpleEncoding 解析简单字体的编码表：基础编码加Differences
+func (f *textFont) loadSimpleEncoding(ctx *model.Context, d types.Dict) {
+	base := ""
+	var diffs types.Array
+	if o, found := d.Find("Encoding"); found {
+		o, _ = ctx.XRefTable.Dereference(o)
+		switch enc := o.(type) {
+		case types.Name:
+			base = enc.Value()
+		case types.Dict:
+			if s := enc.NameEntry("BaseEncoding"); s != nil {
+				base = *s
+			}
+			diffs, _ = ctx.XRefTable.DereferenceArray(enc["Differences"])
+		}
+	}
+	var cm *charmap.Charmap
+	switch base {
+	case "WinAnsiEncoding":
+		cm = charmap.Windows1252
+	case "MacRomanEncoding":
+		cm = charmap.Macintosh
+	}
+	for i := range f.encoding {
+		if cm != nil {
+			f.encoding[i] = cm.DecodeByte(byte(i))
+		} else {
+			f.encoding[i] = rune(i)
+		}
+	}
+	code := 0
+	for _, o := range diffs {
+		o, _ = ctx.XRefTable.Dereference(o)
+		switch v := o.(type) {
+		case types.Integer:
+			code = v.Value()
+		case types.Float:
+			code = int(v.Value())
+		case types.Name:
+			if code >= 0 && code < len(f.encoding) {
+				if r, ok := glyphRune(v.Value()); ok {
+					f.encoding[code] = r
+				}
+			}
+			code++
+		}
+	}
+}
+
+// loadSimpleWidths 解析简单字体的Widths，缺失的字形使用MissingWidth
+func (f *textFont) loadSimpleWidths(ctx *model.Context, d types.Dict) {
+	// 标准14种字体可以不提供宽度，按平均字宽估算
+	f.defaultWidth = 500
+	if fd, err := ctx.XRefTable.DereferenceDict(d["FontDescriptor"]); err == nil && fd != nil {
+		if o, found := fd.Find("MissingWidth"); found {
+			if v, err := ctx.XRefTable.DereferenceNumber(o); err == nil && v > 0 {
+				f.defaultWidth = v
+			}
+		}
+	}
+	widths, err := ctx.XRefTable.DereferenceArray(d["Widths"])
+	if err != nil || widths == nil {
+		return
+	}
+	first := 0
+	if o, found := d.Find("FirstChar"); found {
+		if v, err := ctx.XRefTable.DereferenceNumber(o); err == nil {
+			first = int(v)
+		}
+	}
+	for i, o := range widths {
+		if v, err := ctx.XRefTable.DereferenceNumber(o); err == nil {
+			f.widths[uint32(first+i)] = v
+		}
+	}
+}
+
+// glyphRune 按字形名称返回对应字符
+func glyphRune(name string) (rune, bool) {
+	if r, ok := glyphNames[name]; ok {
+		return r, true
+	}
+	if len(name) == 1 {
+		return rune(name[0]), true
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if strings.HasPrefix(name, prefix) && len(name) >= len(prefix)+4 {
+			if v, err := strconv.ParseUint(name[len(prefix):len(prefix)+4], 16, 32); err == nil {
+				return rune(v), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// codes 将字符串切分为字符编码
+func (f *textFont) codes(b []byte) []fontCode {
+	var codes []fontCode
+	for i := 0; i < len(b); {
+		n := f.codeLen(b[i:])
+		codes = append(codes, fontCode{code: cmapCode(b[i : i+n]), n: n})
+		i += n
+	}
+	return codes
+}
+
+// codeLen 按编码空间返回下一个编码的字节数
+func (f *textFont) codeLen(b []byte) int {
+	for _, r := range f.codespace {
+		if r.n > len(b) || r.n <= 0 {
+			continue
+		}
+		if c := cmapCode(b[:r.n]); c >= r.lo && c <= r.hi {
+			return r.n
+		}
+	}
+	n := 1
+	if f.composite {
+		n = 2
+	}
+	if n > len(b) {
+		n = len(b)
+	}
+	return n
+}
+
+// text 返回编码对应的文字，无法解码时返回替换字符
+func (f *textFont) text(c fontCode) string {
+	if s, ok := f.toUnicode[c.code]; ok {
+		return s
+	}
+	if !f.composite {
+		if c.code < 256 {
+			return string(f.encoding[c.code])
+		}
+		return "�"
+	}
+	switch {
+	case f.ucs2:
+		return string(rune(c.code))
+	case f.gbk && c.n == 1:
+		return string(rune(c.code))
+	case f.gbk:
+		if s, err := simplifiedchinese.GBK.NewDecoder().Bytes([]byte{byte(c.code >> 8), byte(c.code)}); err == nil {
+			return string(s)
+		}
+	}
+	return "�"
+}
+
+// width 返回编码对应字形在文字空间中的宽度（未乘字号）
+func (f *textFont) width(code uint32) float64 {
+	w, ok := f.widths[code]
+	if !ok {
+		w = f.defaultWidth
+	}
+	return w * f.widthScale
+}
+
+// parseCMap 解析CMap文件中的bfchar、bfrange和codespacerange
+func parseCMap(data []byte) (map[uint32]string, []codeRange) {
+	m := map[uint32]string{}
+	var space []codeRange
+	var stack []interface{}
+	l := &contentLexer{data: data}
+	for {
+		v, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		kw, ok := v.(pdfKeyword)
+		if !ok {
+			stack = append(stack, v)
+			continue
+		}
+		switch kw {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(stack); i += 2 {
+				lo, ok1 := stack[i].([]byte)
+				hi, ok2 := stack[i+1].([]byte)
+				if ok1 && ok2 && len(lo) > 0 && len(lo) <= 4 {
+					space = append(space, codeRange{n: len(lo), lo: cmapCode(lo), hi: cmapCode(hi)})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(stack); i += 2 {
+				src, ok := stack[i].([]byte)
+				if !ok {
+					continue
+				}
+				switch dst := stack[i+1].(type) {
+				case []byte:
+					m[cmapCode(src)] = utf16String(dst)
+				case pdfName:
+					if r, ok := glyphRune(string(dst)); ok {
+						m[cmapCode(src)] = string(r)
+					}
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(stack); i += 3 {
+				lo, ok1 := stack[i].([]byte)
+				hi, ok2 := stack[i+1].([]byte)
+				if !ok1 || !ok2 {
+					continue
+				}
+				start, end := cmapCode(lo), cmapCode(hi)
+				if end < start || end-start > 0xFFFF {
+					continue
+				}
+				switch dst := stack[i+2].(type) {
+				case []byte:
+					// 目标为起始值，后续编码的最后一个字符依次递增
+					runes := []rune(utf16String(dst))
+					if len(runes) == 0 {
+						continue
+					}
+					for c := start; c <= end; c++ {
+						r := append([]rune{}, runes...)
+						r[len(r)-1] += rune(c - start)
+						m[c] = string(r)
+					}
+				case []interface{}:
+					for j, o := range dst {
+						if b, ok := o.([]byte); ok && start+uint32(j) <= end {
+							m[start+uint32(j)] = utf16String(b)
+						}
+					}
+				}
+			}
+		}
+		stack = stack[:0]
+	}
+	return m, space
+}
+
+// cmapCode 将大端字节序的编码转换为整数
+func cmapCode(b []byte) uint32 {
+	var c uint32
+	for _, x := range b {
+		c = c<<8 | uint32(x)
+	}
+	return c
+}
+
+// utf16String 将UTF-16BE字节解码为字符串
+func utf16String(b []byte) string {
+	if len(b)%2 == 1 {
+		b = append(b, 0)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
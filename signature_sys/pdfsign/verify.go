@@ -0,0 +1,545 @@
+package pdfsign
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/ses"
+	"signature_sys/tsp"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// pdfsign/verify.go
+// 本文件实现了PDF数字签名的验证。
+// 对文档中的每个签名字典：校验ByteRange、按ByteRange计算摘要并与CMS中的messageDigest比对、
+// 用内嵌的签名证书校验CMS签名值，并判断签名后文档是否被修改。
+// 文档时间戳（ETSI.RFC3161）按时间戳令牌校验，并结合DSS判断签名是否具备长期验证能力。
+// 签名中带有GB/T 38540电子签章时，同时校验电子签章及其引用的电子印章。
+
+// SignatureInfo 单个签名的验证结果
+type SignatureInfo struct {
+	FieldName            string              // 签名域名称
+	Name                 string              // 签名字典中的签名人名称
+	Reason               string              // 签名原因
+	Location             string              // 签名地点
+	SubFilter            string              // 签名格式，如ETSI.CAdES.detached
+	ByteRange            []int64             // 签名覆盖的字节范围
+	SigningTime          time.Time           // 声明的签名时间（签名属性优先，其次为/M）
+	Signer               *x509.Certificate   // 签名者证书
+	Certificates         []*x509.Certificate // CMS中内嵌的全部证书
+	DigestAlgorithm      string              // 摘要算法
+	SignatureAlgorithm   string              // 签名算法
+	Signature            []byte              // 签名值
+	CoversWholeFile      bool                // 是否覆盖整个文件
+	DigestValid          bool                // 文档摘要是否与签名一致
+	SignatureValid       bool                // 签名值是否正确
+	ModifiedAfterSigning bool                // 签名后是否存在非签名类的修改
+	LaterSignaturesOnly  bool                // 签名后仅追加了其他签名
+	Timestamp            *TimestampInfo      // 签名时间戳，无时间戳时为nil；文档时间戳为其自身
+	DocTimestamp         bool                // 是否为文档时间戳（ETSI.RFC3161）
+	LTV                  bool                // DSS中是否登记了该签名的验证数据
+	ArchiveTime          time.Time           // 覆盖该签名的最早有效文档时间戳时间，没有时为零值
+	Revocation           *RevocationInfo     // 签名证书吊销检查结果，自签名证书和文档时间戳为nil
+	Seal                 *SealInfo           // 电子签章（GB/T 38540），签名中没有电子签章时为nil
+	Errors               []string            // 验证失败原因
+	Warnings             []string            // 不影响完整性的提示
+}
+
+// TimestampInfo 签名时间戳（RFC 3161）的验证结果
+type TimestampInfo struct {
+	Time         time.Time         // 时间戳时间
+	Authority    *x509.Certificate // 签发时间戳的TSA证书
+	SerialNumber *big.Int          // 时间戳令牌序列号
+	Valid        bool              // 令牌签名有效且盖在本签名的签名值上
+	Errors       []string          // 验证失败原因
+}
+
+// SealInfo 电子签章（SES_Signature）及其引用的电子印章的验证结果
+type SealInfo struct {
+	ESID       string            // 印章编码
+	Name       string            // 印章名称
+	Type       int               // 印章类型（ses.TypeOrganization/ses.TypePersonal）
+	ValidStart time.Time         // 印章有效期起始
+	ValidEnd   time.Time         // 印章有效期截止
+	Maker      *x509.Certificate // 制章人证书
+	SignTime   time.Time         // 电子签章中的签章时间
+	Valid      bool              // 制章人签名、签章人签名和原文摘要均正确，且签章人与CMS签名者一致
+	Errors     []string          // 验证失败原因
+}
+
+// Valid 签名是否完整有效（摘要、签名值正确且签名后未被修改）
+func (s *SignatureInfo) Valid() bool {
+	return s.DigestValid && s.SignatureValid && !s.ModifiedAfterSigning && len(s.Errors) == 0
+}
+
+// Report 文档验证报告
+type Report struct {
+	FileSize   int64            // 文件大小
+	Revisions  int              // 文档修订（增量更新）数量
+	Signatures []*SignatureInfo // 各签名的验证结果
+	DSS        *DSSInfo         // 文档安全存储，没有DSS时为nil
+}
+
+// VerifyFile 验证path指向的PDF中的全部签名
+func VerifyFile(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(data)
+}
+
+// Verify 验证PDF字节中的全部签名
+func Verify(data []byte) (*Report, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(data), model.NewDefaultConfiguration())
+	if err != nil {
+		return nil, fmt.Errorf("PDF解析失败: %w", err)
+	}
+	fields, err := signatureFields(ctx)
+	if err != nil {
+		return nil, err
+	}
+	revEnds := revisionEnds(data)
+	report := &Report{FileSize: int64(len(data)), Revisions: len(revEnds)}
+	// 先逐个校验签名，再根据各签名覆盖范围判断签名后的修改
+	sigEnds := map[int64]bool{}
+	for _, f := range fields {
+		info := verifySignature(ctx, data, f.name, f.sig)
+		report.Signatures = append(report.Signatures, info)
+		if len(info.ByteRange) == 4 {
+			sigEnds[info.ByteRange[2]+info.ByteRange[3]] = true
+		}
+	}
+	// 逐个检查最早的签名之后的各修订写入了哪些对象
+	from := int64(len(data))
+	for end := range sigEnds {
+		from = min(from, end)
+	}
+	revErrs := checkRevisionsAfter(data, revEnds, from)
+	for _, info := range report.Signatures {
+		if len(info.ByteRange) != 4 {
+			continue
+		}
+		checkLaterRevisions(info, revEnds, revErrs, int64(len(data)))
+	}
+	if report.DSS, err = readDSS(ctx); err != nil {
+		return nil, fmt.Errorf("DSS解析失败: %w", err)
+	}
+	for i, info := range report.Signatures {
+		if report.DSS != nil {
+			if contents, err := contentsBytes(ctx, fields[i].sig); err == nil {
+				info.LTV = report.DSS.VRI[vriKey(contents)]
+			}
+		}
+		checkArchiveTime(info, report.Signatures)
+	}
+	// 依据DSS中的吊销信息检查签名证书，需要在线查询的由调用方再次调用CheckRevocation
+	for _, info := range report.Signatures {
+		CheckRevocation(info, report.DSS, nil)
+	}
+	return report, nil
+}
+
+// checkArchiveTime 查找覆盖该签名的最早有效文档时间戳，并检查签名证书是否已过期
+func checkArchiveTime(info *SignatureInfo, all []*SignatureInfo) {
+	if len(info.ByteRange) != 4 {
+		return
+	}
+	end := info.ByteRange[2] + info.ByteRange[3]
+	for _, other := range all {
+		if !other.DocTimestamp || !other.Valid() || other == info {
+			continue
+		}
+		if other.ByteRange[2]+other.ByteRange[3] <= end {
+			continue
+		}
+		if info.ArchiveTime.IsZero() || other.SigningTime.Before(info.ArchiveTime) {
+			info.ArchiveTime = other.SigningTime
+		}
+	}
+	if info.Signer == nil || !time.Now().After(info.Signer.NotAfter) {
+		return
+	}
+	// 证书过期后，需由有效期内的时间戳证明签名产生于证书有效期内
+	proof := info.ArchiveTime
+	if ts := info.Timestamp; ts != nil && ts.Valid && (proof.IsZero() || ts.Time.Before(proof)) {
+		proof = ts.Time
+	}
+	if !proof.IsZero() && !proof.After(info.Signer.NotAfter) {
+		info.Warnings = append(info.Warnings, "签名证书已过期，时间戳证明签名产生于证书有效期内")
+	} else {
+		info.Warnings = append(info.Warnings, "签名证书已过期，且没有有效期内的时间戳")
+	}
+}
+
+// sigField 签名域及其签名字典
+type sigField struct {
+	name string
+	sig  types.Dict
+}
+
+// signatureFields 遍历AcroForm域树，收集已签名的签名域
+func signatureFields(ctx *model.Context) ([]sigField, error) {
+	root, err := ctx.XRefTable.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	o, found := root.Find("AcroForm")
+	if !found {
+		return nil, nil
+	}
+	acroForm, err := ctx.XRefTable.DereferenceDict(o)
+	if err != nil || acroForm == nil {
+		return nil, err
+	}
+	fields, err := ctx.XRefTable.DereferenceArray(acroForm["Fields"])
+	if err != nil {
+		return nil, err
+	}
+	var result []sigField
+	visited := map[int]bool{}
+	var walk func(arr types.Array, parentName, parentFT string) error
+	walk = func(arr types.Array, parentName, parentFT string) error {
+		for _, item := range arr {
+			if ref, ok := item.(types.IndirectRef); ok {
+				if visited[ref.ObjectNumber.Value()] {
+					continue
+				}
+				visited[ref.ObjectNumber.Value()] = true
+			}
+			d, err := ctx.XRefTable.DereferenceDict(item)
+			if err != nil || d == nil {
+				continue
+			}
+			name := parentName
+			if t, found := d.Find("T"); found {
+				if name != "" {
+					name += "."
+				}
+				name += textValue(t)
+			}
+			ft := parentFT
+			if n := d.NameEntry("FT"); n != nil {
+				ft = *n
+			}
+			if kids, found := d.Find("Kids"); found {
+				arr, err := ctx.XRefTable.DereferenceArray(kids)
+				if err == nil && len(arr) > 0 {
+					if err := walk(arr, name, ft); err != nil {
+						return err
+					}
+				}
+			}
+			if ft != "Sig" {
+				continue
+			}
+			v, found := d.Find("V")
+			if !found {
+				continue
+			}
+			sig, err := ctx.XRefTable.DereferenceDict(v)
+			if err != nil || sig == nil {
+				continue
+			}
+			result = append(result, sigField{name: name, sig: sig})
+		}
+		return nil
+	}
+	if err := walk(fields, "", ""); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// verifySignature 验证单个签名字典
+func verifySignature(ctx *model.Context, data []byte, fieldName string, sig types.Dict) *SignatureInfo {
+	info := &SignatureInfo{FieldName: fieldName}
+	fail := func(format string, args ...interface{}) *SignatureInfo {
+		info.Errors = append(info.Errors, fmt.Sprintf(format, args...))
+		return info
+	}
+	if v, found := sig.Find("Name"); found {
+		info.Name = textValue(v)
+	}
+	if v, found := sig.Find("Reason"); found {
+		info.Reason = textValue(v)
+	}
+	if v, found := sig.Find("Location"); found {
+		info.Location = textValue(v)
+	}
+	if n := sig.NameEntry("SubFilter"); n != nil {
+		info.SubFilter = *n
+	}
+	if v, found := sig.Find("M"); found {
+		if t, ok := types.DateTime(textValue(v), true); ok {
+			info.SigningTime = t
+		}
+	}
+	// 校验ByteRange：从0开始、中间的空隙恰好是Contents十六进制串
+	brArr, err := ctx.XRefTable.DereferenceArray(sig["ByteRange"])
+	if err != nil || len(brArr) != 4 {
+		return fail("签名字典缺少有效的ByteRange")
+	}
+	for _, o := range brArr {
+		obj, _ := ctx.XRefTable.Dereference(o)
+		i, ok := obj.(types.Integer)
+		if !ok || i < 0 {
+			return fail("ByteRange包含非法数值")
+		}
+		info.ByteRange = append(info.ByteRange, int64(i))
+	}
+	br := info.ByteRange
+	size := int64(len(data))
+	if br[0] != 0 || br[1] >= br[2] || br[2]+br[3] > size {
+		return fail("ByteRange超出文件范围或顺序错误")
+	}
+	if data[br[1]] != '<' || data[br[2]-1] != '>' {
+		return fail("ByteRange未正确排除签名值（Contents）")
+	}
+	info.CoversWholeFile = br[2]+br[3] == size
+	// 解析Contents中的CMS签名
+	contents, err := contentsBytes(ctx, sig)
+	if err != nil {
+		return fail("签名值（Contents）读取失败: %v", err)
+	}
+	switch info.SubFilter {
+	case "ETSI.RFC3161":
+		return verifyDocTimestamp(info, data, contents)
+	case "adbe.pkcs7.detached", "ETSI.CAdES.detached", "adbe.pkcs7.sha1":
+	default:
+		return fail("不支持的签名格式: %s", info.SubFilter)
+	}
+	sd, err := cms.Parse(contents)
+	if err != nil {
+		return fail("%v", err)
+	}
+	signer := sd.Signers[0]
+	info.Certificates = sd.Certificates
+	info.Signature = signer.Signature
+	info.DigestAlgorithm = cms.HashName(signer.DigestAlgorithm)
+	info.SignatureAlgorithm = signer.AlgorithmName()
+	if raw, ok := signer.UnsignedAttribute(cms.OIDAttrTimeStampToken); ok {
+		info.Timestamp = verifyTimestamp(raw.FullBytes, signer.Signature)
+	}
+	cert, err := sd.SignerCertificate(signer)
+	if err != nil {
+		return fail("%v", err)
+	}
+	info.Signer = cert
+	if t := signer.SigningTime(); !t.IsZero() {
+		info.SigningTime = t
+	}
+	h, err := signer.NewHash()
+	if err != nil {
+		return fail("%v", err)
+	}
+	// 计算ByteRange覆盖内容的摘要
+	digest := rangeDigest(data, br, h)
+	if info.SubFilter == "adbe.pkcs7.sha1" {
+		// adbe.pkcs7.sha1：封装内容为文档的SHA1摘要，签名针对封装内容
+		sum := rangeDigest(data, br, crypto.SHA1.New())
+		if !bytes.Equal(sd.Content, sum) {
+			return fail("文档摘要与签名中封装的摘要不一致，文档已被篡改")
+		}
+		h.Reset()
+		h.Write(sd.Content)
+		digest = h.Sum(nil)
+	}
+	if err := signer.VerifyDigest(digest); err != nil {
+		return fail("%v，文档已被篡改", err)
+	}
+	info.DigestValid = true
+	if err := signer.VerifySignature(cert, digest); err != nil {
+		return fail("%v", err)
+	}
+	info.SignatureValid = true
+	if found, err := signer.CheckSigningCertificate(cert); err != nil {
+		return fail("%v", err)
+	} else if !found && info.SubFilter == "ETSI.CAdES.detached" {
+		info.Warnings = append(info.Warnings, "CAdES签名缺少signing-certificate-v2属性")
+	}
+	if raw, ok := signer.Attribute(ses.OIDAttrSESSignature); ok {
+		info.Seal = VerifySeal(raw.FullBytes, digest, cert)
+	}
+	if !info.SigningTime.IsZero() && (info.SigningTime.Before(cert.NotBefore) || info.SigningTime.After(cert.NotAfter)) {
+		info.Warnings = append(info.Warnings, "声明的签名时间不在签名证书有效期内")
+	}
+	if ts := info.Timestamp; ts != nil && ts.Valid && (ts.Time.Before(cert.NotBefore) || ts.Time.After(cert.NotAfter)) {
+		info.Warnings = append(info.Warnings, "时间戳时间不在签名证书有效期内")
+	}
+	return info
+}
+
+// VerifySeal 验证电子签章：签章人签名和原文摘要、所引用电子印章的制章人签名和有效期，签章人须为CMS签名者
+func VerifySeal(der, digest []byte, signer *x509.Certificate) *SealInfo {
+	info := &SealInfo{}
+	sig, err := ses.ParseSignature(der)
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return info
+	}
+	info.SignTime = sig.ToSign.TimeInfo
+	if seal, err := sig.Seal(); err == nil {
+		p := seal.SealInfo.Property
+		info.ESID, info.Name, info.Type = seal.SealInfo.ESID, p.Name, p.Type
+		info.ValidStart, info.ValidEnd = p.ValidStart, p.ValidEnd
+		info.Maker, _ = seal.MakerCertificate()
+	}
+	if err := sig.Verify(digest); err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return info
+	}
+	if !bytes.Equal(sig.Cert, signer.Raw) {
+		info.Errors = append(info.Errors, "电子签章的签章人证书与签名证书不一致")
+		return info
+	}
+	info.Valid = true
+	return info
+}
+
+// verifyTimestamp 验证签名时间戳令牌：TSA签名有效，且消息摘要为签名值的摘要
+func verifyTimestamp(token, signature []byte) *TimestampInfo {
+	info := &TimestampInfo{}
+	ts, err := tsp.Parse(token)
+	if ts != nil {
+		info.Time = ts.Time
+		info.Authority = ts.Certificate
+		info.SerialNumber = ts.SerialNumber
+	}
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return info
+	}
+	if err := ts.Matches(signature); err != nil {
+		info.Errors = append(info.Errors, err.Error())
+		return info
+	}
+	info.Valid = true
+	return info
+}
+
+// verifyDocTimestamp 验证文档时间戳：令牌签名有效，且消息摘要为ByteRange覆盖内容的摘要
+func verifyDocTimestamp(info *SignatureInfo, data, token []byte) *SignatureInfo {
+	info.DocTimestamp = true
+	info.Timestamp = &TimestampInfo{}
+	fail := func(msg string) *SignatureInfo {
+		info.Errors = append(info.Errors, msg)
+		info.Timestamp.Errors = append(info.Timestamp.Errors, msg)
+		return info
+	}
+	ts, err := tsp.Parse(token)
+	if ts != nil {
+		info.Signer = ts.Certificate
+		info.Certificates = ts.Certificates
+		info.SigningTime = ts.Time
+		info.DigestAlgorithm = ts.HashAlgorithm.String()
+		info.Timestamp.Time = ts.Time
+		info.Timestamp.Authority = ts.Certificate
+		info.Timestamp.SerialNumber = ts.SerialNumber
+	}
+	if err != nil {
+		return fail(err.Error())
+	}
+	info.SignatureValid = true
+	if !bytes.Equal(rangeDigest(data, info.ByteRange, ts.HashAlgorithm.New()), ts.HashedMessage) {
+		return fail("文档摘要与文档时间戳不一致，文档已被篡改")
+	}
+	info.DigestValid = true
+	info.Timestamp.Valid = true
+	return info
+}
+
+// checkLaterRevisions 判断签名之后的修订是否只追加了其他签名
+// 之后的每个修订都只能写入签名、表单签名域、DSS和文档时间戳相关的对象（见revision.go）
+func checkLaterRevisions(info *SignatureInfo, revEnds []int64, revErrs map[int64]error, size int64) {
+	end := info.ByteRange[2] + info.ByteRange[3]
+	if end == size {
+		return
+	}
+	later := false
+	for _, r := range revEnds {
+		if r <= end {
+			continue
+		}
+		later = true
+		if err := revErrs[r]; err != nil && !info.ModifiedAfterSigning {
+			info.ModifiedAfterSigning = true
+			info.Errors = append(info.Errors, fmt.Sprintf("签名后文档被修改（%v）", err))
+		}
+	}
+	// 文件末尾之后存在未以%%EOF结尾的附加数据
+	if !info.ModifiedAfterSigning && (len(revEnds) == 0 || revEnds[len(revEnds)-1] < size) {
+		info.ModifiedAfterSigning = true
+		info.Errors = append(info.Errors, "签名后文档被修改（存在非签名的增量更新）")
+	}
+	if !info.ModifiedAfterSigning && later {
+		info.LaterSignaturesOnly = true
+		info.Warnings = append(info.Warnings, "签名后文档追加了其他签名")
+	}
+}
+
+// revisionEnds 返回每个修订（以%%EOF结尾）的结束偏移
+func revisionEnds(data []byte) []int64 {
+	var ends []int64
+	marker := []byte("%%EOF")
+	pos := 0
+	for {
+		i := bytes.Index(data[pos:], marker)
+		if i < 0 {
+			break
+		}
+		end := pos + i + len(marker)
+		for end < len(data) && (data[end] == '\r' || data[end] == '\n') {
+			end++
+		}
+		ends = append(ends, int64(end))
+		pos = end
+	}
+	return ends
+}
+
+// rangeDigest 计算ByteRange覆盖内容的摘要
+func rangeDigest(data []byte, br []int64, h hash.Hash) []byte {
+	for i := 0; i+1 < len(br); i += 2 {
+		h.Write(data[br[i] : br[i]+br[i+1]])
+	}
+	return h.Sum(nil)
+}
+
+// contentsBytes 读取签名字典中Contents的原始字节
+func contentsBytes(ctx *model.Context, sig types.Dict) ([]byte, error) {
+	o, err := ctx.XRefTable.Dereference(sig["Contents"])
+	if err != nil {
+		return nil, err
+	}
+	switch v := o.(type) {
+	case types.HexLiteral:
+		return v.Bytes()
+	case types.StringLiteral:
+		return types.Unescape(v.Value())
+	}
+	return nil, errors.New("签名字典缺少Contents")
+}
+
+// Summary 返回签名验证结论的简要描述
+func (s *SignatureInfo) Summary() string {
+	if s.Valid() {
+		return "签名有效"
+	}
+	if len(s.Errors) > 0 {
+		return strings.Join(s.Errors, "；")
+	}
+	return "签名无效"
+}
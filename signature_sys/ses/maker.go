@@ -0,0 +1,88 @@
+package ses
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// ses/maker.go
+// 本文件实现了制章人：持有制章人证书和私钥，为用户制作电子印章。
+// 制章人密钥由系统管理员保管在服务端，首次启动时生成SM2密钥并由国密签发CA签发制章人证书。
+
+// Maker 制章人
+type Maker struct {
+	Signer      crypto.Signer     // 制章人私钥
+	Certificate *x509.Certificate // 制章人证书
+}
+
+// LoadOrCreateMaker 从证书和私钥文件加载制章人，文件不存在时生成新的SM2密钥，由issuer签发制章人证书
+func LoadOrCreateMaker(certPath, keyPath string, issuer *x509.Certificate, issuerKey crypto.Signer) (*Maker, error) {
+	if _, err := os.Stat(certPath); err == nil {
+		cert, err := utils.LoadCertificate(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取制章人证书失败: %w", err)
+		}
+		key, err := utils.LoadPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取制章人私钥失败: %w", err)
+		}
+		return &Maker{Signer: key, Certificate: cert}, nil
+	}
+	key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Signature System Seal Maker", Organization: []string{"Signature System"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+	}
+	if tmpl.NotAfter.After(issuer.NotAfter) {
+		tmpl.NotAfter = issuer.NotAfter
+	}
+	der, err := smx509.CreateCertificate(rand.Reader, &tmpl, issuer, key.Public(), issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := utils.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := smx509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	return &Maker{Signer: key, Certificate: cert}, nil
+}
+
+// CreateSeal 制作电子印章，返回SES_Seal的DER编码
+func (m *Maker) CreateSeal(p SealParams) ([]byte, error) {
+	return CreateSeal(p, m.Signer, m.Certificate)
+}
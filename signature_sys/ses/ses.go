@@ -0,0 +1,412 @@
+package ses
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/sm3"
+)
+
+// ses/ses.go
+// 本文件实现了GB/T 38540（GM/T 0031）安全电子签章的数据格式：电子印章（SES_Seal）和电子签章（SES_Signature）。
+// 电子印章由制章人签名，绑定印章图片、印章名称、有效期和印章所有者的签名证书；
+// 电子签章由签章人对原文摘要和所用电子印章签名。SM2证书使用SM2-with-SM3，其他证书使用SHA256。
+
+// Version 本实现生成的电子印章和电子签章数据格式版本（GB/T 38540为第4版）
+const Version = 4
+
+// VendorID 电子印章头中的厂商标识
+const VendorID = "signature_sys"
+
+// OIDAttrSESSignature PDF签名中承载电子签章的CMS签名属性（2.999为示例弧，正式部署时应替换为机构自有的OID）
+var OIDAttrSESSignature = asn1.ObjectIdentifier{2, 999, 38540, 1}
+
+// 印章类型（SES_ESPropertyInfo.type）
+const (
+	TypeOrganization = 1 // 单位印章
+	TypePersonal     = 2 // 个人印章
+)
+
+// 证书列表类型（SES_ESPropertyInfo.certListType）
+const (
+	CertListCerts   = 1 // 签章人证书列表
+	CertListDigests = 2 // 签章人证书摘要列表
+)
+
+// Header 电子印章头（SES_Header）
+type Header struct {
+	ID      string `asn1:"ia5"` // 固定为"ES"
+	Version int    // 版本号
+	VID     string `asn1:"ia5"` // 厂商标识
+}
+
+// PropertyInfo 印章属性（SES_ESPropertyInfo）
+type PropertyInfo struct {
+	Type         int           // 印章类型
+	Name         string        `asn1:"utf8"` // 印章名称
+	CertListType int           // 证书列表类型
+	CertList     asn1.RawValue // 签章人证书列表（SES_CertList）
+	CreateDate   time.Time     `asn1:"generalized"` // 制章日期
+	ValidStart   time.Time     `asn1:"generalized"` // 有效期起始
+	ValidEnd     time.Time     `asn1:"generalized"` // 有效期截止
+}
+
+// PictureInfo 印章图片（SES_ESPictrueInfo）
+type PictureInfo struct {
+	Type   string `asn1:"ia5"` // 图片类型，如png、jpg、gif
+	Data   []byte // 图片数据
+	Width  int    // 显示宽度（毫米）
+	Height int    // 显示高度（毫米）
+}
+
+// SealInfo 印章信息（SES_SealInfo）
+type SealInfo struct {
+	Raw      asn1.RawContent
+	Header   Header
+	ESID     string        `asn1:"ia5"` // 印章编码
+	Property PropertyInfo  // 印章属性
+	Picture  PictureInfo   // 印章图片
+	ExtDatas asn1.RawValue `asn1:"optional"` // 自定义数据
+}
+
+// Seal 电子印章（SES_Seal）
+type Seal struct {
+	Raw         asn1.RawContent
+	SealInfo    SealInfo
+	Cert        []byte                // 制章人证书（DER）
+	SignAlgID   asn1.ObjectIdentifier // 签名算法
+	SignedValue asn1.BitString        // 制章人对印章信息的签名值
+}
+
+// certDigest 证书摘要（CertDigestObj）
+type certDigest struct {
+	Type  string `asn1:"printable"` // 摘要算法名称
+	Value []byte
+}
+
+// TBSSign 待签名的电子签章数据（TBS_Sign）
+type TBSSign struct {
+	Raw          asn1.RawContent
+	Version      int
+	ESeal        asn1.RawValue  // 所用电子印章（SES_Seal）
+	TimeInfo     time.Time      `asn1:"generalized"` // 签章时间
+	DataHash     asn1.BitString // 原文摘要
+	PropertyInfo string         `asn1:"ia5"`      // 原文属性
+	ExtDatas     asn1.RawValue  `asn1:"optional"` // 自定义数据
+}
+
+// Signature 电子签章（SES_Signature）
+type Signature struct {
+	Raw            asn1.RawContent
+	ToSign         TBSSign
+	Cert           []byte                // 签章人证书（DER）
+	SignatureAlgID asn1.ObjectIdentifier // 签名算法
+	Signature      asn1.BitString        // 签章人签名值
+	TimeStamp      asn1.BitString        `asn1:"optional,tag:0"` // 对签名值的时间戳（可选）
+}
+
+// signedData 签名覆盖的数据：待签名内容、签名人证书和签名算法
+type signedData struct {
+	Content asn1.RawValue
+	Cert    []byte
+	AlgID   asn1.ObjectIdentifier
+}
+
+// SealParams 制作电子印章的参数
+type SealParams struct {
+	ESID       string              // 印章编码（ASCII）
+	Type       int                 // 印章类型
+	Name       string              // 印章名称
+	Certs      []*x509.Certificate // 允许使用该印章签章的证书
+	ValidStart time.Time           // 有效期起始
+	ValidEnd   time.Time           // 有效期截止
+	Image      []byte              // 印章图片
+	ImageType  string              // 图片类型
+	Width      int                 // 显示宽度（毫米）
+	Height     int                 // 显示高度（毫米）
+}
+
+// CreateSeal 由制章人制作电子印章，返回SES_Seal的DER编码
+func CreateSeal(p SealParams, maker crypto.Signer, makerCert *x509.Certificate) ([]byte, error) {
+	if len(p.Certs) == 0 {
+		return nil, errors.New("电子印章至少需要绑定一个签章人证书")
+	}
+	if !p.ValidEnd.After(p.ValidStart) {
+		return nil, errors.New("印章有效期截止时间须晚于起始时间")
+	}
+	var certs [][]byte
+	for _, c := range p.Certs {
+		certs = append(certs, c.Raw)
+	}
+	certList, err := asn1.Marshal(certs)
+	if err != nil {
+		return nil, err
+	}
+	info := SealInfo{
+		Header: Header{ID: "ES", Version: Version, VID: VendorID},
+		ESID:   p.ESID,
+		Property: PropertyInfo{
+			Type:         p.Type,
+			Name:         p.Name,
+			CertListType: CertListCerts,
+			CertList:     asn1.RawValue{FullBytes: certList},
+			CreateDate:   time.Now().UTC().Truncate(time.Second),
+			ValidStart:   p.ValidStart.UTC().Truncate(time.Second),
+			ValidEnd:     p.ValidEnd.UTC().Truncate(time.Second),
+		},
+		Picture: PictureInfo{Type: p.ImageType, Data: p.Image, Width: p.Width, Height: p.Height},
+	}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, fmt.Errorf("印章信息编码失败: %w", err)
+	}
+	alg, sig, err := sign(maker, asn1.RawValue{FullBytes: infoDER}, makerCert.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("制章人签名失败: %w", err)
+	}
+	return asn1.Marshal(Seal{
+		SealInfo:    SealInfo{Raw: infoDER},
+		Cert:        makerCert.Raw,
+		SignAlgID:   alg,
+		SignedValue: asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+}
+
+// ParseSeal 解析DER编码的电子印章
+func ParseSeal(der []byte) (*Seal, error) {
+	var s Seal
+	rest, err := asn1.Unmarshal(der, &s)
+	if err != nil {
+		return nil, fmt.Errorf("电子印章格式错误: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("电子印章后存在多余数据")
+	}
+	return &s, nil
+}
+
+// MakerCertificate 返回制章人证书
+func (s *Seal) MakerCertificate() (*x509.Certificate, error) {
+	return utils.ParseCertificate(s.Cert)
+}
+
+// Verify 校验电子印章：印章头、制章人签名，以及at是否在印章有效期内
+func (s *Seal) Verify(at time.Time) error {
+	if s.SealInfo.Header.ID != "ES" {
+		return errors.New("电子印章头标识错误")
+	}
+	maker, err := s.MakerCertificate()
+	if err != nil {
+		return fmt.Errorf("制章人证书解析失败: %w", err)
+	}
+	if err := verify(maker, s.SignAlgID, asn1.RawValue{FullBytes: s.SealInfo.Raw}, s.Cert, s.SignedValue.RightAlign()); err != nil {
+		return fmt.Errorf("制章人签名无效: %w", err)
+	}
+	p := s.SealInfo.Property
+	if at.Before(p.ValidStart) || at.After(p.ValidEnd) {
+		return errors.New("签章时间不在电子印章有效期内")
+	}
+	return nil
+}
+
+// Allows 判断印章的证书列表是否包含cert
+func (s *Seal) Allows(cert *x509.Certificate) (bool, error) {
+	p := s.SealInfo.Property
+	switch p.CertListType {
+	case CertListCerts:
+		var certs [][]byte
+		if _, err := asn1.Unmarshal(p.CertList.FullBytes, &certs); err != nil {
+			return false, errors.New("电子印章证书列表格式错误")
+		}
+		for _, c := range certs {
+			if bytes.Equal(c, cert.Raw) {
+				return true, nil
+			}
+		}
+	case CertListDigests:
+		var digests []certDigest
+		if _, err := asn1.Unmarshal(p.CertList.FullBytes, &digests); err != nil {
+			return false, errors.New("电子印章证书列表格式错误")
+		}
+		for _, d := range digests {
+			var sum []byte
+			switch d.Type {
+			case "SM3", "sm3":
+				s := sm3.Sum(cert.Raw)
+				sum = s[:]
+			case "SHA256", "sha256":
+				s := sha256.Sum256(cert.Raw)
+				sum = s[:]
+			}
+			if sum != nil && bytes.Equal(sum, d.Value) {
+				return true, nil
+			}
+		}
+	default:
+		return false, fmt.Errorf("不支持的证书列表类型: %d", p.CertListType)
+	}
+	return false, nil
+}
+
+// Sign 用电子印章对原文摘要dataHash生成电子签章，返回SES_Signature的DER编码
+// 签章人证书须在印章的证书列表中，签章时间须在印章有效期内；propertyInfo为原文属性（如签名域名称）
+func Sign(sealDER, dataHash []byte, propertyInfo string, signer crypto.Signer, cert *x509.Certificate, at time.Time) ([]byte, error) {
+	seal, err := ParseSeal(sealDER)
+	if err != nil {
+		return nil, err
+	}
+	if err := seal.Verify(at); err != nil {
+		return nil, err
+	}
+	if ok, err := seal.Allows(cert); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("签名证书不在电子印章绑定的证书列表中")
+	}
+	tbs, err := asn1.Marshal(TBSSign{
+		Version:      Version,
+		ESeal:        asn1.RawValue{FullBytes: sealDER},
+		TimeInfo:     at.UTC().Truncate(time.Second),
+		DataHash:     asn1.BitString{Bytes: dataHash, BitLength: len(dataHash) * 8},
+		PropertyInfo: propertyInfo,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("电子签章编码失败: %w", err)
+	}
+	alg, sig, err := sign(signer, asn1.RawValue{FullBytes: tbs}, cert.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("签章人签名失败: %w", err)
+	}
+	return asn1.Marshal(Signature{
+		ToSign:         TBSSign{Raw: tbs},
+		Cert:           cert.Raw,
+		SignatureAlgID: alg,
+		Signature:      asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+}
+
+// ParseSignature 解析DER编码的电子签章
+func ParseSignature(der []byte) (*Signature, error) {
+	var s Signature
+	rest, err := asn1.Unmarshal(der, &s)
+	if err != nil {
+		return nil, fmt.Errorf("电子签章格式错误: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("电子签章后存在多余数据")
+	}
+	return &s, nil
+}
+
+// Seal 返回电子签章引用的电子印章
+func (s *Signature) Seal() (*Seal, error) {
+	return ParseSeal(s.ToSign.ESeal.FullBytes)
+}
+
+// SignerCertificate 返回签章人证书
+func (s *Signature) SignerCertificate() (*x509.Certificate, error) {
+	return utils.ParseCertificate(s.Cert)
+}
+
+// Verify 校验电子签章：签章人签名、原文摘要与dataHash一致、签章人证书在印章证书列表中，
+// 以及所引用的电子印章在签章时间有效
+func (s *Signature) Verify(dataHash []byte) error {
+	cert, err := s.SignerCertificate()
+	if err != nil {
+		return fmt.Errorf("签章人证书解析失败: %w", err)
+	}
+	if err := verify(cert, s.SignatureAlgID, asn1.RawValue{FullBytes: s.ToSign.Raw}, s.Cert, s.Signature.RightAlign()); err != nil {
+		return fmt.Errorf("签章人签名无效: %w", err)
+	}
+	if !bytes.Equal(s.ToSign.DataHash.RightAlign(), dataHash) {
+		return errors.New("电子签章中的原文摘要与文档不一致")
+	}
+	seal, err := s.Seal()
+	if err != nil {
+		return err
+	}
+	if err := seal.Verify(s.ToSign.TimeInfo); err != nil {
+		return err
+	}
+	if ok, err := seal.Allows(cert); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("签章人证书不在电子印章绑定的证书列表中")
+	}
+	return nil
+}
+
+// sign 对content、签名人证书和签名算法组成的数据签名，返回签名算法和签名值
+func sign(signer crypto.Signer, content asn1.RawValue, cert []byte) (asn1.ObjectIdentifier, []byte, error) {
+	pub := signer.Public()
+	var alg asn1.ObjectIdentifier
+	switch {
+	case utils.IsSM2PublicKey(pub):
+		alg = cms.OIDSignatureSM2WithSM3
+	case isECDSA(pub):
+		alg = cms.OIDSignatureECDSASHA256
+	case isRSA(pub):
+		alg = cms.OIDSignatureSHA256WithRSA
+	default:
+		return nil, nil, errors.New("不支持的签名密钥类型")
+	}
+	tbs, err := asn1.Marshal(signedData{Content: content, Cert: cert, AlgID: alg})
+	if err != nil {
+		return nil, nil, err
+	}
+	if alg.Equal(cms.OIDSignatureSM2WithSM3) {
+		e, err := utils.SM2Digest(pub, tbs)
+		if err != nil {
+			return nil, nil, err
+		}
+		sig, err := signer.Sign(rand.Reader, e, utils.SM2SignerOpts{})
+		return alg, sig, err
+	}
+	sum := sha256.Sum256(tbs)
+	sig, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+	return alg, sig, err
+}
+
+// verify 用证书公钥校验sign生成的签名值
+func verify(cert *x509.Certificate, alg asn1.ObjectIdentifier, content asn1.RawValue, certDER, sig []byte) error {
+	tbs, err := asn1.Marshal(signedData{Content: content, Cert: certDER, AlgID: alg})
+	if err != nil {
+		return err
+	}
+	switch {
+	case alg.Equal(cms.OIDSignatureSM2WithSM3):
+		if !utils.IsSM2PublicKey(cert.PublicKey) || !utils.VerifySM2(cert.PublicKey, tbs, sig) {
+			return errors.New("SM2签名值校验失败")
+		}
+		return nil
+	case alg.Equal(cms.OIDSignatureECDSASHA256):
+		return cert.CheckSignature(x509.ECDSAWithSHA256, tbs, sig)
+	case alg.Equal(cms.OIDSignatureSHA256WithRSA):
+		return cert.CheckSignature(x509.SHA256WithRSA, tbs, sig)
+	}
+	return fmt.Errorf("不支持的签名算法: %s", alg)
+}
+
+// isECDSA 判断是否为ECDSA公钥
+func isECDSA(pub crypto.PublicKey) bool {
+	_, ok := pub.(*ecdsa.PublicKey)
+	return ok
+}
+
+// isRSA 判断是否为RSA公钥
+func isRSA(pub crypto.PublicKey) bool {
+	_, ok := pub.(*rsa.PublicKey)
+	return ok
+}
@@ -0,0 +1,51 @@
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"path/filepath"
+	"signature_sys/utils"
+)
+
+// signer/file.go
+// 本文件实现了本地文件密钥后端：私钥以用户签名PIN码加密的PKCS#8文件保存在私钥目录中，
+// 密钥引用为私钥文件路径，签名时解密到内存，签名结束即丢弃。
+
+// File 本地文件密钥后端
+type File struct {
+	Dir string // 私钥文件目录
+}
+
+// Name 实现Provider
+func (f *File) Name() string { return BackendFile }
+
+// GenerateKey 生成私钥并以PIN码加密保存为<label>_private.pem
+func (f *File) GenerateKey(algo, label, pin string) (string, crypto.PublicKey, error) {
+	key, err := GenerateSoftwareKey(algo)
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(f.Dir, label+"_private.pem")
+	if err := utils.SaveEncryptedPrivateKey(path, key, pin); err != nil {
+		return "", nil, fmt.Errorf("私钥保存失败: %w", err)
+	}
+	return path, key.Public(), nil
+}
+
+// Open 用PIN码解密私钥文件；早期未加密保存的私钥在解密成功后改为加密保存
+func (f *File) Open(ref, pin string) (Signer, error) {
+	encrypted, err := utils.IsEncryptedPrivateKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	key, err := utils.LoadEncryptedPrivateKey(ref, pin)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		if err := utils.SaveEncryptedPrivateKey(ref, key, pin); err != nil {
+			fmt.Println("[Signer] 私钥加密保存失败:", err)
+		}
+	}
+	return nopCloser{key}, nil
+}
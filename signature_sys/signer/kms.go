@@ -0,0 +1,154 @@
+package signer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// signer/kms.go
+// 本文件实现了远程密钥管理服务（KMS）后端，私钥保存在KMS中，签名时只把摘要发送给KMS。
+// KMS接口（JSON，Authorization: Bearer <Token>）：
+//   POST {URL}/keys                 {"algorithm":"ECC","label":"..."} -> {"key_id":"...","public_key":"<SPKI DER的Base64>"}
+//   GET  {URL}/keys/{key_id}        -> {"key_id":"...","public_key":"..."}
+//   POST {URL}/keys/{key_id}/sign   {"digest":"<Base64>","hash":"SHA256"} -> {"signature":"<Base64>"}
+// ECDSA和SM2签名值为ASN.1 DER编码，RSA签名采用PKCS#1 v1.5。SM2签名的hash为"SM3"，digest为SM3(Z_A‖M)。
+// signer/kmsmock提供了该接口的模拟实现。
+
+// KMS 远程密钥管理服务后端
+type KMS struct {
+	URL        string       // 服务地址
+	Token      string       // 访问令牌
+	HTTPClient *http.Client // 为nil时使用30秒超时的默认客户端
+}
+
+// KMSKey KMS密钥信息
+type KMSKey struct {
+	KeyID     string `json:"key_id"`
+	PublicKey []byte `json:"public_key"`
+}
+
+// KMSSignRequest KMS签名请求
+type KMSSignRequest struct {
+	Digest []byte `json:"digest"`
+	Hash   string `json:"hash"`
+}
+
+// KMSSignResponse KMS签名响应
+type KMSSignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+// KMSError KMS错误响应
+type KMSError struct {
+	Error string `json:"error"`
+}
+
+// Name 实现Provider
+func (k *KMS) Name() string { return BackendKMS }
+
+// GenerateKey 在KMS中生成密钥，密钥引用为KMS返回的key_id
+func (k *KMS) GenerateKey(algo, label, pin string) (string, crypto.PublicKey, error) {
+	var key KMSKey
+	if err := k.call(http.MethodPost, "/keys", map[string]string{"algorithm": algo, "label": label}, &key); err != nil {
+		return "", nil, err
+	}
+	pub, err := smx509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("KMS返回的公钥格式错误: %w", err)
+	}
+	return key.KeyID, pub, nil
+}
+
+// Open 读取KMS密钥的公钥，返回通过KMS签名的Signer
+func (k *KMS) Open(ref, pin string) (Signer, error) {
+	var key KMSKey
+	if err := k.call(http.MethodGet, "/keys/"+url.PathEscape(ref), nil, &key); err != nil {
+		return nil, err
+	}
+	pub, err := smx509.ParsePKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("KMS返回的公钥格式错误: %w", err)
+	}
+	return &kmsSigner{kms: k, keyID: ref, pub: pub}, nil
+}
+
+// call 调用KMS接口
+func (k *KMS) call(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(k.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if k.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.Token)
+	}
+	client := k.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("KMS请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e KMSError
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("KMS返回错误: %s", e.Error)
+		}
+		return fmt.Errorf("KMS返回HTTP %d", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// kmsSigner 通过KMS签名的密钥
+type kmsSigner struct {
+	kms   *KMS
+	keyID string
+	pub   crypto.PublicKey
+}
+
+func (s *kmsSigner) Public() crypto.PublicKey { return s.pub }
+
+func (s *kmsSigner) Close() error { return nil }
+
+// Sign 把摘要发送给KMS签名
+func (s *kmsSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("KMS后端不支持RSA-PSS签名")
+	}
+	var resp KMSSignResponse
+	req := KMSSignRequest{Digest: digest, Hash: opts.HashFunc().String()}
+	if _, ok := opts.(utils.SM2SignerOpts); ok {
+		req.Hash = "SM3"
+	}
+	if err := s.kms.call(http.MethodPost, "/keys/"+url.PathEscape(s.keyID)+"/sign", req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
@@ -0,0 +1,133 @@
+package kmsmock
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"signature_sys/signer"
+	"signature_sys/utils"
+	"strings"
+	"sync"
+
+	"github.com/emmansun/gmsm/smx509"
+	"github.com/google/uuid"
+)
+
+// signer/kmsmock/kmsmock.go
+// 本文件实现了KMS接口的内存模拟服务，用于本地联调和测试KMS后端，密钥只保存在内存中。
+// 可通过httptest.NewServer(kmsmock.New(token))启动，或运行cmd/kmsmock。
+
+// Server KMS模拟服务
+type Server struct {
+	Token string // 访问令牌，为空时不校验
+	mu    sync.Mutex
+	keys  map[string]crypto.Signer
+}
+
+// New 创建KMS模拟服务
+func New(token string) *Server {
+	return &Server{Token: token, keys: map[string]crypto.Signer{}}
+}
+
+// ServeHTTP 处理KMS接口请求
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "访问令牌无效")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "keys" && r.Method == http.MethodPost:
+		s.generate(w, r)
+	case len(parts) == 2 && parts[0] == "keys" && r.Method == http.MethodGet:
+		s.get(w, parts[1])
+	case len(parts) == 3 && parts[0] == "keys" && parts[2] == "sign" && r.Method == http.MethodPost:
+		s.sign(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, "接口不存在")
+	}
+}
+
+// generate 生成密钥
+func (s *Server) generate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Algorithm string `json:"algorithm"`
+		Label     string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+	key, err := signer.GenerateSoftwareKey(req.Algorithm)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := uuid.New().String()
+	s.mu.Lock()
+	s.keys[id] = key
+	s.mu.Unlock()
+	s.get(w, id)
+}
+
+// get 返回密钥的公钥
+func (s *Server) get(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	key, ok := s.keys[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "密钥不存在")
+		return
+	}
+	pub, err := smx509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(signer.KMSKey{KeyID: id, PublicKey: pub})
+}
+
+// sign 对摘要签名
+func (s *Server) sign(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	key, ok := s.keys[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "密钥不存在")
+		return
+	}
+	var req signer.KMSSignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "请求格式错误")
+		return
+	}
+	var opts crypto.SignerOpts
+	size := 0
+	for _, h := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if h.String() == req.Hash {
+			opts, size = h, h.Size()
+		}
+	}
+	// SM2签名的摘要为SM3(Z_A‖M)，直接签名
+	if req.Hash == "SM3" && utils.IsSM2PublicKey(key.Public()) {
+		opts, size = utils.SM2SignerOpts{}, 32
+	}
+	if opts == nil || len(req.Digest) != size {
+		writeError(w, http.StatusBadRequest, "不支持的摘要算法或摘要长度错误")
+		return
+	}
+	sig, err := key.Sign(rand.Reader, req.Digest, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	json.NewEncoder(w).Encode(signer.KMSSignResponse{Signature: sig})
+}
+
+// writeError 返回JSON错误响应
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(signer.KMSError{Error: msg})
+}
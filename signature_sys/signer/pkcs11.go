@@ -0,0 +1,22 @@
+package signer
+
+import "sync"
+
+// signer/pkcs11.go
+// 本文件定义了PKCS#11密钥后端，私钥在HSM（或SoftHSM等软件令牌）中生成并保存，不可导出，
+// 签名运算在令牌内完成。密钥引用为私钥对象CKA_ID的十六进制编码。
+// 访问PKCS#11模块需要cgo，未启用cgo编译时该后端不可用（见pkcs11_cgo.go和pkcs11_nocgo.go）。
+
+// PKCS11 PKCS#11密钥后端
+type PKCS11 struct {
+	Module     string // PKCS#11模块路径，如/usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string // 令牌标签
+	PIN        string // 令牌用户PIN码（由运维配置，与用户签名PIN码无关）
+
+	once  sync.Once
+	state *pkcs11State
+	err   error
+}
+
+// Name 实现Provider
+func (p *PKCS11) Name() string { return BackendPKCS11 }
@@ -0,0 +1,283 @@
+//go:build cgo
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs11State 已初始化并登录的令牌
+// 登录状态对应用的全部会话有效，loginSession在程序运行期间保持打开
+type pkcs11State struct {
+	ctx          *pkcs11.Ctx
+	slot         uint
+	loginSession pkcs11.SessionHandle
+}
+
+// P-256曲线OID的DER编码（CKA_EC_PARAMS）
+var p256Params, _ = asn1.Marshal(asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7})
+
+// RSA PKCS#1 v1.5签名需要由调用方拼接的DigestInfo前缀
+var digestInfoPrefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// init 加载PKCS#11模块，按标签查找令牌并登录，只执行一次
+func (p *PKCS11) init() (*pkcs11State, error) {
+	p.once.Do(func() {
+		ctx := pkcs11.New(p.Module)
+		if ctx == nil {
+			p.err = fmt.Errorf("无法加载PKCS#11模块: %s", p.Module)
+			return
+		}
+		if err := ctx.Initialize(); err != nil {
+			p.err = fmt.Errorf("PKCS#11模块初始化失败: %w", err)
+			return
+		}
+		slots, err := ctx.GetSlotList(true)
+		if err != nil {
+			p.err = err
+			return
+		}
+		for _, slot := range slots {
+			info, err := ctx.GetTokenInfo(slot)
+			if err != nil || info.Label != p.TokenLabel {
+				continue
+			}
+			session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+			if err != nil {
+				p.err = err
+				return
+			}
+			if err := ctx.Login(session, pkcs11.CKU_USER, p.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+				ctx.CloseSession(session)
+				p.err = fmt.Errorf("PKCS#11令牌登录失败: %w", err)
+				return
+			}
+			p.state = &pkcs11State{ctx: ctx, slot: slot, loginSession: session}
+			return
+		}
+		p.err = fmt.Errorf("未找到标签为%q的PKCS#11令牌", p.TokenLabel)
+	})
+	return p.state, p.err
+}
+
+// GenerateKey 在令牌中生成不可导出的签名密钥对
+func (p *PKCS11) GenerateKey(algo, label, pin string) (string, crypto.PublicKey, error) {
+	st, err := p.init()
+	if err != nil {
+		return "", nil, err
+	}
+	session, err := st.ctx.OpenSession(st.slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		return "", nil, err
+	}
+	defer st.ctx.CloseSession(session)
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	pubTmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	privTmpl := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	var mech *pkcs11.Mechanism
+	switch algo {
+	case AlgoECC:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)
+		pubTmpl = append(pubTmpl, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC), pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, p256Params))
+		privTmpl = append(privTmpl, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC))
+	case AlgoRSA:
+		mech = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)
+		pubTmpl = append(pubTmpl, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, 2048), pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+		privTmpl = append(privTmpl, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA))
+	case AlgoSM2:
+		// SM2密钥生成和签名机制是各厂商的扩展，PKCS#11标准中没有定义
+		return "", nil, errors.New("PKCS#11后端暂不支持SM2密钥")
+	default:
+		return "", nil, fmt.Errorf("不支持的密钥算法: %s", algo)
+	}
+	pubHandle, _, err := st.ctx.GenerateKeyPair(session, []*pkcs11.Mechanism{mech}, pubTmpl, privTmpl)
+	if err != nil {
+		return "", nil, fmt.Errorf("PKCS#11生成密钥失败: %w", err)
+	}
+	pub, err := readPublicKey(st.ctx, session, pubHandle)
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(id), pub, nil
+}
+
+// Open 打开令牌中的私钥，每个Signer使用独立的会话
+func (p *PKCS11) Open(ref, pin string) (Signer, error) {
+	st, err := p.init()
+	if err != nil {
+		return nil, err
+	}
+	id, err := hex.DecodeString(ref)
+	if err != nil {
+		return nil, errors.New("PKCS#11密钥引用格式错误")
+	}
+	session, err := st.ctx.OpenSession(st.slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := findObject(st.ctx, session, pkcs11.CKO_PRIVATE_KEY, id)
+	if err == nil {
+		var pubHandle pkcs11.ObjectHandle
+		if pubHandle, err = findObject(st.ctx, session, pkcs11.CKO_PUBLIC_KEY, id); err == nil {
+			var pub crypto.PublicKey
+			if pub, err = readPublicKey(st.ctx, session, pubHandle); err == nil {
+				return &pkcs11Signer{ctx: st.ctx, session: session, key: priv, pub: pub}, nil
+			}
+		}
+	}
+	st.ctx.CloseSession(session)
+	return nil, err
+}
+
+// findObject 按类型和CKA_ID查找对象
+func findObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, id []byte) (pkcs11.ObjectHandle, error) {
+	if err := ctx.FindObjectsInit(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}); err != nil {
+		return 0, err
+	}
+	handles, _, err := ctx.FindObjects(session, 1)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, fmt.Errorf("PKCS#11令牌中未找到密钥: %x", id)
+	}
+	return handles[0], nil
+}
+
+// readPublicKey 读取公钥对象，支持EC（P-256）和RSA
+func readPublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, h pkcs11.ObjectHandle) (crypto.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, h, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil)})
+	if err != nil || len(attrs) == 0 {
+		return nil, fmt.Errorf("读取公钥类型失败: %v", err)
+	}
+	keyType := ulong(attrs[0].Value)
+	switch keyType {
+	case pkcs11.CKK_EC:
+		attrs, err = ctx.GetAttributeValue(session, h, []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil)})
+		if err != nil || len(attrs) == 0 {
+			return nil, fmt.Errorf("读取EC公钥失败: %v", err)
+		}
+		// CKA_EC_POINT为DER编码的OCTET STRING
+		var point []byte
+		if _, err := asn1.Unmarshal(attrs[0].Value, &point); err != nil {
+			point = attrs[0].Value
+		}
+		x, y := elliptic.Unmarshal(elliptic.P256(), point)
+		if x == nil {
+			return nil, errors.New("不支持的EC公钥（仅支持P-256）")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case pkcs11.CKK_RSA:
+		attrs, err = ctx.GetAttributeValue(session, h, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil || len(attrs) != 2 {
+			return nil, fmt.Errorf("读取RSA公钥失败: %v", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(attrs[0].Value), E: int(new(big.Int).SetBytes(attrs[1].Value).Int64())}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %d", keyType)
+}
+
+// ulong 解析CK_ULONG属性值（本机字节序，Windows上为4字节，其他64位平台为8字节）
+func ulong(b []byte) uint {
+	switch len(b) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(b))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(b))
+	}
+	return 0
+}
+
+// pkcs11Signer 令牌中的私钥，会话不能并发使用
+type pkcs11Signer struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	key     pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey { return s.pub }
+
+// Close 关闭会话
+func (s *pkcs11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ctx.CloseSession(s.session)
+}
+
+// Sign 在令牌中对摘要签名：ECDSA返回ASN.1 DER编码的签名值，RSA使用PKCS#1 v1.5
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, s.key); err != nil {
+			return nil, err
+		}
+		raw, err := s.ctx.Sign(s.session, digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11返回r||s，转换为DER编码的ECDSA-Sig-Value
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(raw[:half]), new(big.Int).SetBytes(raw[half:])})
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("PKCS#11后端不支持RSA-PSS签名")
+		}
+		prefix, ok := digestInfoPrefix[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("不支持的摘要算法: %v", opts.HashFunc())
+		}
+		if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}, s.key); err != nil {
+			return nil, err
+		}
+		return s.ctx.Sign(s.session, append(append([]byte{}, prefix...), digest...))
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %T", s.pub)
+}
@@ -0,0 +1,23 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"crypto"
+	"errors"
+)
+
+// pkcs11State 未启用cgo时不使用
+type pkcs11State struct{}
+
+var errNoCgo = errors.New("PKCS#11后端需要启用cgo编译")
+
+// GenerateKey 实现Provider
+func (p *PKCS11) GenerateKey(algo, label, pin string) (string, crypto.PublicKey, error) {
+	return "", nil, errNoCgo
+}
+
+// Open 实现Provider
+func (p *PKCS11) Open(ref, pin string) (Signer, error) {
+	return nil, errNoCgo
+}
@@ -0,0 +1,48 @@
+//go:build cgo && softhsm
+
+package signer_test
+
+import (
+	"os"
+	"testing"
+
+	"signature_sys/signer"
+)
+
+// 需要SoftHSM（或其他PKCS#11令牌）时运行：
+//
+//	softhsm2-util --init-token --free --label test --so-pin 1234 --pin 1234
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN=test PKCS11_PIN=1234 go test -tags softhsm ./signer
+func TestPKCS11Provider(t *testing.T) {
+	module := os.Getenv("PKCS11_MODULE")
+	if module == "" {
+		t.Skip("未设置PKCS11_MODULE")
+	}
+	p := &signer.PKCS11{Module: module, TokenLabel: os.Getenv("PKCS11_TOKEN"), PIN: os.Getenv("PKCS11_PIN")}
+	if p.Name() != signer.BackendPKCS11 {
+		t.Fatalf("后端名称 = %s", p.Name())
+	}
+	for _, algo := range []string{signer.AlgoECC, signer.AlgoRSA} {
+		t.Run(algo, func(t *testing.T) {
+			ref, pub, err := p.GenerateKey(algo, "test_"+algo, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := p.Open(ref, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			checkSign(t, s, pub)
+		})
+	}
+	if _, _, err := p.GenerateKey(signer.AlgoSM2, "test_SM2", ""); err == nil {
+		t.Fatal("PKCS#11后端不应支持SM2密钥")
+	}
+	if _, err := p.Open("not-hex", ""); err == nil {
+		t.Fatal("格式错误的密钥引用应打开失败")
+	}
+	if _, err := p.Open("00ff00ff", ""); err == nil {
+		t.Fatal("不存在的密钥应打开失败")
+	}
+}
@@ -0,0 +1,67 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/emmansun/gmsm/sm2"
+)
+
+// signer/signer.go
+// 本文件定义了签名密钥的抽象：签名时通过密钥后端（Provider）按密钥引用打开签名密钥（Signer），
+// 私钥可以是服务器上的加密文件，也可以保存在HSM（PKCS#11）或远程密钥管理服务（KMS）中，不离开设备。
+// SM2密钥的Sign收到的摘要已是SM3(Z_A‖M)，opts为utils.SM2SignerOpts，各后端直接对摘要签名。
+
+// 密钥后端名称，对应[Cert].KeyBackend
+const (
+	BackendFile   = "file"   // 服务器本地文件（PIN码加密的PKCS#8）
+	BackendPKCS11 = "pkcs11" // HSM或软件令牌（PKCS#11）
+	BackendKMS    = "kms"    // 远程密钥管理服务（HTTP）
+)
+
+// 密钥算法，对应[Cert].Algo
+const (
+	AlgoECC = "ECC" // ECDSA P-256
+	AlgoRSA = "RSA" // RSA 2048
+	AlgoSM2 = "SM2" // 国密SM2（GB/T 32918），摘要算法为SM3
+)
+
+// Signer 已打开的签名密钥，用完后须调用Close释放会话或连接
+type Signer interface {
+	crypto.Signer
+	Close() error
+}
+
+// Provider 密钥后端
+type Provider interface {
+	// Name 返回后端名称
+	Name() string
+	// GenerateKey 在后端中生成新的签名密钥，返回密钥引用和公钥；label为密钥标签，pin为用户签名PIN码（文件后端用于加密私钥）
+	GenerateKey(algo, label, pin string) (ref string, pub crypto.PublicKey, err error)
+	// Open 按密钥引用打开签名密钥，pin为用户签名PIN码（文件后端用于解密私钥）
+	Open(ref, pin string) (Signer, error)
+}
+
+// GenerateSoftwareKey 在内存中生成签名密钥，供文件后端和KMS模拟服务使用
+func GenerateSoftwareKey(algo string) (crypto.Signer, error) {
+	switch algo {
+	case AlgoECC:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgoRSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case AlgoSM2:
+		return sm2.GenerateKey(rand.Reader)
+	}
+	return nil, fmt.Errorf("不支持的密钥算法: %s", algo)
+}
+
+// nopCloser 无需释放资源的签名密钥
+type nopCloser struct {
+	crypto.Signer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,171 @@
+package signer_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"signature_sys/signer"
+	"signature_sys/signer/kmsmock"
+	"signature_sys/utils"
+
+	"github.com/emmansun/gmsm/smx509"
+)
+
+var algos = []string{signer.AlgoECC, signer.AlgoRSA, signer.AlgoSM2}
+
+// checkSign 用打开的签名密钥签名，并用生成密钥时返回的公钥验签
+func checkSign(t *testing.T, s signer.Signer, pub crypto.PublicKey) {
+	t.Helper()
+	if eq, ok := s.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !eq.Equal(pub) {
+		t.Fatal("打开的密钥与生成时返回的公钥不一致")
+	}
+	msg := []byte("待签名的数据")
+	if utils.IsSM2PublicKey(pub) {
+		digest, err := utils.SM2Digest(pub, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := s.Sign(rand.Reader, digest, utils.SM2SignerOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !utils.VerifySM2(pub, msg, sig) {
+			t.Fatal("SM2签名验签失败")
+		}
+		return
+	}
+	sum := sha256.Sum256(msg)
+	sig, err := s.Sign(rand.Reader, sum[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, sum[:], sig) {
+			t.Fatal("ECDSA签名验签失败")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			t.Fatal("RSA签名验签失败:", err)
+		}
+	default:
+		t.Fatalf("不支持的公钥类型: %T", pub)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	p := &signer.File{Dir: t.TempDir()}
+	if p.Name() != signer.BackendFile {
+		t.Fatalf("后端名称 = %s", p.Name())
+	}
+	for _, algo := range algos {
+		t.Run(algo, func(t *testing.T) {
+			ref, pub, err := p.GenerateKey(algo, "key_"+algo, "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encrypted, err := utils.IsEncryptedPrivateKey(ref); err != nil || !encrypted {
+				t.Fatalf("私钥文件应以PIN码加密保存: %v", err)
+			}
+			s, err := p.Open(ref, "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			checkSign(t, s, pub)
+			if _, err := p.Open(ref, "000000"); !errors.Is(err, utils.ErrWrongPIN) {
+				t.Fatalf("PIN码错误时返回 %v，应为ErrWrongPIN", err)
+			}
+		})
+	}
+	if _, _, err := p.GenerateKey("DSA", "bad", "123456"); err == nil {
+		t.Fatal("不支持的算法应生成失败")
+	}
+}
+
+func TestFileProviderEncryptsLegacyKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := signer.GenerateSoftwareKey(signer.AlgoECC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 早期未加密保存的私钥
+	ref := filepath.Join(dir, "legacy_private.pem")
+	if err := writePlainKey(ref, key); err != nil {
+		t.Fatal(err)
+	}
+	p := &signer.File{Dir: dir}
+	s, err := p.Open(ref, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSign(t, s, key.Public())
+	if encrypted, err := utils.IsEncryptedPrivateKey(ref); err != nil || !encrypted {
+		t.Fatalf("打开后私钥文件应改为加密保存: %v", err)
+	}
+	if _, err := p.Open(ref, "000000"); !errors.Is(err, utils.ErrWrongPIN) {
+		t.Fatalf("加密保存后PIN码错误时返回 %v，应为ErrWrongPIN", err)
+	}
+}
+
+func TestKMSProvider(t *testing.T) {
+	srv := httptest.NewServer(kmsmock.New("token"))
+	defer srv.Close()
+	p := &signer.KMS{URL: srv.URL, Token: "token"}
+	if p.Name() != signer.BackendKMS {
+		t.Fatalf("后端名称 = %s", p.Name())
+	}
+	for _, algo := range algos {
+		t.Run(algo, func(t *testing.T) {
+			ref, pub, err := p.GenerateKey(algo, "key_"+algo, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := p.Open(ref, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			checkSign(t, s, pub)
+		})
+	}
+	ref, _, err := p.GenerateKey(signer.AlgoECC, "key", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&signer.KMS{URL: srv.URL, Token: "wrong"}).Open(ref, ""); err == nil {
+		t.Fatal("访问令牌错误时应打开失败")
+	}
+	if _, err := p.Open("missing", ""); err == nil {
+		t.Fatal("不存在的密钥应打开失败")
+	}
+	if _, _, err := p.GenerateKey("DSA", "bad", ""); err == nil {
+		t.Fatal("不支持的算法应生成失败")
+	}
+	s, err := p.Open(ref, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("data"))
+	if _, err := s.Sign(rand.Reader, sum[:], &rsa.PSSOptions{Hash: crypto.SHA256}); err == nil {
+		t.Fatal("KMS后端不应支持RSA-PSS签名")
+	}
+}
+
+// writePlainKey 以未加密的PKCS#8格式写入私钥，模拟早期保存的私钥文件
+func writePlainKey(path string, key crypto.Signer) error {
+	der, err := smx509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,229 @@
+package tsp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"signature_sys/cms"
+	"signature_sys/utils"
+)
+
+// tsp/authority.go
+// 本文件实现了系统内置的时间戳服务（TSA）。
+// 使用专用密钥签发RFC 3161时间戳令牌，既可在进程内直接调用，也可通过HTTP对外提供服务，
+// 便于在无外部TSA的离线环境和测试中使用。
+
+// DefaultPolicy 内置TSA的默认时间戳策略OID（2.999为示例弧，正式部署时应替换为机构自有的策略OID）
+var DefaultPolicy = asn1.ObjectIdentifier{2, 999, 3161, 1}
+
+// Authority 内置时间戳服务
+type Authority struct {
+	Signer      crypto.Signer         // TSA专用签名私钥
+	Certificate *x509.Certificate     // TSA证书，须包含时间戳扩展密钥用途
+	Chain       []*x509.Certificate   // 需要内嵌到令牌中的上级证书（可选）
+	Policy      asn1.ObjectIdentifier // 时间戳策略，默认DefaultPolicy
+}
+
+// LoadOrCreateAuthority 从证书和私钥文件加载内置TSA，文件不存在时生成新的ECC密钥和TSA证书
+// issuer不为空时TSA证书由issuer签发并作为证书链内嵌到令牌中，否则生成自签名证书
+func LoadOrCreateAuthority(certPath, keyPath string, issuer *x509.Certificate, issuerKey crypto.Signer) (*Authority, error) {
+	if _, err := os.Stat(certPath); err == nil {
+		cert, err := utils.LoadCertificate(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取TSA证书失败: %w", err)
+		}
+		key, err := utils.LoadPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取TSA私钥失败: %w", err)
+		}
+		a := &Authority{Signer: key, Certificate: cert}
+		if issuer != nil && cert.CheckSignatureFrom(issuer) == nil {
+			a.Chain = []*x509.Certificate{issuer}
+		}
+		return a, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 3161要求TSA证书的扩展密钥用途仅为timeStamping且标记为关键扩展
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{OIDKeyPurposeTimeStamping})
+	if err != nil {
+		return nil, err
+	}
+	tmpl := x509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		Subject:         pkix.Name{CommonName: "Signature System TSA"},
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().AddDate(10, 0, 0),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Critical: true, Value: eku}},
+	}
+	parent, parentKey := &tmpl, crypto.Signer(key)
+	var chain []*x509.Certificate
+	if issuer != nil {
+		parent, parentKey = issuer, issuerKey
+		chain = []*x509.Certificate{issuer}
+		if tmpl.NotAfter.After(issuer.NotAfter) {
+			tmpl.NotAfter = issuer.NotAfter
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, err
+	}
+	return &Authority{Signer: key, Certificate: cert, Chain: chain}, nil
+}
+
+// Timestamp 在进程内直接为摘要值签发时间戳令牌
+func (a *Authority) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	oid, err := cms.DigestOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != hash.Size() {
+		return nil, errors.New("摘要长度与摘要算法不符")
+	}
+	imprint := messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, HashedMessage: digest}
+	return a.issue(imprint, nil, true)
+}
+
+// issue 签发时间戳令牌
+func (a *Authority) issue(imprint messageImprint, nonce *big.Int, certReq bool) ([]byte, error) {
+	if a.Signer == nil || a.Certificate == nil {
+		return nil, errors.New("时间戳服务未配置密钥")
+	}
+	policy := a.Policy
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+	genTime := time.Now().UTC().Truncate(time.Second)
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         policy,
+		MessageImprint: imprint,
+		SerialNumber:   serial,
+		GenTime:        genTime,
+		Accuracy:       accuracy{Seconds: 1},
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	sd, err := cms.Sign(sum[:], a.Signer, a.Certificate, a.Chain, cms.SignOptions{
+		Hash:        crypto.SHA256,
+		ContentType: cms.OIDTSTInfo,
+		Content:     content,
+		SigningTime: genTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 请求未要求返回证书时，令牌中不内嵌证书
+	if !certReq {
+		sd.Certificates = nil
+	}
+	return sd.Marshal()
+}
+
+// Respond 处理DER编码的时间戳请求，返回DER编码的时间戳响应
+func (a *Authority) Respond(reqDER []byte) []byte {
+	var req timeStampReq
+	rest, err := asn1.Unmarshal(reqDER, &req)
+	if err != nil || len(rest) > 0 || req.Version != 1 {
+		return rejection(FailBadRequest, "时间戳请求格式错误")
+	}
+	hash, err := cms.HashForOID(req.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil || hash == crypto.SHA1 {
+		return rejection(FailBadAlg, "不支持的摘要算法")
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return rejection(FailBadDataFormat, "摘要长度与摘要算法不符")
+	}
+	policy := a.Policy
+	if policy == nil {
+		policy = DefaultPolicy
+	}
+	if req.ReqPolicy != nil && !req.ReqPolicy.Equal(policy) {
+		return rejection(FailUnacceptedPolicy, "不支持请求的时间戳策略")
+	}
+	if len(req.Extensions) > 0 {
+		return rejection(FailUnacceptedExtension, "不支持请求扩展")
+	}
+	token, err := a.issue(req.MessageImprint, req.Nonce, req.CertReq)
+	if err != nil {
+		return rejection(FailSystemFailure, "时间戳签发失败")
+	}
+	resp, _ := asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: StatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+	return resp
+}
+
+// ServeHTTP 以HTTP方式提供时间戳服务（RFC 3161第3.4节）
+func (a *Authority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "仅支持POST", 405)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "读取请求失败", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(a.Respond(body))
+}
+
+// rejection 生成拒绝响应
+func rejection(failBit int, msg string) []byte {
+	bits := make([]byte, failBit/8+1)
+	bits[failBit/8] = 0x80 >> (failBit % 8)
+	resp, _ := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{
+		Status:       StatusRejection,
+		StatusString: []string{msg},
+		FailInfo:     asn1.BitString{Bytes: bits, BitLength: failBit + 1},
+	}})
+	return resp
+}
@@ -0,0 +1,96 @@
+package tsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"signature_sys/cms"
+)
+
+// tsp/client.go
+// 本文件实现了RFC 3161时间戳客户端，通过HTTP向外部TSA申请时间戳令牌。
+
+// Client 外部时间戳服务客户端
+type Client struct {
+	URL        string       // TSA地址
+	HTTPClient *http.Client // 为空时使用30秒超时的默认客户端
+}
+
+// Timestamp 对摘要值申请时间戳，返回校验通过的令牌
+func (c *Client) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	oid, err := cms.DigestOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, HashedMessage: digest},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Post(c.URL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("时间戳服务请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("时间戳服务返回HTTP %d", resp.StatusCode)
+	}
+	token, err := ParseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	// 校验令牌内容与请求一致
+	ts, err := Parse(token)
+	if err != nil {
+		return nil, fmt.Errorf("时间戳令牌无效: %w", err)
+	}
+	if ts.HashAlgorithm != hash || !bytes.Equal(ts.HashedMessage, digest) {
+		return nil, errors.New("时间戳令牌的消息摘要与请求不一致")
+	}
+	if ts.Nonce == nil || ts.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("时间戳令牌的随机数与请求不一致")
+	}
+	return token, nil
+}
+
+// ParseResponse 解析时间戳响应，状态为已签发时返回其中的令牌
+func ParseResponse(data []byte) ([]byte, error) {
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("时间戳响应解析失败: %w", err)
+	}
+	if resp.Status.Status != StatusGranted && resp.Status.Status != StatusGrantedWithMods {
+		msg := strings.Join(resp.Status.StatusString, "; ")
+		return nil, fmt.Errorf("时间戳服务拒绝请求（状态%d）: %s", resp.Status.Status, msg)
+	}
+	if len(resp.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("时间戳响应中没有令牌")
+	}
+	return resp.TimeStampToken.FullBytes, nil
+}
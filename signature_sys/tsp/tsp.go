@@ -0,0 +1,195 @@
+package tsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"signature_sys/cms"
+)
+
+// tsp/tsp.go
+// 本文件定义了RFC 3161时间戳协议的数据结构，以及时间戳令牌的解析与校验。
+// 时间戳令牌是封装TSTInfo的CMS SignedData，由时间戳服务（TSA）签名。
+
+// Timestamper 时间戳服务接口，对摘要值申请时间戳令牌（DER编码的ContentInfo）
+type Timestamper interface {
+	Timestamp(digest []byte, hash crypto.Hash) ([]byte, error)
+}
+
+// OIDKeyPurposeTimeStamping TSA证书必须包含的扩展密钥用途
+var OIDKeyPurposeTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+// PKIStatus取值
+const (
+	StatusGranted         = 0 // 已签发
+	StatusGrantedWithMods = 1 // 已签发（有修改）
+	StatusRejection       = 2 // 拒绝
+)
+
+// PKIFailureInfo的位序号
+const (
+	FailBadAlg              = 0  // 不支持的摘要算法
+	FailBadRequest          = 2  // 请求格式错误
+	FailBadDataFormat       = 5  // 数据格式错误
+	FailUnacceptedPolicy    = 15 // 不支持的策略
+	FailUnacceptedExtension = 16 // 不支持的扩展
+	FailSystemFailure       = 25 // 系统错误
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// timeStampReq 时间戳请求
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+// pkiStatusInfo 响应状态
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+// timeStampResp 时间戳响应
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// tstInfo 时间戳令牌中被签名的内容
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+// Timestamp 解析并校验通过的时间戳令牌
+type Timestamp struct {
+	Time          time.Time             // 时间戳时间（genTime）
+	Accuracy      time.Duration         // 时间精度，未声明时为0
+	SerialNumber  *big.Int              // 令牌序列号
+	Policy        asn1.ObjectIdentifier // 时间戳策略
+	Nonce         *big.Int              // 请求中的随机数
+	HashAlgorithm crypto.Hash           // 消息摘要算法
+	HashedMessage []byte                // 被盖时间戳的数据摘要
+	Certificate   *x509.Certificate     // TSA签名证书
+	Certificates  []*x509.Certificate   // 令牌内嵌的全部证书
+	Raw           []byte                // 令牌原始DER
+}
+
+// Parse 解析时间戳令牌，并校验TSA签名、签名证书绑定和证书用途
+// 返回错误表示令牌无效
+func Parse(token []byte) (*Timestamp, error) {
+	sd, err := cms.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentType.Equal(cms.OIDTSTInfo) || len(sd.Content) == 0 {
+		return nil, errors.New("时间戳令牌中没有TSTInfo")
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.Content, &info); err != nil {
+		return nil, fmt.Errorf("TSTInfo解析失败: %w", err)
+	}
+	hash, err := cms.HashForOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	ts := &Timestamp{
+		Time:          info.GenTime,
+		Accuracy:      time.Duration(info.Accuracy.Seconds)*time.Second + time.Duration(info.Accuracy.Millis)*time.Millisecond + time.Duration(info.Accuracy.Micros)*time.Microsecond,
+		SerialNumber:  info.SerialNumber,
+		Policy:        info.Policy,
+		Nonce:         info.Nonce,
+		HashAlgorithm: hash,
+		HashedMessage: info.MessageImprint.HashedMessage,
+		Certificates:  sd.Certificates,
+		Raw:           token,
+	}
+	// 校验TSA对TSTInfo的签名
+	signer := sd.Signers[0]
+	if ts.Certificate, err = sd.SignerCertificate(signer); err != nil {
+		return ts, err
+	}
+	contentHash, err := signer.Hash()
+	if err != nil {
+		return ts, err
+	}
+	h := contentHash.New()
+	h.Write(sd.Content)
+	digest := h.Sum(nil)
+	if err := signer.VerifyDigest(digest); err != nil {
+		return ts, err
+	}
+	if err := signer.VerifySignature(ts.Certificate, digest); err != nil {
+		return ts, err
+	}
+	// RFC 3161要求令牌通过ESS签名证书属性绑定TSA证书
+	if found, err := signer.CheckSigningCertificate(ts.Certificate); err != nil {
+		return ts, err
+	} else if !found {
+		if _, ok := signer.Attribute(cms.OIDAttrSigningCertV1); !ok {
+			return ts, errors.New("时间戳令牌缺少签名证书属性")
+		}
+	}
+	if !hasTimeStampingUsage(ts.Certificate) {
+		return ts, errors.New("签发时间戳的证书不具备时间戳用途")
+	}
+	if ts.Time.Before(ts.Certificate.NotBefore) || ts.Time.After(ts.Certificate.NotAfter) {
+		return ts, errors.New("时间戳时间不在TSA证书有效期内")
+	}
+	return ts, nil
+}
+
+// Matches 校验时间戳是否盖在data上
+func (ts *Timestamp) Matches(data []byte) error {
+	h := ts.HashAlgorithm.New()
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), ts.HashedMessage) {
+		return errors.New("时间戳的消息摘要与被盖时间戳的数据不一致")
+	}
+	return nil
+}
+
+// hasTimeStampingUsage 检查证书的扩展密钥用途是否为时间戳
+func hasTimeStampingUsage(cert *x509.Certificate) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == x509.ExtKeyUsageTimeStamping {
+			return true
+		}
+	}
+	for _, u := range cert.UnknownExtKeyUsage {
+		if u.Equal(OIDKeyPurposeTimeStamping) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// utils/cert.go
+// 本文件实现了证书和私钥PEM文件的读取工具函数。
+// 供PDF数字签名时加载注册阶段生成的ECC/RSA/SM2证书和私钥。
+
+// LoadCertificate 读取PEM格式证书文件
+// 输入证书文件路径，返回解析后的X.509证书
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("证书文件格式错误")
+	}
+	return ParseCertificate(block.Bytes)
+}
+
+// LoadPrivateKey 读取PEM格式私钥文件
+// 支持EC PRIVATE KEY、RSA PRIVATE KEY和PKCS#8格式，返回可用于签名的私钥（SM2私钥为*sm2.PrivateKey）
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("私钥文件格式错误")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err := smx509.ParseTypedECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return key.(crypto.Signer), nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := smx509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("不支持的私钥类型: %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("不支持的私钥格式: %s", block.Type)
+}
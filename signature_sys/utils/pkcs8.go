@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/emmansun/gmsm/smx509"
+	"golang.org/x/crypto/scrypt"
+)
+
+// utils/pkcs8.go
+// 本文件实现了用户私钥的加密存储：私钥以加密的PKCS#8格式（ENCRYPTED PRIVATE KEY）保存，
+// 加密方案为PBES2（RFC 8018），由签名PIN码经scrypt（RFC 7914，内存困难）派生AES-256-CBC密钥。
+// 私钥只在签名时用PIN码解密到内存中，不落盘、不缓存。
+
+// scrypt参数：N=2^15、r=8时每次派生约占用32MB内存
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptMaxN   = 1 << 20 // 读取文件时允许的最大N，防止异常参数耗尽内存
+	pkcs8KeySize = 32
+)
+
+// ErrWrongPIN PIN码错误，无法解密私钥
+var ErrWrongPIN = errors.New("PIN码错误，无法解密私钥")
+
+var (
+	oidPBES2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidScrypt    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo PKCS#8 EncryptedPrivateKeyInfo
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params PBES2-params
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// scryptParams scrypt-params
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// EncryptPrivateKey 用PIN码加密私钥，返回PEM编码的加密PKCS#8私钥
+func EncryptPrivateKey(key crypto.Signer, pin string) ([]byte, error) {
+	plain, err := smx509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(plain)
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	kdf := scryptParams{Salt: salt, CostParameter: scryptN, BlockSize: scryptR, ParallelizationParameter: scryptP, KeyLength: pkcs8KeySize}
+	dk, err := scrypt.Key([]byte(pin), salt, kdf.CostParameter, kdf.BlockSize, kdf.ParallelizationParameter, pkcs8KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(dk)
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#7填充
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	padded := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	defer wipe(padded)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	kdfDER, err := asn1.Marshal(kdf)
+	if err != nil {
+		return nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	paramsDER, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfDER}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: paramsDER}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
+
+// DecryptPrivateKey 用PIN码解密PEM编码的加密PKCS#8私钥
+func DecryptPrivateKey(data []byte, pin string) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("不是加密的私钥文件")
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("加密私钥格式错误: %v", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("不支持的私钥加密方案: %v", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("PBES2参数格式错误: %v", err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidScrypt) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, errors.New("不支持的密钥派生或加密算法")
+	}
+	var kdf scryptParams
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("scrypt参数格式错误: %v", err)
+	}
+	if kdf.CostParameter > scryptMaxN || (kdf.KeyLength != 0 && kdf.KeyLength != pkcs8KeySize) {
+		return nil, errors.New("scrypt参数超出允许范围")
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("AES-CBC初始向量格式错误")
+	}
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, errors.New("加密数据长度错误")
+	}
+	dk, err := scrypt.Key([]byte(pin), kdf.Salt, kdf.CostParameter, kdf.BlockSize, kdf.ParallelizationParameter, pkcs8KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(dk)
+	aesBlock, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	defer wipe(plain)
+	cipher.NewCBCDecrypter(aesBlock, iv).CryptBlocks(plain, info.EncryptedData)
+	// PIN码错误时填充或PKCS#8结构校验失败
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, ErrWrongPIN
+	}
+	key, err := smx509.ParsePKCS8PrivateKey(plain[:len(plain)-pad])
+	if err != nil {
+		return nil, ErrWrongPIN
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("不支持的私钥类型: %T", key)
+	}
+	return signer, nil
+}
+
+// IsEncryptedPrivateKey 判断私钥文件是否已加密
+func IsEncryptedPrivateKey(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == "ENCRYPTED PRIVATE KEY", nil
+}
+
+// LoadEncryptedPrivateKey 读取私钥文件并用PIN码解密
+// 早期未加密的私钥文件直接读取，由调用方在校验PIN码后重新加密保存
+func LoadEncryptedPrivateKey(path, pin string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type != "ENCRYPTED PRIVATE KEY" {
+		return LoadPrivateKey(path)
+	}
+	return DecryptPrivateKey(data, pin)
+}
+
+// SaveEncryptedPrivateKey 用PIN码加密私钥并保存（权限0600），先写临时文件再替换，避免写入中断损坏原私钥
+func SaveEncryptedPrivateKey(path string, key crypto.Signer, pin string) error {
+	data, err := EncryptPrivateKey(key, pin)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// wipe 清零内存中的敏感数据
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// testKeys ECDSA、RSA和SM2测试私钥
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2Key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"ECC": ecKey, "RSA": rsaKey, "SM2": sm2Key}
+}
+
+// samePublicKey 判断两个私钥的公钥是否相同
+func samePublicKey(a, b crypto.Signer) bool {
+	pub, ok := a.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b.Public())
+}
+
+func TestEncryptDecryptPrivateKey(t *testing.T) {
+	for name, key := range testKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			data, err := EncryptPrivateKey(key, "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(data); block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+				t.Fatal("加密结果不是ENCRYPTED PRIVATE KEY")
+			}
+			got, err := DecryptPrivateKey(data, "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !samePublicKey(key, got) {
+				t.Fatal("解密后的私钥与原私钥不一致")
+			}
+			if _, err := DecryptPrivateKey(data, "654321"); !errors.Is(err, ErrWrongPIN) {
+				t.Fatalf("PIN码错误时返回 %v，应为ErrWrongPIN", err)
+			}
+			if _, err := DecryptPrivateKey(data, ""); !errors.Is(err, ErrWrongPIN) {
+				t.Fatalf("PIN码为空时返回 %v，应为ErrWrongPIN", err)
+			}
+		})
+	}
+}
+
+func TestEncryptPrivateKeySalted(t *testing.T) {
+	key := testKeys(t)["ECC"]
+	a, err := EncryptPrivateKey(key, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncryptPrivateKey(key, "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) == string(b) {
+		t.Fatal("同一私钥两次加密的结果相同，盐值或初始向量未随机生成")
+	}
+}
+
+func TestSaveLoadEncryptedPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	for name, key := range testKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+"_private.pem")
+			if err := SaveEncryptedPrivateKey(path, key, "123456"); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("私钥文件权限为%v，应为0600", fi.Mode().Perm())
+			}
+			encrypted, err := IsEncryptedPrivateKey(path)
+			if err != nil || !encrypted {
+				t.Fatalf("私钥文件应为加密格式: %v", err)
+			}
+			got, err := LoadEncryptedPrivateKey(path, "123456")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !samePublicKey(key, got) {
+				t.Fatal("读取的私钥与保存的私钥不一致")
+			}
+			if _, err := LoadEncryptedPrivateKey(path, "000000"); !errors.Is(err, ErrWrongPIN) {
+				t.Fatalf("PIN码错误时返回 %v，应为ErrWrongPIN", err)
+			}
+		})
+	}
+	// 保存时不留下临时文件
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("目录中有%d个文件，应为3个", len(entries))
+	}
+}
+
+func TestLoadUnencryptedPrivateKey(t *testing.T) {
+	key := testKeys(t)["SM2"]
+	der, err := smx509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "legacy_private.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := IsEncryptedPrivateKey(path)
+	if err != nil || encrypted {
+		t.Fatalf("未加密的私钥文件不应识别为加密格式: %v", err)
+	}
+	// 早期未加密的私钥直接读取，不校验PIN码
+	got, err := LoadEncryptedPrivateKey(path, "任意PIN码")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !samePublicKey(key, got) {
+		t.Fatal("读取的私钥与原私钥不一致")
+	}
+}
+
+func TestDecryptPrivateKeyInvalid(t *testing.T) {
+	if _, err := DecryptPrivateKey([]byte("not a pem"), "123456"); err == nil {
+		t.Fatal("非PEM数据应解密失败")
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{0x30, 0x00}})
+	if _, err := DecryptPrivateKey(data, "123456"); err == nil {
+		t.Fatal("结构错误的加密私钥应解密失败")
+	}
+}
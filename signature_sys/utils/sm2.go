@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+)
+
+// utils/sm2.go
+// 本文件封装了国密算法（SM2/SM3）相关的证书处理。
+// 标准库crypto/x509不识别SM2曲线和SM2-with-SM3签名算法，解析证书、校验证书和CRL签名时统一调用这里的函数，
+// 由smx509同时处理国际算法和国密算法；解析结果仍为*x509.Certificate，SM2公钥为SM2曲线上的*ecdsa.PublicKey。
+
+// SM2SignerOpts SM2签名的crypto.SignerOpts
+// 按GB/T 32918.2，传给Sign的摘要已是SM3(Z_A‖M)，签名密钥直接对其签名，不再计算摘要
+type SM2SignerOpts struct{}
+
+// HashFunc 实现crypto.SignerOpts，SM3不在crypto.Hash中，返回0
+func (SM2SignerOpts) HashFunc() crypto.Hash { return 0 }
+
+// IsSM2PublicKey 判断公钥是否为SM2公钥
+func IsSM2PublicKey(pub crypto.PublicKey) bool {
+	return sm2.IsSM2PublicKey(pub)
+}
+
+// SM2Digest 计算SM2签名使用的摘要SM3(Z_A‖M)，Z_A使用默认用户标识1234567812345678
+func SM2Digest(pub crypto.PublicKey, msg []byte) ([]byte, error) {
+	return sm2.CalculateSM2Hash(pub.(*ecdsa.PublicKey), msg, nil)
+}
+
+// VerifySM2 用SM2公钥校验对msg的签名（ASN.1 DER编码），使用默认用户标识
+func VerifySM2(pub crypto.PublicKey, msg, sig []byte) bool {
+	return sm2.VerifyASN1WithSM2(pub.(*ecdsa.PublicKey), nil, msg, sig)
+}
+
+// ParseCertificate 解析DER编码的证书，支持SM2证书（GM/T 0015）
+func ParseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, err := smx509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return cert.ToX509(), nil
+}
+
+// CheckSignatureFrom 校验cert是否由parent签发，支持SM2-with-SM3签名的证书
+func CheckSignatureFrom(cert, parent *x509.Certificate) error {
+	return (*smx509.Certificate)(cert).CheckSignatureFrom((*smx509.Certificate)(parent))
+}
+
+// ParseRevocationList 解析DER编码的CRL并校验颁发者签名，支持SM2-with-SM3签名的CRL
+func ParseRevocationList(der []byte, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	crl, err := smx509.ParseRevocationList(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := crl.CheckSignatureFrom((*smx509.Certificate)(issuer)); err != nil {
+		return nil, err
+	}
+	return crl.ToX509(), nil
+}